@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+const usage = `usage: signin-ui [command] [args]
+
+With no command, signin-ui runs the server.
+
+commands:
+  migrate           status | up | down-to <version>
+  keys              list | create | rotate <id> | revoke <id>
+  grant-admin       <upn>
+  sync              run a directory sync now
+  export-checkins   --from <date> --to <date> [--location <id>] [--out <file>]
+  purge             delete archived users past retention and, optionally, apply
+                    checkin retention rules (--retention) or delete old checkins
+  partitions        status | maintain
+  health            check config, database, migrations, settings and partitions
+`
+
+// commandFunc runs an operator subcommand and returns the exit code.
+type commandFunc func(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int
+
+// commands lists the operator subcommands. They share the server's config and
+// go through internal/store, so they behave as the admin API does.
+func commands() map[string]commandFunc {
+	return map[string]commandFunc{
+		"migrate":         runMigrate,
+		"keys":            runKeys,
+		"grant-admin":     runGrantAdmin,
+		"sync":            runSync,
+		"export-checkins": runExportCheckins,
+		"purge":           runPurge,
+		"partitions":      runPartitions,
+		"health":          runHealth,
+	}
+}
+
+// wantsHelp reports whether the command line asks for usage, which needs no
+// config.
+func wantsHelp(args []string) bool {
+	return len(args) > 1 && (args[1] == "help" || args[1] == "-h" || args[1] == "--help")
+}
+
+// runCommand dispatches a subcommand by name.
+func runCommand(ctx context.Context, cfg config.Config, logger *slog.Logger, name string, args []string) int {
+	cmd, ok := commands()[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+	return cmd(ctx, cfg, logger, args)
+}
+
+// openCommandStore connects without migrating; operators run migrate
+// explicitly.
+func openCommandStore(ctx context.Context, cfg config.Config, logger *slog.Logger) (*store.Store, error) {
+	cfg.MigrateOnStart = false
+	return openStore(ctx, cfg, logger)
+}
+
+// newFlagSet builds a flag set that reports errors instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseCommandTime reads a date (midnight local time) or an RFC 3339 time.
+func parseCommandTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+func formatCommandTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// fail reports a command error and returns the failure exit code.
+func fail(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return 1
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+const keysUsage = `usage: signin-ui keys <command>
+
+commands:
+  list                                       list portal keys
+  create [--description text] [--location id-or-identifier]...
+                                             create a key and print its value
+  rotate <id>                                give a key a new value and print it
+  revoke <id>                                delete a key
+`
+
+// runKeys manages portal keys.
+func runKeys(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		return listKeys(ctx, db)
+	case "create":
+		return createKey(ctx, db, args[1:])
+	case "rotate", "revoke":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, keysUsage)
+			return 2
+		}
+		keyID, parseErr := uuid.Parse(args[1])
+		if parseErr != nil {
+			return fail("invalid key id %q", args[1])
+		}
+		if args[0] == "rotate" {
+			return rotateKey(ctx, db, keyID)
+		}
+		return revokeKey(ctx, db, keyID)
+	}
+	fmt.Fprint(os.Stderr, keysUsage)
+	return 2
+}
+
+func listKeys(ctx context.Context, db *store.Store) int {
+	keys, err := db.ListKeys(ctx)
+	if err != nil {
+		return fail("list keys: %v", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDESCRIPTION\tLOCATIONS\tCREATED\tLAST USED")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			key.ID, key.Description.String, len(key.LocationIds),
+			formatCommandTime(key.CreatedAt.Time), formatCommandTime(key.LastUsedAt.Time))
+	}
+	if err = tw.Flush(); err != nil {
+		return fail("list keys: %v", err)
+	}
+	return 0
+}
+
+// locationFlags collects repeated --location values.
+type locationFlags []string
+
+func (f *locationFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *locationFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func createKey(ctx context.Context, db *store.Store, args []string) int {
+	fs := newFlagSet("keys create")
+	description := fs.String("description", "", "what the key is for, such as the kiosk it is on")
+	var locations locationFlags
+	fs.Var(&locations, "location", "location ID or identifier the key may check in to; repeatable")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	locationIDs := make([]uuid.UUID, 0, len(locations))
+	for _, value := range locations {
+		id, err := resolveLocation(ctx, db, value)
+		if err != nil {
+			return fail("%v", err)
+		}
+		locationIDs = append(locationIDs, id)
+	}
+	key, err := db.CreateKey(ctx, sqlc.CreateKeyParams{
+		ID:          uuid.New(),
+		Description: pgtype.Text{String: *description, Valid: *description != ""},
+		KeyValue:    store.GenerateKeyValue(),
+		LocationIds: locationIDs,
+	})
+	if err != nil {
+		return fail("create key: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "created key %s\n%s\n", key.ID, key.KeyValue)
+	return 0
+}
+
+func rotateKey(ctx context.Context, db *store.Store, keyID uuid.UUID) int {
+	key, err := db.RotateKey(ctx, keyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fail("key %s not found", keyID)
+	}
+	if err != nil {
+		return fail("rotate key: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "rotated key %s\n%s\n", key.ID, key.KeyValue)
+	return 0
+}
+
+func revokeKey(ctx context.Context, db *store.Store, keyID uuid.UUID) int {
+	if _, err := db.GetKey(ctx, keyID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fail("key %s not found", keyID)
+		}
+		return fail("load key: %v", err)
+	}
+	if err := db.DeleteKey(ctx, keyID); err != nil {
+		return fail("revoke key: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "revoked key %s\n", keyID)
+	return 0
+}
+
+// resolveLocation accepts a location ID or identifier.
+func resolveLocation(ctx context.Context, db *store.Store, value string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(value); err == nil {
+		if _, err = db.GetLocation(ctx, id); err != nil {
+			return uuid.Nil, fmt.Errorf("location %s: %w", value, err)
+		}
+		return id, nil
+	}
+	loc, err := db.GetLocationByIdentifier(ctx, value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("location %q: %w", value, err)
+	}
+	return loc.ID, nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -12,8 +14,11 @@ import (
 
 	"github.com/woodleighschool/signin-ui/internal/auth"
 	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/directory"
 	"github.com/woodleighschool/signin-ui/internal/graph"
 	httpapi "github.com/woodleighschool/signin-ui/internal/http"
+	"github.com/woodleighschool/signin-ui/internal/roster"
+	"github.com/woodleighschool/signin-ui/internal/settings"
 	"github.com/woodleighschool/signin-ui/internal/store"
 	"github.com/woodleighschool/signin-ui/internal/syncer"
 )
@@ -23,7 +28,14 @@ const (
 	requestReadTimeout  = 15 * time.Second
 	requestWriteTimeout = 30 * time.Second
 	idleTimeout         = 60 * time.Second
-	syncInterval        = 15 * time.Minute
+	// settingsPollInterval is how often settings changed on other replicas
+	// are picked up.
+	settingsPollInterval = 30 * time.Second
+	// retentionJobTimeout bounds a scheduled retention pass; batches left
+	// over are picked up by the next one.
+	retentionJobTimeout = 2 * time.Hour
+	// partitionJobTimeout bounds a scheduled partition maintenance pass.
+	partitionJobTimeout = 30 * time.Minute
 )
 
 var (
@@ -38,6 +50,11 @@ func main() {
 }
 
 func run() int {
+	if wantsHelp(os.Args) {
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
@@ -54,26 +71,44 @@ func run() int {
 		"build_date", buildInfo.BuildDate,
 	)
 
+	if len(os.Args) > 1 {
+		return runCommand(ctx, cfg, logger, os.Args[1], os.Args[2:])
+	}
+
 	db, err := openStore(ctx, cfg, logger)
 	if err != nil {
 		return 1
 	}
 	defer db.Close()
 
-	oidcProvider, sessions, err := setupAuth(ctx, cfg, logger)
+	current := settings.NewService(db, logger)
+	if err = current.Reload(ctx); err != nil {
+		logger.ErrorContext(ctx, "load settings", "err", err)
+		return 1
+	}
+	go current.Watch(ctx, settingsPollInterval)
+
+	rosters := roster.NewCache(db, logger)
+	go rosters.Run(ctx)
+
+	providers, sessions, err := setupAuth(ctx, cfg, current, logger)
 	if err != nil {
 		return 1
 	}
 
-	scheduler := scheduleSync(ctx, cfg, db, logger)
+	runner := newSyncRunner(ctx, cfg, db, current, logger)
+	scheduler := scheduleSync(cfg, runner, db, current, logger)
 	defer scheduler.Stop()
 
 	router := httpapi.NewAdminRouter(cfg, httpapi.AdminDeps{
-		Store:        db,
-		Logger:       logger,
-		Sessions:     sessions,
-		OIDCProvider: oidcProvider,
-		BuildInfo:    buildInfo,
+		Store:     db,
+		Logger:    logger,
+		Sessions:  sessions,
+		Providers: providers,
+		Sync:      runner,
+		Settings:  current,
+		Rosters:   rosters,
+		BuildInfo: buildInfo,
 	})
 	server := newHTTPServer(cfg.ListenAddr, router)
 
@@ -99,27 +134,39 @@ func openStore(ctx context.Context, cfg config.Config, logger *slog.Logger) (*st
 		logger.ErrorContext(ctx, "connect db", "err", err)
 		return nil, err
 	}
-	if err = db.Migrate(ctx); err != nil {
+	if !cfg.MigrateOnStart {
+		return db, nil
+	}
+	applied, err := db.Migrate(ctx)
+	if err != nil {
 		logger.ErrorContext(ctx, "run migrations", "err", err)
+		db.Close()
 		return nil, err
 	}
+	for _, m := range applied {
+		logger.InfoContext(ctx, "applied migration", "version", m.Version, "name", m.Name)
+	}
 	return db, nil
 }
 
 func setupAuth(
 	ctx context.Context,
 	cfg config.Config,
+	current *settings.Service,
 	logger *slog.Logger,
-) (*auth.OIDCProvider, *auth.SessionManager, error) {
-	oidcProvider, err := auth.NewOIDCProvider(
-		ctx,
-		cfg.AdminIssuer,
-		cfg.AdminClientID,
-		cfg.AdminClientSecret,
-		cfg.SiteBaseURL,
-	)
+) (*auth.Providers, *auth.SessionManager, error) {
+	var list []auth.Provider
+	for _, pc := range cfg.LoginProviders() {
+		provider, err := newLoginProvider(ctx, cfg, pc)
+		if err != nil {
+			logger.ErrorContext(ctx, "login provider", "provider", pc.Name, "err", err)
+			return nil, nil, err
+		}
+		list = append(list, provider)
+	}
+	providers, err := auth.NewProviders(list...)
 	if err != nil {
-		logger.ErrorContext(ctx, "oidc provider", "err", err)
+		logger.ErrorContext(ctx, "login providers", "err", err)
 		return nil, nil, err
 	}
 
@@ -127,30 +174,138 @@ func setupAuth(
 		cfg.SessionCookieName,
 		cfg.SessionSecret,
 		strings.HasPrefix(cfg.SiteBaseURL, "https"),
+		current.SessionTTL,
 	)
 	if err != nil {
 		logger.ErrorContext(ctx, "session manager", "err", err)
 		return nil, nil, err
 	}
-	return oidcProvider, sessions, nil
+	return providers, sessions, nil
 }
 
-func scheduleSync(ctx context.Context, cfg config.Config, db *store.Store, logger *slog.Logger) *syncer.Scheduler {
-	scheduler := syncer.NewScheduler(logger)
-	graphClient, err := graph.NewClient(ctx, cfg.GraphTenantID, cfg.GraphClientID, cfg.GraphClientSecret)
+// newLoginProvider builds an OIDC or SAML provider from config.
+func newLoginProvider(ctx context.Context, cfg config.Config, pc config.AuthProvider) (auth.Provider, error) {
+	baseURL := strings.TrimRight(cfg.SiteBaseURL, "/")
+	if pc.Type == config.ProviderTypeSAML {
+		samlBase := baseURL + "/api/auth/saml/" + url.PathEscape(pc.Name)
+		return auth.NewSAMLProvider(ctx, auth.SAMLOptions{
+			Name:        pc.Name,
+			DisplayName: pc.DisplayName,
+			MetadataURL: pc.SAMLMetadataURL,
+			EntityID:    pc.SAMLEntityID,
+			AcsURL:      samlBase + "/acs",
+			SPMetadata:  samlBase + "/metadata",
+			CertFile:    pc.SAMLCertFile,
+			KeyFile:     pc.SAMLKeyFile,
+			LoginClaims: pc.LoginClaims,
+		})
+	}
+	return auth.NewOIDCProvider(ctx, auth.OIDCOptions{
+		Name:         pc.Name,
+		DisplayName:  pc.DisplayName,
+		Issuer:       pc.Issuer,
+		ClientID:     pc.ClientID,
+		ClientSecret: pc.ClientSecret,
+		RedirectURL:  baseURL + "/api/auth/callback",
+		Scopes:       pc.Scopes,
+		LoginClaims:  pc.LoginClaims,
+	})
+}
+
+func newSyncRunner(
+	ctx context.Context,
+	cfg config.Config,
+	db *store.Store,
+	current *settings.Service,
+	logger *slog.Logger,
+) *syncer.Runner {
+	source, err := newDirectorySource(ctx, cfg)
 	if err != nil {
-		logger.WarnContext(ctx, "graph client", "err", err)
+		logger.WarnContext(ctx, "directory source", "source", cfg.DirectorySource, "err", err)
+	}
+	return syncer.NewRunner(db, source, syncer.Options{
+		FullInterval: cfg.SyncFullInterval,
+		Timeout:      current.SyncTimeout,
+	}, logger)
+}
+
+// newDirectorySource builds the source selected by DIRECTORY_SOURCE.
+func newDirectorySource(ctx context.Context, cfg config.Config) (directory.Source, error) {
+	switch cfg.DirectorySource {
+	case config.DirectorySourceLDAP:
+		return directory.NewLDAPSource(directory.LDAPOptions{
+			URL:                  cfg.LDAP.URL,
+			BindDN:               cfg.LDAP.BindDN,
+			BindPassword:         cfg.LDAP.BindPassword,
+			BaseDN:               cfg.LDAP.BaseDN,
+			StartTLS:             cfg.LDAP.StartTLS,
+			UserFilter:           cfg.LDAP.UserFilter,
+			GroupFilter:          cfg.LDAP.GroupFilter,
+			IDAttribute:          cfg.LDAP.IDAttribute,
+			UPNAttribute:         cfg.LDAP.UPNAttribute,
+			DisplayNameAttribute: cfg.LDAP.DisplayNameAttribute,
+			DepartmentAttribute:  cfg.LDAP.DepartmentAttribute,
+			EmployeeIDAttribute:  cfg.LDAP.EmployeeIDAttribute,
+			JobTitleAttribute:    cfg.LDAP.JobTitleAttribute,
+			Attributes:           cfg.SyncUserAttributes,
+		}), nil
+	case config.DirectorySourceFile:
+		return directory.NewFileSource(cfg.DirectoryFilePath), nil
+	}
+	graphClient, err := graph.NewClient(ctx, cfg.GraphTenantID, cfg.GraphClientID, cfg.GraphClientSecret)
+	scope := graph.UserScope{
+		GroupIDs:     cfg.SyncUserGroupIDs,
+		AdminUnitIDs: cfg.SyncUserAdminUnitIDs,
+		Filter:       cfg.SyncUserFilter,
+	}
+	return graph.NewSource(graphClient, scope, cfg.SyncGroupFilter, cfg.SyncUserAttributes), err
+}
+
+func scheduleSync(
+	cfg config.Config,
+	runner *syncer.Runner,
+	db *store.Store,
+	current *settings.Service,
+	logger *slog.Logger,
+) *syncer.Scheduler {
+	scheduler := syncer.NewScheduler(logger)
+	if runner.Enabled() {
+		addSyncJob(logger, scheduler, current, cfg.SyncCron, "directory-sync", runner.Job())
+	} else {
+		// Directory syncs refresh rule-based groups; without one, do it here.
+		addSyncJob(logger, scheduler, current, cfg.SyncCron, "rule-groups", syncer.RuleGroupsJob(db, logger))
 	}
-	if graphClient != nil && graphClient.Enabled() {
-		addSyncJob(logger, scheduler, cfg.SyncCron, "entra-users", syncer.NewUserJob(db, graphClient, logger))
-		addSyncJob(logger, scheduler, cfg.SyncCron, "entra-groups", syncer.NewGroupJob(db, graphClient, logger))
+	retentionTimeout := func() time.Duration { return retentionJobTimeout }
+	retention := syncer.RetentionJob(db, current.RetentionBatchSize, logger)
+	if err := scheduler.Add(cfg.RetentionCron, "retention", retentionTimeout, retention); err != nil {
+		logger.Warn("schedule retention job", "err", err)
+	}
+	partitionTimeout := func() time.Duration { return partitionJobTimeout }
+	partitions := syncer.PartitionJob(db, func() syncer.PartitionPolicy { return partitionPolicy(current) }, logger)
+	if err := scheduler.Add(cfg.PartitionCron, "checkin-partitions", partitionTimeout, partitions); err != nil {
+		logger.Warn("schedule partition job", "err", err)
 	}
 	scheduler.Start()
 	return scheduler
 }
 
-func addSyncJob(logger *slog.Logger, scheduler *syncer.Scheduler, cron, name string, job syncer.Job) {
-	if err := scheduler.Add(cron, name, syncInterval, job); err != nil {
+// partitionPolicy reads the partition settings.
+func partitionPolicy(current *settings.Service) syncer.PartitionPolicy {
+	values := current.Current()
+	return syncer.PartitionPolicy{
+		MonthsAhead:        values.PartitionMonthsAhead,
+		ArchiveAfterMonths: values.ArchiveAfterMonths,
+	}
+}
+
+func addSyncJob(
+	logger *slog.Logger,
+	scheduler *syncer.Scheduler,
+	current *settings.Service,
+	cron, name string,
+	job syncer.Job,
+) {
+	if err := scheduler.Add(cron, name, current.SyncTimeout, job); err != nil {
 		logger.Warn("schedule sync job", "job", name, "err", err)
 	}
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+const migrateUsage = `usage: signin-ui migrate <command>
+
+commands:
+  status             list migrations and whether each has been applied
+  up                 apply every pending migration
+  down-to <version>  roll back applied migrations above version
+`
+
+// runMigrate handles the migrate subcommand.
+func runMigrate(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		err = printMigrationStatus(ctx, os.Stdout, db)
+	case args[0] == "up" && len(args) == 1:
+		var applied []store.Migration
+		applied, err = db.Migrate(ctx)
+		printMigrations(os.Stdout, "applied", applied)
+	case args[0] == "down-to" && len(args) == 2:
+		target, convErr := strconv.Atoi(args[1])
+		if convErr != nil || target < 0 {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n", args[1])
+			return 2
+		}
+		var reverted []store.Migration
+		reverted, err = db.MigrateDown(ctx, target)
+		printMigrations(os.Stdout, "rolled back", reverted)
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "migrate "+args[0], "err", err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(ctx context.Context, out io.Writer, db *store.Store) error {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT\tDOWN")
+	for _, s := range statuses {
+		state := "pending"
+		switch {
+		case s.Unknown:
+			state = "applied (unknown to this build)"
+		case s.Changed:
+			state = "applied (file changed)"
+		case s.Applied:
+			state = "applied"
+		}
+		down := "no"
+		if s.HasDown() {
+			down = "yes"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\t%s\n", s.Version, s.Name, state, formatCommandTime(s.AppliedAt), down)
+	}
+	return tw.Flush()
+}
+
+func printMigrations(out io.Writer, verb string, migrations []store.Migration) {
+	if len(migrations) == 0 {
+		fmt.Fprintf(out, "nothing %s\n", verb)
+		return
+	}
+	for _, m := range migrations {
+		fmt.Fprintf(out, "%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+}
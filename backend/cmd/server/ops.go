@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/settings"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
+)
+
+// healthCheckTimeout bounds each health check.
+const healthCheckTimeout = 10 * time.Second
+
+// runGrantAdmin makes a UPN an admin, creating the user if needed. This
+// bootstraps the first admin without the local admin password.
+func runGrantAdmin(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: signin-ui grant-admin <upn>")
+		return 2
+	}
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	user, err := db.GrantAdmin(ctx, args[0])
+	if err != nil {
+		return fail("grant admin: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "%s (%s) is now an admin\n", user.Upn, user.ID)
+	return 0
+}
+
+// runSync runs one directory sync in the foreground.
+func runSync(ctx context.Context, cfg config.Config, logger *slog.Logger, _ []string) int {
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	current := settings.NewService(db, logger)
+	if err = current.Reload(ctx); err != nil {
+		return fail("load settings: %v", err)
+	}
+	runner := newSyncRunner(ctx, cfg, db, current, logger)
+	runCtx, cancel := context.WithTimeout(ctx, current.SyncTimeout())
+	defer cancel()
+	run, err := runner.Run(runCtx, uuid.Nil)
+	switch {
+	case errors.Is(err, syncer.ErrNotConfigured):
+		return fail("no directory source is configured")
+	case errors.Is(err, syncer.ErrSyncRunning):
+		return fail("a sync is already running")
+	case err != nil:
+		return fail("sync %s failed: %v", run.ID, err)
+	}
+	fmt.Fprintf(os.Stdout,
+		"sync %s succeeded: users %d created, %d updated, %d archived; groups %d created, %d updated, %d deleted\n",
+		run.ID, run.UsersCreated, run.UsersUpdated, run.UsersArchived,
+		run.GroupsCreated, run.GroupsUpdated, run.GroupsDeleted)
+	return 0
+}
+
+// runPurge deletes archived users past ARCHIVED_USER_RETENTION and, when asked,
+// applies location retention rules and deletes checkins older than a cutoff.
+func runPurge(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int {
+	fs := newFlagSet("purge")
+	checkinsBefore := fs.String("checkins-before", "", "also delete checkins before this day or time")
+	retention := fs.Bool("retention", false, "also apply each location's retention rules now")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var checkinCutoff time.Time
+	if *checkinsBefore != "" {
+		var err error
+		if checkinCutoff, err = parseCommandTime(*checkinsBefore); err != nil {
+			return fail("--checkins-before: %v", err)
+		}
+	}
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	archivedBefore := time.Now().Add(-cfg.ArchivedUserRetention)
+	users, err := db.PurgeArchivedUsers(ctx, archivedBefore)
+	if err != nil {
+		return fail("purge archived users: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "purged %d users archived before %s\n", users, formatCommandTime(archivedBefore))
+	if *retention {
+		current := settings.NewService(db, logger)
+		if err = current.Reload(ctx); err != nil {
+			return fail("load settings: %v", err)
+		}
+		run, runErr := syncer.RunRetention(ctx, db, current.RetentionBatchSize(), logger)
+		if errors.Is(runErr, syncer.ErrRetentionRunning) {
+			return fail("retention is already running")
+		}
+		if runErr != nil {
+			return fail("retention %s failed: %v", run.ID, runErr)
+		}
+		fmt.Fprintf(os.Stdout, "retention %s: %d checkins deleted, %d anonymised, %d notes cleared\n",
+			run.ID, run.Deleted, run.Anonymised, run.NotesCleared)
+	}
+	if checkinCutoff.IsZero() {
+		return 0
+	}
+	checkins, err := db.PurgeCheckinsBefore(ctx, checkinCutoff)
+	if err != nil {
+		return fail("purge checkins: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "purged %d checkins before %s\n", checkins, formatCommandTime(checkinCutoff))
+	return 0
+}
+
+// runHealth checks what the server needs to start and serve. Config was
+// loaded and validated before any command runs.
+func runHealth(ctx context.Context, cfg config.Config, logger *slog.Logger, _ []string) int {
+	healthy := true
+	report := func(name string, err error) {
+		if err != nil {
+			healthy = false
+			fmt.Fprintf(os.Stdout, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "ok    %s\n", name)
+	}
+	report("config", nil)
+
+	checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	db, err := openCommandStore(checkCtx, cfg, logger)
+	if err == nil {
+		defer db.Close()
+		err = db.Ping(checkCtx)
+	}
+	report("database", err)
+	if err != nil {
+		return 1
+	}
+
+	statuses, err := db.MigrationStatus(checkCtx)
+	if err == nil {
+		var pending, changed int
+		for _, s := range statuses {
+			switch {
+			case !s.Applied:
+				pending++
+			case s.Changed:
+				changed++
+			}
+		}
+		if pending > 0 || changed > 0 {
+			err = fmt.Errorf("%d pending, %d changed since applied", pending, changed)
+		}
+	}
+	report("migrations", err)
+
+	report("settings", settings.NewService(db, logger).Reload(checkCtx))
+
+	stray, err := db.CountUnpartitionedCheckins(checkCtx)
+	if err == nil && stray > 0 {
+		err = fmt.Errorf("%d checkins in months without a partition", stray)
+	}
+	report("checkin partitions", err)
+
+	_, err = newDirectorySource(checkCtx, cfg)
+	report("directory source "+cfg.DirectorySource, err)
+
+	if !healthy {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/settings"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
+)
+
+const partitionsUsage = `usage: signin-ui partitions <command>
+
+commands:
+  status    list monthly checkin partitions
+  maintain  create upcoming partitions and archive old ones now
+`
+
+// runPartitions manages monthly checkin partitions.
+func runPartitions(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, partitionsUsage)
+		return 2
+	}
+	db, err := openCommandStore(ctx, cfg, logger)
+	if err != nil {
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		return printPartitions(ctx, db)
+	case "maintain":
+		current := settings.NewService(db, logger)
+		if err = current.Reload(ctx); err != nil {
+			return fail("load settings: %v", err)
+		}
+		changes, maintainErr := syncer.MaintainPartitions(ctx, db, partitionPolicy(current))
+		for _, name := range changes.Created {
+			fmt.Fprintf(os.Stdout, "created %s\n", name)
+		}
+		for _, name := range changes.Archived {
+			fmt.Fprintf(os.Stdout, "archived %s\n", name)
+		}
+		if errors.Is(maintainErr, syncer.ErrPartitionsBusy) {
+			return fail("partition maintenance is already running")
+		}
+		if maintainErr != nil {
+			return fail("maintain partitions: %v", maintainErr)
+		}
+		if len(changes.Created) == 0 && len(changes.Archived) == 0 {
+			fmt.Fprintln(os.Stdout, "partitions are up to date")
+		}
+		return 0
+	}
+	fmt.Fprint(os.Stderr, partitionsUsage)
+	return 2
+}
+
+func printPartitions(ctx context.Context, db *store.Store) int {
+	partitions, err := db.ListCheckinPartitions(ctx)
+	if err != nil {
+		return fail("list partitions: %v", err)
+	}
+	stray, err := db.CountUnpartitionedCheckins(ctx)
+	if err != nil {
+		return fail("count unpartitioned checkins: %v", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tMONTH\tSTATUS\tROWS (EST.)")
+	for _, p := range partitions {
+		state := "attached"
+		if p.Archived {
+			state = "archived"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", p.Name, p.Month.Format("2006-01"), state, p.EstimatedRows)
+	}
+	if err = tw.Flush(); err != nil {
+		return fail("list partitions: %v", err)
+	}
+	if stray > 0 {
+		fmt.Fprintf(os.Stdout, "%d checkins are in the default partition; run partitions maintain\n", stray)
+	}
+	return 0
+}
@@ -3,32 +3,117 @@ package auth
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 )
 
+// OIDCOptions configure a single OIDC provider.
+type OIDCOptions struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	LoginClaims  []string
+}
+
 // OIDCProvider bundles the OAuth client and ID token verifier.
 type OIDCProvider struct {
-	verifier *oidc.IDTokenVerifier
-	oauth    *oauth2.Config
+	name        string
+	displayName string
+	loginClaims []string
+	verifier    *oidc.IDTokenVerifier
+	oauth       *oauth2.Config
 }
 
 // NewOIDCProvider prepares the OAuth config and verifier.
-func NewOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, siteBaseURL string) (*OIDCProvider, error) {
-	provider, err := oidc.NewProvider(ctx, issuer)
+func NewOIDCProvider(ctx context.Context, opts OIDCOptions) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, opts.Issuer)
 	if err != nil {
-		return nil, fmt.Errorf("discover oidc provider: %w", err)
+		return nil, fmt.Errorf("discover oidc provider %s: %w", opts.Name, err)
+	}
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
 	}
 	config := &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  siteBaseURL + "/api/auth/callback",
+		ClientID:     opts.ClientID,
+		ClientSecret: opts.ClientSecret,
+		RedirectURL:  opts.RedirectURL,
 		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: opts.ClientID})
+	displayName := opts.DisplayName
+	if displayName == "" {
+		displayName = opts.Name
+	}
+	return &OIDCProvider{
+		name:        opts.Name,
+		displayName: displayName,
+		loginClaims: opts.LoginClaims,
+		verifier:    verifier,
+		oauth:       config,
+	}, nil
+}
+
+// Name returns the provider identifier.
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// DisplayName returns the login button label.
+func (p *OIDCProvider) DisplayName() string {
+	return p.displayName
+}
+
+// Type reports the provider protocol.
+func (p *OIDCProvider) Type() string {
+	return "oidc"
+}
+
+// LoginURL builds the authorization URL.
+func (p *OIDCProvider) LoginURL(state, nonce string) (string, error) {
+	return p.AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Complete exchanges the code and verifies the ID token.
+func (p *OIDCProvider) Complete(ctx context.Context, r *http.Request, state, nonce string) (Identity, error) {
+	if r.URL.Query().Get("state") != state {
+		return Identity{}, fmt.Errorf("%w: state mismatch", ErrLoginFailed)
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return Identity{}, fmt.Errorf("%w: authorization code missing", ErrLoginFailed)
+	}
+	token, err := p.Exchange(ctx, code)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: oidc exchange: %w", ErrLoginFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: id token missing", ErrLoginFailed)
+	}
+	idToken, err := p.VerifyIDToken(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: verify id token: %w", ErrLoginFailed, err)
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", ErrLoginFailed)
+	}
+	claims := map[string]any{}
+	if err = idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: decode claims: %w", ErrLoginFailed, err)
 	}
-	verifier := provider.Verifier(&oidc.Config{ClientID: clientID})
-	return &OIDCProvider{verifier: verifier, oauth: config}, nil
+	return Identity{
+		Subject: idToken.Subject,
+		Login:   resolveLogin(claims, p.loginClaims, idToken.Subject),
+		Claims:  claims,
+	}, nil
 }
 
 // AuthCodeURL builds the login URL.
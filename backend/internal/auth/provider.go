@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrLoginFailed wraps provider-side sign-in failures.
+var ErrLoginFailed = errors.New("auth: login failed")
+
+// Identity is what a provider learned about the signed-in user.
+type Identity struct {
+	Subject string
+	Login   string
+	Claims  map[string]any
+}
+
+// Provider is a named external login method (OIDC or SAML).
+type Provider interface {
+	// Name is the stable identifier used in URLs and sessions.
+	Name() string
+	// DisplayName is the label shown on the login button.
+	DisplayName() string
+	// Type reports "oidc" or "saml".
+	Type() string
+	// LoginURL builds the IdP redirect for the given state and nonce.
+	LoginURL(state, nonce string) (string, error)
+	// Complete validates the IdP response and returns the identity.
+	Complete(ctx context.Context, r *http.Request, state, nonce string) (Identity, error)
+}
+
+// Providers is the ordered set of configured login providers.
+type Providers struct {
+	list   []Provider
+	byName map[string]Provider
+}
+
+// NewProviders indexes providers by name, rejecting duplicates.
+func NewProviders(list ...Provider) (*Providers, error) {
+	p := &Providers{byName: make(map[string]Provider, len(list))}
+	for _, provider := range list {
+		if provider == nil {
+			continue
+		}
+		if _, exists := p.byName[provider.Name()]; exists {
+			return nil, fmt.Errorf("duplicate auth provider %q", provider.Name())
+		}
+		p.byName[provider.Name()] = provider
+		p.list = append(p.list, provider)
+	}
+	return p, nil
+}
+
+// Get looks up a provider by name.
+func (p *Providers) Get(name string) (Provider, bool) {
+	if p == nil {
+		return nil, false
+	}
+	provider, ok := p.byName[name]
+	return provider, ok
+}
+
+// Default returns the first configured provider.
+func (p *Providers) Default() (Provider, bool) {
+	if p == nil || len(p.list) == 0 {
+		return nil, false
+	}
+	return p.list[0], true
+}
+
+// All returns providers in configuration order.
+func (p *Providers) All() []Provider {
+	if p == nil {
+		return nil
+	}
+	return p.list
+}
+
+// Len reports how many providers are configured.
+func (p *Providers) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.list)
+}
+
+// resolveLogin picks the first non-empty claim from the mapping.
+func resolveLogin(claims map[string]any, mapping []string, fallback string) string {
+	for _, key := range mapping {
+		if val, ok := claims[strings.TrimSpace(key)].(string); ok {
+			if trimmed := strings.TrimSpace(val); trimmed != "" {
+				return trimmed
+			}
+		}
+	}
+	return strings.TrimSpace(fallback)
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+const (
+	samlMetadataTimeout = 15 * time.Second
+	maxSAMLMetadataSize = 1 << 20
+	samlRequestIDPrefix = "id-"
+	samlNameIDClaim     = "nameid"
+)
+
+// SAMLOptions configure a single SAML 2.0 provider.
+type SAMLOptions struct {
+	Name        string
+	DisplayName string
+	MetadataURL string
+	EntityID    string
+	AcsURL      string
+	SPMetadata  string
+	CertFile    string
+	KeyFile     string
+	LoginClaims []string
+}
+
+// SAMLProvider is a SAML 2.0 service provider for one IdP.
+type SAMLProvider struct {
+	name        string
+	displayName string
+	loginClaims []string
+	sp          *saml.ServiceProvider
+}
+
+// NewSAMLProvider fetches IdP metadata and prepares the service provider.
+func NewSAMLProvider(ctx context.Context, opts SAMLOptions) (*SAMLProvider, error) {
+	idpMetadata, err := fetchSAMLMetadata(ctx, opts.MetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("saml provider %s: %w", opts.Name, err)
+	}
+	acsURL, err := url.Parse(opts.AcsURL)
+	if err != nil {
+		return nil, fmt.Errorf("saml provider %s: acs url: %w", opts.Name, err)
+	}
+	metadataURL, err := url.Parse(opts.SPMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("saml provider %s: metadata url: %w", opts.Name, err)
+	}
+	sp := &saml.ServiceProvider{
+		EntityID:          opts.EntityID,
+		AcsURL:            *acsURL,
+		MetadataURL:       *metadataURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+	if opts.CertFile != "" && opts.KeyFile != "" {
+		keyPair, loadErr := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if loadErr != nil {
+			return nil, fmt.Errorf("saml provider %s: load key pair: %w", opts.Name, loadErr)
+		}
+		key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("saml provider %s: key must be RSA", opts.Name)
+		}
+		sp.Key = key
+		sp.Certificate = keyPair.Leaf
+	}
+	displayName := opts.DisplayName
+	if displayName == "" {
+		displayName = opts.Name
+	}
+	return &SAMLProvider{
+		name:        opts.Name,
+		displayName: displayName,
+		loginClaims: opts.LoginClaims,
+		sp:          sp,
+	}, nil
+}
+
+// Name returns the provider identifier.
+func (p *SAMLProvider) Name() string {
+	return p.name
+}
+
+// DisplayName returns the login button label.
+func (p *SAMLProvider) DisplayName() string {
+	return p.displayName
+}
+
+// Type reports the provider protocol.
+func (p *SAMLProvider) Type() string {
+	return "saml"
+}
+
+// LoginURL builds an HTTP-Redirect AuthnRequest whose ID is tied to the nonce.
+func (p *SAMLProvider) LoginURL(state, nonce string) (string, error) {
+	req, err := p.sp.MakeAuthenticationRequest(
+		p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return "", err
+	}
+	req.ID = samlRequestIDPrefix + nonce
+	redirect, err := req.Redirect(url.QueryEscape(state), p.sp)
+	if err != nil {
+		return "", err
+	}
+	return redirect.String(), nil
+}
+
+// Complete validates the posted SAML response and maps its attributes.
+func (p *SAMLProvider) Complete(_ context.Context, r *http.Request, state, nonce string) (Identity, error) {
+	if err := r.ParseForm(); err != nil {
+		return Identity{}, fmt.Errorf("%w: parse form: %w", ErrLoginFailed, err)
+	}
+	if r.PostForm.Get("RelayState") != state {
+		return Identity{}, fmt.Errorf("%w: relay state mismatch", ErrLoginFailed)
+	}
+	assertion, err := p.sp.ParseResponse(r, []string{samlRequestIDPrefix + nonce})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return Identity{}, fmt.Errorf("%w: saml response: %w", ErrLoginFailed, err)
+	}
+	claims := samlClaims(assertion)
+	subject, _ := claims[samlNameIDClaim].(string)
+	return Identity{
+		Subject: subject,
+		Login:   resolveLogin(claims, p.loginClaims, subject),
+		Claims:  claims,
+	}, nil
+}
+
+// Metadata returns the SP metadata document for IdP registration.
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+}
+
+// samlClaims flattens assertion attributes to first values by name and friendly name.
+func samlClaims(assertion *saml.Assertion) map[string]any {
+	claims := make(map[string]any)
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		claims[samlNameIDClaim] = assertion.Subject.NameID.Value
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if len(attr.Values) == 0 {
+				continue
+			}
+			value := attr.Values[0].Value
+			if attr.Name != "" {
+				claims[attr.Name] = value
+			}
+			if attr.FriendlyName != "" {
+				claims[attr.FriendlyName] = value
+			}
+		}
+	}
+	return claims
+}
+
+// fetchSAMLMetadata downloads IdP metadata, unwrapping an EntitiesDescriptor.
+func fetchSAMLMetadata(ctx context.Context, metadataURL string) (*saml.EntityDescriptor, error) {
+	ctx, cancel := context.WithTimeout(ctx, samlMetadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("metadata request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch metadata: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSAMLMetadataSize))
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	entity := &saml.EntityDescriptor{}
+	if err = xml.Unmarshal(data, entity); err == nil {
+		return entity, nil
+	}
+	entities := &saml.EntitiesDescriptor{}
+	if entitiesErr := xml.Unmarshal(data, entities); entitiesErr != nil {
+		return nil, fmt.Errorf("parse metadata: %w", err)
+	}
+	for i, e := range entities.EntityDescriptors {
+		if len(e.IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("parse metadata: no IdP entity found")
+}
@@ -15,7 +15,6 @@ import (
 var ErrInvalidSession = errors.New("auth: invalid session")
 
 const (
-	defaultSessionTTL   = 8 * time.Hour
 	minSessionSecretLen = 32
 	signedTokenParts    = 2
 )
@@ -32,19 +31,20 @@ type Session struct {
 type SessionManager struct {
 	name   string
 	secret []byte
-	maxAge time.Duration
+	ttl    func() time.Duration
 	secure bool
 }
 
-// NewSessionManager builds a manager from cookie settings.
-func NewSessionManager(cookieName, secret string, secure bool) (*SessionManager, error) {
+// NewSessionManager builds a manager from cookie settings. ttl is read on
+// each sign-in so changes apply without a restart.
+func NewSessionManager(cookieName, secret string, secure bool, ttl func() time.Duration) (*SessionManager, error) {
 	if len(secret) < minSessionSecretLen {
 		return nil, errors.New("session secret must be at least 32 bytes")
 	}
 	return &SessionManager{
 		name:   cookieName,
 		secret: []byte(secret),
-		maxAge: defaultSessionTTL,
+		ttl:    ttl,
 		secure: secure,
 	}, nil
 }
@@ -58,7 +58,7 @@ func (m *SessionManager) Issue(w http.ResponseWriter, session Session) error {
 		session.IssuedAt = time.Now().UTC()
 	}
 	if session.ExpiresAt.IsZero() {
-		session.ExpiresAt = session.IssuedAt.Add(m.maxAge)
+		session.ExpiresAt = session.IssuedAt.Add(m.ttl())
 	}
 	payload, err := json.Marshal(session)
 	if err != nil {
@@ -0,0 +1,43 @@
+package branding
+
+import "sync"
+
+// maxCacheEntries bounds the resized images kept in memory.
+const maxCacheEntries = 64
+
+// Image is an encoded image ready to serve.
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+// Cache keeps resized images so each size is only scaled once. Keys should
+// include the source etag, so replaced images are never served stale.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]Image
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]Image)}
+}
+
+// Get returns a cached image.
+func (c *Cache) Get(key string) (Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, ok := c.entries[key]
+	return img, ok
+}
+
+// Put stores an image, dropping everything once the cache is full; resizes
+// are cheap enough to redo now and then.
+func (c *Cache) Put(key string, img Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCacheEntries {
+		clear(c.entries)
+	}
+	c.entries[key] = img
+}
@@ -0,0 +1,96 @@
+// Package branding validates, resizes and caches portal branding images.
+package branding
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"slices"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the WebP decoder
+)
+
+// ErrUnsupportedImage means an upload is not a usable JPEG, PNG or WebP image.
+var ErrUnsupportedImage = errors.New("branding: image must be a JPEG, PNG or WebP")
+
+// ImageTypes are the accepted upload content types.
+var ImageTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+// KioskWidths are the widths images are resized to, covering common kiosk
+// and tablet displays.
+var KioskWidths = []int{720, 1080, 1280, 1920, 2560, 3840}
+
+// maxImagePixels guards against decompression bombs.
+const maxImagePixels = 50_000_000
+
+// jpegQuality is used for resized JPEG output.
+const jpegQuality = 85
+
+// DetectImage sniffs an upload's content type and checks it decodes.
+func DetectImage(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(ImageTypes, contentType) {
+		return "", ErrUnsupportedImage
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return "", fmt.Errorf("%w: %dx%d is too large", ErrUnsupportedImage, cfg.Width, cfg.Height)
+	}
+	return contentType, nil
+}
+
+// ETag returns a short content hash for image bytes.
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// FitWidth rounds a requested width up to the nearest kiosk width, capped at
+// the largest.
+func FitWidth(requested int) int {
+	for _, width := range KioskWidths {
+		if width >= requested {
+			return width
+		}
+	}
+	return KioskWidths[len(KioskWidths)-1]
+}
+
+// Resize scales an image down to width, keeping its aspect ratio. Images no
+// wider than width are returned as they are. PNGs stay PNG to keep their
+// transparency; everything else becomes JPEG.
+func Resize(data []byte, contentType string, width int) ([]byte, string, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return data, contentType, nil
+	}
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&buf, dst)
+	} else {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), contentType, nil
+}
@@ -6,33 +6,118 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/google/uuid"
+)
+
+// Login provider types.
+const (
+	ProviderTypeOIDC = "oidc"
+	ProviderTypeSAML = "saml"
+
+	legacyProviderName = "oidc"
+)
+
+// Directory source types.
+const (
+	DirectorySourceGraph = "graph"
+	DirectorySourceLDAP  = "ldap"
+	DirectorySourceFile  = "file"
 )
 
 // Config holds runtime settings loaded from env.
 type Config struct {
-	ListenAddr           string        `env:"LISTEN_ADDR"                       envDefault:":8080"`
-	DatabaseHost         string        `env:"DATABASE_HOST,required"`
-	DatabasePort         string        `env:"DATABASE_PORT"                     envDefault:"5432"`
-	DatabaseName         string        `env:"DATABASE_NAME,required"`
-	DatabaseUser         string        `env:"DATABASE_USER,required"`
-	DatabasePassword     string        `env:"DATABASE_PASSWORD,required"`
-	DatabaseSSLMode      string        `env:"DATABASE_SSLMODE"                  envDefault:"disable"`
-	MaxConnLifetime      time.Duration `env:"DB_MAX_CONN_LIFETIME"              envDefault:"30m"`
-	MaxConnections       int32         `env:"DB_MAX_CONNECTIONS"                envDefault:"10"`
-	MinConnections       int32         `env:"DB_MIN_CONNECTIONS"                envDefault:"2"`
-	AdminIssuer          string        `env:"ADMIN_OIDC_ISSUER,required"`
-	AdminClientID        string        `env:"ADMIN_OIDC_CLIENT_ID,required"`
-	AdminClientSecret    string        `env:"ADMIN_OIDC_CLIENT_SECRET,required"`
-	SessionSecret        string        `env:"SESSION_SECRET,required"`
-	SessionCookieName    string        `env:"SESSION_COOKIE_NAME"               envDefault:"signin-ui_session"`
-	InitialAdminPassword string        `env:"INITIAL_ADMIN_PASSWORD"`
-	SyncCron             string        `env:"SYNC_CRON"                         envDefault:"@every 5m"`
-	SiteBaseURL          string        `env:"SITE_BASE_URL,required"`
-	GraphTenantID        string        `env:"GRAPH_TENANT_ID"`
-	GraphClientID        string        `env:"GRAPH_CLIENT_ID"`
-	GraphClientSecret    string        `env:"GRAPH_CLIENT_SECRET"`
-	LogLevel             string        `env:"LOG_LEVEL"                         envDefault:"info"`
-	FrontendDistDir      string        `env:"FRONTEND_DIST_DIR"`
+	ListenAddr            string            `env:"LISTEN_ADDR"                       envDefault:":8080"`
+	DatabaseHost          string            `env:"DATABASE_HOST,required"`
+	DatabasePort          string            `env:"DATABASE_PORT"                     envDefault:"5432"`
+	DatabaseName          string            `env:"DATABASE_NAME,required"`
+	DatabaseUser          string            `env:"DATABASE_USER,required"`
+	DatabasePassword      string            `env:"DATABASE_PASSWORD,required"`
+	DatabaseSSLMode       string            `env:"DATABASE_SSLMODE"                  envDefault:"disable"`
+	MaxConnLifetime       time.Duration     `env:"DB_MAX_CONN_LIFETIME"              envDefault:"30m"`
+	MaxConnections        int32             `env:"DB_MAX_CONNECTIONS"                envDefault:"10"`
+	MinConnections        int32             `env:"DB_MIN_CONNECTIONS"                envDefault:"2"`
+	MigrateOnStart        bool              `env:"MIGRATE_ON_START"                  envDefault:"true"`
+	AdminIssuer           string            `env:"ADMIN_OIDC_ISSUER"`
+	AdminClientID         string            `env:"ADMIN_OIDC_CLIENT_ID"`
+	AdminClientSecret     string            `env:"ADMIN_OIDC_CLIENT_SECRET"`
+	AdminAllowedDomains   []string          `env:"ADMIN_OIDC_ALLOWED_DOMAINS"`
+	AdminJIT              JITPolicy         `envPrefix:"ADMIN_OIDC_JIT_"`
+	AuthProviders         []AuthProvider    `envPrefix:"AUTH_PROVIDERS"`
+	SessionSecret         string            `env:"SESSION_SECRET,required"`
+	SessionCookieName     string            `env:"SESSION_COOKIE_NAME"               envDefault:"signin-ui_session"`
+	InitialAdminPassword  string            `env:"INITIAL_ADMIN_PASSWORD"`
+	DirectorySource       string            `env:"DIRECTORY_SOURCE"                  envDefault:"graph"`
+	DirectoryFilePath     string            `env:"DIRECTORY_FILE_PATH"`
+	SCIMToken             string            `env:"SCIM_TOKEN"`
+	LDAP                  LDAPConfig        `envPrefix:"LDAP_"`
+	SyncCron              string            `env:"SYNC_CRON"                         envDefault:"@every 5m"`
+	SyncFullInterval      time.Duration     `env:"SYNC_FULL_INTERVAL"                envDefault:"24h"`
+	SyncUserGroupIDs      []string          `env:"SYNC_USER_GROUP_IDS"`
+	SyncUserAdminUnitIDs  []string          `env:"SYNC_USER_ADMIN_UNIT_IDS"`
+	SyncUserFilter        string            `env:"SYNC_USER_FILTER"`
+	SyncGroupFilter       string            `env:"SYNC_GROUP_FILTER"`
+	SyncUserAttributes    map[string]string `env:"SYNC_USER_ATTRIBUTES"              envKeyValSeparator:"="`
+	ArchivedUserRetention time.Duration     `env:"ARCHIVED_USER_RETENTION"           envDefault:"8760h"`
+	RetentionCron         string            `env:"RETENTION_CRON"                    envDefault:"0 3 * * *"`
+	PartitionCron         string            `env:"PARTITION_CRON"                    envDefault:"30 2 * * *"`
+	SiteBaseURL           string            `env:"SITE_BASE_URL,required"`
+	GraphTenantID         string            `env:"GRAPH_TENANT_ID"`
+	GraphClientID         string            `env:"GRAPH_CLIENT_ID"`
+	GraphClientSecret     string            `env:"GRAPH_CLIENT_SECRET"`
+	LogLevel              string            `env:"LOG_LEVEL"                         envDefault:"info"`
+	FrontendDistDir       string            `env:"FRONTEND_DIST_DIR"`
+}
+
+// AuthProvider describes one named login provider, read from
+// AUTH_PROVIDERS_<n>_* variables. A provider reaches only the users it
+// linked or provisioned itself unless MatchExisting lets it link to the
+// existing user with the same login on first sign-in. AllowedDomains limits
+// it to logins in those domains.
+type AuthProvider struct {
+	Name            string    `env:"NAME"`
+	DisplayName     string    `env:"DISPLAY_NAME"`
+	Type            string    `env:"TYPE"              envDefault:"oidc"`
+	LoginClaims     []string  `env:"LOGIN_CLAIMS"      envDefault:"upn,preferred_username,email"`
+	Issuer          string    `env:"ISSUER"`
+	ClientID        string    `env:"CLIENT_ID"`
+	ClientSecret    string    `env:"CLIENT_SECRET"`
+	Scopes          []string  `env:"SCOPES"`
+	SAMLMetadataURL string    `env:"SAML_METADATA_URL"`
+	SAMLEntityID    string    `env:"SAML_ENTITY_ID"`
+	SAMLCertFile    string    `env:"SAML_CERT_FILE"`
+	SAMLKeyFile     string    `env:"SAML_KEY_FILE"`
+	MatchExisting   bool      `env:"MATCH_EXISTING"`
+	AllowedDomains  []string  `env:"ALLOWED_DOMAINS"`
+	JIT             JITPolicy `envPrefix:"JIT_"`
+}
+
+// LDAPConfig describes the LDAP or Active Directory source, read from LDAP_*
+// variables. Empty attribute names use the Active Directory defaults.
+type LDAPConfig struct {
+	URL                  string `env:"URL"`
+	BindDN               string `env:"BIND_DN"`
+	BindPassword         string `env:"BIND_PASSWORD"`
+	BaseDN               string `env:"BASE_DN"`
+	StartTLS             bool   `env:"START_TLS"`
+	UserFilter           string `env:"USER_FILTER"`
+	GroupFilter          string `env:"GROUP_FILTER"`
+	IDAttribute          string `env:"ID_ATTRIBUTE"`
+	UPNAttribute         string `env:"UPN_ATTRIBUTE"`
+	DisplayNameAttribute string `env:"DISPLAY_NAME_ATTRIBUTE"`
+	DepartmentAttribute  string `env:"DEPARTMENT_ATTRIBUTE"`
+	EmployeeIDAttribute  string `env:"EMPLOYEE_ID_ATTRIBUTE"`
+	JobTitleAttribute    string `env:"JOB_TITLE_ATTRIBUTE"`
+}
+
+// JITPolicy controls just-in-time user provisioning at login.
+type JITPolicy struct {
+	Enabled            bool              `env:"ENABLED"`
+	AllowedDomains     []string          `env:"ALLOWED_DOMAINS"`
+	RequiredClaims     map[string]string `env:"REQUIRED_CLAIMS"      envKeyValSeparator:"="`
+	ObjectIDClaim      string            `env:"OBJECT_ID_CLAIM"      envDefault:"oid"`
+	DisplayNameClaim   string            `env:"DISPLAY_NAME_CLAIM"   envDefault:"name"`
+	DepartmentClaim    string            `env:"DEPARTMENT_CLAIM"`
+	DefaultLocationIDs []string          `env:"DEFAULT_LOCATION_IDS"`
 }
 
 // Load populates Config from environment variables.
@@ -41,9 +126,75 @@ func Load() (Config, error) {
 	if err := env.Parse(&cfg); err != nil {
 		return Config{}, fmt.Errorf("parse config: %w", err)
 	}
+	if err := cfg.validateAuthProviders(); err != nil {
+		return Config{}, fmt.Errorf("parse config: %w", err)
+	}
+	switch cfg.DirectorySource {
+	case DirectorySourceGraph, DirectorySourceLDAP, DirectorySourceFile:
+	default:
+		return Config{}, fmt.Errorf("parse config: unknown directory source %q", cfg.DirectorySource)
+	}
 	return cfg, nil
 }
 
+// LoginProviders returns every configured login provider. The legacy
+// ADMIN_OIDC_* settings come first as the "oidc" provider.
+func (c Config) LoginProviders() []AuthProvider {
+	var providers []AuthProvider
+	if c.AdminIssuer != "" {
+		providers = append(providers, AuthProvider{
+			Name:         legacyProviderName,
+			DisplayName:  "OAuth",
+			Type:         ProviderTypeOIDC,
+			LoginClaims:  defaultLoginClaims(),
+			Issuer:       c.AdminIssuer,
+			ClientID:     c.AdminClientID,
+			ClientSecret: c.AdminClientSecret,
+			// The legacy provider is the directory's own IdP, so its logins
+			// are the directory's UPNs.
+			MatchExisting:  true,
+			AllowedDomains: c.AdminAllowedDomains,
+			JIT:            c.AdminJIT,
+		})
+	}
+	return append(providers, c.AuthProviders...)
+}
+
+func (c Config) validateAuthProviders() error {
+	seen := make(map[string]struct{})
+	for i, p := range c.LoginProviders() {
+		if p.Name == "" {
+			return fmt.Errorf("auth provider %d: name is required", i)
+		}
+		if _, dup := seen[p.Name]; dup {
+			return fmt.Errorf("auth provider %q: duplicate name", p.Name)
+		}
+		seen[p.Name] = struct{}{}
+		switch p.Type {
+		case ProviderTypeOIDC:
+			if p.Issuer == "" || p.ClientID == "" {
+				return fmt.Errorf("auth provider %q: issuer and client id are required", p.Name)
+			}
+		case ProviderTypeSAML:
+			if p.SAMLMetadataURL == "" {
+				return fmt.Errorf("auth provider %q: saml metadata url is required", p.Name)
+			}
+		default:
+			return fmt.Errorf("auth provider %q: unknown type %q", p.Name, p.Type)
+		}
+		for _, id := range p.JIT.DefaultLocationIDs {
+			if _, err := uuid.Parse(id); err != nil {
+				return fmt.Errorf("auth provider %q: invalid jit default location %q", p.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+func defaultLoginClaims() []string {
+	return []string{"upn", "preferred_username", "email"}
+}
+
 // DatabaseURL builds the Postgres DSN.
 func (c Config) DatabaseURL() string {
 	hostPort := net.JoinHostPort(c.DatabaseHost, c.DatabasePort)
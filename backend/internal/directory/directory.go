@@ -0,0 +1,84 @@
+// Package directory defines the user and group sources the syncer reads from.
+package directory
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCursorExpired means a stored cursor is no longer accepted and the
+// caller should start over with a full listing.
+var ErrCursorExpired = errors.New("directory: cursor expired")
+
+// User mirrors a user pulled from a directory.
+type User struct {
+	ObjectID    string
+	UPN         string
+	DisplayName string
+	Department  string
+	// Active is nil when the source did not report whether the account is
+	// enabled.
+	Active     *bool
+	EmployeeID string
+	JobTitle   string
+	// ManagerID is nil when the round did not report the manager and "" when
+	// the user has none.
+	ManagerID  *string
+	Attributes map[string]string
+	// Partial is set on change items that carry only the properties that
+	// changed; empty fields keep their stored values.
+	Partial bool
+}
+
+// Group holds a directory group and the object IDs of its user members,
+// including nested ones.
+type Group struct {
+	ObjectID    string
+	DisplayName string
+	Description string
+	Members     []string
+}
+
+// UserChanges holds user changes since a cursor. Full is set when every
+// user was listed, so users not returned have left the directory.
+type UserChanges struct {
+	Users   []User
+	Removed []string
+	Cursor  string
+	Full    bool
+}
+
+// GroupChanges holds group changes since a cursor, like UserChanges.
+type GroupChanges struct {
+	Groups  []Group
+	Removed []string
+	Cursor  string
+	Full    bool
+}
+
+// Source is a directory the syncer reads users and groups from. An empty
+// cursor requests a full listing; sources without change tracking may
+// always return one.
+type Source interface {
+	// Name identifies the source on synced rows and sync state.
+	Name() string
+	// Enabled reports whether the source is configured.
+	Enabled() bool
+	// Fingerprint changes when the source configuration changes in a way that
+	// invalidates stored cursors.
+	Fingerprint() string
+	FetchUsers(ctx context.Context, cursor string) (UserChanges, error)
+	FetchGroups(ctx context.Context, cursor string) (GroupChanges, error)
+}
+
+// Scoped is implemented by sources that restrict the synced users and groups
+// beyond what their listings return, such as by group membership.
+type Scoped interface {
+	// UserScope returns the object IDs of users in scope; restricted is false
+	// when every user is.
+	UserScope(ctx context.Context) (scope map[string]struct{}, restricted bool, err error)
+	// GroupScope returns the object IDs of groups in scope, like UserScope.
+	GroupScope(ctx context.Context) (scope map[string]struct{}, restricted bool, err error)
+	FetchUser(ctx context.Context, objectID string) (User, error)
+	FetchGroup(ctx context.Context, objectID string) (Group, error)
+}
@@ -0,0 +1,301 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileSourceName identifies CSV and JSON imports on synced rows.
+const FileSourceName = "file"
+
+// ErrInvalidImport means an import file could not be parsed. Imports listing
+// no users are rejected too, so a truncated export cannot archive everyone.
+var ErrInvalidImport = errors.New("directory: invalid import")
+
+// Known CSV columns; any other column becomes a user attribute.
+const (
+	columnID          = "id"
+	columnUPN         = "upn"
+	columnDisplayName = "displayname"
+	columnDepartment  = "department"
+	columnEmployeeID  = "employeeid"
+	columnJobTitle    = "jobtitle"
+	columnManager     = "manager"
+	columnActive      = "active"
+	columnGroups      = "groups"
+)
+
+// FileSource reads users and groups from a CSV or JSON export, such as one
+// from a student information system. The path is watched by content: the
+// cursor is a hash of the file, so unchanged files are skipped.
+//
+// CSV files need a header row with id and upn columns. The groups column
+// lists group names separated by semicolons. JSON files hold
+// {"users": [...], "groups": [...]} using the same field names.
+type FileSource struct {
+	path string
+}
+
+var _ Source = (*FileSource)(nil)
+
+// NewFileSource reads the file at path; a .json extension selects JSON.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+// Name returns FileSourceName.
+func (s *FileSource) Name() string {
+	return FileSourceName
+}
+
+// Enabled reports whether a path is configured.
+func (s *FileSource) Enabled() bool {
+	return s.path != ""
+}
+
+// Fingerprint is the watched path.
+func (s *FileSource) Fingerprint() string {
+	return s.path
+}
+
+// FetchUsers lists every user when the file changed since cursor.
+func (s *FileSource) FetchUsers(_ context.Context, cursor string) (UserChanges, error) {
+	export, hash, err := s.read(cursor)
+	if err != nil || export == nil {
+		return UserChanges{Cursor: cursor}, err
+	}
+	return UserChanges{Users: export.users(), Cursor: hash, Full: true}, nil
+}
+
+// FetchGroups lists every group when the file changed since cursor.
+func (s *FileSource) FetchGroups(_ context.Context, cursor string) (GroupChanges, error) {
+	export, hash, err := s.read(cursor)
+	if err != nil || export == nil {
+		return GroupChanges{Cursor: cursor}, err
+	}
+	return GroupChanges{Groups: export.groups(), Cursor: hash, Full: true}, nil
+}
+
+// Replace validates data in the source's format and atomically swaps it in,
+// as used for uploads.
+func (s *FileSource) Replace(data []byte) error {
+	if !s.Enabled() {
+		return errors.New("directory: file source not configured")
+	}
+	if _, err := parseExport(data, s.isJSON()); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".import-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write import: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("write import: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace import: %w", err)
+	}
+	return nil
+}
+
+// read parses the file, returning a nil export when its hash equals cursor.
+func (s *FileSource) read(cursor string) (*fileExport, string, error) {
+	if !s.Enabled() {
+		return nil, "", errors.New("directory: file source not configured")
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read import: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if hash == cursor {
+		return nil, hash, nil
+	}
+	export, err := parseExport(data, s.isJSON())
+	if err != nil {
+		return nil, "", err
+	}
+	return export, hash, nil
+}
+
+func (s *FileSource) isJSON() bool {
+	return strings.EqualFold(filepath.Ext(s.path), ".json")
+}
+
+// fileExport is the parsed import. The JSON form is decoded into it directly.
+type fileExport struct {
+	Users  []fileUser  `json:"users"`
+	Groups []fileGroup `json:"groups"`
+}
+
+type fileUser struct {
+	ID          string            `json:"id"`
+	UPN         string            `json:"upn"`
+	DisplayName string            `json:"displayName"`
+	Department  string            `json:"department"`
+	EmployeeID  string            `json:"employeeId"`
+	JobTitle    string            `json:"jobTitle"`
+	Manager     string            `json:"manager"`
+	Active      *bool             `json:"active"`
+	Groups      []string          `json:"groups"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+type fileGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+}
+
+func parseExport(data []byte, isJSON bool) (*fileExport, error) {
+	var export *fileExport
+	var err error
+	if isJSON {
+		export = &fileExport{}
+		err = json.Unmarshal(data, export)
+	} else {
+		export, err = parseCSV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	if len(export.Users) == 0 {
+		return nil, fmt.Errorf("%w: no users", ErrInvalidImport)
+	}
+	for i, u := range export.Users {
+		if u.ID == "" || u.UPN == "" {
+			return nil, fmt.Errorf("%w: user %d: id and upn are required", ErrInvalidImport, i+1)
+		}
+	}
+	return export, nil
+}
+
+func parseCSV(data []byte) (*fileExport, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = strings.TrimSpace(name)
+	}
+	export := &fileExport{}
+	for {
+		record, readErr := reader.Read()
+		if errors.Is(readErr, io.EOF) {
+			return export, nil
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		u := fileUser{Attributes: map[string]string{}}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(columns[i]) {
+			case columnID:
+				u.ID = value
+			case columnUPN:
+				u.UPN = value
+			case columnDisplayName:
+				u.DisplayName = value
+			case columnDepartment:
+				u.Department = value
+			case columnEmployeeID:
+				u.EmployeeID = value
+			case columnJobTitle:
+				u.JobTitle = value
+			case columnManager:
+				u.Manager = value
+			case columnActive:
+				if active, parseErr := strconv.ParseBool(value); parseErr == nil {
+					u.Active = &active
+				}
+			case columnGroups:
+				for _, group := range strings.Split(value, ";") {
+					if group = strings.TrimSpace(group); group != "" {
+						u.Groups = append(u.Groups, group)
+					}
+				}
+			default:
+				if value != "" {
+					u.Attributes[columns[i]] = value
+				}
+			}
+		}
+		export.Users = append(export.Users, u)
+	}
+}
+
+func (e *fileExport) users() []User {
+	users := make([]User, 0, len(e.Users))
+	for _, u := range e.Users {
+		manager := u.Manager
+		active := u.Active == nil || *u.Active
+		displayName := u.DisplayName
+		if displayName == "" {
+			displayName = u.UPN
+		}
+		users = append(users, User{
+			ObjectID:    u.ID,
+			UPN:         u.UPN,
+			DisplayName: displayName,
+			Department:  u.Department,
+			Active:      &active,
+			EmployeeID:  u.EmployeeID,
+			JobTitle:    u.JobTitle,
+			ManagerID:   &manager,
+			Attributes:  u.Attributes,
+		})
+	}
+	return users
+}
+
+// groups lists the declared groups plus any only named on users, matching
+// user group references against group IDs and display names.
+func (e *fileExport) groups() []Group {
+	groups := make([]Group, 0, len(e.Groups))
+	index := make(map[string]int, len(e.Groups))
+	for _, g := range e.Groups {
+		if g.ID == "" {
+			continue
+		}
+		name := g.DisplayName
+		if name == "" {
+			name = g.ID
+		}
+		index[g.ID] = len(groups)
+		index[name] = len(groups)
+		groups = append(groups, Group{ObjectID: g.ID, DisplayName: name, Description: g.Description})
+	}
+	for _, u := range e.Users {
+		for _, ref := range u.Groups {
+			i, ok := index[ref]
+			if !ok {
+				i = len(groups)
+				index[ref] = i
+				groups = append(groups, Group{ObjectID: ref, DisplayName: ref})
+			}
+			groups[i].Members = append(groups[i].Members, u.ID)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,316 @@
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// LDAPSourceName identifies LDAP and Active Directory on synced rows.
+const LDAPSourceName = "ldap"
+
+const (
+	ldapPageSize     = 500
+	ldapDialTimeout  = 10 * time.Second
+	ldapQueryTimeout = 2 * time.Minute
+	// uacAccountDisable is the Active Directory userAccountControl bit for
+	// disabled accounts.
+	uacAccountDisable = 0x2
+)
+
+// LDAPOptions configures an LDAPSource. Attribute names default to the
+// Active Directory schema.
+type LDAPOptions struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	StartTLS     bool
+	UserFilter   string
+	GroupFilter  string
+	// IDAttribute holds a stable object ID, such as objectGUID or entryUUID.
+	IDAttribute          string
+	UPNAttribute         string
+	DisplayNameAttribute string
+	DepartmentAttribute  string
+	EmployeeIDAttribute  string
+	JobTitleAttribute    string
+	// Attributes maps user attribute names to LDAP attributes.
+	Attributes map[string]string
+}
+
+// LDAPSource reads users and groups from an LDAP or Active Directory server.
+// Every round is a full listing. Group membership comes from the memberOf
+// attribute of users and nested groups.
+type LDAPSource struct {
+	opts LDAPOptions
+}
+
+var _ Source = (*LDAPSource)(nil)
+
+// NewLDAPSource fills in default attribute names and filters.
+func NewLDAPSource(opts LDAPOptions) *LDAPSource {
+	setDefault(&opts.UserFilter, "(&(objectCategory=person)(objectClass=user))")
+	setDefault(&opts.GroupFilter, "(objectClass=group)")
+	setDefault(&opts.IDAttribute, "objectGUID")
+	setDefault(&opts.UPNAttribute, "userPrincipalName")
+	setDefault(&opts.DisplayNameAttribute, "displayName")
+	setDefault(&opts.DepartmentAttribute, "department")
+	setDefault(&opts.EmployeeIDAttribute, "employeeID")
+	setDefault(&opts.JobTitleAttribute, "title")
+	return &LDAPSource{opts: opts}
+}
+
+// Name returns LDAPSourceName.
+func (s *LDAPSource) Name() string {
+	return LDAPSourceName
+}
+
+// Enabled reports whether a server URL and base DN are configured.
+func (s *LDAPSource) Enabled() bool {
+	return s.opts.URL != "" && s.opts.BaseDN != ""
+}
+
+// Fingerprint identifies the directory tree being read.
+func (s *LDAPSource) Fingerprint() string {
+	return s.opts.URL + "|" + s.opts.BaseDN
+}
+
+// FetchUsers lists every user matching the user filter.
+func (s *LDAPSource) FetchUsers(ctx context.Context, _ string) (UserChanges, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return UserChanges{}, err
+	}
+	defer conn.close()
+	entries, err := conn.search(s.opts.UserFilter, s.userAttributes())
+	if err != nil {
+		return UserChanges{}, fmt.Errorf("search users: %w", err)
+	}
+	ids := s.objectIDsByDN(entries)
+	changes := UserChanges{Full: true}
+	for _, entry := range entries {
+		changes.Users = append(changes.Users, s.user(entry, ids))
+	}
+	return changes, nil
+}
+
+// FetchGroups lists every group matching the group filter with its
+// transitive user members.
+func (s *LDAPSource) FetchGroups(ctx context.Context, _ string) (GroupChanges, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return GroupChanges{}, err
+	}
+	defer conn.close()
+	groups, err := conn.search(s.opts.GroupFilter, []string{
+		s.opts.IDAttribute, "cn", s.opts.DisplayNameAttribute, "description", "memberOf",
+	})
+	if err != nil {
+		return GroupChanges{}, fmt.Errorf("search groups: %w", err)
+	}
+	users, err := conn.search(s.opts.UserFilter, []string{s.opts.IDAttribute, "memberOf"})
+	if err != nil {
+		return GroupChanges{}, fmt.Errorf("search users: %w", err)
+	}
+	// parents maps a group DN to the DNs of groups it is a direct member of.
+	parents := make(map[string][]string, len(groups))
+	for _, entry := range groups {
+		parents[normalizeDN(entry.DN)] = normalizeDNs(entry.GetEqualFoldAttributeValues("memberOf"))
+	}
+	members := make(map[string][]string, len(groups))
+	for _, entry := range users {
+		objectID := s.objectID(entry)
+		if objectID == "" {
+			continue
+		}
+		for groupDN := range ancestors(normalizeDNs(entry.GetEqualFoldAttributeValues("memberOf")), parents) {
+			members[groupDN] = append(members[groupDN], objectID)
+		}
+	}
+	changes := GroupChanges{Full: true}
+	for _, entry := range groups {
+		objectID := s.objectID(entry)
+		if objectID == "" {
+			continue
+		}
+		name := entry.GetEqualFoldAttributeValue(s.opts.DisplayNameAttribute)
+		if name == "" {
+			name = entry.GetEqualFoldAttributeValue("cn")
+		}
+		changes.Groups = append(changes.Groups, Group{
+			ObjectID:    objectID,
+			DisplayName: name,
+			Description: entry.GetEqualFoldAttributeValue("description"),
+			Members:     members[normalizeDN(entry.DN)],
+		})
+	}
+	return changes, nil
+}
+
+func (s *LDAPSource) userAttributes() []string {
+	attrs := []string{
+		s.opts.IDAttribute, s.opts.UPNAttribute, s.opts.DisplayNameAttribute, s.opts.DepartmentAttribute,
+		s.opts.EmployeeIDAttribute, s.opts.JobTitleAttribute, "manager", "userAccountControl",
+	}
+	for _, attr := range s.opts.Attributes {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// user maps an entry, resolving the manager DN through ids.
+func (s *LDAPSource) user(entry *ldap.Entry, ids map[string]string) User {
+	manager := ""
+	if dn := entry.GetEqualFoldAttributeValue("manager"); dn != "" {
+		manager = ids[normalizeDN(dn)]
+	}
+	attrs := make(map[string]string, len(s.opts.Attributes))
+	for name, attr := range s.opts.Attributes {
+		if value := entry.GetEqualFoldAttributeValue(attr); value != "" {
+			attrs[name] = value
+		}
+	}
+	active := true
+	if uac, err := strconv.ParseInt(entry.GetEqualFoldAttributeValue("userAccountControl"), 10, 64); err == nil {
+		active = uac&uacAccountDisable == 0
+	}
+	return User{
+		ObjectID:    s.objectID(entry),
+		UPN:         entry.GetEqualFoldAttributeValue(s.opts.UPNAttribute),
+		DisplayName: entry.GetEqualFoldAttributeValue(s.opts.DisplayNameAttribute),
+		Department:  entry.GetEqualFoldAttributeValue(s.opts.DepartmentAttribute),
+		Active:      &active,
+		EmployeeID:  entry.GetEqualFoldAttributeValue(s.opts.EmployeeIDAttribute),
+		JobTitle:    entry.GetEqualFoldAttributeValue(s.opts.JobTitleAttribute),
+		ManagerID:   &manager,
+		Attributes:  attrs,
+	}
+}
+
+func (s *LDAPSource) objectIDsByDN(entries []*ldap.Entry) map[string]string {
+	ids := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if objectID := s.objectID(entry); objectID != "" {
+			ids[normalizeDN(entry.DN)] = objectID
+		}
+	}
+	return ids
+}
+
+// objectID reads the ID attribute. Active Directory GUIDs are binary with
+// the first three fields little-endian.
+func (s *LDAPSource) objectID(entry *ldap.Entry) string {
+	if strings.EqualFold(s.opts.IDAttribute, "objectGUID") {
+		raw := entry.GetEqualFoldRawAttributeValue(s.opts.IDAttribute)
+		if len(raw) != len(uuid.UUID{}) {
+			return ""
+		}
+		var id uuid.UUID
+		binary.BigEndian.PutUint32(id[0:4], binary.LittleEndian.Uint32(raw[0:4]))
+		binary.BigEndian.PutUint16(id[4:6], binary.LittleEndian.Uint16(raw[4:6]))
+		binary.BigEndian.PutUint16(id[6:8], binary.LittleEndian.Uint16(raw[6:8]))
+		copy(id[8:], raw[8:])
+		return id.String()
+	}
+	return entry.GetEqualFoldAttributeValue(s.opts.IDAttribute)
+}
+
+// ldapConn is a bound connection that closes when the context ends.
+type ldapConn struct {
+	conn   *ldap.Conn
+	baseDN string
+	stop   func() bool
+}
+
+func (s *LDAPSource) connect(ctx context.Context) (*ldapConn, error) {
+	if !s.Enabled() {
+		return nil, errors.New("ldap: source not configured")
+	}
+	conn, err := ldap.DialURL(s.opts.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapDialTimeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(ldapQueryTimeout)
+	if s.opts.StartTLS {
+		host := s.opts.URL
+		if parsed, parseErr := url.Parse(s.opts.URL); parseErr == nil {
+			host = parsed.Hostname()
+		}
+		if err = conn.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if s.opts.BindDN != "" {
+		if err = conn.Bind(s.opts.BindDN, s.opts.BindPassword); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("ldap bind: %w", err)
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return &ldapConn{conn: conn, baseDN: s.opts.BaseDN, stop: stop}, nil
+}
+
+func (c *ldapConn) search(filter string, attrs []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(c.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, attrs, nil)
+	result, err := c.conn.SearchWithPaging(req, ldapPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+func (c *ldapConn) close() {
+	c.stop()
+	_ = c.conn.Close()
+}
+
+// ancestors expands direct group DNs to every group reached through parents.
+func ancestors(direct []string, parents map[string][]string) map[string]struct{} {
+	found := make(map[string]struct{})
+	queue := append([]string(nil), direct...)
+	for len(queue) > 0 {
+		dn := queue[0]
+		queue = queue[1:]
+		if _, ok := found[dn]; ok {
+			continue
+		}
+		found[dn] = struct{}{}
+		queue = append(queue, parents[dn]...)
+	}
+	return found
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// normalizeDN returns a comparable form of a DN.
+func normalizeDN(dn string) string {
+	if parsed, err := ldap.ParseDN(dn); err == nil {
+		return strings.ToLower(parsed.String())
+	}
+	return strings.ToLower(dn)
+}
+
+func normalizeDNs(dns []string) []string {
+	out := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		out = append(out, normalizeDN(dn))
+	}
+	return out
+}
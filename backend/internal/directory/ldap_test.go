@@ -0,0 +1,307 @@
+package directory_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+)
+
+const (
+	testBaseDN   = "dc=school,dc=example"
+	testBindDN   = "cn=sync,ou=service," + testBaseDN
+	testPassword = "correct horse"
+)
+
+// ldapEntry is a directory object the fake server returns.
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// fakeLDAP is an in-process LDAP server answering simple binds and
+// searches. Searches evaluate and, or, not, equality and presence filters
+// over every entry, case-insensitively as Active Directory does.
+type fakeLDAP struct {
+	listener net.Listener
+	entries  []ldapEntry
+}
+
+func newFakeLDAP(t *testing.T, entries []ldapEntry) *fakeLDAP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeLDAP{listener: listener, entries: entries}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLDAP) url() string {
+	return "ldap://" + f.listener.Addr().String()
+}
+
+func (f *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if op.Children[1].Data.String() != testBindDN || op.Children[2].Data.String() != testPassword {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			err = reply(conn, messageID, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			err = f.search(conn, messageID, op.Children[6])
+		default:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeLDAP) search(conn net.Conn, messageID any, filter *ber.Packet) error {
+	for _, entry := range f.entries {
+		if !matches(filter, entry) {
+			continue
+		}
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+		attrs := ber.NewSequence("")
+		for name, values := range entry.attrs {
+			attr := ber.NewSequence("")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		result.AppendChild(attrs)
+		if err := reply(conn, messageID, result); err != nil {
+			return err
+		}
+	}
+	return reply(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func matches(filter *ber.Packet, entry ldapEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		return slices.ContainsFunc(filter.Children, func(child *ber.Packet) bool { return matches(child, entry) })
+	case ldap.FilterNot:
+		return !matches(filter.Children[0], entry)
+	case ldap.FilterEqualityMatch:
+		want := filter.Children[1].Data.String()
+		return slices.ContainsFunc(attrValues(entry, filter.Children[0].Data.String()), func(value string) bool {
+			return strings.EqualFold(value, want)
+		})
+	case ldap.FilterPresent:
+		return len(attrValues(entry, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func attrValues(entry ldapEntry, name string) []string {
+	for attr, values := range entry.attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func ldapResult(op ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return result
+}
+
+func reply(conn net.Conn, messageID any, op *ber.Packet) error {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	envelope.AppendChild(op)
+	_, err := conn.Write(envelope.Bytes())
+	return err
+}
+
+// objectGUID encodes id the way Active Directory stores it: the first three
+// fields little-endian.
+func objectGUID(id uuid.UUID) string {
+	raw := id
+	slices.Reverse(raw[0:4])
+	slices.Reverse(raw[4:6])
+	slices.Reverse(raw[6:8])
+	return string(raw[:])
+}
+
+// schoolDirectory is two users, one disabled, in Year 7, which is nested in
+// Students.
+func schoolDirectory(ada, ben, year7, students uuid.UUID) []ldapEntry {
+	person := []string{"top", "person", "user"}
+	return []ldapEntry{
+		{dn: "cn=Ada,ou=Students," + testBaseDN, attrs: map[string][]string{
+			"objectClass":        person,
+			"objectCategory":     {"person"},
+			"objectGUID":         {objectGUID(ada)},
+			"userPrincipalName":  {"ada@school.example"},
+			"displayName":        {"Ada Lovelace"},
+			"department":         {"Mathematics"},
+			"userAccountControl": {"512"},
+			"memberOf":           {"cn=Year 7,ou=Groups," + testBaseDN},
+		}},
+		{dn: "cn=Ben,ou=Students," + testBaseDN, attrs: map[string][]string{
+			"objectClass":        person,
+			"objectCategory":     {"person"},
+			"objectGUID":         {objectGUID(ben)},
+			"userPrincipalName":  {"ben@school.example"},
+			"displayName":        {"Ben"},
+			"userAccountControl": {"514"},
+			"manager":            {"CN=Ada,OU=Students," + testBaseDN},
+		}},
+		{dn: "cn=Year 7,ou=Groups," + testBaseDN, attrs: map[string][]string{
+			"objectClass": {"top", "group"},
+			"objectGUID":  {objectGUID(year7)},
+			"cn":          {"Year 7"},
+			"memberOf":    {"cn=Students,ou=Groups," + testBaseDN},
+		}},
+		{dn: "cn=Students,ou=Groups," + testBaseDN, attrs: map[string][]string{
+			"objectClass": {"top", "group"},
+			"objectGUID":  {objectGUID(students)},
+			"cn":          {"Students"},
+			"description": {"Every student"},
+		}},
+	}
+}
+
+func TestLDAPSourceBindsAndListsUsers(t *testing.T) {
+	ada, ben := uuid.New(), uuid.New()
+	server := newFakeLDAP(t, schoolDirectory(ada, ben, uuid.New(), uuid.New()))
+	source := directory.NewLDAPSource(directory.LDAPOptions{
+		URL:          server.url(),
+		BindDN:       testBindDN,
+		BindPassword: testPassword,
+		BaseDN:       testBaseDN,
+	})
+
+	changes, err := source.FetchUsers(context.Background(), "")
+	if err != nil {
+		t.Fatalf("fetch users: %v", err)
+	}
+	if !changes.Full || len(changes.Users) != 2 {
+		t.Fatalf("fetched %d users (full %t), want 2 in a full listing", len(changes.Users), changes.Full)
+	}
+	byID := make(map[string]directory.User)
+	for _, u := range changes.Users {
+		byID[u.ObjectID] = u
+	}
+	got, ok := byID[ada.String()]
+	if !ok {
+		t.Fatalf("users %v missing %s", changes.Users, ada)
+	}
+	if got.UPN != "ada@school.example" || got.DisplayName != "Ada Lovelace" ||
+		got.Department != "Mathematics" || got.Active == nil || !*got.Active {
+		t.Errorf("ada = %+v", got)
+	}
+	got = byID[ben.String()]
+	if got.Active == nil || *got.Active {
+		t.Error("ben is disabled but active")
+	}
+	if got.ManagerID == nil || *got.ManagerID != ada.String() {
+		t.Errorf("ben's manager = %v, want %s", got.ManagerID, ada)
+	}
+}
+
+func TestLDAPSourceRejectsBadPassword(t *testing.T) {
+	server := newFakeLDAP(t, schoolDirectory(uuid.New(), uuid.New(), uuid.New(), uuid.New()))
+	source := directory.NewLDAPSource(directory.LDAPOptions{
+		URL:          server.url(),
+		BindDN:       testBindDN,
+		BindPassword: "wrong",
+		BaseDN:       testBaseDN,
+	})
+
+	_, err := source.FetchUsers(context.Background(), "")
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultInvalidCredentials {
+		t.Fatalf("fetch users with a bad password: %v, want invalid credentials", err)
+	}
+}
+
+func TestLDAPSourceResolvesNestedGroups(t *testing.T) {
+	ada, ben, year7, students := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	server := newFakeLDAP(t, schoolDirectory(ada, ben, year7, students))
+	source := directory.NewLDAPSource(directory.LDAPOptions{
+		URL:          server.url(),
+		BindDN:       testBindDN,
+		BindPassword: testPassword,
+		BaseDN:       testBaseDN,
+	})
+
+	changes, err := source.FetchGroups(context.Background(), "")
+	if err != nil {
+		t.Fatalf("fetch groups: %v", err)
+	}
+	groups := make(map[string]directory.Group)
+	for _, g := range changes.Groups {
+		groups[g.ObjectID] = g
+	}
+	tests := []struct {
+		name        string
+		id          uuid.UUID
+		displayName string
+		description string
+	}{
+		{name: "direct membership", id: year7, displayName: "Year 7"},
+		{name: "nested membership", id: students, displayName: "Students", description: "Every student"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, ok := groups[tt.id.String()]
+			if !ok {
+				t.Fatalf("groups %v missing %s", changes.Groups, tt.id)
+			}
+			if g.DisplayName != tt.displayName || g.Description != tt.description {
+				t.Errorf("group = %q %q, want %q %q", g.DisplayName, g.Description, tt.displayName, tt.description)
+			}
+			if !slices.Equal(g.Members, []string{ada.String()}) {
+				t.Errorf("members = %v, want only %s", g.Members, ada)
+			}
+		})
+	}
+}
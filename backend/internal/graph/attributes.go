@@ -0,0 +1,111 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	absser "github.com/microsoft/kiota-abstractions-go/serialization"
+)
+
+// AttributeMap maps user attribute names to Graph property paths, e.g.
+// "yearLevel" to "extension_<appId>_yearLevel" or "house" to
+// "onPremisesExtensionAttributes.extensionAttribute1".
+type AttributeMap map[string]string
+
+// userSelect lists the user properties every sync requests.
+var userSelect = []string{ //nolint:gochecknoglobals // fixed select list
+	"id", "userPrincipalName", "displayName", "department", "accountEnabled", "employeeId", "jobTitle",
+}
+
+// UserSelect returns the sorted Graph properties requested for users with the
+// given attribute mapping. Manager is fetched separately.
+func UserSelect(attrs AttributeMap) []string {
+	fields := slices.Clone(userSelect)
+	for _, path := range attrs {
+		property, _, _ := strings.Cut(path, ".")
+		if property != "" && !slices.Contains(fields, property) {
+			fields = append(fields, property)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// modelJSON flattens a Graph model, including additional data such as
+// directory extensions and @delta annotations, into a generic JSON map.
+func modelJSON(model absser.Parsable) (map[string]any, error) {
+	raw, err := absser.SerializeToJson(model)
+	if err != nil {
+		return nil, fmt.Errorf("serialize graph model: %w", err)
+	}
+	var out map[string]any
+	if err = json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode graph model: %w", err)
+	}
+	return out, nil
+}
+
+// mapAttributes resolves each mapped path to a string value, skipping nulls.
+func mapAttributes(doc map[string]any, attrs AttributeMap) map[string]string {
+	out := make(map[string]string, len(attrs))
+	for name, path := range attrs {
+		var value any = doc
+		for part := range strings.SplitSeq(path, ".") {
+			obj, ok := value.(map[string]any)
+			if !ok {
+				value = nil
+				break
+			}
+			value = obj[part]
+		}
+		if s, ok := stringValue(value); ok {
+			out[name] = s
+		}
+	}
+	return out
+}
+
+// stringValue renders scalars as text and arrays or objects as JSON.
+func stringValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case bool, float64:
+		return fmt.Sprint(v), true
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(raw), true
+	}
+}
+
+// managerID reads the manager from a delta "manager@delta" annotation or an
+// expanded "manager". It returns nil when neither is present and "" when the
+// manager was removed.
+func managerID(doc map[string]any) *string {
+	var ref map[string]any
+	if delta, ok := doc["manager@delta"].([]any); ok {
+		if len(delta) > 0 {
+			ref, _ = delta[0].(map[string]any)
+		}
+		if ref == nil {
+			empty := ""
+			return &empty
+		}
+	} else if expanded, isObj := doc["manager"].(map[string]any); isObj {
+		ref = expanded
+	} else {
+		return nil
+	}
+	id, _ := ref["id"].(string)
+	if _, removed := ref["@removed"]; removed {
+		id = ""
+	}
+	return &id
+}
@@ -4,13 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 
 	azidentity "github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/microsoft/kiota-abstractions-go/authentication"
 	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/woodleighschool/signin-ui/internal/directory"
 )
 
-// ErrNotConfigured signals missing Graph credentials.
-var ErrNotConfigured = errors.New("graph: client not configured")
+var (
+	// ErrNotConfigured signals missing Graph credentials.
+	ErrNotConfigured = errors.New("graph: client not configured")
+	// ErrDeltaExpired means the stored delta link is no longer valid.
+	ErrDeltaExpired = fmt.Errorf("graph: delta token expired: %w", directory.ErrCursorExpired)
+)
 
 // Client wraps the Microsoft Graph SDK and knows if it's usable.
 type Client struct {
@@ -34,6 +42,19 @@ func NewClient(_ context.Context, tenantID, clientID, clientSecret string) (*Cli
 	return &Client{graph: graphClient, enabled: true}, nil
 }
 
+// NewClientForServer targets a Graph-compatible server at baseURL without
+// authentication, e.g. a local fake for tests.
+func NewClientForServer(baseURL string, httpClient *http.Client) (*Client, error) {
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(
+		&authentication.AnonymousAuthenticationProvider{}, nil, nil, httpClient,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("graph adapter: %w", err)
+	}
+	adapter.SetBaseUrl(strings.TrimRight(baseURL, "/"))
+	return &Client{graph: msgraphsdk.NewGraphServiceClient(adapter), enabled: true}, nil
+}
+
 // Enabled reports if the client is configured.
 func (c *Client) Enabled() bool {
 	return c.enabled
@@ -6,64 +6,108 @@ import (
 	"fmt"
 
 	msgraphgroups "github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/woodleighschool/signin-ui/internal/directory"
 )
 
 const graphGroupPageSize = int32(100)
 
-// DirectoryGroup holds the Entra ID fields we expose.
-type DirectoryGroup struct {
-	ObjectID    string
-	DisplayName string
-	Description string
-	Members     []string
-}
-
-// FetchGroups lists Entra ID security groups and their members.
-func (c *Client) FetchGroups(ctx context.Context) ([]DirectoryGroup, error) {
+// FetchGroupDelta returns groups changed since deltaLink (or every group when
+// it is empty), with transitive members loaded for each changed group.
+func (c *Client) FetchGroupDelta(ctx context.Context, deltaLink string) (directory.GroupChanges, error) {
 	if !c.enabled {
-		return nil, ErrNotConfigured
+		return directory.GroupChanges{}, ErrNotConfigured
 	}
 	if c.graph == nil {
-		return nil, errors.New("graph client missing")
+		return directory.GroupChanges{}, errors.New("graph client missing")
 	}
-	builder := c.graph.Groups()
 	adapter := c.graph.GetAdapter()
-	selectFields := []string{"id", "displayName", "description"}
-	var groups []DirectoryGroup
+	builder := c.graph.Groups().Delta()
+	config := &msgraphgroups.DeltaRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphgroups.DeltaRequestBuilderGetQueryParameters{
+			// Selecting members makes membership changes show up in the delta.
+			Select: []string{"id", "displayName", "description", "members"},
+		},
+	}
+	if deltaLink != "" {
+		builder = msgraphgroups.NewDeltaRequestBuilder(deltaLink, adapter)
+		config = nil
+	}
+	result := directory.GroupChanges{Full: deltaLink == ""}
+	changed := make(map[string]int)
 	for {
-		top := graphGroupPageSize
-		resp, err := builder.Get(ctx, &msgraphgroups.GroupsRequestBuilderGetRequestConfiguration{
-			QueryParameters: &msgraphgroups.GroupsRequestBuilderGetQueryParameters{
-				Top:    &top,
-				Select: selectFields,
-			},
-		})
+		resp, err := builder.GetAsDeltaGetResponse(ctx, config)
 		if err != nil {
-			return nil, fmt.Errorf("list groups: %w", err)
+			if isDeltaExpired(err) {
+				return directory.GroupChanges{}, ErrDeltaExpired
+			}
+			return directory.GroupChanges{}, fmt.Errorf("group delta: %w", err)
 		}
 		for _, item := range resp.GetValue() {
 			if item == nil {
 				continue
 			}
 			groupID := deref(item.GetId())
-			members, fetchErr := c.fetchGroupMembers(ctx, groupID)
-			if fetchErr != nil {
-				return nil, fmt.Errorf("fetch members for %s: %w", groupID, fetchErr)
+			if isRemoved(item.GetAdditionalData()) {
+				result.Removed = append(result.Removed, groupID)
+				continue
 			}
-			groups = append(groups, DirectoryGroup{
+			// A group can appear on several pages; keep the latest properties.
+			group := directory.Group{
 				ObjectID:    groupID,
 				DisplayName: deref(item.GetDisplayName()),
 				Description: deref(item.GetDescription()),
-				Members:     members,
-			})
+			}
+			if idx, seen := changed[groupID]; seen {
+				if group.DisplayName != "" {
+					result.Groups[idx] = group
+				}
+				continue
+			}
+			changed[groupID] = len(result.Groups)
+			result.Groups = append(result.Groups, group)
 		}
-		next := resp.GetOdataNextLink()
-		if next == nil || len(*next) == 0 {
-			break
+		if next := deref(resp.GetOdataNextLink()); next != "" {
+			builder = msgraphgroups.NewDeltaRequestBuilder(next, adapter)
+			config = nil
+			continue
 		}
-		builder = msgraphgroups.NewGroupsRequestBuilder(*next, adapter)
+		result.Cursor = deref(resp.GetOdataDeltaLink())
+		break
+	}
+	for i := range result.Groups {
+		members, err := c.fetchGroupMembers(ctx, result.Groups[i].ObjectID)
+		if err != nil {
+			return directory.GroupChanges{}, fmt.Errorf("fetch members for %s: %w", result.Groups[i].ObjectID, err)
+		}
+		result.Groups[i].Members = members
+	}
+	return result, nil
+}
+
+// FetchGroup loads a single group with its transitive members.
+func (c *Client) FetchGroup(ctx context.Context, objectID string) (directory.Group, error) {
+	if !c.enabled {
+		return directory.Group{}, ErrNotConfigured
+	}
+	config := &msgraphgroups.GroupItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphgroups.GroupItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "displayName", "description"},
+		},
+	}
+	group, err := c.graph.Groups().ByGroupId(objectID).Get(ctx, config)
+	if err != nil {
+		return directory.Group{}, fmt.Errorf("get group %s: %w", objectID, err)
+	}
+	members, err := c.fetchGroupMembers(ctx, objectID)
+	if err != nil {
+		return directory.Group{}, fmt.Errorf("fetch members for %s: %w", objectID, err)
 	}
-	return groups, nil
+	return directory.Group{
+		ObjectID:    deref(group.GetId()),
+		DisplayName: deref(group.GetDisplayName()),
+		Description: deref(group.GetDescription()),
+		Members:     members,
+	}, nil
 }
 
 // fetchGroupMembers collects member IDs from Graph.
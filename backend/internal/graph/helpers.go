@@ -1,5 +1,12 @@
 package graph
 
+import (
+	"errors"
+	"net/http"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+)
+
 // deref returns the string or an empty value when nil.
 func deref(value *string) string {
 	if value == nil {
@@ -7,3 +14,15 @@ func deref(value *string) string {
 	}
 	return *value
 }
+
+// isRemoved reports the @removed annotation Graph adds to deleted delta items.
+func isRemoved(additional map[string]any) bool {
+	_, ok := additional["@removed"]
+	return ok
+}
+
+// isDeltaExpired detects the 410 Graph returns for stale delta tokens.
+func isDeltaExpired(err error) bool {
+	var apiErr abstractions.ApiErrorable
+	return errors.As(err, &apiErr) && apiErr.GetStatusCode() == http.StatusGone
+}
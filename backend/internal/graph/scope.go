@@ -0,0 +1,211 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphdirectory "github.com/microsoftgraph/msgraph-sdk-go/directory"
+	msgraphgroups "github.com/microsoftgraph/msgraph-sdk-go/groups"
+	msgraphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// UserScope restricts which users are synced. User members of any listed
+// group (transitively) or administrative unit (directly) are in scope; a
+// Filter further narrows the set with an OData expression. The zero value
+// syncs every user.
+type UserScope struct {
+	GroupIDs     []string
+	AdminUnitIDs []string
+	Filter       string
+}
+
+// Restricted reports whether the scope limits the synced users.
+func (s UserScope) Restricted() bool {
+	return len(s.GroupIDs) > 0 || len(s.AdminUnitIDs) > 0 || s.Filter != ""
+}
+
+// ListUserScope returns the object IDs of users in scope.
+func (c *Client) ListUserScope(ctx context.Context, scope UserScope) (map[string]struct{}, error) {
+	if !c.enabled {
+		return nil, ErrNotConfigured
+	}
+	var ids map[string]struct{}
+	if len(scope.GroupIDs) > 0 || len(scope.AdminUnitIDs) > 0 {
+		ids = make(map[string]struct{})
+		for _, groupID := range scope.GroupIDs {
+			members, err := c.fetchGroupUserIDs(ctx, groupID)
+			if err != nil {
+				return nil, fmt.Errorf("scope group %s: %w", groupID, err)
+			}
+			addAll(ids, members)
+		}
+		for _, unitID := range scope.AdminUnitIDs {
+			members, err := c.fetchAdminUnitUserIDs(ctx, unitID)
+			if err != nil {
+				return nil, fmt.Errorf("scope administrative unit %s: %w", unitID, err)
+			}
+			addAll(ids, members)
+		}
+	}
+	if scope.Filter == "" {
+		return ids, nil
+	}
+	filtered, err := c.filterUserIDs(ctx, scope.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("scope filter: %w", err)
+	}
+	if ids == nil {
+		return filtered, nil
+	}
+	for id := range ids {
+		if _, ok := filtered[id]; !ok {
+			delete(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ListGroupScope returns the object IDs of groups matching an OData filter.
+func (c *Client) ListGroupScope(ctx context.Context, filter string) (map[string]struct{}, error) {
+	if !c.enabled {
+		return nil, ErrNotConfigured
+	}
+	count := true
+	top := graphGroupPageSize
+	builder := c.graph.Groups()
+	config := &msgraphgroups.GroupsRequestBuilderGetRequestConfiguration{
+		Headers: advancedQueryHeaders(),
+		QueryParameters: &msgraphgroups.GroupsRequestBuilderGetQueryParameters{
+			Filter: &filter,
+			Select: []string{"id"},
+			Count:  &count,
+			Top:    &top,
+		},
+	}
+	ids := make(map[string]struct{})
+	for {
+		resp, err := builder.Get(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("group scope filter: %w", err)
+		}
+		for _, group := range resp.GetValue() {
+			if id := deref(group.GetId()); id != "" {
+				ids[id] = struct{}{}
+			}
+		}
+		next := deref(resp.GetOdataNextLink())
+		if next == "" {
+			return ids, nil
+		}
+		builder = msgraphgroups.NewGroupsRequestBuilder(next, c.graph.GetAdapter())
+		config = &msgraphgroups.GroupsRequestBuilderGetRequestConfiguration{Headers: advancedQueryHeaders()}
+	}
+}
+
+// filterUserIDs lists the IDs of users matching an OData filter.
+func (c *Client) filterUserIDs(ctx context.Context, filter string) (map[string]struct{}, error) {
+	count := true
+	top := graphGroupPageSize
+	builder := c.graph.Users()
+	config := &msgraphusers.UsersRequestBuilderGetRequestConfiguration{
+		Headers: advancedQueryHeaders(),
+		QueryParameters: &msgraphusers.UsersRequestBuilderGetQueryParameters{
+			Filter: &filter,
+			Select: []string{"id"},
+			Count:  &count,
+			Top:    &top,
+		},
+	}
+	ids := make(map[string]struct{})
+	for {
+		resp, err := builder.Get(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range resp.GetValue() {
+			if id := deref(user.GetId()); id != "" {
+				ids[id] = struct{}{}
+			}
+		}
+		next := deref(resp.GetOdataNextLink())
+		if next == "" {
+			return ids, nil
+		}
+		builder = msgraphusers.NewUsersRequestBuilder(next, c.graph.GetAdapter())
+		config = &msgraphusers.UsersRequestBuilderGetRequestConfiguration{Headers: advancedQueryHeaders()}
+	}
+}
+
+// fetchGroupUserIDs collects the IDs of users in a group, transitively.
+func (c *Client) fetchGroupUserIDs(ctx context.Context, groupID string) ([]string, error) {
+	top := graphGroupPageSize
+	builder := c.graph.Groups().ByGroupId(groupID).TransitiveMembers().GraphUser()
+	config := &msgraphgroups.ItemTransitiveMembersGraphUserRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphgroups.ItemTransitiveMembersGraphUserRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+			Top:    &top,
+		},
+	}
+	var ids []string
+	for {
+		resp, err := builder.Get(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range resp.GetValue() {
+			if id := deref(user.GetId()); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		next := deref(resp.GetOdataNextLink())
+		if next == "" {
+			return ids, nil
+		}
+		builder = msgraphgroups.NewItemTransitiveMembersGraphUserRequestBuilder(next, c.graph.GetAdapter())
+		config = nil
+	}
+}
+
+// fetchAdminUnitUserIDs collects the IDs of users in an administrative unit.
+func (c *Client) fetchAdminUnitUserIDs(ctx context.Context, unitID string) ([]string, error) {
+	top := graphGroupPageSize
+	builder := c.graph.Directory().AdministrativeUnits().ByAdministrativeUnitId(unitID).Members().GraphUser()
+	config := &msgraphdirectory.AdministrativeUnitsItemMembersGraphUserRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphdirectory.AdministrativeUnitsItemMembersGraphUserRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+			Top:    &top,
+		},
+	}
+	var ids []string
+	for {
+		resp, err := builder.Get(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range resp.GetValue() {
+			if id := deref(user.GetId()); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		next := deref(resp.GetOdataNextLink())
+		if next == "" {
+			return ids, nil
+		}
+		builder = msgraphdirectory.NewAdministrativeUnitsItemMembersGraphUserRequestBuilder(next, c.graph.GetAdapter())
+		config = nil
+	}
+}
+
+// advancedQueryHeaders enables Graph advanced queries for OData filters.
+func advancedQueryHeaders() *abstractions.RequestHeaders {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	return headers
+}
+
+func addAll(set map[string]struct{}, ids []string) {
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+}
@@ -0,0 +1,83 @@
+package graph
+
+import (
+	"context"
+	"strings"
+
+	"github.com/woodleighschool/signin-ui/internal/directory"
+)
+
+// SourceName identifies Entra ID on synced rows.
+const SourceName = "entra"
+
+// Source reads Entra ID users and groups through Graph delta queries.
+type Source struct {
+	client      *Client
+	scope       UserScope
+	groupFilter string
+	attrs       AttributeMap
+}
+
+var (
+	_ directory.Source = (*Source)(nil)
+	_ directory.Scoped = (*Source)(nil)
+)
+
+// NewSource wraps a client. The scope and group filter limit what is synced;
+// attrs maps extra Graph properties into user attributes.
+func NewSource(client *Client, scope UserScope, groupFilter string, attrs AttributeMap) *Source {
+	return &Source{client: client, scope: scope, groupFilter: groupFilter, attrs: attrs}
+}
+
+// Name returns SourceName.
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// Enabled reports whether Graph credentials are configured.
+func (s *Source) Enabled() bool {
+	return s.client != nil && s.client.Enabled()
+}
+
+// Fingerprint is the user select list; delta links are only reusable with it.
+func (s *Source) Fingerprint() string {
+	return strings.Join(UserSelect(s.attrs), ",")
+}
+
+// FetchUsers runs a user delta round.
+func (s *Source) FetchUsers(ctx context.Context, cursor string) (directory.UserChanges, error) {
+	return s.client.FetchUserDelta(ctx, cursor, s.attrs)
+}
+
+// FetchGroups runs a group delta round.
+func (s *Source) FetchGroups(ctx context.Context, cursor string) (directory.GroupChanges, error) {
+	return s.client.FetchGroupDelta(ctx, cursor)
+}
+
+// UserScope lists users in the configured scope.
+func (s *Source) UserScope(ctx context.Context) (map[string]struct{}, bool, error) {
+	if !s.scope.Restricted() {
+		return nil, false, nil
+	}
+	scope, err := s.client.ListUserScope(ctx, s.scope)
+	return scope, true, err
+}
+
+// GroupScope lists groups matching the group filter.
+func (s *Source) GroupScope(ctx context.Context) (map[string]struct{}, bool, error) {
+	if s.groupFilter == "" {
+		return nil, false, nil
+	}
+	scope, err := s.client.ListGroupScope(ctx, s.groupFilter)
+	return scope, true, err
+}
+
+// FetchUser loads one user.
+func (s *Source) FetchUser(ctx context.Context, objectID string) (directory.User, error) {
+	return s.client.FetchUser(ctx, objectID, s.attrs)
+}
+
+// FetchGroup loads one group with its members.
+func (s *Source) FetchGroup(ctx context.Context, objectID string) (directory.Group, error) {
+	return s.client.FetchGroup(ctx, objectID)
+}
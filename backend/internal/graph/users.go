@@ -5,64 +5,115 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	msgraphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
+	"github.com/woodleighschool/signin-ui/internal/directory"
 )
 
-const graphUserPageSize = int32(100)
-
-// DirectoryUser mirrors a user pulled from Entra ID.
-type DirectoryUser struct {
-	ObjectID    string
-	UPN         string
-	DisplayName string
-	Active      bool
-	Department  string
-}
-
-// FetchUsers reads users from Graph and normalises them.
-func (c *Client) FetchUsers(ctx context.Context) ([]DirectoryUser, error) {
+// FetchUserDelta returns user changes since deltaLink, or every user when
+// deltaLink is empty. ErrDeltaExpired means the caller must start over.
+func (c *Client) FetchUserDelta(
+	ctx context.Context,
+	deltaLink string,
+	attrs AttributeMap,
+) (directory.UserChanges, error) {
 	if !c.enabled {
-		return nil, ErrNotConfigured
+		return directory.UserChanges{}, ErrNotConfigured
 	}
 	if c.graph == nil {
-		return nil, errors.New("graph client missing")
+		return directory.UserChanges{}, errors.New("graph client missing")
 	}
-	builder := c.graph.Users()
 	adapter := c.graph.GetAdapter()
-	selectFields := []string{"id", "userPrincipalName", "displayName", "department", "accountEnabled"}
-	var users []DirectoryUser
+	builder := c.graph.Users().Delta()
+	config := &msgraphusers.DeltaRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphusers.DeltaRequestBuilderGetQueryParameters{
+			// Selecting manager makes manager changes show up in the delta.
+			Select: append(UserSelect(attrs), "manager"),
+		},
+	}
+	if deltaLink != "" {
+		builder = msgraphusers.NewDeltaRequestBuilder(deltaLink, adapter)
+		config = nil
+	}
+	result := directory.UserChanges{Full: deltaLink == ""}
 	for {
-		top := graphUserPageSize
-		resp, err := builder.Get(ctx, &msgraphusers.UsersRequestBuilderGetRequestConfiguration{
-			QueryParameters: &msgraphusers.UsersRequestBuilderGetQueryParameters{
-				Top:    &top,
-				Select: selectFields,
-			},
-		})
+		resp, err := builder.GetAsDeltaGetResponse(ctx, config)
 		if err != nil {
-			return nil, fmt.Errorf("list users: %w", err)
+			if isDeltaExpired(err) {
+				return directory.UserChanges{}, ErrDeltaExpired
+			}
+			return directory.UserChanges{}, fmt.Errorf("user delta: %w", err)
 		}
 		for _, user := range resp.GetValue() {
 			if user == nil {
 				continue
 			}
-			active := true
-			if enabled := user.GetAccountEnabled(); enabled != nil {
-				active = *enabled
+			if isRemoved(user.GetAdditionalData()) {
+				result.Removed = append(result.Removed, deref(user.GetId()))
+				continue
+			}
+			dirUser, mapErr := directoryUser(user, attrs)
+			if mapErr != nil {
+				return directory.UserChanges{}, mapErr
 			}
-			users = append(users, DirectoryUser{
-				ObjectID:    deref(user.GetId()),
-				UPN:         deref(user.GetUserPrincipalName()),
-				DisplayName: deref(user.GetDisplayName()),
-				Active:      active,
-				Department:  deref(user.GetDepartment()),
-			})
+			// A full round annotates every user that has a manager; later rounds
+			// carry only the properties that changed.
+			if result.Full && dirUser.ManagerID == nil {
+				dirUser.ManagerID = new(string)
+			}
+			dirUser.Partial = !result.Full
+			result.Users = append(result.Users, dirUser)
 		}
-		next := resp.GetOdataNextLink()
-		if next == nil || len(*next) == 0 {
-			break
+		if next := deref(resp.GetOdataNextLink()); next != "" {
+			builder = msgraphusers.NewDeltaRequestBuilder(next, adapter)
+			config = nil
+			continue
 		}
-		builder = msgraphusers.NewUsersRequestBuilder(*next, adapter)
+		result.Cursor = deref(resp.GetOdataDeltaLink())
+		return result, nil
+	}
+}
+
+// FetchUser loads a single user with its manager.
+func (c *Client) FetchUser(ctx context.Context, objectID string, attrs AttributeMap) (directory.User, error) {
+	if !c.enabled {
+		return directory.User{}, ErrNotConfigured
+	}
+	config := &msgraphusers.UserItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphusers.UserItemRequestBuilderGetQueryParameters{
+			Select: UserSelect(attrs),
+			Expand: []string{"manager($select=id)"},
+		},
+	}
+	user, err := c.graph.Users().ByUserId(objectID).Get(ctx, config)
+	if err != nil {
+		return directory.User{}, fmt.Errorf("get user %s: %w", objectID, err)
+	}
+	dirUser, err := directoryUser(user, attrs)
+	if err != nil {
+		return directory.User{}, err
+	}
+	if dirUser.ManagerID == nil {
+		dirUser.ManagerID = new(string)
+	}
+	return dirUser, nil
+}
+
+// directoryUser maps a Graph user, resolving mapped attributes and manager.
+func directoryUser(user models.Userable, attrs AttributeMap) (directory.User, error) {
+	doc, err := modelJSON(user)
+	if err != nil {
+		return directory.User{}, err
 	}
-	return users, nil
+	return directory.User{
+		ObjectID:    deref(user.GetId()),
+		UPN:         deref(user.GetUserPrincipalName()),
+		DisplayName: deref(user.GetDisplayName()),
+		Active:      user.GetAccountEnabled(),
+		Department:  deref(user.GetDepartment()),
+		EmployeeID:  deref(user.GetEmployeeId()),
+		JobTitle:    deref(user.GetJobTitle()),
+		ManagerID:   managerID(doc),
+		Attributes:  mapAttributes(doc, attrs),
+	}, nil
 }
@@ -0,0 +1,315 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/branding"
+	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+	"golang.org/x/text/language"
+)
+
+// maxWelcomeTextLength bounds the portal welcome text.
+const maxWelcomeTextLength = 500
+
+// accentColorPattern matches #rrggbb colours.
+var accentColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// brandingDTO is one branding scope. Both IDs null is the global branding.
+type brandingDTO struct {
+	ID            uuid.UUID  `json:"id"`
+	LocationID    *uuid.UUID `json:"locationId"`
+	KeyID         *uuid.UUID `json:"keyId"`
+	AccentColor   string     `json:"accentColor,omitempty"`
+	WelcomeText   string     `json:"welcomeText,omitempty"`
+	Language      string     `json:"language,omitempty"`
+	BackgroundURL string     `json:"backgroundUrl,omitempty"`
+	LogoURL       string     `json:"logoUrl,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// brandingRoutes manages portal branding for every portal, a location (and
+// its subtree) or a key.
+func (h Handler) brandingRoutes(r chi.Router) {
+	r.Get("/", h.listBranding)
+	r.Route("/global", h.brandingScopeRoutes)
+	r.Route("/locations/{locationId}", h.brandingScopeRoutes)
+	r.Route("/keys/{keyId}", h.brandingScopeRoutes)
+}
+
+func (h Handler) brandingScopeRoutes(r chi.Router) {
+	r.Get("/", h.getBranding)
+	r.Put("/", h.updateBranding)
+	r.Delete("/", h.deleteBranding)
+	r.Put("/background", h.uploadBrandingImage(store.BrandingBackground))
+	r.Delete("/background", h.deleteBrandingImage(store.BrandingBackground))
+	r.Put("/logo", h.uploadBrandingImage(store.BrandingLogo))
+	r.Delete("/logo", h.deleteBrandingImage(store.BrandingLogo))
+}
+
+func (h Handler) listBranding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	rows, err := h.Store.ListBranding(ctx)
+	if err != nil {
+		h.Logger.Error("list branding", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list branding")
+		return
+	}
+	resp := make([]brandingDTO, 0, len(rows))
+	for _, row := range rows {
+		resp = append(resp, mapBranding(sqlc.PortalBranding{
+			ID:             row.ID,
+			LocationID:     row.LocationID,
+			KeyID:          row.KeyID,
+			AccentColor:    row.AccentColor,
+			WelcomeText:    row.WelcomeText,
+			Language:       row.Language,
+			BackgroundEtag: row.BackgroundEtag,
+			LogoEtag:       row.LogoEtag,
+			UpdatedAt:      row.UpdatedAt,
+		}))
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (h Handler) getBranding(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.brandingScope(w, r)
+	if !ok {
+		return
+	}
+	brand, err := h.Store.GetBranding(r.Context(), scope)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "branding not set")
+			return
+		}
+		h.Logger.Error("get branding", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load branding")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapBranding(brand))
+}
+
+// updateBranding replaces a scope's text settings. Empty fields fall back to
+// the next scope out.
+func (h Handler) updateBranding(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.brandingScope(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		AccentColor string `json:"accentColor"`
+		WelcomeText string `json:"welcomeText"`
+		Language    string `json:"language"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	text := store.BrandingText{
+		AccentColor: strings.ToLower(strings.TrimSpace(body.AccentColor)),
+		WelcomeText: strings.TrimSpace(body.WelcomeText),
+		Language:    strings.TrimSpace(body.Language),
+	}
+	if text.AccentColor != "" && !accentColorPattern.MatchString(text.AccentColor) {
+		respondError(w, http.StatusBadRequest, "accentColor must be a #rrggbb colour")
+		return
+	}
+	if len([]rune(text.WelcomeText)) > maxWelcomeTextLength {
+		respondError(w, http.StatusBadRequest, "welcomeText is too long")
+		return
+	}
+	if text.Language != "" {
+		tag, err := language.Parse(text.Language)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "language must be a BCP 47 tag such as en-AU")
+			return
+		}
+		text.Language = tag.String()
+	}
+	brand, err := h.Store.SetBrandingText(r.Context(), scope, text)
+	if err != nil {
+		h.Logger.Error("save branding", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to save branding")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapBranding(brand))
+}
+
+func (h Handler) deleteBranding(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.brandingScope(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := h.Store.DeleteBranding(r.Context(), scope)
+	if err != nil {
+		h.Logger.Error("delete branding", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to delete branding")
+		return
+	}
+	if !deleted {
+		respondError(w, http.StatusNotFound, "branding not set")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadBrandingImage stores a JPEG, PNG or WebP background or logo from the
+// multipart "file" field.
+func (h Handler) uploadBrandingImage(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := h.brandingScope(w, r)
+		if !ok {
+			return
+		}
+		data, contentType, ok := h.readBrandingImage(w, r)
+		if !ok {
+			return
+		}
+		brand, err := h.Store.SetBrandingImage(r.Context(), scope, kind, data, contentType)
+		if err != nil {
+			h.Logger.Error("save branding image", "kind", kind, "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to save image")
+			return
+		}
+		respondJSON(w, http.StatusOK, mapBranding(brand))
+	}
+}
+
+func (h Handler) deleteBrandingImage(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := h.brandingScope(w, r)
+		if !ok {
+			return
+		}
+		brand, err := h.Store.SetBrandingImage(r.Context(), scope, kind, nil, "")
+		if err != nil {
+			h.Logger.Error("delete branding image", "kind", kind, "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to delete image")
+			return
+		}
+		respondJSON(w, http.StatusOK, mapBranding(brand))
+	}
+}
+
+// brandingScope checks the viewer is an admin and reads the scope from the
+// URL, rejecting unknown locations and keys.
+func (h Handler) brandingScope(w http.ResponseWriter, r *http.Request) (store.BrandingScope, bool) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return store.BrandingScope{}, false
+	}
+	var scope store.BrandingScope
+	var err error
+	switch {
+	case chi.URLParam(r, "locationId") != "":
+		if scope.LocationID, err = parseUUIDParam(r, "locationId"); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid location id")
+			return store.BrandingScope{}, false
+		}
+		_, err = h.Store.GetLocation(ctx, scope.LocationID)
+	case chi.URLParam(r, "keyId") != "":
+		if scope.KeyID, err = parseUUIDParam(r, "keyId"); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid key id")
+			return store.BrandingScope{}, false
+		}
+		_, err = h.Store.GetKey(ctx, scope.KeyID)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "location or key not found")
+		return store.BrandingScope{}, false
+	}
+	if err != nil {
+		h.Logger.Error("load branding scope", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load branding")
+		return store.BrandingScope{}, false
+	}
+	return scope, true
+}
+
+// readBrandingImage reads and checks an uploaded image.
+func (h Handler) readBrandingImage(w http.ResponseWriter, r *http.Request) ([]byte, string, bool) {
+	maxBytes := h.Settings.Current().MaxUploadBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid upload")
+		return nil, "", false
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "image file is required")
+		return nil, "", false
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			h.Logger.Warn("close image upload", "err", cerr)
+		}
+	}()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		h.Logger.Error("read image upload", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to read upload")
+		return nil, "", false
+	}
+	contentType, err := branding.DetectImage(data)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "image must be a JPEG, PNG or WebP")
+		return nil, "", false
+	}
+	return data, contentType, true
+}
+
+func mapBranding(brand sqlc.PortalBranding) brandingDTO {
+	dto := brandingDTO{
+		ID:          brand.ID,
+		LocationID:  optionalUUID(brand.LocationID),
+		KeyID:       optionalUUID(brand.KeyID),
+		AccentColor: brand.AccentColor.String,
+		WelcomeText: brand.WelcomeText.String,
+		Language:    brand.Language.String,
+		UpdatedAt:   brand.UpdatedAt.Time,
+	}
+	if brand.BackgroundEtag.Valid {
+		dto.BackgroundURL = brandingImageURL(brand, store.BrandingBackground)
+	}
+	if brand.LogoEtag.Valid {
+		dto.LogoURL = brandingImageURL(brand, store.BrandingLogo)
+	}
+	return dto
+}
+
+// brandingImageURL links to the portal's image endpoint, versioned by etag.
+func brandingImageURL(brand sqlc.PortalBranding, kind string) string {
+	etag := brand.BackgroundEtag.String
+	if kind == store.BrandingLogo {
+		etag = brand.LogoEtag.String
+	}
+	params := url.Values{"id": {brand.ID.String()}, "v": {etag}}
+	return "/api/portal/" + kind + "?" + params.Encode()
+}
+
+func optionalUUID(id pgtype.UUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	value := uuid.UUID(id.Bytes)
+	return &value
+}
@@ -1,16 +1,33 @@
 package admin
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
 )
 
+type checkinPartitionDTO struct {
+	Name          string    `json:"name"`
+	Month         time.Time `json:"month"`
+	Archived      bool      `json:"archived"`
+	EstimatedRows int64     `json:"estimatedRows"`
+}
+
+type checkinPartitionsResponse struct {
+	Partitions []checkinPartitionDTO `json:"partitions"`
+	// Unpartitioned counts checkins waiting in the default partition for
+	// their month to be created.
+	Unpartitioned int64 `json:"unpartitioned"`
+}
+
 // checkinsRoutes serves read-only checkin listings.
 func (h Handler) checkinsRoutes(r chi.Router) {
 	r.Get("/", h.listCheckins)
+	r.Get("/partitions", h.listCheckinPartitions)
 }
 
 func (h Handler) listCheckins(w http.ResponseWriter, r *http.Request) {
@@ -31,7 +48,9 @@ func (h Handler) listCheckins(w http.ResponseWriter, r *http.Request) {
 	limit := parseInt32(r.URL.Query().Get("limit"), defaultCheckinLimit)
 	offset := parseInt32(r.URL.Query().Get("offset"), defaultCheckinOffset)
 
-	records, err := h.Store.ListCheckinDetails(ctx, viewer.IsAdmin, viewer.ID, locationID, userID, limit, offset)
+	attrs := parseAttributeFilter(r.URL.Query())
+
+	records, err := h.Store.ListCheckinDetails(ctx, viewer.IsAdmin, viewer.ID, locationID, userID, attrs, limit, offset)
 	if err != nil {
 		h.Logger.Error("list checkins", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to list checkins")
@@ -46,6 +65,9 @@ func (h Handler) listCheckins(w http.ResponseWriter, r *http.Request) {
 			"userDisplayName":    c.UserDisplayName,
 			"userUpn":            c.UserUpn,
 			"userDepartment":     c.UserDepartment.String,
+			"userEmployeeId":     c.UserEmployeeID.String,
+			"userJobTitle":       c.UserJobTitle.String,
+			"userAttributes":     json.RawMessage(c.UserAttributes),
 			"locationId":         c.LocationID,
 			"locationName":       c.LocationName,
 			"locationIdentifier": c.LocationIdentifier,
@@ -54,6 +76,10 @@ func (h Handler) listCheckins(w http.ResponseWriter, r *http.Request) {
 			"notes":              c.Notes.String,
 			"occurredAt":         c.OccurredAt,
 			"createdAt":          c.CreatedAt,
+			"outOfHours":         c.OutOfHours,
+			"contradictory":      c.Contradictory,
+			"autoInserted":       c.AutoInserted,
+			"transferId":         c.TransferID,
 		})
 	}
 	respondJSON(w, http.StatusOK, resp)
@@ -69,3 +95,38 @@ func parseNullUUID(value string) uuid.NullUUID {
 	}
 	return uuid.NullUUID{UUID: id, Valid: true}
 }
+
+// listCheckinPartitions reports the monthly checkin partitions.
+func (h Handler) listCheckinPartitions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	partitions, err := h.Store.ListCheckinPartitions(ctx)
+	if err != nil {
+		h.Logger.Error("list checkin partitions", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list partitions")
+		return
+	}
+	stray, err := h.Store.CountUnpartitionedCheckins(ctx)
+	if err != nil {
+		h.Logger.Error("count unpartitioned checkins", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list partitions")
+		return
+	}
+	resp := checkinPartitionsResponse{
+		Partitions:    make([]checkinPartitionDTO, 0, len(partitions)),
+		Unpartitioned: stray,
+	}
+	for _, p := range partitions {
+		resp.Partitions = append(resp.Partitions, checkinPartitionDTO{
+			Name:          p.Name,
+			Month:         p.Month,
+			Archived:      p.Archived,
+			EstimatedRows: p.EstimatedRows,
+		})
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -3,25 +3,43 @@ package admin
 import (
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
-// groupDTO matches what the admin UI needs.
+// groupDTO matches what the admin UI needs. Source is "local" for groups
+// managed here rather than synced.
 type groupDTO struct {
-	ID          uuid.UUID `json:"id"`
-	DisplayName string    `json:"displayName"`
-	Description string    `json:"description"`
+	ID          uuid.UUID         `json:"id"`
+	DisplayName string            `json:"displayName"`
+	Description string            `json:"description"`
+	Source      string            `json:"source"`
+	Rules       *store.GroupRules `json:"rules,omitempty"`
+}
+
+// localGroupBody is the editable part of a local group.
+type localGroupBody struct {
+	DisplayName string            `json:"displayName"`
+	Description string            `json:"description"`
+	Rules       *store.GroupRules `json:"rules"`
 }
 
 // groupsRoutes registers group and membership endpoints.
 func (h Handler) groupsRoutes(r chi.Router) {
 	r.Get("/", h.listGroups)
+	r.Post("/", h.createGroup)
+	r.Patch("/{id}", h.updateGroup)
+	r.Delete("/{id}", h.deleteGroup)
 	r.Get("/{id}/members", h.groupEffectiveMembers)
+	r.Post("/{id}/members", h.addGroupMembers)
+	r.Delete("/{id}/members/{userId}", h.removeGroupMember)
 }
 
 // listGroups returns directory groups with optional search.
@@ -41,11 +59,7 @@ func (h Handler) listGroups(w http.ResponseWriter, r *http.Request) {
 	}
 	resp := make([]groupDTO, 0, len(groups))
 	for _, g := range groups {
-		resp = append(resp, groupDTO{
-			ID:          g.ID,
-			DisplayName: g.DisplayName,
-			Description: g.Description.String,
-		})
+		resp = append(resp, mapGroup(g))
 	}
 	respondJSON(w, http.StatusOK, resp)
 }
@@ -90,7 +104,7 @@ func (h Handler) groupEffectiveMembers(w http.ResponseWriter, r *http.Request) {
 		members = mapUserList(users)
 	}
 	resp := groupEffectiveMembersResponse{
-		Group:     groupDTO{ID: group.ID, DisplayName: group.DisplayName, Description: group.Description.String},
+		Group:     mapGroup(group),
 		Members:   members,
 		MemberIDs: memberIDs,
 		Count:     len(memberIDs),
@@ -105,3 +119,222 @@ type groupEffectiveMembersResponse struct {
 	MemberIDs []uuid.UUID `json:"member_ids"`
 	Count     int         `json:"count"`
 }
+
+// createGroup creates a local group with optional manual members and rules.
+func (h Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	var body struct {
+		localGroupBody
+		MemberIDs []uuid.UUID `json:"memberIds"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	id := uuid.New()
+	if msg := validateLocalGroup(id, body.localGroupBody); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if !h.checkUsersExist(w, r, body.MemberIDs) {
+		return
+	}
+	group, err := h.Store.CreateLocalGroup(ctx, store.LocalGroupParams{
+		ID:          id,
+		DisplayName: strings.TrimSpace(body.DisplayName),
+		Description: strings.TrimSpace(body.Description),
+		Rules:       body.Rules,
+	}, body.MemberIDs)
+	if err != nil {
+		h.Logger.Error("create local group", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to create group")
+		return
+	}
+	respondJSON(w, http.StatusCreated, mapGroup(group))
+}
+
+// updateGroup replaces a local group's name, description and rules.
+func (h Handler) updateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	group, ok := h.loadLocalGroup(w, r)
+	if !ok {
+		return
+	}
+	var body localGroupBody
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if msg := validateLocalGroup(group.ID, body); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	updated, err := h.Store.UpdateLocalGroup(ctx, store.LocalGroupParams{
+		ID:          group.ID,
+		DisplayName: strings.TrimSpace(body.DisplayName),
+		Description: strings.TrimSpace(body.Description),
+		Rules:       body.Rules,
+	})
+	if err != nil {
+		h.Logger.Error("update local group", "err", err, "group", group.ID)
+		respondError(w, http.StatusInternalServerError, "failed to update group")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapGroup(updated))
+}
+
+// deleteGroup removes a local group. Locations listing it keep the stale ID,
+// as they do when a synced group vanishes.
+func (h Handler) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	group, ok := h.loadLocalGroup(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.Store.DeleteLocalGroup(ctx, group.ID); err != nil {
+		h.Logger.Error("delete local group", "err", err, "group", group.ID)
+		respondError(w, http.StatusInternalServerError, "failed to delete group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addGroupMembers adds manual members to a local group.
+func (h Handler) addGroupMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	group, ok := h.loadLocalGroup(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		UserIDs []uuid.UUID `json:"userIds"`
+	}
+	if err := decodeJSON(r, &body); err != nil || len(body.UserIDs) == 0 {
+		respondError(w, http.StatusBadRequest, "userIds is required")
+		return
+	}
+	if !h.checkUsersExist(w, r, body.UserIDs) {
+		return
+	}
+	if err := h.Store.AddLocalGroupMembers(ctx, group.ID, body.UserIDs); err != nil {
+		h.Logger.Error("add local group members", "err", err, "group", group.ID)
+		respondError(w, http.StatusInternalServerError, "failed to add members")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeGroupMember removes a manual member from a local group. Members that
+// match the group's rules stay until the rules change.
+func (h Handler) removeGroupMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	group, ok := h.loadLocalGroup(w, r)
+	if !ok {
+		return
+	}
+	userID, err := parseUUIDParam(r, "userId")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	removed, err := h.Store.RemoveLocalGroupMember(ctx, group.ID, userID)
+	if err != nil {
+		h.Logger.Error("remove local group member", "err", err, "group", group.ID, "user", userID)
+		respondError(w, http.StatusInternalServerError, "failed to remove member")
+		return
+	}
+	if !removed {
+		respondError(w, http.StatusNotFound, "user is not a manual member of this group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadLocalGroup fetches the group in the URL, rejecting synced groups.
+func (h Handler) loadLocalGroup(w http.ResponseWriter, r *http.Request) (sqlc.Group, bool) {
+	groupID, err := parseUUIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid group id")
+		return sqlc.Group{}, false
+	}
+	group, err := h.Store.GetGroup(r.Context(), groupID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "group not found")
+			return sqlc.Group{}, false
+		}
+		h.Logger.Error("get group", "err", err, "group", groupID)
+		respondError(w, http.StatusInternalServerError, "failed to load group")
+		return sqlc.Group{}, false
+	}
+	if group.Source.String != store.SourceLocal {
+		respondError(w, http.StatusConflict, "group is managed by the directory")
+		return sqlc.Group{}, false
+	}
+	return group, true
+}
+
+// checkUsersExist rejects member lists naming unknown users.
+func (h Handler) checkUsersExist(w http.ResponseWriter, r *http.Request, userIDs []uuid.UUID) bool {
+	for _, userID := range userIDs {
+		if _, err := h.Store.GetUser(r.Context(), userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				respondError(w, http.StatusBadRequest, "unknown user "+userID.String())
+				return false
+			}
+			h.Logger.Error("get user", "err", err, "user", userID)
+			respondError(w, http.StatusInternalServerError, "failed to load user")
+			return false
+		}
+	}
+	return true
+}
+
+// validateLocalGroup returns a message describing an invalid body, or "".
+func validateLocalGroup(id uuid.UUID, body localGroupBody) string {
+	if strings.TrimSpace(body.DisplayName) == "" {
+		return "displayName is required"
+	}
+	if body.Rules != nil && slices.Contains(body.Rules.MemberOf, id) {
+		return "rules cannot refer to the group itself"
+	}
+	return ""
+}
+
+func mapGroup(g sqlc.Group) groupDTO {
+	dto := groupDTO{
+		ID:          g.ID,
+		DisplayName: g.DisplayName,
+		Description: g.Description.String,
+		Source:      g.Source.String,
+	}
+	if rules, ok := store.DecodeGroupRules(g.Rules); ok {
+		dto.Rules = &rules
+	}
+	return dto
+}
@@ -2,8 +2,6 @@ package admin
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"net/http"
 	"time"
@@ -13,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
@@ -31,6 +30,7 @@ func (h Handler) keysRoutes(r chi.Router) {
 	r.Post("/", h.createKey)
 	r.Patch("/{id}", h.updateKey)
 	r.Delete("/{id}", h.deleteKey)
+	r.Post("/{id}/rotate", h.rotateKey)
 }
 
 func (h Handler) listKeys(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +97,7 @@ func (h Handler) createKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if body.KeyValue == "" {
-		body.KeyValue = generateKeyValue()
+		body.KeyValue = store.GenerateKeyValue()
 	}
 
 	key, err := h.Store.CreateKey(ctx, sqlc.CreateKeyParams{
@@ -211,6 +211,32 @@ func (h Handler) deleteKey(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// rotateKey replaces a key's value with a new random one.
+func (h Handler) rotateKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	keyID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid key id")
+		return
+	}
+	key, err := h.Store.RotateKey(ctx, keyID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		h.Logger.Error("rotate key", "err", err, "key", keyID)
+		respondError(w, http.StatusInternalServerError, "failed to rotate key")
+		return
+	}
+	respondJSON(w, http.StatusOK, h.mapKey(ctx, key))
+}
+
 func (h Handler) mapKey(ctx context.Context, key sqlc.Key) keyDTO {
 	locIDs, _ := h.Store.ListKeyLocations(ctx, key.ID)
 	var locs []locationDTO
@@ -228,13 +254,3 @@ func (h Handler) mapKey(ctx context.Context, key sqlc.Key) keyDTO {
 		Locations:   locs,
 	}
 }
-
-func generateKeyValue() string {
-	const keyBytes = 24
-
-	buf := make([]byte, keyBytes)
-	if _, err := rand.Read(buf); err != nil {
-		return uuid.NewString()
-	}
-	return base64.RawURLEncoding.EncodeToString(buf)
-}
@@ -3,13 +3,16 @@ package admin
 import (
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
@@ -20,8 +23,23 @@ type locationDTO struct {
 	CreatedAt    time.Time   `json:"createdAt"`
 	GroupIDs     []uuid.UUID `json:"groupIds"`
 	NotesEnabled bool        `json:"notesEnabled"`
+	ParentID     *uuid.UUID  `json:"parentId"`
+	Kind         string      `json:"kind,omitempty"`
+	// Capacity is null for unlimited locations.
+	Capacity       *int32 `json:"capacity"`
+	CapacityPolicy string `json:"capacityPolicy,omitempty"`
+	// DirectionPolicy is empty when inherited from the parent.
+	DirectionPolicy string `json:"directionPolicy,omitempty"`
+	// TransferPolicy is empty when inherited from the parent.
+	TransferPolicy string `json:"transferPolicy,omitempty"`
 }
 
+// Location kinds; kind is optional and only labels the tree level.
+var locationKinds = []string{"site", "building", "room"}
+
+// defaultPresenceWindow bounds how far back presence looks for checkins.
+const defaultPresenceWindow = 24 * time.Hour
+
 // locationsRoutes handles location CRUD.
 func (h Handler) locationsRoutes(r chi.Router) {
 	r.Get("/", h.listLocations)
@@ -29,6 +47,20 @@ func (h Handler) locationsRoutes(r chi.Router) {
 	r.Post("/", h.createLocation)
 	r.Patch("/{id}", h.updateLocation)
 	r.Delete("/{id}", h.deleteLocation)
+	r.Post("/{id}/move", h.moveLocation)
+	r.Get("/{id}/presence", h.locationPresence)
+	r.Get("/{id}/schedule", h.getLocationSchedule)
+	r.Put("/{id}/schedule", h.updateLocationSchedule)
+	r.Get("/{id}/closures", h.listClosures)
+	r.Post("/{id}/closures", h.createClosure)
+	r.Post("/{id}/closures/import", h.importClosures)
+	r.Delete("/{id}/closures/{closureId}", h.deleteClosure)
+	r.Put("/{id}/capacity", h.updateLocationCapacity)
+	r.Put("/{id}/direction-policy", h.updateLocationDirectionPolicy)
+	r.Put("/{id}/transfer-policy", h.updateLocationTransferPolicy)
+	r.Get("/{id}/occupancy", h.locationOccupancy)
+	r.Get("/{id}/retention", h.getLocationRetention)
+	r.Put("/{id}/retention", h.updateLocationRetention)
 }
 
 func (h Handler) listLocations(w http.ResponseWriter, r *http.Request) {
@@ -110,6 +142,8 @@ func (h Handler) createLocation(w http.ResponseWriter, r *http.Request) {
 		Identifier   string      `json:"identifier"`
 		GroupIDs     []uuid.UUID `json:"groupIds"`
 		NotesEnabled bool        `json:"notesEnabled"`
+		ParentID     *uuid.UUID  `json:"parentId"`
+		Kind         string      `json:"kind"`
 	}
 	if err := decodeJSON(r, &body); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid body")
@@ -119,12 +153,25 @@ func (h Handler) createLocation(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "identifier is required")
 		return
 	}
+	if !validLocationKind(body.Kind) {
+		respondError(w, http.StatusBadRequest, "kind must be site, building or room")
+		return
+	}
+	parentID := pgtype.UUID{}
+	if body.ParentID != nil {
+		if !h.checkParentLocation(w, r, *body.ParentID) {
+			return
+		}
+		parentID = pgtype.UUID{Bytes: *body.ParentID, Valid: true}
+	}
 	loc, err := h.Store.CreateLocation(ctx, sqlc.CreateLocationParams{
 		ID:           uuid.New(),
 		Name:         strings.TrimSpace(body.Name),
 		Lower:        strings.ToLower(strings.TrimSpace(body.Identifier)),
 		GroupIds:     body.GroupIDs,
 		NotesEnabled: body.NotesEnabled,
+		ParentID:     parentID,
+		Kind:         pgtype.Text{String: body.Kind, Valid: body.Kind != ""},
 	})
 	if err != nil {
 		h.Logger.Error("create location", "err", err)
@@ -151,6 +198,7 @@ func (h Handler) updateLocation(w http.ResponseWriter, r *http.Request) {
 		Identifier   string      `json:"identifier"`
 		GroupIDs     []uuid.UUID `json:"groupIds"`
 		NotesEnabled bool        `json:"notesEnabled"`
+		Kind         string      `json:"kind"`
 	}
 	err = decodeJSON(r, &body)
 	if err != nil {
@@ -161,6 +209,10 @@ func (h Handler) updateLocation(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "identifier is required")
 		return
 	}
+	if !validLocationKind(body.Kind) {
+		respondError(w, http.StatusBadRequest, "kind must be site, building or room")
+		return
+	}
 	_, err = h.Store.GetLocation(ctx, locID)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -179,6 +231,7 @@ func (h Handler) updateLocation(w http.ResponseWriter, r *http.Request) {
 		Lower:        strings.ToLower(strings.TrimSpace(body.Identifier)),
 		GroupIds:     body.GroupIDs,
 		NotesEnabled: body.NotesEnabled,
+		Kind:         pgtype.Text{String: body.Kind, Valid: body.Kind != ""},
 	})
 	if err != nil {
 		h.Logger.Error("update location", "err", err, "id", locID)
@@ -201,6 +254,10 @@ func (h Handler) deleteLocation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err = h.Store.DeleteLocation(ctx, locID); err != nil {
+		if errors.Is(err, store.ErrLocationHasChildren) {
+			respondError(w, http.StatusConflict, "move or delete child locations first")
+			return
+		}
 		h.Logger.Error("delete location", "err", err, "id", locID)
 		respondError(w, http.StatusInternalServerError, "failed to delete location")
 		return
@@ -208,13 +265,209 @@ func (h Handler) deleteLocation(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// moveLocation reparents a location; a null parentId makes it a root.
+func (h Handler) moveLocation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := sessionctx.User(ctx)
+	if !ok || !user.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	locID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid location id")
+		return
+	}
+	var body struct {
+		ParentID *uuid.UUID `json:"parentId"`
+	}
+	if err = decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	parentID := uuid.Nil
+	if body.ParentID != nil {
+		if !h.checkParentLocation(w, r, *body.ParentID) {
+			return
+		}
+		parentID = *body.ParentID
+	}
+	loc, err := h.Store.MoveLocation(ctx, locID, parentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			respondError(w, http.StatusNotFound, "location not found")
+		case errors.Is(err, store.ErrLocationCycle):
+			respondError(w, http.StatusConflict, "a location cannot move below itself")
+		default:
+			h.Logger.Error("move location", "err", err, "id", locID)
+			respondError(w, http.StatusInternalServerError, "failed to move location")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, mapLocation(loc, loc.GroupIds))
+}
+
+// presenceResponse lists who is in a location's subtree. Counts roll up, so
+// a building's count includes its rooms.
+type presenceResponse struct {
+	LocationID uuid.UUID       `json:"locationId"`
+	Total      int             `json:"total"`
+	Since      time.Time       `json:"since"`
+	Locations  []presenceCount `json:"locations"`
+	Users      []presenceEntry `json:"users"`
+}
+
+type presenceCount struct {
+	ID       uuid.UUID  `json:"id"`
+	Name     string     `json:"name"`
+	ParentID *uuid.UUID `json:"parentId"`
+	Count    int        `json:"count"`
+}
+
+type presenceEntry struct {
+	UserID          uuid.UUID `json:"userId"`
+	UserDisplayName string    `json:"userDisplayName"`
+	UserUPN         string    `json:"userUpn"`
+	LocationID      uuid.UUID `json:"locationId"`
+	LocationName    string    `json:"locationName"`
+	Since           time.Time `json:"since"`
+}
+
+// locationPresence reports users whose latest checkin placed them inside the
+// location's subtree. since (RFC 3339) bounds the lookback, 24h by default.
+func (h Handler) locationPresence(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := sessionctx.User(ctx)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "auth required")
+		return
+	}
+	locID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid location id")
+		return
+	}
+	since := time.Now().Add(-defaultPresenceWindow)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			respondError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+	}
+	if !user.IsAdmin {
+		hasAccess, accessErr := h.Store.HasUserLocationAccess(ctx, user.ID, locID)
+		if accessErr != nil {
+			h.Logger.Error("check location access", "err", accessErr, "user", user.ID, "location", locID)
+			respondError(w, http.StatusInternalServerError, "failed to check access")
+			return
+		}
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "insufficient permissions")
+			return
+		}
+	}
+	locs, err := h.Store.ListLocations(ctx, "")
+	if err != nil {
+		h.Logger.Error("list locations", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list locations")
+		return
+	}
+	rows, err := h.Store.ListPresence(ctx, locID, since)
+	if err != nil {
+		h.Logger.Error("list presence", "err", err, "location", locID)
+		respondError(w, http.StatusInternalServerError, "failed to load presence")
+		return
+	}
+	resp := presenceResponse{
+		LocationID: locID,
+		Total:      len(rows),
+		Since:      since,
+		Users:      make([]presenceEntry, 0, len(rows)),
+	}
+	byID := make(map[uuid.UUID]sqlc.Location, len(locs))
+	for _, l := range locs {
+		byID[l.ID] = l
+	}
+	counts := make(map[uuid.UUID]int)
+	for _, row := range rows {
+		resp.Users = append(resp.Users, presenceEntry{
+			UserID:          row.UserID,
+			UserDisplayName: row.UserDisplayName,
+			UserUPN:         row.UserUpn,
+			LocationID:      row.LocationID,
+			LocationName:    row.LocationName,
+			Since:           row.OccurredAt.Time,
+		})
+		// Count the user at their location and each ancestor up to this one.
+		for id := row.LocationID; ; {
+			counts[id]++
+			l, found := byID[id]
+			if id == locID || !found || !l.ParentID.Valid {
+				break
+			}
+			id = uuid.UUID(l.ParentID.Bytes)
+		}
+	}
+	for _, l := range locs {
+		if count, found := counts[l.ID]; found {
+			resp.Locations = append(resp.Locations, presenceCount{
+				ID:       l.ID,
+				Name:     l.Name,
+				ParentID: parentID(l),
+				Count:    count,
+			})
+		}
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// checkParentLocation rejects unknown parent locations.
+func (h Handler) checkParentLocation(w http.ResponseWriter, r *http.Request, parentID uuid.UUID) bool {
+	if _, err := h.Store.GetLocation(r.Context(), parentID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusBadRequest, "parent location not found")
+			return false
+		}
+		h.Logger.Error("get location", "err", err, "id", parentID)
+		respondError(w, http.StatusInternalServerError, "failed to load location")
+		return false
+	}
+	return true
+}
+
+func validLocationKind(kind string) bool {
+	return kind == "" || slices.Contains(locationKinds, kind)
+}
+
+func parentID(loc sqlc.Location) *uuid.UUID {
+	if !loc.ParentID.Valid {
+		return nil
+	}
+	id := uuid.UUID(loc.ParentID.Bytes)
+	return &id
+}
+
 func mapLocation(loc sqlc.Location, groupIDs []uuid.UUID) locationDTO {
 	return locationDTO{
-		ID:           loc.ID,
-		Name:         loc.Name,
-		Identifier:   loc.Identifier,
-		CreatedAt:    loc.CreatedAt.Time,
-		GroupIDs:     groupIDs,
-		NotesEnabled: loc.NotesEnabled,
+		ID:              loc.ID,
+		Name:            loc.Name,
+		Identifier:      loc.Identifier,
+		CreatedAt:       loc.CreatedAt.Time,
+		GroupIDs:        groupIDs,
+		NotesEnabled:    loc.NotesEnabled,
+		ParentID:        parentID(loc),
+		Kind:            loc.Kind.String,
+		Capacity:        locationCapacity(loc),
+		CapacityPolicy:  loc.CapacityPolicy.String,
+		DirectionPolicy: loc.DirectionPolicy.String,
+		TransferPolicy:  loc.TransferPolicy.String,
+	}
+}
+
+func locationCapacity(loc sqlc.Location) *int32 {
+	if !loc.Capacity.Valid {
+		return nil
 	}
+	return &loc.Capacity.Int32
 }
@@ -0,0 +1,256 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+const (
+	// defaultOccupancyStep is the spacing of occupancy history samples.
+	defaultOccupancyStep = time.Hour
+	// maxOccupancySamples bounds the history one request may ask for.
+	maxOccupancySamples = 500
+)
+
+var capacityPolicies = []string{store.CapacityPolicySoft, store.CapacityPolicyHard, store.CapacityPolicyWaitlist}
+
+var directionPolicies = []string{store.DirectionPolicyReject, store.DirectionPolicyInsert, store.DirectionPolicyFlag}
+
+var transferPolicies = []string{store.TransferPolicyAllow, store.TransferPolicyDeny}
+
+type occupancyResponse struct {
+	LocationID uuid.UUID          `json:"locationId"`
+	Current    int                `json:"current"`
+	Limits     []capacityLimitDTO `json:"limits"`
+	Waitlist   []waitlistEntry    `json:"waitlist"`
+	History    []occupancySample  `json:"history"`
+}
+
+type capacityLimitDTO struct {
+	LocationID uuid.UUID `json:"locationId"`
+	Name       string    `json:"name"`
+	Capacity   int       `json:"capacity"`
+	Policy     string    `json:"policy"`
+	Occupancy  int       `json:"occupancy"`
+	Full       bool      `json:"full"`
+}
+
+type waitlistEntry struct {
+	UserID          uuid.UUID `json:"userId"`
+	UserDisplayName string    `json:"userDisplayName"`
+	UserUPN         string    `json:"userUpn"`
+	Since           time.Time `json:"since"`
+}
+
+type occupancySample struct {
+	At        time.Time `json:"at"`
+	Occupancy int32     `json:"occupancy"`
+}
+
+// updateLocationCapacity sets or clears a location's capacity and what
+// happens once it is reached.
+func (h Handler) updateLocationCapacity(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Capacity *int32 `json:"capacity"`
+		Policy   string `json:"policy"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.Capacity != nil && *body.Capacity <= 0 {
+		respondError(w, http.StatusBadRequest, "capacity must be positive")
+		return
+	}
+	if body.Policy != "" && !slices.Contains(capacityPolicies, body.Policy) {
+		respondError(w, http.StatusBadRequest, "policy must be soft, hard or waitlist")
+		return
+	}
+	params := sqlc.SetLocationCapacityParams{
+		ID:             loc.ID,
+		CapacityPolicy: pgtype.Text{String: body.Policy, Valid: body.Policy != ""},
+	}
+	if body.Capacity != nil {
+		params.Capacity = pgtype.Int4{Int32: *body.Capacity, Valid: true}
+	}
+	updated, err := h.Store.SetLocationCapacity(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		h.Logger.Error("set location capacity", "err", err, "id", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to save capacity")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapLocation(updated, updated.GroupIds))
+}
+
+// updateLocationDirectionPolicy sets what happens to a checkin that
+// contradicts the user's state, such as a second in without an out. An empty
+// policy inherits the parent's.
+func (h Handler) updateLocationDirectionPolicy(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Policy string `json:"policy"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.Policy != "" && !slices.Contains(directionPolicies, body.Policy) {
+		respondError(w, http.StatusBadRequest, "policy must be reject, insert or flag")
+		return
+	}
+	updated, err := h.Store.SetLocationDirectionPolicy(r.Context(), sqlc.SetLocationDirectionPolicyParams{
+		ID:              loc.ID,
+		DirectionPolicy: pgtype.Text{String: body.Policy, Valid: body.Policy != ""},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		h.Logger.Error("set location direction policy", "err", err, "id", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to save direction policy")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapLocation(updated, updated.GroupIds))
+}
+
+// updateLocationTransferPolicy sets whether a location takes part in
+// transfers: deny stops its kiosks starting them and keeps them from checking
+// anyone out of it. An empty policy inherits the parent's.
+func (h Handler) updateLocationTransferPolicy(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Policy string `json:"policy"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.Policy != "" && !slices.Contains(transferPolicies, body.Policy) {
+		respondError(w, http.StatusBadRequest, "policy must be allow or deny")
+		return
+	}
+	updated, err := h.Store.SetLocationTransferPolicy(r.Context(), sqlc.SetLocationTransferPolicyParams{
+		ID:             loc.ID,
+		TransferPolicy: pgtype.Text{String: body.Policy, Valid: body.Policy != ""},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		h.Logger.Error("set location transfer policy", "err", err, "id", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to save transfer policy")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapLocation(updated, updated.GroupIds))
+}
+
+// locationOccupancy reports current occupancy, the limits that apply, the
+// waitlist and sampled history. from and to (RFC 3339) default to the last
+// 24 hours; step is a duration such as "15m", an hour by default.
+func (h Handler) locationOccupancy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	loc, ok := h.loadViewableLocation(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-store.OccupancyWindow)
+	step := defaultOccupancyStep
+	var err error
+	if raw := query.Get("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			respondError(w, http.StatusBadRequest, "from must be an RFC 3339 time")
+			return
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			respondError(w, http.StatusBadRequest, "to must be an RFC 3339 time")
+			return
+		}
+	}
+	if raw := query.Get("step"); raw != "" {
+		if step, err = time.ParseDuration(raw); err != nil || step < time.Minute {
+			respondError(w, http.StatusBadRequest, "step must be a duration of at least 1m")
+			return
+		}
+	}
+	if to.Before(from) || to.Sub(from)/step > maxOccupancySamples {
+		respondError(w, http.StatusBadRequest, "range must run forwards and hold at most 500 steps")
+		return
+	}
+
+	current, limits, err := h.Store.LocationOccupancy(ctx, loc.ID)
+	if err != nil {
+		h.Logger.Error("location occupancy", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to load occupancy")
+		return
+	}
+	waitlist, err := h.Store.ListWaitlist(ctx, loc.ID)
+	if err != nil {
+		h.Logger.Error("list waitlist", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to load occupancy")
+		return
+	}
+	history, err := h.Store.ListOccupancyHistory(ctx, loc.ID, from, to, step)
+	if err != nil {
+		h.Logger.Error("list occupancy history", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to load occupancy")
+		return
+	}
+
+	resp := occupancyResponse{
+		LocationID: loc.ID,
+		Current:    current,
+		Limits:     make([]capacityLimitDTO, 0, len(limits)),
+		Waitlist:   make([]waitlistEntry, 0, len(waitlist)),
+		History:    make([]occupancySample, 0, len(history)),
+	}
+	for _, l := range limits {
+		resp.Limits = append(resp.Limits, capacityLimitDTO{
+			LocationID: l.LocationID,
+			Name:       l.Name,
+			Capacity:   l.Capacity,
+			Policy:     l.Policy,
+			Occupancy:  l.Occupancy,
+			Full:       l.Full(),
+		})
+	}
+	for _, entry := range waitlist {
+		resp.Waitlist = append(resp.Waitlist, waitlistEntry{
+			UserID:          entry.UserID,
+			UserDisplayName: entry.UserDisplayName,
+			UserUPN:         entry.UserUpn,
+			Since:           entry.CreatedAt.Time,
+		})
+	}
+	for _, sample := range history {
+		resp.History = append(resp.History, occupancySample{At: sample.At.Time, Occupancy: sample.Occupancy})
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,270 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+const retentionHistoryLimit = int32(20)
+
+var retentionActions = []string{store.RetentionDelete, store.RetentionAnonymise}
+
+// retentionDTO shows a location's own retention settings, where null inherits
+// from the parent, next to the resolved rule.
+type retentionDTO struct {
+	RetentionDays      *int32             `json:"retentionDays"`
+	RetentionAction    *string            `json:"retentionAction"`
+	NotesRetentionDays *int32             `json:"notesRetentionDays"`
+	Effective          effectiveRetention `json:"effective"`
+}
+
+// effectiveRetention is a resolved rule; null days keep data indefinitely.
+type effectiveRetention struct {
+	Days      *int   `json:"days"`
+	Action    string `json:"action"`
+	NotesDays *int   `json:"notesDays"`
+}
+
+type retentionCountsDTO struct {
+	Deleted      int64 `json:"deleted"`
+	Anonymised   int64 `json:"anonymised"`
+	NotesCleared int64 `json:"notesCleared"`
+}
+
+// retentionPreviewDTO reports what a retention pass would change now.
+type retentionPreviewDTO struct {
+	At        time.Time                  `json:"at"`
+	Locations []retentionLocationPreview `json:"locations"`
+	Total     retentionCountsDTO         `json:"total"`
+}
+
+type retentionLocationPreview struct {
+	LocationID   uuid.UUID          `json:"locationId"`
+	Name         string             `json:"name"`
+	Rule         effectiveRetention `json:"rule"`
+	ExpireBefore *time.Time         `json:"expireBefore"`
+	NotesBefore  *time.Time         `json:"notesBefore"`
+	retentionCountsDTO
+}
+
+type retentionRunDTO struct {
+	ID         uuid.UUID  `json:"id"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	retentionCountsDTO
+	Error string `json:"error,omitempty"`
+}
+
+// retentionRoutes registers the retention dry run and history.
+func (h Handler) retentionRoutes(r chi.Router) {
+	r.Get("/preview", h.previewRetention)
+	r.Get("/runs", h.listRetentionRuns)
+}
+
+func (h Handler) getLocationRetention(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	h.respondRetention(w, r, loc)
+}
+
+// updateLocationRetention replaces a location's retention settings. Null
+// fields inherit from the parent location.
+func (h Handler) updateLocationRetention(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		RetentionDays      *int32  `json:"retentionDays"`
+		RetentionAction    *string `json:"retentionAction"`
+		NotesRetentionDays *int32  `json:"notesRetentionDays"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if (body.RetentionDays != nil && *body.RetentionDays <= 0) ||
+		(body.NotesRetentionDays != nil && *body.NotesRetentionDays <= 0) {
+		respondError(w, http.StatusBadRequest, "retention days must be positive")
+		return
+	}
+	params := sqlc.SetLocationRetentionParams{ID: loc.ID}
+	if body.RetentionDays != nil {
+		params.RetentionDays = pgtype.Int4{Int32: *body.RetentionDays, Valid: true}
+	}
+	if body.RetentionAction != nil && *body.RetentionAction != "" {
+		if !slices.Contains(retentionActions, *body.RetentionAction) {
+			respondError(w, http.StatusBadRequest, "retentionAction must be delete or anonymise")
+			return
+		}
+		params.RetentionAction = pgtype.Text{String: *body.RetentionAction, Valid: true}
+	}
+	if body.NotesRetentionDays != nil {
+		params.NotesRetentionDays = pgtype.Int4{Int32: *body.NotesRetentionDays, Valid: true}
+	}
+	updated, err := h.Store.SetLocationRetention(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		h.Logger.Error("set location retention", "err", err, "id", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to save retention")
+		return
+	}
+	h.respondRetention(w, r, updated)
+}
+
+func (h Handler) respondRetention(w http.ResponseWriter, r *http.Request, loc sqlc.Location) {
+	rule, err := h.Store.GetRetentionRule(r.Context(), loc.ID)
+	if err != nil {
+		h.Logger.Error("resolve retention", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to load retention")
+		return
+	}
+	resp := retentionDTO{Effective: mapRetentionRule(rule)}
+	if loc.RetentionDays.Valid {
+		resp.RetentionDays = &loc.RetentionDays.Int32
+	}
+	if loc.RetentionAction.Valid {
+		resp.RetentionAction = &loc.RetentionAction.String
+	}
+	if loc.NotesRetentionDays.Valid {
+		resp.NotesRetentionDays = &loc.NotesRetentionDays.Int32
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// previewRetention is a dry run: it counts what the retention job would
+// delete, anonymise and clear if it ran now, for every location with a rule
+// or only the one in locationId.
+func (h Handler) previewRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	var rules []store.RetentionRule
+	var err error
+	if raw := r.URL.Query().Get("locationId"); raw != "" {
+		locID, parseErr := uuid.Parse(raw)
+		if parseErr != nil {
+			respondError(w, http.StatusBadRequest, "invalid location id")
+			return
+		}
+		var rule store.RetentionRule
+		rule, err = h.Store.GetRetentionRule(ctx, locID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		if rule.Active() {
+			rules = append(rules, rule)
+		}
+	} else {
+		rules, err = h.Store.ListRetentionRules(ctx)
+	}
+	if err != nil {
+		h.Logger.Error("list retention rules", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to preview retention")
+		return
+	}
+
+	now := time.Now()
+	resp := retentionPreviewDTO{At: now, Locations: make([]retentionLocationPreview, 0, len(rules))}
+	var total store.RetentionCounts
+	for _, rule := range rules {
+		counts, previewErr := h.Store.PreviewRetention(ctx, rule, now)
+		if previewErr != nil {
+			h.Logger.Error("preview retention", "err", previewErr, "location", rule.LocationID)
+			respondError(w, http.StatusInternalServerError, "failed to preview retention")
+			return
+		}
+		total.Add(counts)
+		preview := retentionLocationPreview{
+			LocationID:         rule.LocationID,
+			Name:               rule.Name,
+			Rule:               mapRetentionRule(rule),
+			retentionCountsDTO: mapRetentionCounts(counts),
+		}
+		expireBefore, notesBefore := rule.Cutoffs(now)
+		if !expireBefore.IsZero() {
+			preview.ExpireBefore = &expireBefore
+		}
+		if !notesBefore.IsZero() {
+			preview.NotesBefore = &notesBefore
+		}
+		resp.Locations = append(resp.Locations, preview)
+	}
+	resp.Total = mapRetentionCounts(total)
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// listRetentionRuns returns recent retention passes, newest first.
+func (h Handler) listRetentionRuns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	runs, err := h.Store.ListRetentionRuns(ctx, retentionHistoryLimit)
+	if err != nil {
+		h.Logger.Error("list retention runs", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list retention runs")
+		return
+	}
+	resp := make([]retentionRunDTO, 0, len(runs))
+	for _, run := range runs {
+		dto := retentionRunDTO{
+			ID:        run.ID,
+			Status:    run.Status,
+			StartedAt: run.StartedAt.Time,
+			retentionCountsDTO: retentionCountsDTO{
+				Deleted:      run.Deleted,
+				Anonymised:   run.Anonymised,
+				NotesCleared: run.NotesCleared,
+			},
+			Error: run.Error.String,
+		}
+		if run.FinishedAt.Valid {
+			t := run.FinishedAt.Time
+			dto.FinishedAt = &t
+		}
+		resp = append(resp, dto)
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func mapRetentionRule(rule store.RetentionRule) effectiveRetention {
+	dto := effectiveRetention{Action: rule.Action}
+	if rule.Days > 0 {
+		dto.Days = &rule.Days
+	}
+	if rule.NotesDays > 0 {
+		dto.NotesDays = &rule.NotesDays
+	}
+	return dto
+}
+
+func mapRetentionCounts(counts store.RetentionCounts) retentionCountsDTO {
+	return retentionCountsDTO{
+		Deleted:      counts.Deleted,
+		Anonymised:   counts.Anonymised,
+		NotesCleared: counts.NotesCleared,
+	}
+}
@@ -5,19 +5,30 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/settings"
 	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
 )
 
 // Handler carries admin handlers and shared deps.
 type Handler struct {
-	Store  *store.Store
-	Logger *slog.Logger
-	Config config.Config
+	Store    *store.Store
+	Logger   *slog.Logger
+	Config   config.Config
+	Sync     *syncer.Runner
+	Settings *settings.Service
 }
 
 // RegisterRoutes mounts admin endpoints under /v1.
-func RegisterRoutes(r chi.Router, cfg config.Config, store *store.Store, logger *slog.Logger) {
-	h := Handler{Store: store, Logger: logger, Config: cfg}
+func RegisterRoutes(
+	r chi.Router,
+	cfg config.Config,
+	store *store.Store,
+	sync *syncer.Runner,
+	settings *settings.Service,
+	logger *slog.Logger,
+) {
+	h := Handler{Store: store, Logger: logger, Config: cfg, Sync: sync, Settings: settings}
 	r.Route("/v1", func(r chi.Router) {
 		r.Route("/locations", h.locationsRoutes)
 		r.Route("/keys", h.keysRoutes)
@@ -25,5 +36,8 @@ func RegisterRoutes(r chi.Router, cfg config.Config, store *store.Store, logger
 		r.Route("/groups", h.groupsRoutes)
 		r.Route("/checkins", h.checkinsRoutes)
 		r.Route("/settings", h.settingsRoutes)
+		r.Route("/branding", h.brandingRoutes)
+		r.Route("/sync", h.syncRoutes)
+		r.Route("/retention", h.retentionRoutes)
 	})
 }
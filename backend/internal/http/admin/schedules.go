@@ -0,0 +1,358 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/schedule"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// maxCalendarUploadBytes bounds iCal imports.
+const maxCalendarUploadBytes = int64(4 << 20) // 4MiB
+
+// dateLayout is the format of closure dates.
+const dateLayout = "2006-01-02"
+
+var outOfHoursPolicies = []string{schedule.PolicyAllow, schedule.PolicyFlag, schedule.PolicyReject}
+
+// scheduleDTO shows a location's own settings, where null inherits from the
+// parent, next to the resolved schedule and current status.
+type scheduleDTO struct {
+	Timezone         *string           `json:"timezone"`
+	OpeningHours     schedule.Hours    `json:"openingHours"`
+	OutOfHoursPolicy *string           `json:"outOfHoursPolicy"`
+	Effective        effectiveSchedule `json:"effective"`
+	Status           scheduleStatus    `json:"status"`
+}
+
+type effectiveSchedule struct {
+	Timezone         string         `json:"timezone"`
+	OpeningHours     schedule.Hours `json:"openingHours"`
+	OutOfHoursPolicy string         `json:"outOfHoursPolicy"`
+}
+
+type scheduleStatus struct {
+	Open         bool       `json:"open"`
+	ClosedReason string     `json:"closedReason,omitempty"`
+	NextOpen     *time.Time `json:"nextOpen"`
+}
+
+type closureDTO struct {
+	ID         uuid.UUID `json:"id"`
+	LocationID uuid.UUID `json:"locationId"`
+	StartsOn   string    `json:"startsOn"`
+	EndsOn     string    `json:"endsOn"`
+	Reason     string    `json:"reason"`
+	Source     string    `json:"source"`
+	UID        string    `json:"uid,omitempty"`
+}
+
+func (h Handler) getLocationSchedule(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadViewableLocation(w, r)
+	if !ok {
+		return
+	}
+	h.respondSchedule(w, r, loc)
+}
+
+// updateLocationSchedule replaces a location's timezone, opening hours and
+// out-of-hours policy. Null fields inherit from the parent location.
+func (h Handler) updateLocationSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := sessionctx.User(ctx)
+	if !ok || !user.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	locID, err := parseUUIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid location id")
+		return
+	}
+	var body struct {
+		Timezone         *string         `json:"timezone"`
+		OpeningHours     json.RawMessage `json:"openingHours"`
+		OutOfHoursPolicy *string         `json:"outOfHoursPolicy"`
+	}
+	if err = decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	params := sqlc.SetLocationScheduleParams{ID: locID}
+	if body.Timezone != nil && *body.Timezone != "" {
+		if _, err = time.LoadLocation(*body.Timezone); err != nil {
+			respondError(w, http.StatusBadRequest, "unknown timezone")
+			return
+		}
+		params.Timezone = pgtype.Text{String: *body.Timezone, Valid: true}
+	}
+	if len(body.OpeningHours) > 0 && string(body.OpeningHours) != "null" {
+		hours, hoursErr := schedule.ParseHours(body.OpeningHours)
+		if hoursErr != nil {
+			respondError(w, http.StatusBadRequest, hoursErr.Error())
+			return
+		}
+		if params.OpeningHours, err = json.Marshal(hours); err != nil {
+			h.Logger.Error("encode opening hours", "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to save schedule")
+			return
+		}
+	}
+	if body.OutOfHoursPolicy != nil && *body.OutOfHoursPolicy != "" {
+		if !slices.Contains(outOfHoursPolicies, *body.OutOfHoursPolicy) {
+			respondError(w, http.StatusBadRequest, "outOfHoursPolicy must be allow, flag or reject")
+			return
+		}
+		params.OutOfHoursPolicy = pgtype.Text{String: *body.OutOfHoursPolicy, Valid: true}
+	}
+	loc, err := h.Store.SetLocationSchedule(ctx, params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return
+		}
+		h.Logger.Error("set location schedule", "err", err, "id", locID)
+		respondError(w, http.StatusInternalServerError, "failed to save schedule")
+		return
+	}
+	h.respondSchedule(w, r, loc)
+}
+
+func (h Handler) listClosures(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadViewableLocation(w, r)
+	if !ok {
+		return
+	}
+	closures, err := h.Store.ListLocationClosures(r.Context(), loc.ID)
+	if err != nil {
+		h.Logger.Error("list closures", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to list closures")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapClosures(closures))
+}
+
+// createClosure adds a manual closure; endsOn defaults to startsOn.
+func (h Handler) createClosure(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		StartsOn string `json:"startsOn"`
+		EndsOn   string `json:"endsOn"`
+		Reason   string `json:"reason"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.EndsOn == "" {
+		body.EndsOn = body.StartsOn
+	}
+	start, startErr := time.Parse(dateLayout, body.StartsOn)
+	end, endErr := time.Parse(dateLayout, body.EndsOn)
+	if startErr != nil || endErr != nil {
+		respondError(w, http.StatusBadRequest, "startsOn and endsOn must be YYYY-MM-DD dates")
+		return
+	}
+	if end.Before(start) {
+		respondError(w, http.StatusBadRequest, "endsOn must not be before startsOn")
+		return
+	}
+	closure, err := h.Store.CreateLocationClosure(ctx, loc.ID, schedule.Closure{
+		Start:  start,
+		End:    end,
+		Reason: strings.TrimSpace(body.Reason),
+	})
+	if err != nil {
+		h.Logger.Error("create closure", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to create closure")
+		return
+	}
+	respondJSON(w, http.StatusCreated, mapClosure(closure))
+}
+
+// importClosures replaces the location's imported closures with the events of
+// an uploaded iCal file. Manual closures are kept.
+func (h Handler) importClosures(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCalendarUploadBytes)
+	if err := r.ParseMultipartForm(maxCalendarUploadBytes); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid upload")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "calendar file is required")
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			h.Logger.Warn("close calendar upload", "err", cerr)
+		}
+	}()
+	events, err := schedule.ParseICal(file)
+	if err != nil {
+		if errors.Is(err, schedule.ErrInvalidCalendar) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.Logger.Error("read calendar upload", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to read upload")
+		return
+	}
+	closures, err := h.Store.ReplaceCalendarClosures(ctx, loc.ID, events)
+	if err != nil {
+		h.Logger.Error("import closures", "err", err, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to import closures")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapClosures(closures))
+}
+
+func (h Handler) deleteClosure(w http.ResponseWriter, r *http.Request) {
+	loc, ok := h.loadAdminLocation(w, r)
+	if !ok {
+		return
+	}
+	closureID, err := parseUUIDParam(r, "closureId")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid closure id")
+		return
+	}
+	deleted, err := h.Store.DeleteLocationClosure(r.Context(), loc.ID, closureID)
+	if err != nil {
+		h.Logger.Error("delete closure", "err", err, "id", closureID)
+		respondError(w, http.StatusInternalServerError, "failed to delete closure")
+		return
+	}
+	if !deleted {
+		respondError(w, http.StatusNotFound, "closure not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) respondSchedule(w http.ResponseWriter, r *http.Request, loc sqlc.Location) {
+	now := time.Now()
+	sched, err := h.Store.GetLocationSchedule(r.Context(), loc.ID, now)
+	if err != nil {
+		h.Logger.Error("get location schedule", "err", err, "id", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to load schedule")
+		return
+	}
+	status := sched.StatusAt(now)
+	resp := scheduleDTO{
+		Effective: effectiveSchedule{
+			Timezone:         sched.Timezone,
+			OpeningHours:     sched.Hours,
+			OutOfHoursPolicy: sched.Policy,
+		},
+		Status: scheduleStatus{
+			Open:         status.Open,
+			ClosedReason: status.Reason,
+			NextOpen:     status.NextOpen,
+		},
+	}
+	if loc.Timezone.Valid {
+		resp.Timezone = &loc.Timezone.String
+	}
+	if len(loc.OpeningHours) > 0 {
+		// Stored hours were validated on write.
+		resp.OpeningHours, _ = schedule.ParseHours(loc.OpeningHours)
+	}
+	if loc.OutOfHoursPolicy.Valid {
+		resp.OutOfHoursPolicy = &loc.OutOfHoursPolicy.String
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// loadViewableLocation loads the location in the URL if the viewer is an
+// admin or has access to it.
+func (h Handler) loadViewableLocation(w http.ResponseWriter, r *http.Request) (sqlc.Location, bool) {
+	ctx := r.Context()
+	user, ok := sessionctx.User(ctx)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "auth required")
+		return sqlc.Location{}, false
+	}
+	loc, ok := h.loadLocation(w, r)
+	if !ok || user.IsAdmin {
+		return loc, ok
+	}
+	hasAccess, err := h.Store.HasUserLocationAccess(ctx, user.ID, loc.ID)
+	if err != nil {
+		h.Logger.Error("check location access", "err", err, "user", user.ID, "location", loc.ID)
+		respondError(w, http.StatusInternalServerError, "failed to check access")
+		return sqlc.Location{}, false
+	}
+	if !hasAccess {
+		respondError(w, http.StatusForbidden, "insufficient permissions")
+		return sqlc.Location{}, false
+	}
+	return loc, true
+}
+
+// loadAdminLocation loads the location in the URL for an admin.
+func (h Handler) loadAdminLocation(w http.ResponseWriter, r *http.Request) (sqlc.Location, bool) {
+	user, ok := sessionctx.User(r.Context())
+	if !ok || !user.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return sqlc.Location{}, false
+	}
+	return h.loadLocation(w, r)
+}
+
+func (h Handler) loadLocation(w http.ResponseWriter, r *http.Request) (sqlc.Location, bool) {
+	locID, err := parseUUIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid location id")
+		return sqlc.Location{}, false
+	}
+	loc, err := h.Store.GetLocation(r.Context(), locID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "location not found")
+			return sqlc.Location{}, false
+		}
+		h.Logger.Error("get location", "err", err, "id", locID)
+		respondError(w, http.StatusInternalServerError, "failed to load location")
+		return sqlc.Location{}, false
+	}
+	return loc, true
+}
+
+func mapClosures(closures []sqlc.LocationClosure) []closureDTO {
+	resp := make([]closureDTO, 0, len(closures))
+	for _, c := range closures {
+		resp = append(resp, mapClosure(c))
+	}
+	return resp
+}
+
+func mapClosure(c sqlc.LocationClosure) closureDTO {
+	return closureDTO{
+		ID:         c.ID,
+		LocationID: c.LocationID,
+		StartsOn:   c.StartsOn.Time.Format(dateLayout),
+		EndsOn:     c.EndsOn.Time.Format(dateLayout),
+		Reason:     c.Reason,
+		Source:     c.Source,
+		UID:        c.Uid.String,
+	}
+}
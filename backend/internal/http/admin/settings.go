@@ -1,26 +1,21 @@
 package admin
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/settings"
+	"github.com/woodleighschool/signin-ui/internal/store"
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
-const maxBackgroundUploadBytes = int64(2 << 20) // 2MiB
-
-var allowedBackgroundTypes = map[string]struct{}{ //nolint:gochecknoglobals // allowed upload types
-	"image/jpeg":  {},
-	"image/pjpeg": {},
-}
-
 type portalBackgroundResponse struct {
 	HasImage    bool       `json:"hasImage"`
 	URL         string     `json:"url,omitempty"`
@@ -28,13 +23,39 @@ type portalBackgroundResponse struct {
 	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
 }
 
+// settingDTO is a runtime setting with its bounds and stored state.
+type settingDTO struct {
+	Key         string     `json:"key"`
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Value       any        `json:"value"`
+	Default     any        `json:"default"`
+	Min         any        `json:"min"`
+	Max         any        `json:"max"`
+	IsDefault   bool       `json:"isDefault"`
+	Version     int64      `json:"version"`
+	UpdatedBy   *uuid.UUID `json:"updatedBy,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+}
+
+// settingUpdateRequest sets a value. Version, when present, must match the
+// stored version; 0 means the setting has never been changed.
+type settingUpdateRequest struct {
+	Value   json.RawMessage `json:"value"`
+	Version *int64          `json:"version"`
+}
+
 func (h Handler) settingsRoutes(r chi.Router) {
+	r.Get("/", h.listSettings)
 	r.Get("/portal-background", h.getPortalBackground)
 	r.Post("/portal-background", h.uploadPortalBackground)
 	r.Delete("/portal-background", h.deletePortalBackground)
+	r.Get("/{key}", h.getSetting)
+	r.Put("/{key}", h.updateSetting)
+	r.Delete("/{key}", h.resetSetting)
 }
 
-func (h Handler) getPortalBackground(w http.ResponseWriter, r *http.Request) {
+func (h Handler) listSettings(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	viewer, ok := sessionctx.User(ctx)
 	if !ok || !viewer.IsAdmin {
@@ -42,21 +63,20 @@ func (h Handler) getPortalBackground(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	asset, err := h.Store.GetAsset(ctx, "portal_background")
+	entries, err := h.Settings.List(ctx)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			respondJSON(w, http.StatusOK, portalBackgroundResponse{HasImage: false})
-			return
-		}
-		h.Logger.Error("load portal background", "err", err)
-		respondError(w, http.StatusInternalServerError, "failed to load background")
+		h.Logger.Error("list settings", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load settings")
 		return
 	}
-
-	respondJSON(w, http.StatusOK, mapPortalBackground(asset))
+	dtos := make([]settingDTO, 0, len(entries))
+	for _, entry := range entries {
+		dtos = append(dtos, mapSetting(entry))
+	}
+	respondJSON(w, http.StatusOK, dtos)
 }
 
-func (h Handler) uploadPortalBackground(w http.ResponseWriter, r *http.Request) {
+func (h Handler) getSetting(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	viewer, ok := sessionctx.User(ctx)
 	if !ok || !viewer.IsAdmin {
@@ -64,51 +84,137 @@ func (h Handler) uploadPortalBackground(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxBackgroundUploadBytes)
-	if err := r.ParseMultipartForm(maxBackgroundUploadBytes); err != nil {
-		respondError(w, http.StatusBadRequest, "invalid upload")
+	entry, err := h.Settings.Get(ctx, chi.URLParam(r, "key"))
+	if err != nil {
+		h.respondSettingError(w, "load setting", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, mapSetting(entry))
+}
+
+func (h Handler) updateSetting(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	var body settingUpdateRequest
+	if err := decodeJSON(r, &body); err != nil || len(body.Value) == 0 {
+		respondError(w, http.StatusBadRequest, "value is required")
+		return
+	}
+	entry, err := h.Settings.Set(ctx, chi.URLParam(r, "key"), body.Value, viewer.ID, body.Version)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "background file is required")
+		h.respondSettingError(w, "update setting", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, mapSetting(entry))
+}
+
+// resetSetting puts a setting back on its default. An optional version query
+// param guards against overwriting someone else's change.
+func (h Handler) resetSetting(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
 		return
 	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			h.Logger.Warn("close background upload", "err", cerr)
-		}
-	}()
 
-	data, err := io.ReadAll(file)
+	var expected *int64
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid version")
+			return
+		}
+		expected = &version
+	}
+	entry, err := h.Settings.Reset(ctx, chi.URLParam(r, "key"), viewer.ID, expected)
 	if err != nil {
-		h.Logger.Error("read background file", "err", err)
-		respondError(w, http.StatusInternalServerError, "failed to read upload")
+		h.respondSettingError(w, "reset setting", err)
 		return
 	}
-	if len(data) == 0 {
-		respondError(w, http.StatusBadRequest, "background file is empty")
+	respondJSON(w, http.StatusOK, mapSetting(entry))
+}
+
+func (h Handler) respondSettingError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, settings.ErrUnknownSetting):
+		respondError(w, http.StatusNotFound, "setting not found")
+	case errors.Is(err, settings.ErrInvalidValue):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, store.ErrSettingConflict):
+		respondError(w, http.StatusConflict, "setting was changed by someone else; reload and try again")
+	default:
+		h.Logger.Error(action, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to "+action)
+	}
+}
+
+func mapSetting(entry settings.Entry) settingDTO {
+	dto := settingDTO{
+		Key:         entry.Key,
+		Type:        string(entry.Kind),
+		Description: entry.Description,
+		Value:       entry.Format(entry.Value),
+		Default:     entry.Format(entry.Default),
+		Min:         entry.Format(entry.Min),
+		Max:         entry.Format(entry.Max),
+		IsDefault:   !entry.Stored,
+		Version:     entry.Version,
+	}
+	if entry.UpdatedBy != uuid.Nil {
+		updatedBy := entry.UpdatedBy
+		dto.UpdatedBy = &updatedBy
+	}
+	if !entry.UpdatedAt.IsZero() {
+		updatedAt := entry.UpdatedAt
+		dto.UpdatedAt = &updatedAt
+	}
+	return dto
+}
+
+func (h Handler) getPortalBackground(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
 		return
 	}
 
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = http.DetectContentType(data)
+	brand, err := h.Store.GetBranding(ctx, store.BrandingScope{})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		h.Logger.Error("load portal background", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load background")
+		return
 	}
-	if _, exists := allowedBackgroundTypes[strings.ToLower(contentType)]; !exists {
-		respondError(w, http.StatusBadRequest, "background must be a JPEG image")
+
+	respondJSON(w, http.StatusOK, mapPortalBackground(brand))
+}
+
+func (h Handler) uploadPortalBackground(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
 		return
 	}
 
-	asset, err := h.Store.SaveAsset(ctx, "portal_background", contentType, data)
+	data, contentType, ok := h.readBrandingImage(w, r)
+	if !ok {
+		return
+	}
+	brand, err := h.Store.SetBrandingImage(ctx, store.BrandingScope{}, store.BrandingBackground, data, contentType)
 	if err != nil {
 		h.Logger.Error("save portal background", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to save background")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, mapPortalBackground(asset))
+	respondJSON(w, http.StatusOK, mapPortalBackground(brand))
 }
 
 func (h Handler) deletePortalBackground(w http.ResponseWriter, r *http.Request) {
@@ -119,7 +225,8 @@ func (h Handler) deletePortalBackground(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.Store.DeleteAsset(ctx, "portal_background"); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+	_, err := h.Store.SetBrandingImage(ctx, store.BrandingScope{}, store.BrandingBackground, nil, "")
+	if err != nil {
 		h.Logger.Error("delete portal background", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to delete background")
 		return
@@ -128,24 +235,15 @@ func (h Handler) deletePortalBackground(w http.ResponseWriter, r *http.Request)
 	respondJSON(w, http.StatusNoContent, nil)
 }
 
-func mapPortalBackground(asset sqlc.Asset) portalBackgroundResponse {
-	var updatedAt *time.Time
-	if asset.UpdatedAt.Valid {
-		t := asset.UpdatedAt.Time
-		updatedAt = &t
+func mapPortalBackground(brand sqlc.PortalBranding) portalBackgroundResponse {
+	if !brand.BackgroundEtag.Valid {
+		return portalBackgroundResponse{HasImage: false}
 	}
-
+	updatedAt := brand.UpdatedAt.Time
 	return portalBackgroundResponse{
 		HasImage:    true,
-		URL:         backgroundURL(asset),
-		ContentType: asset.ContentType,
-		UpdatedAt:   updatedAt,
-	}
-}
-
-func backgroundURL(asset sqlc.Asset) string {
-	if asset.UpdatedAt.Valid {
-		return fmt.Sprintf("/api/portal/background?ts=%d", asset.UpdatedAt.Time.Unix())
+		URL:         brandingImageURL(brand, store.BrandingBackground),
+		ContentType: brand.BackgroundType.String,
+		UpdatedAt:   &updatedAt,
 	}
-	return "/api/portal/background"
 }
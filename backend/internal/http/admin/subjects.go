@@ -0,0 +1,344 @@
+package admin
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+const erasureHistoryLimit = int32(50)
+
+// subjectExportDTO is the subject access bundle. The ZIP form holds the same
+// sections as separate files, with checkins also as CSV.
+type subjectExportDTO struct {
+	GeneratedAt time.Time             `json:"generatedAt"`
+	Profile     subjectProfileDTO     `json:"profile"`
+	Groups      []subjectGroupDTO     `json:"groups"`
+	Locations   []subjectLocationDTO  `json:"locationAccess"`
+	Checkins    []subjectCheckinDTO   `json:"checkins"`
+	Waitlist    []subjectWaitlistDTO  `json:"waitlist"`
+	Audit       subjectAuditReference `json:"auditReferences"`
+}
+
+type subjectProfileDTO struct {
+	userDTO
+	ObjectID string `json:"objectId,omitempty"`
+	Source   string `json:"source,omitempty"`
+}
+
+type subjectGroupDTO struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName string    `json:"displayName"`
+	Source      string    `json:"source,omitempty"`
+	Derived     bool      `json:"derived"`
+	Since       time.Time `json:"since"`
+}
+
+type subjectLocationDTO struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Identifier string    `json:"identifier"`
+}
+
+type subjectCheckinDTO struct {
+	ID                 int64      `json:"id"`
+	LocationID         uuid.UUID  `json:"locationId"`
+	LocationName       string     `json:"locationName"`
+	LocationIdentifier string     `json:"locationIdentifier"`
+	KeyID              *uuid.UUID `json:"keyId"`
+	Direction          string     `json:"direction"`
+	Notes              string     `json:"notes,omitempty"`
+	OccurredAt         time.Time  `json:"occurredAt"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	OutOfHours         bool       `json:"outOfHours"`
+}
+
+type subjectWaitlistDTO struct {
+	LocationID   uuid.UUID `json:"locationId"`
+	LocationName string    `json:"locationName"`
+	Since        time.Time `json:"since"`
+}
+
+// subjectAuditReference lists audit records that name the user as the admin
+// who acted.
+type subjectAuditReference struct {
+	SyncRuns []subjectSyncRunDTO `json:"syncRunsTriggered"`
+	Settings []subjectSettingDTO `json:"settingsLastChanged"`
+}
+
+type subjectSyncRunDTO struct {
+	ID        uuid.UUID `json:"id"`
+	Trigger   string    `json:"trigger"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type subjectSettingDTO struct {
+	Key       string    `json:"key"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type userErasureDTO struct {
+	ID                 uuid.UUID  `json:"id"`
+	SubjectID          uuid.UUID  `json:"subjectId"`
+	Reference          string     `json:"reference,omitempty"`
+	ErasedBy           *uuid.UUID `json:"erasedBy,omitempty"`
+	ErasedAt           time.Time  `json:"erasedAt"`
+	CheckinsAnonymised int64      `json:"checkinsAnonymised"`
+	MembershipsRemoved int64      `json:"membershipsRemoved"`
+}
+
+// exportSubject returns everything held about a user, as JSON or, with
+// format=zip, a ZIP of one file per section.
+func (h Handler) exportSubject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	userID, err := parseUUIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondError(w, http.StatusBadRequest, "format must be json or zip")
+		return
+	}
+	export, err := h.Store.ExportSubject(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		h.Logger.Error("export subject", "err", err, "user", userID)
+		respondError(w, http.StatusInternalServerError, "failed to export user")
+		return
+	}
+	h.Logger.Info("exported subject data", "user", userID, "by", viewer.Upn)
+
+	bundle := mapSubjectExport(export)
+	name := "subject-" + userID.String()
+	if format != "zip" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+		respondJSON(w, http.StatusOK, bundle)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	w.WriteHeader(http.StatusOK)
+	if err = writeSubjectZip(w, bundle); err != nil {
+		h.Logger.Error("write subject zip", "err", err, "user", userID)
+	}
+}
+
+// writeSubjectZip writes the bundle one section per file.
+func writeSubjectZip(w http.ResponseWriter, bundle subjectExportDTO) error {
+	zw := zip.NewWriter(w)
+	sections := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", bundle.Profile},
+		{"groups.json", bundle.Groups},
+		{"location-access.json", bundle.Locations},
+		{"checkins.json", bundle.Checkins},
+		{"waitlist.json", bundle.Waitlist},
+		{"audit-references.json", bundle.Audit},
+	}
+	for _, section := range sections {
+		f, err := zw.Create(section.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(section.data); err != nil {
+			return err
+		}
+	}
+	f, err := zw.Create("checkins.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	_ = cw.Write([]string{
+		"occurred_at", "direction", "location_identifier", "location_name", "key_id", "notes", "out_of_hours",
+	})
+	for _, c := range bundle.Checkins {
+		keyID := ""
+		if c.KeyID != nil {
+			keyID = c.KeyID.String()
+		}
+		_ = cw.Write([]string{
+			c.OccurredAt.UTC().Format(time.RFC3339),
+			c.Direction,
+			c.LocationIdentifier,
+			c.LocationName,
+			keyID,
+			c.Notes,
+			strconv.FormatBool(c.OutOfHours),
+		})
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// eraseSubject anonymises a user's checkins and deletes everything else about
+// them. The body may carry a reference, such as a request ticket, which is
+// kept with the erasure record.
+func (h Handler) eraseSubject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	userID, err := parseUUIDParam(r, "id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if userID == viewer.ID {
+		respondError(w, http.StatusBadRequest, "cannot erase yourself")
+		return
+	}
+	var body struct {
+		Reference string `json:"reference"`
+	}
+	if r.ContentLength != 0 {
+		if err = decodeJSON(r, &body); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	erasure, err := h.Store.EraseUser(ctx, userID, body.Reference, viewer.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		h.Logger.Error("erase user", "err", err, "user", userID)
+		respondError(w, http.StatusInternalServerError, "failed to erase user")
+		return
+	}
+	h.Logger.Info("erased user", "user", userID, "erasure", erasure.ID,
+		"checkins", erasure.CheckinsAnonymised, "by", viewer.Upn)
+	respondJSON(w, http.StatusOK, mapUserErasure(erasure))
+}
+
+// listUserErasures returns recent erasures, newest first.
+func (h Handler) listUserErasures(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	erasures, err := h.Store.ListUserErasures(ctx, erasureHistoryLimit)
+	if err != nil {
+		h.Logger.Error("list user erasures", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list erasures")
+		return
+	}
+	resp := make([]userErasureDTO, 0, len(erasures))
+	for _, e := range erasures {
+		resp = append(resp, mapUserErasure(e))
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func mapSubjectExport(export store.SubjectExport) subjectExportDTO {
+	dto := subjectExportDTO{
+		GeneratedAt: time.Now(),
+		Profile: subjectProfileDTO{
+			userDTO:  mapUserDTO(export.User),
+			ObjectID: export.User.ObjectID.String,
+			Source:   export.User.Source.String,
+		},
+		Groups:    make([]subjectGroupDTO, 0, len(export.Groups)),
+		Locations: make([]subjectLocationDTO, 0, len(export.Locations)),
+		Checkins:  make([]subjectCheckinDTO, 0, len(export.Checkins)),
+		Waitlist:  make([]subjectWaitlistDTO, 0, len(export.Waitlist)),
+		Audit: subjectAuditReference{
+			SyncRuns: make([]subjectSyncRunDTO, 0, len(export.SyncRuns)),
+			Settings: make([]subjectSettingDTO, 0, len(export.Settings)),
+		},
+	}
+	for _, g := range export.Groups {
+		dto.Groups = append(dto.Groups, subjectGroupDTO{
+			ID:          g.ID,
+			DisplayName: g.DisplayName,
+			Source:      g.Source.String,
+			Derived:     g.Derived,
+			Since:       g.CreatedAt.Time,
+		})
+	}
+	for _, l := range export.Locations {
+		dto.Locations = append(dto.Locations, subjectLocationDTO{ID: l.ID, Name: l.Name, Identifier: l.Identifier})
+	}
+	for _, c := range export.Checkins {
+		dto.Checkins = append(dto.Checkins, subjectCheckinDTO{
+			ID:                 c.ID,
+			LocationID:         c.LocationID,
+			LocationName:       c.LocationName,
+			LocationIdentifier: c.LocationIdentifier,
+			KeyID:              optionalUUID(c.KeyID),
+			Direction:          c.Direction,
+			Notes:              c.Notes.String,
+			OccurredAt:         c.OccurredAt.Time,
+			CreatedAt:          c.CreatedAt.Time,
+			OutOfHours:         c.OutOfHours,
+		})
+	}
+	for _, w := range export.Waitlist {
+		dto.Waitlist = append(dto.Waitlist, subjectWaitlistDTO{
+			LocationID:   w.LocationID,
+			LocationName: w.LocationName,
+			Since:        w.CreatedAt.Time,
+		})
+	}
+	for _, run := range export.SyncRuns {
+		dto.Audit.SyncRuns = append(dto.Audit.SyncRuns, subjectSyncRunDTO{
+			ID:        run.ID,
+			Trigger:   run.Trigger,
+			Status:    run.Status,
+			StartedAt: run.StartedAt.Time,
+		})
+	}
+	for _, setting := range export.Settings {
+		dto.Audit.Settings = append(dto.Audit.Settings, subjectSettingDTO{
+			Key:       setting.Key,
+			UpdatedAt: setting.UpdatedAt.Time,
+		})
+	}
+	return dto
+}
+
+func mapUserErasure(e sqlc.UserErasure) userErasureDTO {
+	return userErasureDTO{
+		ID:                 e.ID,
+		SubjectID:          e.SubjectID,
+		Reference:          e.Reference.String,
+		ErasedBy:           optionalUUID(e.ErasedBy),
+		ErasedAt:           e.ErasedAt.Time,
+		CheckinsAnonymised: e.CheckinsAnonymised,
+		MembershipsRemoved: e.MembershipsRemoved,
+	}
+}
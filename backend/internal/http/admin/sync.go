@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
+)
+
+const (
+	syncHistoryLimit     = int32(20)
+	maxImportUploadBytes = int64(32 << 20) // 32MiB
+)
+
+// syncRunDTO is one row of sync history.
+type syncRunDTO struct {
+	ID            uuid.UUID       `json:"id"`
+	Trigger       string          `json:"trigger"`
+	TriggeredBy   *uuid.UUID      `json:"triggeredBy,omitempty"`
+	Status        string          `json:"status"`
+	StartedAt     time.Time       `json:"startedAt"`
+	FinishedAt    *time.Time      `json:"finishedAt,omitempty"`
+	UsersCreated  int32           `json:"usersCreated"`
+	UsersUpdated  int32           `json:"usersUpdated"`
+	UsersArchived int32           `json:"usersArchived"`
+	GroupsCreated int32           `json:"groupsCreated"`
+	GroupsUpdated int32           `json:"groupsUpdated"`
+	GroupsDeleted int32           `json:"groupsDeleted"`
+	Error         string          `json:"error,omitempty"`
+	Diff          json.RawMessage `json:"diff,omitempty"`
+}
+
+// syncStatusResponse summarises directory sync health.
+type syncStatusResponse struct {
+	Enabled     bool         `json:"enabled"`
+	Running     bool         `json:"running"`
+	LastRun     *syncRunDTO  `json:"lastRun,omitempty"`
+	LastSuccess *syncRunDTO  `json:"lastSuccess,omitempty"`
+	Runs        []syncRunDTO `json:"runs"`
+}
+
+// syncRoutes registers sync status and trigger endpoints.
+func (h Handler) syncRoutes(r chi.Router) {
+	r.Get("/status", h.syncStatus)
+	r.Post("/run", h.runSync)
+	r.Post("/import", h.uploadImport)
+}
+
+// syncStatus returns recent runs and the last successful one.
+func (h Handler) syncStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	runs, err := h.Store.ListSyncRuns(ctx, syncHistoryLimit)
+	if err != nil {
+		h.Logger.Error("list sync runs", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load sync status")
+		return
+	}
+	resp := syncStatusResponse{
+		Enabled: h.Sync.Enabled(),
+		Runs:    make([]syncRunDTO, 0, len(runs)),
+	}
+	for _, run := range runs {
+		resp.Runs = append(resp.Runs, mapSyncRun(run))
+	}
+	if len(resp.Runs) > 0 {
+		resp.LastRun = &resp.Runs[0]
+		resp.Running = resp.LastRun.Status == "running"
+	}
+	lastSuccess, err := h.Store.GetLastSuccessfulSyncRun(ctx)
+	switch {
+	case err == nil:
+		dto := mapSyncRun(lastSuccess)
+		resp.LastSuccess = &dto
+	case !errors.Is(err, pgx.ErrNoRows):
+		h.Logger.Error("get last successful sync run", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load sync status")
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// runSync starts a manual sync unless one is already running anywhere.
+func (h Handler) runSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	h.startSync(w, r, viewer.ID)
+}
+
+// uploadImport replaces the file watched by the file directory source and
+// starts a sync. The upload must match the configured CSV or JSON format.
+func (h Handler) uploadImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	if h.Config.DirectorySource != config.DirectorySourceFile || h.Config.DirectoryFilePath == "" {
+		respondError(w, http.StatusConflict, "file directory source is not configured")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadBytes)
+	if err := r.ParseMultipartForm(maxImportUploadBytes); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid upload")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "import file is required")
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			h.Logger.Warn("close import upload", "err", cerr)
+		}
+	}()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		h.Logger.Error("read import file", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to read upload")
+		return
+	}
+
+	if err = directory.NewFileSource(h.Config.DirectoryFilePath).Replace(data); err != nil {
+		if errors.Is(err, directory.ErrInvalidImport) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.Logger.Error("replace import file", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to save import")
+		return
+	}
+	h.Logger.Info("directory import uploaded", "bytes", len(data), "by", viewer.Upn)
+	h.startSync(w, r, viewer.ID)
+}
+
+// startSync starts a manual run and reports its row or why it could not start.
+func (h Handler) startSync(w http.ResponseWriter, r *http.Request, triggeredBy uuid.UUID) {
+	run, err := h.Sync.Start(r.Context(), triggeredBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, syncer.ErrNotConfigured):
+			respondError(w, http.StatusServiceUnavailable, "directory sync is not configured")
+		case errors.Is(err, syncer.ErrSyncRunning):
+			respondError(w, http.StatusConflict, "sync already running")
+		default:
+			h.Logger.Error("start sync", "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to start sync")
+		}
+		return
+	}
+	respondJSON(w, http.StatusAccepted, mapSyncRun(run))
+}
+
+func mapSyncRun(run sqlc.SyncRun) syncRunDTO {
+	dto := syncRunDTO{
+		ID:            run.ID,
+		Trigger:       run.Trigger,
+		Status:        run.Status,
+		StartedAt:     run.StartedAt.Time,
+		UsersCreated:  run.UsersCreated,
+		UsersUpdated:  run.UsersUpdated,
+		UsersArchived: run.UsersArchived,
+		GroupsCreated: run.GroupsCreated,
+		GroupsUpdated: run.GroupsUpdated,
+		GroupsDeleted: run.GroupsDeleted,
+		Error:         run.Error.String,
+		Diff:          run.Diff,
+	}
+	if run.TriggeredBy.Valid {
+		id := uuid.UUID(run.TriggeredBy.Bytes)
+		dto.TriggeredBy = &id
+	}
+	if run.FinishedAt.Valid {
+		t := run.FinishedAt.Time
+		dto.FinishedAt = &t
+	}
+	return dto
+}
@@ -15,13 +15,19 @@ import (
 )
 
 type userDTO struct {
-	ID          uuid.UUID `json:"id"`
-	UPN         string    `json:"upn"`
-	DisplayName string    `json:"displayName"`
-	Department  string    `json:"department,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
-	IsAdmin     bool      `json:"isAdmin"`
+	ID            uuid.UUID         `json:"id"`
+	UPN           string            `json:"upn"`
+	DisplayName   string            `json:"displayName"`
+	Department    string            `json:"department,omitempty"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+	IsAdmin       bool              `json:"isAdmin"`
+	ArchivedAt    *time.Time        `json:"archivedAt,omitempty"`
+	ArchiveReason string            `json:"archiveReason,omitempty"`
+	EmployeeID    string            `json:"employeeId,omitempty"`
+	JobTitle      string            `json:"jobTitle,omitempty"`
+	ManagerID     *uuid.UUID        `json:"managerId,omitempty"`
+	Attributes    map[string]string `json:"attributes"`
 }
 
 type userDetailResponse struct {
@@ -31,6 +37,11 @@ type userDetailResponse struct {
 	AccessibleIDs []uuid.UUID `json:"accessibleLocationIds"`
 }
 
+type purgeUsersResponse struct {
+	Purged         int64     `json:"purged"`
+	ArchivedBefore time.Time `json:"archivedBefore"`
+}
+
 type updateUserRequest struct {
 	IsAdmin     *bool        `json:"isAdmin"`
 	LocationIDs *[]uuid.UUID `json:"locationIds"`
@@ -39,11 +50,16 @@ type updateUserRequest struct {
 // usersRoutes registers directory and access endpoints.
 func (h Handler) usersRoutes(r chi.Router) {
 	r.Get("/", h.listUsers)
+	r.Post("/purge", h.purgeArchivedUsers)
+	r.Get("/erasures", h.listUserErasures)
 	r.Get("/{id}", h.userDetails)
 	r.Patch("/{id}", h.updateUser)
+	r.Get("/{id}/export", h.exportSubject)
+	r.Post("/{id}/erase", h.eraseSubject)
 }
 
-// listUsers returns users for admin callers.
+// listUsers returns users for admin callers, hiding archived users unless
+// includeArchived=true. attr.<name>=<value> params filter on mapped attributes.
 func (h Handler) listUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	viewer, ok := sessionctx.User(ctx)
@@ -52,7 +68,9 @@ func (h Handler) listUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	search := strings.TrimSpace(r.URL.Query().Get("search"))
-	users, err := h.Store.ListUsers(ctx, search)
+	includeArchived := r.URL.Query().Get("includeArchived") == "true"
+	attrs := parseAttributeFilter(r.URL.Query())
+	users, err := h.Store.ListUsers(ctx, search, includeArchived, attrs)
 	if err != nil {
 		h.Logger.Error("list users", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to list users")
@@ -107,6 +125,26 @@ func (h Handler) userDetails(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, resp)
 }
 
+// purgeArchivedUsers permanently deletes users that have been archived for
+// longer than the configured retention period, anonymising their checkins.
+func (h Handler) purgeArchivedUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewer, ok := sessionctx.User(ctx)
+	if !ok || !viewer.IsAdmin {
+		respondError(w, http.StatusForbidden, "admin required")
+		return
+	}
+	cutoff := time.Now().Add(-h.Config.ArchivedUserRetention)
+	purged, err := h.Store.PurgeArchivedUsers(ctx, cutoff)
+	if err != nil {
+		h.Logger.Error("purge archived users", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to purge users")
+		return
+	}
+	h.Logger.Info("purged archived users", "count", purged, "archived_before", cutoff, "by", viewer.Upn)
+	respondJSON(w, http.StatusOK, purgeUsersResponse{Purged: purged, ArchivedBefore: cutoff})
+}
+
 // updateUser updates admin and access fields for a user.
 func (h Handler) updateUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -159,15 +197,37 @@ func mapUserDTO(u sqlc.User) userDTO {
 	if u.UpdatedAt.Valid {
 		updated = u.UpdatedAt.Time
 	}
-	return userDTO{
-		ID:          u.ID,
-		UPN:         u.Upn,
-		DisplayName: u.DisplayName,
-		Department:  u.Department.String,
-		CreatedAt:   created,
-		UpdatedAt:   updated,
-		IsAdmin:     u.IsAdmin,
+	dto := userDTO{
+		ID:            u.ID,
+		UPN:           u.Upn,
+		DisplayName:   u.DisplayName,
+		Department:    u.Department.String,
+		CreatedAt:     created,
+		UpdatedAt:     updated,
+		IsAdmin:       u.IsAdmin,
+		ArchiveReason: u.ArchiveReason.String,
+		EmployeeID:    u.EmployeeID.String,
+		JobTitle:      u.JobTitle.String,
+		Attributes:    decodeAttributes(u.Attributes),
+	}
+	if u.ArchivedAt.Valid {
+		archived := u.ArchivedAt.Time
+		dto.ArchivedAt = &archived
+	}
+	if u.ManagerID.Valid {
+		manager := uuid.UUID(u.ManagerID.Bytes)
+		dto.ManagerID = &manager
+	}
+	return dto
+}
+
+// decodeAttributes reads the stored attribute map, tolerating bad JSON.
+func decodeAttributes(raw []byte) map[string]string {
+	attrs := map[string]string{}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &attrs)
 	}
+	return attrs
 }
 
 func mapUserList(users []sqlc.User) []userDTO {
@@ -181,11 +241,7 @@ func mapUserList(users []sqlc.User) []userDTO {
 func mapGroups(groups []sqlc.Group) []groupDTO {
 	resp := make([]groupDTO, 0, len(groups))
 	for _, g := range groups {
-		resp = append(resp, groupDTO{
-			ID:          g.ID,
-			DisplayName: g.DisplayName,
-			Description: g.Description.String,
-		})
+		resp = append(resp, mapGroup(g))
 	}
 	return resp
 }
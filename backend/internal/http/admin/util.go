@@ -2,7 +2,9 @@ package admin
 
 import (
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -23,3 +25,22 @@ func parseInt32(value string, fallback int32) int32 {
 func parseUUIDParam(r *http.Request, key string) (uuid.UUID, error) {
 	return uuid.Parse(chi.URLParam(r, key))
 }
+
+// attributeQueryPrefix marks query params that filter on user attributes.
+const attributeQueryPrefix = "attr."
+
+// parseAttributeFilter collects attr.<name>=<value> query params.
+func parseAttributeFilter(query url.Values) map[string]string {
+	var attrs map[string]string
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, attributeQueryPrefix)
+		if !ok || name == "" || len(values) == 0 {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = values[0]
+	}
+	return attrs
+}
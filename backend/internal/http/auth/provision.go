@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/woodleighschool/signin-ui/internal/auth"
+	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// errProvisionDenied means the identity failed the provider's JIT policy.
+var errProvisionDenied = errors.New("jit provisioning denied")
+
+// errLoginDenied means the identity failed the provider's login policy.
+var errLoginDenied = errors.New("login denied")
+
+// loginPolicy is the parsed identity matching of a config.AuthProvider.
+type loginPolicy struct {
+	allowedDomains []string
+	matchExisting  bool
+}
+
+// newLoginPolicies keys every provider's login policy by provider name.
+func newLoginPolicies(providers []config.AuthProvider) map[string]loginPolicy {
+	policies := make(map[string]loginPolicy)
+	for _, p := range providers {
+		policies[p.Name] = loginPolicy{
+			allowedDomains: normaliseDomains(p.AllowedDomains),
+			matchExisting:  p.MatchExisting,
+		}
+	}
+	return policies
+}
+
+// check requires a subject and, when domains are listed, a login in one.
+func (p loginPolicy) check(identity auth.Identity) error {
+	if identity.Subject == "" || identity.Login == "" {
+		return fmt.Errorf("%w: missing subject or login", errLoginDenied)
+	}
+	if !domainAllowed(p.allowedDomains, identity.Login) {
+		return fmt.Errorf("%w: domain of %q not allowed", errLoginDenied, identity.Login)
+	}
+	return nil
+}
+
+// provisionPolicy is a parsed config.JITPolicy.
+type provisionPolicy struct {
+	allowedDomains     []string
+	requiredClaims     map[string]string
+	objectIDClaim      string
+	displayNameClaim   string
+	departmentClaim    string
+	defaultLocationIDs []uuid.UUID
+}
+
+// newProvisionPolicies keeps the enabled JIT policies keyed by provider name.
+func newProvisionPolicies(providers []config.AuthProvider) map[string]provisionPolicy {
+	policies := make(map[string]provisionPolicy)
+	for _, p := range providers {
+		if !p.JIT.Enabled {
+			continue
+		}
+		policy := provisionPolicy{
+			requiredClaims:   p.JIT.RequiredClaims,
+			objectIDClaim:    p.JIT.ObjectIDClaim,
+			displayNameClaim: p.JIT.DisplayNameClaim,
+			departmentClaim:  p.JIT.DepartmentClaim,
+		}
+		policy.allowedDomains = normaliseDomains(p.JIT.AllowedDomains)
+		for _, id := range p.JIT.DefaultLocationIDs {
+			if parsed, err := uuid.Parse(id); err == nil {
+				policy.defaultLocationIDs = append(policy.defaultLocationIDs, parsed)
+			}
+		}
+		policies[p.Name] = policy
+	}
+	return policies
+}
+
+// check enforces the allowed domains and required claim values.
+func (p provisionPolicy) check(identity auth.Identity) error {
+	if !domainAllowed(p.allowedDomains, identity.Login) {
+		return fmt.Errorf("%w: domain of %q not allowed", errProvisionDenied, identity.Login)
+	}
+	for claim, want := range p.requiredClaims {
+		if !claimHasValue(identity.Claims[claim], want) {
+			return fmt.Errorf("%w: claim %q does not match", errProvisionDenied, claim)
+		}
+	}
+	return nil
+}
+
+// signIn resolves the user an identity signs in as. Providers with a JIT
+// policy create the user when none matches; the others return
+// pgx.ErrNoRows.
+func (h *Handler) signIn(ctx context.Context, provider auth.Provider, identity auth.Identity) (sqlc.User, error) {
+	login := h.logins[provider.Name()]
+	if err := login.check(identity); err != nil {
+		return sqlc.User{}, err
+	}
+	ident := store.Identity{
+		Provider:      provider.Name(),
+		Subject:       identity.Subject,
+		Login:         identity.Login,
+		MatchExisting: login.matchExisting,
+	}
+	policy, ok := h.provisioning[provider.Name()]
+	if !ok {
+		return h.store.SignIn(ctx, ident)
+	}
+	if err := policy.check(identity); err != nil {
+		return sqlc.User{}, err
+	}
+	objectID := claimValue(identity.Claims, policy.objectIDClaim)
+	userID, err := uuid.Parse(objectID)
+	if err != nil {
+		userID = uuid.Nil
+	}
+	displayName := claimValue(identity.Claims, policy.displayNameClaim)
+	if displayName == "" {
+		displayName = identity.Login
+	}
+	user, err := h.store.ProvisionUser(ctx, ident, store.ProvisionUserParams{
+		ID:                 userID,
+		UPN:                identity.Login,
+		DisplayName:        displayName,
+		ObjectID:           objectID,
+		Department:         claimValue(identity.Claims, policy.departmentClaim),
+		DefaultLocationIDs: policy.defaultLocationIDs,
+	})
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("provision user: %w", err)
+	}
+	h.logger.Debug("jit provisioned user", "provider", provider.Name(), "user", user.ID, "upn", user.Upn)
+	return user, nil
+}
+
+// normaliseDomains lowercases domains and drops any leading "@".
+func normaliseDomains(domains []string) []string {
+	var out []string
+	for _, domain := range domains {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			out = append(out, strings.TrimPrefix(domain, "@"))
+		}
+	}
+	return out
+}
+
+// domainAllowed reports whether login is in one of domains; an empty list
+// allows every login.
+func domainAllowed(domains []string, login string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	_, domain, found := strings.Cut(strings.ToLower(login), "@")
+	return found && slices.Contains(domains, domain)
+}
+
+func claimValue(claims map[string]any, key string) string {
+	if key == "" {
+		return ""
+	}
+	if val, ok := claims[key].(string); ok {
+		return strings.TrimSpace(val)
+	}
+	return ""
+}
+
+// claimHasValue matches a string claim or any element of a list claim.
+func claimHasValue(claim any, want string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
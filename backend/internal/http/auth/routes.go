@@ -7,13 +7,15 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
-	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/woodleighschool/signin-ui/internal/auth"
 	"github.com/woodleighschool/signin-ui/internal/config"
+	"github.com/woodleighschool/signin-ui/internal/store"
 )
 
 const (
@@ -23,11 +25,17 @@ const (
 	localAdminName  = "Master Claus"
 	localAdminLogin = "local:admin"
 	stateCookiePath = "/api/auth"
+	loginClaim      = "login"
+	providerClaim   = "idp"
+	userClaim       = "uid"
 )
 
-// Handler serves OIDC and local login endpoints.
+// Handler serves OIDC, SAML and local login endpoints.
 type Handler struct {
-	provider             *auth.OIDCProvider
+	providers            *auth.Providers
+	logins               map[string]loginPolicy
+	provisioning         map[string]provisionPolicy
+	store                *store.Store
 	sessions             *auth.SessionManager
 	logger               *slog.Logger
 	siteURL              string
@@ -41,14 +49,24 @@ type oidcState struct {
 	State    string `json:"state"`
 	Nonce    string `json:"nonce"`
 	Redirect string `json:"redirect"`
+	Provider string `json:"provider"`
+}
+
+// providerInfo describes a login button for the UI.
+type providerInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Type        string `json:"type"`
+	LoginURL    string `json:"loginUrl"`
 }
 
 // RegisterRoutes wires the auth endpoints.
 func RegisterRoutes(
 	r chi.Router,
 	cfg config.Config,
-	provider *auth.OIDCProvider,
+	providers *auth.Providers,
 	sessions *auth.SessionManager,
+	store *store.Store,
 	logger *slog.Logger,
 ) {
 	if sessions == nil {
@@ -58,7 +76,10 @@ func RegisterRoutes(
 		return
 	}
 	h := &Handler{
-		provider:             provider,
+		providers:            providers,
+		logins:               newLoginPolicies(cfg.LoginProviders()),
+		provisioning:         newProvisionPolicies(cfg.LoginProviders()),
+		store:                store,
 		sessions:             sessions,
 		logger:               logger,
 		siteURL:              cfg.SiteBaseURL,
@@ -72,16 +93,20 @@ func RegisterRoutes(
 		r.Post("/", h.login)
 	})
 
-	if provider != nil {
+	if providers.Len() > 0 {
 		r.Get("/callback", h.callback)
+		r.Route("/saml/{provider}", func(r chi.Router) {
+			r.Get("/metadata", h.samlMetadata)
+			r.Post("/acs", h.samlACS)
+		})
 	}
 
 	r.Get("/me", h.me)
-	r.Get("/providers", h.providers)
+	r.Get("/providers", h.listProviders)
 	r.Post("/logout", h.logout)
 }
 
-// login handles OAuth and optional local login.
+// login handles OIDC/SAML redirects and optional local login.
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	method := r.URL.Query().Get("method")
 
@@ -90,7 +115,11 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.provider == nil {
+	provider, ok := h.providers.Default()
+	if name := r.URL.Query().Get("provider"); name != "" {
+		provider, ok = h.providers.Get(name)
+	}
+	if !ok {
 		http.Error(w, "OAuth login not configured", http.StatusNotFound)
 		return
 	}
@@ -109,12 +138,17 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	if redirect == "" || !strings.HasPrefix(redirect, h.siteURL) {
 		redirect = strings.TrimRight(h.siteURL, "/") + defaultRedirect
 	}
-	err = h.setStateCookie(w, oidcState{State: state, Nonce: nonce, Redirect: redirect})
+	authURL, err := provider.LoginURL(state, nonce)
 	if err != nil {
+		h.logger.Error("build login url", "provider", provider.Name(), "err", err)
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	st := oidcState{State: state, Nonce: nonce, Redirect: redirect, Provider: provider.Name()}
+	if err = h.setStateCookie(w, st, provider.Type() == config.ProviderTypeSAML); err != nil {
 		http.Error(w, "failed to persist state", http.StatusInternalServerError)
 		return
 	}
-	authURL := h.provider.AuthCodeURL(state, oidc.Nonce(nonce))
 	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
@@ -126,46 +160,83 @@ func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.clearStateCookie(w)
-	if r.URL.Query().Get("state") != stored.State {
-		http.Error(w, "state mismatch", http.StatusBadRequest)
+	provider, ok := h.providers.Get(stored.Provider)
+	if stored.Provider == "" {
+		provider, ok = h.providers.Default()
+	}
+	if !ok || provider.Type() != config.ProviderTypeOIDC {
+		http.Error(w, "unknown login provider", http.StatusBadRequest)
 		return
 	}
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "authorization code missing", http.StatusBadRequest)
+	h.completeLogin(w, r, provider, stored)
+}
+
+// samlACS is the assertion consumer service for a SAML provider.
+func (h *Handler) samlACS(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers.Get(chi.URLParam(r, "provider"))
+	if !ok || provider.Type() != config.ProviderTypeSAML {
+		http.NotFound(w, r)
 		return
 	}
-	ctx := r.Context()
-	token, err := h.provider.Exchange(ctx, code)
+	stored, err := h.readStateCookie(r)
 	if err != nil {
-		h.logger.Error("oidc exchange failed", "err", err)
-		http.Error(w, "oidc exchange failed", http.StatusBadRequest)
+		http.Error(w, "missing login context", http.StatusBadRequest)
 		return
 	}
-	rawIDToken, ok := token.Extra("id_token").(string)
-	if !ok {
-		http.Error(w, "id token missing", http.StatusBadRequest)
+	h.clearStateCookie(w)
+	if stored.Provider != provider.Name() {
+		http.Error(w, "provider mismatch", http.StatusBadRequest)
 		return
 	}
-	idToken, err := h.provider.VerifyIDToken(ctx, rawIDToken)
+	h.completeLogin(w, r, provider, stored)
+}
+
+// samlMetadata serves SP metadata for registering with the IdP.
+func (h *Handler) samlMetadata(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers.Get(chi.URLParam(r, "provider"))
+	samlProvider, isSAML := provider.(*auth.SAMLProvider)
+	if !ok || !isSAML {
+		http.NotFound(w, r)
+		return
+	}
+	metadata, err := samlProvider.Metadata()
 	if err != nil {
-		h.logger.Error("verify id token", "err", err)
-		http.Error(w, "invalid id token", http.StatusBadRequest)
+		h.logger.Error("build saml metadata", "provider", provider.Name(), "err", err)
+		http.Error(w, "failed to build metadata", http.StatusInternalServerError)
 		return
 	}
-	if idToken.Nonce != stored.Nonce {
-		http.Error(w, "nonce mismatch", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(metadata)
+}
+
+// completeLogin validates the IdP response and issues the session cookie.
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, provider auth.Provider, stored oidcState) {
+	identity, err := provider.Complete(r.Context(), r, stored.State, stored.Nonce)
+	if err != nil {
+		h.logger.Error("complete login", "provider", provider.Name(), "err", err)
+		http.Error(w, "login failed", http.StatusBadRequest)
 		return
 	}
-	claims := map[string]any{}
-	if err = idToken.Claims(&claims); err != nil {
-		h.logger.Error("decode claims", "err", err)
-		http.Error(w, "invalid claims", http.StatusBadRequest)
+	user, err := h.signIn(r.Context(), provider, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errLoginDenied), errors.Is(err, errProvisionDenied),
+			errors.Is(err, store.ErrIdentityNotLinked), errors.Is(err, pgx.ErrNoRows):
+			h.logger.Warn("login denied", "provider", provider.Name(), "login", identity.Login, "err", err)
+			http.Error(w, "account not permitted", http.StatusForbidden)
+		default:
+			h.logger.Error("sign in", "provider", provider.Name(), "err", err)
+			http.Error(w, "failed to sign in", http.StatusInternalServerError)
+		}
 		return
 	}
+	claims := sanitiseClaims(identity.Claims)
+	claims[loginClaim] = identity.Login
+	claims[providerClaim] = provider.Name()
+	claims[userClaim] = user.ID.String()
 	session := auth.Session{
-		Subject: idToken.Subject,
-		Claims:  sanitiseClaims(claims),
+		Subject: identity.Subject,
+		Claims:  claims,
 	}
 	if err = h.sessions.Issue(w, session); err != nil {
 		h.logger.Error("issue session", "err", err)
@@ -202,11 +273,21 @@ func (h *Handler) me(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// providers reports available login methods.
-func (h *Handler) providers(w http.ResponseWriter, _ *http.Request) {
-	providers := map[string]bool{
-		"oauth": h.provider != nil,
-		"local": h.initialAdminPassword != "",
+// listProviders reports available login methods.
+func (h *Handler) listProviders(w http.ResponseWriter, _ *http.Request) {
+	list := make([]providerInfo, 0, h.providers.Len())
+	for _, p := range h.providers.All() {
+		list = append(list, providerInfo{
+			Name:        p.Name(),
+			DisplayName: p.DisplayName(),
+			Type:        p.Type(),
+			LoginURL:    "/api/auth/login?provider=" + url.QueryEscape(p.Name()),
+		})
+	}
+	providers := map[string]any{
+		"oauth":     h.providers.Len() > 0,
+		"local":     h.initialAdminPassword != "",
+		"providers": list,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -259,19 +340,25 @@ func (h *Handler) localLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) setStateCookie(w http.ResponseWriter, st oidcState) error {
+// setStateCookie stores the login context. SAML responses arrive as a
+// cross-site POST, so crossSite relaxes SameSite when the cookie is secure.
+func (h *Handler) setStateCookie(w http.ResponseWriter, st oidcState, crossSite bool) error {
 	payload, err := json.Marshal(st)
 	if err != nil {
 		return err
 	}
 	value := base64.RawURLEncoding.EncodeToString(payload)
+	sameSite := http.SameSiteLaxMode
+	if crossSite && h.secureCookie {
+		sameSite = http.SameSiteNoneMode
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     h.stateCookie,
 		Value:    value,
 		Path:     stateCookiePath,
 		HttpOnly: true,
 		Secure:   h.secureCookie,
-		SameSite: http.SameSiteLaxMode,
+		SameSite: sameSite,
 		MaxAge:   int(stateCookieTTL.Seconds()),
 	})
 	return nil
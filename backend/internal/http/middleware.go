@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/woodleighschool/signin-ui/internal/auth"
 	"github.com/woodleighschool/signin-ui/internal/http/sessionctx"
 	"github.com/woodleighschool/signin-ui/internal/store"
@@ -103,15 +102,10 @@ func loadCurrentUser(r *http.Request, store *store.Store) (sqlc.User, context.Co
 	if !ok {
 		return sqlc.User{}, r.Context(), errors.New("missing session")
 	}
-	login := sessionLogin(sess)
-	if login == "" {
-		return sqlc.User{}, r.Context(), errors.New("missing login claim")
-	}
-
-	if login == "local:admin" {
+	if sess.Subject == "local:admin" && claimString(sess, "idp") == "" {
 		user := sqlc.User{
 			ID:          uuid.Nil,
-			Upn:         login,
+			Upn:         sess.Subject,
 			DisplayName: "Local Admin",
 			IsAdmin:     true,
 		}
@@ -119,32 +113,24 @@ func loadCurrentUser(r *http.Request, store *store.Store) (sqlc.User, context.Co
 		return user, ctx, nil
 	}
 
-	user, err := store.GetUserByUPN(r.Context(), login)
+	// Provider sessions carry the user their identity signed in as.
+	// Sessions issued before identities were linked lack it and must sign
+	// in again.
+	userID, err := uuid.Parse(claimString(sess, "uid"))
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			user, err = store.GetUserByLogin(r.Context(), login)
-		}
+		return sqlc.User{}, r.Context(), errors.New("missing user claim")
 	}
+	user, err := store.GetUser(r.Context(), userID)
 	if err != nil {
 		return sqlc.User{}, r.Context(), err
 	}
+	if user.ArchivedAt.Valid {
+		return sqlc.User{}, r.Context(), errors.New("user archived")
+	}
 	ctx := sessionctx.WithUser(r.Context(), user)
 	return user, ctx, nil
 }
 
-func sessionLogin(sess auth.Session) string {
-	if upn := claimString(sess, "upn"); upn != "" {
-		return upn
-	}
-	if preferred := claimString(sess, "preferred_username"); preferred != "" {
-		return preferred
-	}
-	if email := claimString(sess, "email"); email != "" {
-		return email
-	}
-	return strings.TrimSpace(sess.Subject)
-}
-
 func claimString(sess auth.Session, key string) string {
 	if sess.Claims == nil {
 		return ""
@@ -0,0 +1,304 @@
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// maxReplayCheckins bounds how many queued checkins one replay may carry.
+const maxReplayCheckins = 500
+
+// checkinRequest is one kiosk checkin. Direction is in, out, toggle, which
+// picks the opposite of the user's state, or transfer, which checks the user
+// in and out of wherever else they are. IdempotencyKey is a UUID the kiosk
+// generates per checkin; OccurredAt defaults to when the server receives it.
+type checkinRequest struct {
+	IdempotencyKey uuid.UUID  `json:"idempotencyKey"`
+	UserID         uuid.UUID  `json:"userId"`
+	Direction      string     `json:"direction"`
+	Notes          string     `json:"notes"`
+	OccurredAt     *time.Time `json:"occurredAt"`
+}
+
+// checkinResult is how a checkin is answered: an error message, or a body.
+type checkinResult struct {
+	status  int
+	message string
+	body    map[string]any
+}
+
+func checkinRefused(status int, message string) checkinResult {
+	return checkinResult{status: status, message: message}
+}
+
+// recordCheckin validates and records one checkin for the key's location.
+// Retries of an idempotency key already recorded get the first answer, with
+// duplicate set, even if the checkin would no longer be accepted.
+func (h Handler) recordCheckin(
+	ctx context.Context,
+	row sqlc.GetKeyLocationForIdentifierRow,
+	allowedUsers []sqlc.User,
+	req checkinRequest,
+	now time.Time,
+) checkinResult {
+	checkin := store.CheckinRequest{
+		Params: sqlc.CreateCheckinParams{
+			UserID:     req.UserID,
+			LocationID: row.LocationID,
+			KeyID:      pgtype.UUID{Bytes: row.ID, Valid: true},
+			Direction:  req.Direction,
+		},
+		Requested:      req.Direction,
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if req.IdempotencyKey != uuid.Nil {
+		previous, err := h.Store.PreviousCheckin(ctx, checkin)
+		if err == nil {
+			return mapCheckinOutcome(previous)
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return h.checkinFailed(err, req.Direction)
+		}
+	}
+
+	if !userAllowed(req.UserID, allowedUsers) {
+		return checkinRefused(http.StatusForbidden, "user not permitted for this location")
+	}
+	occurred, problem := h.checkinTime(req.OccurredAt, now)
+	if problem != "" {
+		return checkinRefused(http.StatusBadRequest, problem)
+	}
+	direction := req.Direction
+	if direction == store.DirectionTransfer {
+		policy, err := h.Store.TransferPolicy(ctx, row.LocationID)
+		if err != nil {
+			h.Logger.Error("portal transfer policy", "err", err, "location", row.LocationID)
+			return checkinRefused(http.StatusInternalServerError, "failed to load checkin policy")
+		}
+		if policy == store.TransferPolicyDeny {
+			return checkinRefused(http.StatusForbidden, "transfers are not allowed at this location")
+		}
+		direction = "in"
+	}
+	sched, err := h.Store.GetLocationSchedule(ctx, row.LocationID, occurred)
+	if err != nil {
+		h.Logger.Error("portal schedule lookup", "err", err, "location", row.LocationID)
+		return checkinRefused(http.StatusInternalServerError, "failed to load schedule")
+	}
+	flagged, refused := applyHoursPolicy(sched, occurred, direction)
+	if refused {
+		return checkinRefused(http.StatusForbidden, "location is closed")
+	}
+	if direction == store.DirectionToggle {
+		// The store resolves toggles under its lock; it refuses one that
+		// turns out to be an "in" the hours policy would.
+		_, checkin.ClosedToIn = applyHoursPolicy(sched, occurred, "in")
+	}
+	policy, err := h.Store.DirectionPolicy(ctx, row.LocationID)
+	if err != nil {
+		h.Logger.Error("portal direction policy", "err", err, "location", row.LocationID)
+		return checkinRefused(http.StatusInternalServerError, "failed to load checkin policy")
+	}
+	notesValue := strings.TrimSpace(req.Notes)
+	checkin.Params.Direction = direction
+	checkin.Params.Notes = pgtype.Text{String: notesValue, Valid: notesValue != "" && row.LocationNotesEnabled}
+	checkin.Params.OccurredAt = pgtype.Timestamptz{Time: occurred, Valid: true}
+	checkin.Params.OutOfHours = flagged
+	checkin.Debounce = h.Settings.Current().Debounce
+	checkin.DirectionPolicy = policy
+
+	outcome, err := h.Store.RecordCheckin(ctx, checkin)
+	if err != nil {
+		return h.checkinFailed(err, direction)
+	}
+	return mapCheckinOutcome(outcome)
+}
+
+func (h Handler) checkinFailed(err error, direction string) checkinResult {
+	switch {
+	case errors.Is(err, store.ErrLocationFull):
+		return checkinRefused(http.StatusConflict, "location is full")
+	case errors.Is(err, store.ErrLocationClosed):
+		return checkinRefused(http.StatusForbidden, "location is closed")
+	case errors.Is(err, store.ErrIdempotencyConflict):
+		return checkinRefused(http.StatusConflict, "idempotency key was used for a different checkin")
+	case errors.Is(err, store.ErrContradictoryCheckin) && direction == "in":
+		return checkinRefused(http.StatusConflict, "user is already checked in")
+	case errors.Is(err, store.ErrContradictoryCheckin):
+		return checkinRefused(http.StatusConflict, "user is not checked in")
+	case errors.Is(err, store.ErrTransferDenied):
+		return checkinRefused(http.StatusConflict, "user must check out of their current location first")
+	}
+	h.Logger.Error("portal create checkin", "err", err)
+	return checkinRefused(http.StatusInternalServerError, "failed to record checkin")
+}
+
+// mapCheckinOutcome answers 201 for a recorded checkin, 202 for a waitlisted
+// one and 200 for a double tap that was dropped. transferredFrom lists the
+// locations a transfer checked the user out of.
+func mapCheckinOutcome(outcome store.CheckinOutcome) checkinResult {
+	switch {
+	case outcome.Debounced:
+		return checkinResult{status: http.StatusOK, body: map[string]any{
+			"debounced": true,
+			"direction": outcome.Checkin.Direction,
+			"duplicate": outcome.Duplicate,
+		}}
+	case !outcome.Recorded:
+		return checkinResult{status: http.StatusAccepted, body: map[string]any{
+			"waitlisted": true,
+			"position":   outcome.WaitlistPosition,
+			"duplicate":  outcome.Duplicate,
+		}}
+	}
+	transferredFrom := make([]uuid.UUID, 0, len(outcome.Closed))
+	for _, c := range outcome.Closed {
+		transferredFrom = append(transferredFrom, c.LocationID)
+	}
+	return checkinResult{status: http.StatusCreated, body: map[string]any{
+		"direction":           outcome.Checkin.Direction,
+		"outOfHours":          outcome.Checkin.OutOfHours,
+		"overCapacity":        outcome.OverCapacity,
+		"contradictory":       outcome.Checkin.Contradictory,
+		"counterpartInserted": outcome.Counterpart != nil,
+		"transferredFrom":     transferredFrom,
+		"duplicate":           outcome.Duplicate,
+	}}
+}
+
+// checkinTime applies the clock skew policy to a kiosk's occurredAt. Times
+// ahead of the server by up to the allowed skew are taken as now; further
+// ahead, or older than the replay window, they are refused.
+func (h Handler) checkinTime(occurredAt *time.Time, now time.Time) (time.Time, string) {
+	if occurredAt == nil || occurredAt.IsZero() {
+		return now, ""
+	}
+	values := h.Settings.Current()
+	occurred := occurredAt.UTC()
+	switch {
+	case occurred.After(now.Add(values.ClockSkew)):
+		return time.Time{}, "occurredAt is ahead of the server clock"
+	case occurred.Before(now.Add(-values.ReplayWindow)):
+		return time.Time{}, "occurredAt is older than the replay window"
+	case occurred.After(now):
+		return now, ""
+	}
+	return occurred, ""
+}
+
+// replayCheckins records checkins a kiosk queued while offline, oldest first.
+// Each needs an idempotencyKey and occurredAt, and gets its own result in
+// request order, so one refusal does not hold up the rest.
+func (h Handler) replayCheckins(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var body struct {
+		KeyValue           string           `json:"key"`
+		LocationIdentifier string           `json:"location"`
+		Checkins           []checkinRequest `json:"checkins"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.KeyValue == "" || body.LocationIdentifier == "" || len(body.Checkins) == 0 {
+		respondError(w, http.StatusBadRequest, "missing required fields")
+		return
+	}
+	if len(body.Checkins) > maxReplayCheckins {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("at most %d checkins per replay", maxReplayCheckins))
+		return
+	}
+
+	row, err := h.Store.GetKeyLocationForIdentifier(ctx, body.KeyValue, body.LocationIdentifier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "invalid key or location")
+			return
+		}
+		h.Logger.Error("portal replay lookup", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to validate key")
+		return
+	}
+	_, _ = h.Store.MarkKeyUsed(ctx, row.ID)
+
+	allowedUsers, err := h.Rosters.Users(ctx, row.LocationID)
+	if err != nil {
+		h.Logger.Error("portal list users (roster)", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to validate users")
+		return
+	}
+
+	order := make([]int, len(body.Checkins))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return compareOccurred(body.Checkins[a].OccurredAt, body.Checkins[b].OccurredAt)
+	})
+	now := time.Now().UTC()
+	results := make([]map[string]any, len(body.Checkins))
+	for _, i := range order {
+		req := body.Checkins[i]
+		var res checkinResult
+		switch {
+		case req.IdempotencyKey == uuid.Nil || req.OccurredAt == nil:
+			res = checkinRefused(http.StatusBadRequest, "idempotencyKey and occurredAt are required")
+		case req.UserID == uuid.Nil:
+			res = checkinRefused(http.StatusBadRequest, "missing required fields")
+		case !validDirection(req.Direction):
+			res = checkinRefused(http.StatusBadRequest, "invalid direction")
+		default:
+			res = h.recordCheckin(ctx, row, allowedUsers, req, now)
+		}
+		results[i] = mapReplayResult(req.IdempotencyKey, res)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+func validDirection(direction string) bool {
+	switch direction {
+	case "in", "out", store.DirectionToggle, store.DirectionTransfer:
+		return true
+	}
+	return false
+}
+
+func compareOccurred(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+// mapReplayResult is one replayed checkin's answer, shaped like the single
+// checkin endpoint's response plus its status code.
+func mapReplayResult(idempotencyKey uuid.UUID, res checkinResult) map[string]any {
+	result := map[string]any{
+		"idempotencyKey": idempotencyKey,
+		"status":         res.status,
+	}
+	if res.message != "" {
+		result["error"] = res.message
+		return result
+	}
+	maps.Copy(result, res.body)
+	return result
+}
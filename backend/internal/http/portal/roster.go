@@ -0,0 +1,143 @@
+package portal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// roster returns the key's location roster with its version. The ETag is the
+// version, so polling kiosks get 304 Not Modified until the roster changes.
+// attr.<name>=<value> params narrow it as for config.
+func (h Handler) roster(w http.ResponseWriter, r *http.Request) {
+	row, ok := h.queryKeyLocation(w, r)
+	if !ok {
+		return
+	}
+	roster, err := h.Rosters.Get(r.Context(), row.LocationID)
+	if err != nil {
+		h.Logger.Error("portal roster", "err", err, "location", row.LocationID)
+		respondError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	if notModified(w, r, fmt.Sprintf(`"roster-%d"`, roster.Version)) {
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"version": roster.Version,
+		"users":   mapUsers(filterUsersByAttributes(roster.Users, r.URL.Query())),
+	})
+}
+
+// rosterChanges returns who was added to, changed in or removed from the
+// roster after the version in since. Users that stop matching the attr.*
+// params are listed as removed, and ones that start matching as changed, so
+// kiosks should apply added and changed alike. A since newer than the roster
+// gets 410 Gone; the kiosk should fetch the full roster instead.
+func (h Handler) rosterChanges(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil || since < 0 {
+		respondError(w, http.StatusBadRequest, "since must be a roster version")
+		return
+	}
+	row, ok := h.queryKeyLocation(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	roster, err := h.Rosters.Get(ctx, row.LocationID)
+	if err != nil {
+		h.Logger.Error("portal roster", "err", err, "location", row.LocationID)
+		respondError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	if since > roster.Version {
+		respondError(w, http.StatusGone, "unknown roster version; fetch the full roster")
+		return
+	}
+	if notModified(w, r, fmt.Sprintf(`"roster-%d-%d"`, since, roster.Version)) {
+		return
+	}
+	delta, err := h.Store.RosterChanges(ctx, row.LocationID, since, roster.Version)
+	if err != nil {
+		h.Logger.Error("portal roster changes", "err", err, "location", row.LocationID)
+		respondError(w, http.StatusInternalServerError, "failed to list roster changes")
+		return
+	}
+	respondJSON(w, http.StatusOK, mapRosterDelta(delta, filterUsersByAttributes(delta.Changed, r.URL.Query())))
+}
+
+// queryKeyLocation resolves the key and location query params, answering
+// the request itself when they are missing or invalid.
+func (h Handler) queryKeyLocation(w http.ResponseWriter, r *http.Request) (sqlc.GetKeyLocationForIdentifierRow, bool) {
+	keyValue := strings.TrimSpace(r.URL.Query().Get("key"))
+	locationIdentifier := strings.TrimSpace(r.URL.Query().Get("location"))
+	if keyValue == "" || locationIdentifier == "" {
+		respondError(w, http.StatusBadRequest, "key and location are required")
+		return sqlc.GetKeyLocationForIdentifierRow{}, false
+	}
+	ctx := r.Context()
+	row, err := h.Store.GetKeyLocationForIdentifier(ctx, keyValue, locationIdentifier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "invalid key or location")
+			return row, false
+		}
+		h.Logger.Error("portal key lookup", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to validate key")
+		return row, false
+	}
+	_, _ = h.Store.MarkKeyUsed(ctx, row.ID)
+	return row, true
+}
+
+// notModified sets the ETag and answers 304 when If-None-Match already has
+// it. Kiosks must revalidate every time, as the roster can change at any
+// moment.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	for candidate := range strings.SplitSeq(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// mapRosterDelta splits changed users into added and changed, moving any
+// filtered out by attributes to removed.
+func mapRosterDelta(delta store.RosterDelta, matching []sqlc.User) map[string]any {
+	kept := make(map[uuid.UUID]bool, len(matching))
+	var added, changed []sqlc.User
+	for _, u := range matching {
+		kept[u.ID] = true
+		if delta.Added[u.ID] {
+			added = append(added, u)
+		} else {
+			changed = append(changed, u)
+		}
+	}
+	removed := append([]uuid.UUID{}, delta.Removed...)
+	for _, u := range delta.Changed {
+		if !kept[u.ID] {
+			removed = append(removed, u.ID)
+		}
+	}
+	return map[string]any{
+		"since":   delta.Since,
+		"version": delta.Version,
+		"added":   mapUsers(added),
+		"changed": mapUsers(changed),
+		"removed": removed,
+	}
+}
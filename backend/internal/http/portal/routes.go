@@ -7,13 +7,18 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/branding"
+	"github.com/woodleighschool/signin-ui/internal/roster"
+	"github.com/woodleighschool/signin-ui/internal/schedule"
+	"github.com/woodleighschool/signin-ui/internal/settings"
 	"github.com/woodleighschool/signin-ui/internal/store"
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
@@ -22,17 +27,36 @@ import (
 type Handler struct {
 	Store  *store.Store
 	Logger *slog.Logger
+	// Images caches branding images resized for kiosks.
+	Images *branding.Cache
+	// Rosters caches each location's allowed users.
+	Rosters *roster.Cache
+	// Settings supplies the clock skew and replay window for kiosk checkins.
+	Settings *settings.Service
 }
 
 // RegisterRoutes mounts the portal endpoints.
-func RegisterRoutes(r chi.Router, store *store.Store, logger *slog.Logger) {
-	h := Handler{Store: store, Logger: logger}
+func RegisterRoutes(
+	r chi.Router,
+	store *store.Store,
+	rosters *roster.Cache,
+	settings *settings.Service,
+	logger *slog.Logger,
+) {
+	h := Handler{Store: store, Logger: logger, Images: branding.NewCache(), Rosters: rosters, Settings: settings}
 	r.Get("/config", h.config)
 	r.Post("/checkin", h.checkin)
+	r.Post("/checkins/replay", h.replayCheckins)
+	r.Get("/roster", h.roster)
+	r.Get("/roster/changes", h.rosterChanges)
 	r.Get("/background", h.background)
+	r.Get("/logo", h.logo)
 }
 
-// config returns location details and allowed users for a key.
+// config returns location details, branding and allowed users for a key.
+// Optional attr.<name>=<value> params narrow the roster by mapped user
+// attributes; width sizes image URLs for the kiosk's display. Kiosks that
+// keep the roster up to date through /roster/changes pass users=false.
 func (h Handler) config(w http.ResponseWriter, r *http.Request) {
 	keyValue := strings.TrimSpace(r.URL.Query().Get("key"))
 	locationIdentifier := strings.TrimSpace(r.URL.Query().Get("location"))
@@ -54,40 +78,65 @@ func (h Handler) config(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _ = h.Store.MarkKeyUsed(ctx, row.ID)
 
-	groupIDs, _ := h.Store.ListLocationGroupIDs(ctx, row.LocationID)
-	users, err := h.Store.ListUsersForGroups(ctx, groupIDs)
+	roster, err := h.Rosters.Get(ctx, row.LocationID)
 	if err != nil {
-		h.Logger.Error("portal list users (groups)", "err", err)
+		h.Logger.Error("portal list users (roster)", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to list users")
 		return
 	}
+	transferPolicy, err := h.Store.TransferPolicy(ctx, row.LocationID)
+	if err != nil {
+		h.Logger.Error("portal transfer policy", "err", err, "location", row.LocationID)
+		respondError(w, http.StatusInternalServerError, "failed to load config")
+		return
+	}
 
 	resp := map[string]any{
 		"location": map[string]any{
-			"id":           row.LocationID,
-			"name":         row.LocationName,
-			"identifier":   row.LocationIdentifier,
-			"notesEnabled": row.LocationNotesEnabled,
+			"id":               row.LocationID,
+			"name":             row.LocationName,
+			"identifier":       row.LocationIdentifier,
+			"notesEnabled":     row.LocationNotesEnabled,
+			"transfersEnabled": transferPolicy != store.TransferPolicyDeny,
 		},
-		"users": mapUsers(users),
+		"rosterVersion": roster.Version,
 	}
-	if asset, assetErr := h.Store.GetAsset(ctx, "portal_background"); assetErr == nil {
-		resp["backgroundImageUrl"] = portalBackgroundURL(asset)
-	} else if !errors.Is(assetErr, pgx.ErrNoRows) {
-		h.Logger.Error("portal background lookup", "err", assetErr)
+	if r.URL.Query().Get("users") != "false" {
+		resp["users"] = mapUsers(filterUsersByAttributes(roster.Users, r.URL.Query()))
+	}
+	if count, limits, occErr := h.Store.LocationOccupancy(ctx, row.LocationID); occErr == nil {
+		resp["occupancy"] = mapOccupancy(count, limits)
+	} else {
+		h.Logger.Error("portal occupancy lookup", "err", occErr, "location", row.LocationID)
+	}
+	now := time.Now()
+	if sched, schedErr := h.Store.GetLocationSchedule(ctx, row.LocationID, now); schedErr == nil {
+		resp["schedule"] = mapSchedule(sched, now)
+	} else {
+		h.Logger.Error("portal schedule lookup", "err", schedErr, "location", row.LocationID)
+	}
+	if brand, brandErr := h.Store.ResolveBranding(ctx, row.ID, row.LocationID); brandErr == nil {
+		width, _ := strconv.Atoi(r.URL.Query().Get("width"))
+		resp["branding"] = mapBranding(brand, width)
+		if brand.BackgroundID != uuid.Nil {
+			resp["backgroundImageUrl"] = imageURL(
+				store.BrandingBackground, brand.BackgroundID, brand.BackgroundETag, width)
+		}
+	} else {
+		h.Logger.Error("portal branding lookup", "err", brandErr, "location", row.LocationID)
 	}
 	respondJSON(w, http.StatusOK, resp)
 }
 
-// checkin records a portal check-in/out after validating inputs.
+// checkin records a portal check-in/out after validating inputs. Kiosks send
+// an idempotencyKey so retries are recorded once, and occurredAt when the
+// checkin was made while offline.
 func (h Handler) checkin(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var body struct {
-		KeyValue           string    `json:"key"`
-		LocationIdentifier string    `json:"location"`
-		UserID             uuid.UUID `json:"userId"`
-		Direction          string    `json:"direction"`
-		Notes              string    `json:"notes"`
+		KeyValue           string `json:"key"`
+		LocationIdentifier string `json:"location"`
+		checkinRequest
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid body")
@@ -97,7 +146,7 @@ func (h Handler) checkin(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "missing required fields")
 		return
 	}
-	if body.Direction != "in" && body.Direction != "out" {
+	if !validDirection(body.Direction) {
 		respondError(w, http.StatusBadRequest, "invalid direction")
 		return
 	}
@@ -114,35 +163,65 @@ func (h Handler) checkin(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _ = h.Store.MarkKeyUsed(ctx, row.ID)
 
-	groupIDs, _ := h.Store.ListLocationGroupIDs(ctx, row.LocationID)
-	allowedUsers, err := h.Store.ListUsersForGroups(ctx, groupIDs)
+	allowedUsers, err := h.Rosters.Users(ctx, row.LocationID)
 	if err != nil {
-		h.Logger.Error("portal list users (groups)", "err", err)
+		h.Logger.Error("portal list users (roster)", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to validate user")
 		return
 	}
-	if !userAllowed(body.UserID, allowedUsers) {
-		respondError(w, http.StatusForbidden, "user not permitted for this location")
+	res := h.recordCheckin(ctx, row, allowedUsers, body.checkinRequest, time.Now().UTC())
+	if res.message != "" {
+		respondError(w, res.status, res.message)
 		return
 	}
+	respondJSON(w, res.status, res.body)
+}
 
-	occurred := time.Now().UTC()
-	notesValue := strings.TrimSpace(body.Notes)
-	notes := pgtype.Text{String: notesValue, Valid: notesValue != "" && row.LocationNotesEnabled}
-	_, err = h.Store.CreateCheckin(ctx, sqlc.CreateCheckinParams{
-		UserID:     body.UserID,
-		LocationID: row.LocationID,
-		KeyID:      pgtype.UUID{Bytes: row.ID, Valid: true},
-		Direction:  body.Direction,
-		Notes:      notes,
-		Column6:    occurred,
-	})
-	if err != nil {
-		h.Logger.Error("portal create checkin", "err", err)
-		respondError(w, http.StatusInternalServerError, "failed to record checkin")
-		return
+// applyHoursPolicy reports whether a checkin at t should be flagged as out of
+// hours or refused. Check-outs are never refused, so nobody is stuck signed in.
+func applyHoursPolicy(sched store.LocationSchedule, t time.Time, direction string) (bool, bool) {
+	if sched.Policy == schedule.PolicyAllow || sched.StatusAt(t).Open {
+		return false, false
+	}
+	if sched.Policy == schedule.PolicyReject && direction == "in" {
+		return false, true
+	}
+	return true, false
+}
+
+// mapOccupancy reports how full the location is. limits covers the location
+// and any ancestor with a capacity.
+func mapOccupancy(count int, limits []store.CapacityLimit) map[string]any {
+	mapped := make([]map[string]any, 0, len(limits))
+	full := false
+	for _, l := range limits {
+		full = full || l.Full()
+		mapped = append(mapped, map[string]any{
+			"locationId": l.LocationID,
+			"name":       l.Name,
+			"capacity":   l.Capacity,
+			"policy":     l.Policy,
+			"occupancy":  l.Occupancy,
+		})
+	}
+	return map[string]any{
+		"count":  count,
+		"full":   full,
+		"limits": mapped,
+	}
+}
+
+// mapSchedule reports whether the location is open and, if not, when it
+// next opens.
+func mapSchedule(sched store.LocationSchedule, now time.Time) map[string]any {
+	status := sched.StatusAt(now)
+	return map[string]any{
+		"open":         status.Open,
+		"closedReason": status.Reason,
+		"nextOpen":     status.NextOpen,
+		"timezone":     sched.Timezone,
+		"policy":       sched.Policy,
 	}
-	respondJSON(w, http.StatusCreated, map[string]any{})
 }
 
 func userAllowed(id uuid.UUID, users []sqlc.User) bool {
@@ -162,38 +241,135 @@ func mapUsers(users []sqlc.User) []map[string]any {
 			"id":          u.ID,
 			"displayName": u.DisplayName,
 			"upn":         u.Upn,
+			"department":  u.Department.String,
+			"employeeId":  u.EmployeeID.String,
+			"jobTitle":    u.JobTitle.String,
+			"attributes":  userAttributes(u),
 		})
 	}
 	return resp
 }
 
+// filterUsersByAttributes keeps users whose attributes match every
+// attr.<name>=<value> query param.
+func filterUsersByAttributes(users []sqlc.User, query url.Values) []sqlc.User {
+	want := make(map[string]string)
+	for key, values := range query {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" && len(values) > 0 {
+			want[name] = values[0]
+		}
+	}
+	if len(want) == 0 {
+		return users
+	}
+	filtered := make([]sqlc.User, 0, len(users))
+	for _, u := range users {
+		attrs := userAttributes(u)
+		matched := true
+		for name, value := range want {
+			if attrs[name] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered
+}
+
+func userAttributes(u sqlc.User) map[string]string {
+	attrs := map[string]string{}
+	if len(u.Attributes) > 0 {
+		_ = json.Unmarshal(u.Attributes, &attrs)
+	}
+	return attrs
+}
+
 func (h Handler) background(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	asset, err := h.Store.GetAsset(ctx, "portal_background")
+	h.serveImage(w, r, store.BrandingBackground)
+}
+
+func (h Handler) logo(w http.ResponseWriter, r *http.Request) {
+	h.serveImage(w, r, store.BrandingLogo)
+}
+
+// serveImage serves a branding image from the row named by id, or the global
+// branding without one. w scales it down to the nearest kiosk width.
+// Versioned URLs (with v) are cached for good; ETags cover the rest.
+func (h Handler) serveImage(w http.ResponseWriter, r *http.Request, kind string) {
+	query := r.URL.Query()
+	id := uuid.Nil
+	if raw := query.Get("id"); raw != "" {
+		var err error
+		if id, err = uuid.Parse(raw); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	img, err := h.Store.GetBrandingImage(r.Context(), id, kind)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			http.NotFound(w, r)
 			return
 		}
-		h.Logger.Error("portal background load", "err", err)
-		respondError(w, http.StatusInternalServerError, "failed to load background")
+		h.Logger.Error("portal image load", "kind", kind, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load image")
 		return
 	}
 
-	modTime := time.Now()
-	if asset.UpdatedAt.Valid {
-		modTime = asset.UpdatedAt.Time
+	data, contentType, etag := img.Data, img.ContentType, img.ETag
+	if width, convErr := strconv.Atoi(query.Get("w")); convErr == nil && width > 0 {
+		width = branding.FitWidth(width)
+		etag = fmt.Sprintf("%s-w%d", etag, width)
+		resized, cached := h.Images.Get(etag)
+		if !cached {
+			resizedData, resizedType, resizeErr := branding.Resize(data, contentType, width)
+			if resizeErr != nil {
+				h.Logger.Error("portal image resize", "kind", kind, "err", resizeErr)
+				respondError(w, http.StatusInternalServerError, "failed to resize image")
+				return
+			}
+			resized = branding.Image{Data: resizedData, ContentType: resizedType}
+			h.Images.Put(etag, resized)
+		}
+		data, contentType = resized.Data, resized.ContentType
 	}
-	w.Header().Set("Content-Type", asset.ContentType)
-	w.Header().Set("Cache-Control", "public, max-age=300, stale-while-revalidate=300")
-	http.ServeContent(w, r, "portal-background", modTime, bytes.NewReader(asset.Data))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if query.Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300, stale-while-revalidate=300")
+	}
+	http.ServeContent(w, r, kind, time.Time{}, bytes.NewReader(data))
+}
+
+// mapBranding trims resolved branding for the portal.
+func mapBranding(brand store.ResolvedBranding, width int) map[string]any {
+	resp := map[string]any{
+		"accentColor": brand.AccentColor,
+		"welcomeText": brand.WelcomeText,
+		"language":    brand.Language,
+	}
+	if brand.BackgroundID != uuid.Nil {
+		resp["backgroundUrl"] = imageURL(store.BrandingBackground, brand.BackgroundID, brand.BackgroundETag, width)
+	}
+	if brand.LogoID != uuid.Nil {
+		resp["logoUrl"] = imageURL(store.BrandingLogo, brand.LogoID, brand.LogoETag, 0)
+	}
+	return resp
 }
 
-func portalBackgroundURL(asset sqlc.Asset) string {
-	if asset.UpdatedAt.Valid {
-		return fmt.Sprintf("/api/portal/background?ts=%d", asset.UpdatedAt.Time.Unix())
+// imageURL builds a versioned image URL, sized when width is set.
+func imageURL(kind string, id uuid.UUID, etag string, width int) string {
+	params := url.Values{"id": {id.String()}, "v": {etag}}
+	if width > 0 {
+		params.Set("w", strconv.Itoa(branding.FitWidth(width)))
 	}
-	return "/api/portal/background"
+	return "/api/portal/" + kind + "?" + params.Encode()
 }
 
 func respondJSON(w http.ResponseWriter, status int, payload any) {
@@ -7,7 +7,6 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,16 +15,23 @@ import (
 	"github.com/woodleighschool/signin-ui/internal/http/admin"
 	authhttp "github.com/woodleighschool/signin-ui/internal/http/auth"
 	"github.com/woodleighschool/signin-ui/internal/http/portal"
+	"github.com/woodleighschool/signin-ui/internal/http/scim"
+	"github.com/woodleighschool/signin-ui/internal/roster"
+	"github.com/woodleighschool/signin-ui/internal/settings"
 	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
 )
 
 // AdminDeps bundles dependencies for the admin API and UI.
 type AdminDeps struct {
-	Store        *store.Store
-	Logger       *slog.Logger
-	Sessions     *auth.SessionManager
-	OIDCProvider *auth.OIDCProvider
-	BuildInfo    BuildInfo
+	Store     *store.Store
+	Logger    *slog.Logger
+	Sessions  *auth.SessionManager
+	Providers *auth.Providers
+	Sync      *syncer.Runner
+	Settings  *settings.Service
+	Rosters   *roster.Cache
+	BuildInfo BuildInfo
 }
 
 // BuildInfo is returned to clients for display.
@@ -35,11 +41,9 @@ type BuildInfo struct {
 	BuildDate string `json:"build_date"`
 }
 
-const defaultRequestTimeout = 60 * time.Second
-
 // NewAdminRouter wires the admin API, auth routes, and static UI.
 func NewAdminRouter(cfg config.Config, deps AdminDeps) http.Handler {
-	r := baseRouter()
+	r := baseRouter(deps.Settings)
 
 	r.Get("/api/v1/status", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{
@@ -51,17 +55,23 @@ func NewAdminRouter(cfg config.Config, deps AdminDeps) http.Handler {
 	api := chi.NewRouter()
 	api.Use(AdminAuth(deps.Sessions, deps.Logger))
 	api.Use(LoadUser(deps.Store))
-	admin.RegisterRoutes(api, cfg, deps.Store, deps.Logger)
+	admin.RegisterRoutes(api, cfg, deps.Store, deps.Sync, deps.Settings, deps.Logger)
 	r.Mount("/api", api)
 
 	authRoutes := chi.NewRouter()
-	authhttp.RegisterRoutes(authRoutes, cfg, deps.OIDCProvider, deps.Sessions, deps.Logger)
+	authhttp.RegisterRoutes(authRoutes, cfg, deps.Providers, deps.Sessions, deps.Store, deps.Logger)
 	r.Mount("/api/auth", authRoutes)
 
 	portalRoutes := chi.NewRouter()
-	portal.RegisterRoutes(portalRoutes, deps.Store, deps.Logger)
+	portal.RegisterRoutes(portalRoutes, deps.Store, deps.Rosters, deps.Settings, deps.Logger)
 	r.Mount("/api/portal", portalRoutes)
 
+	if cfg.SCIMToken != "" {
+		scimRoutes := chi.NewRouter()
+		scim.RegisterRoutes(scimRoutes, deps.Store, cfg.SCIMToken, cfg.SiteBaseURL+"/scim/v2", deps.Logger)
+		r.Mount("/scim/v2", scimRoutes)
+	}
+
 	handler := http.Handler(r)
 	if cfg.FrontendDistDir != "" {
 		handler = mountStatic(cfg.FrontendDistDir, handler)
@@ -70,21 +80,31 @@ func NewAdminRouter(cfg config.Config, deps AdminDeps) http.Handler {
 }
 
 // baseRouter applies shared middleware.
-func baseRouter() *chi.Mux {
+func baseRouter(current *settings.Service) *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(defaultRequestTimeout))
+	r.Use(requestTimeout(current))
 	return r
 }
 
-// mountStatic serves the frontend when the path is not under /api/.
+// requestTimeout applies the request timeout setting current at the start of
+// each request.
+func requestTimeout(current *settings.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			middleware.Timeout(current.Current().RequestTimeout)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// mountStatic serves the frontend when the path is not under /api/ or /scim/.
 func mountStatic(distDir string, apiHandler http.Handler) http.Handler {
 	fileServer := http.FileServer(http.Dir(distDir))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/api/") {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/scim/") {
 			apiHandler.ServeHTTP(w, r)
 			return
 		}
@@ -0,0 +1,272 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// errUnknownMember means a group resource referenced a user that does not exist.
+var errUnknownMember = errors.New("unknown group member")
+
+func (h Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f, err := parseFilter(r.URL.Query().Get("filter"), "displayName", "externalId")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+		return
+	}
+	groups, err := h.Store.ListGroups(ctx, "")
+	if err != nil {
+		h.Logger.Error("scim list groups", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to list groups")
+		return
+	}
+	groups = slices.DeleteFunc(groups, func(g sqlc.Group) bool {
+		if g.Source.String == store.SourceLocal {
+			return true
+		}
+		switch f.Attribute {
+		case "displayName":
+			return !strings.EqualFold(g.DisplayName, f.Value)
+		case "externalId":
+			return g.ObjectID.String != f.Value
+		}
+		return false
+	})
+	start, count := page(r)
+	resources := make([]groupResource, 0, count)
+	for _, g := range paginate(groups, start, count) {
+		res, mapErr := h.mapGroup(ctx, g, withMembers(r))
+		if mapErr != nil {
+			h.Logger.Error("scim list group members", "err", mapErr)
+			respondError(w, http.StatusInternalServerError, "", "failed to list groups")
+			return
+		}
+		resources = append(resources, res)
+	}
+	respondJSON(w, http.StatusOK, listResponse(resources, len(groups), start))
+}
+
+func (h Handler) getGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.mapGroup(r.Context(), group, withMembers(r))
+	if err != nil {
+		h.Logger.Error("scim list group members", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to load group")
+		return
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+func (h Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var res groupResource
+	if !decodeBody(w, r, &res) || !validGroup(w, res) {
+		return
+	}
+	id := uuid.New()
+	if externalID, err := uuid.Parse(res.ExternalID); err == nil {
+		id = externalID
+	}
+	group, err := h.saveGroup(r.Context(), id, res)
+	h.respondGroupWrite(w, r, group, err, http.StatusCreated)
+}
+
+func (h Handler) replaceGroup(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+	var res groupResource
+	if !decodeBody(w, r, &res) || !validGroup(w, res) {
+		return
+	}
+	group, err := h.saveGroup(r.Context(), existing.ID, res)
+	h.respondGroupWrite(w, r, group, err, http.StatusOK)
+}
+
+func (h Handler) patchGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	existing, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+	var req patchRequest
+	if !decodeBody(w, r, &req) || !validPatch(w, req) {
+		return
+	}
+	current, err := h.mapGroup(ctx, existing, true)
+	if err != nil {
+		h.Logger.Error("scim list group members", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to load group")
+		return
+	}
+	var res groupResource
+	if err = applyPatch(current, req.Operations, &res); err != nil {
+		h.respondPatchError(w, err)
+		return
+	}
+	if !validGroup(w, res) {
+		return
+	}
+	group, err := h.saveGroup(ctx, existing.ID, res)
+	h.respondGroupWrite(w, r, group, err, http.StatusOK)
+}
+
+func (h Handler) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.Store.DeleteGroup(r.Context(), group.ID); err != nil {
+		h.Logger.Error("scim delete group", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to delete group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveGroup writes a group resource and replaces its members.
+func (h Handler) saveGroup(ctx context.Context, id uuid.UUID, res groupResource) (sqlc.Group, error) {
+	memberIDs := make([]uuid.UUID, 0, len(res.Members))
+	for _, member := range res.Members {
+		userID, err := uuid.Parse(member.Value)
+		if err != nil {
+			return sqlc.Group{}, errUnknownMember
+		}
+		if _, err = h.Store.GetUser(ctx, userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return sqlc.Group{}, errUnknownMember
+			}
+			return sqlc.Group{}, err
+		}
+		memberIDs = append(memberIDs, userID)
+	}
+	objectID := res.ExternalID
+	if objectID == "" {
+		objectID = id.String()
+	}
+	group, err := h.Store.UpsertGroup(ctx, sqlc.UpsertGroupParams{
+		ID:          id,
+		DisplayName: res.DisplayName,
+		ObjectID:    pgtype.Text{String: objectID, Valid: true},
+		Source:      pgtype.Text{String: SourceName, Valid: true},
+	})
+	if err != nil {
+		return sqlc.Group{}, err
+	}
+	if _, _, err = h.Store.ReplaceGroupMembers(ctx, group.ID, memberIDs); err != nil {
+		return sqlc.Group{}, err
+	}
+	return group, nil
+}
+
+// respondGroupWrite reports the outcome of saveGroup.
+func (h Handler) respondGroupWrite(
+	w http.ResponseWriter,
+	r *http.Request,
+	group sqlc.Group,
+	err error,
+	status int,
+) {
+	if errors.Is(err, errUnknownMember) {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidValue, "members must reference existing users")
+		return
+	}
+	var res groupResource
+	if err == nil {
+		res, err = h.mapGroup(r.Context(), group, true)
+	}
+	if err != nil {
+		h.Logger.Error("scim save group", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to save group")
+		return
+	}
+	if status == http.StatusCreated {
+		w.Header().Set("Location", h.groupLocation(group.ID))
+	}
+	respondJSON(w, status, res)
+}
+
+func (h Handler) loadGroup(w http.ResponseWriter, r *http.Request) (sqlc.Group, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "", "group not found")
+		return sqlc.Group{}, false
+	}
+	group, err := h.Store.GetGroup(r.Context(), id)
+	// Local groups belong to the admin UI, not the identity provider.
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && group.Source.String == store.SourceLocal) {
+		respondError(w, http.StatusNotFound, "", "group not found")
+		return sqlc.Group{}, false
+	}
+	if err != nil {
+		h.Logger.Error("scim get group", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to load group")
+		return sqlc.Group{}, false
+	}
+	return group, true
+}
+
+func (h Handler) mapGroup(ctx context.Context, g sqlc.Group, members bool) (groupResource, error) {
+	res := groupResource{
+		Schemas:     []string{schemaGroup},
+		ID:          g.ID.String(),
+		DisplayName: g.DisplayName,
+		Meta:        newMeta("Group", h.groupLocation(g.ID), g.CreatedAt, g.UpdatedAt),
+	}
+	if g.ObjectID.String != g.ID.String() {
+		res.ExternalID = g.ObjectID.String
+	}
+	if !members {
+		return res, nil
+	}
+	users, err := h.Store.ListGroupMembers(ctx, g.ID)
+	if err != nil {
+		return groupResource{}, err
+	}
+	res.Members = make([]reference, 0, len(users))
+	for _, u := range users {
+		res.Members = append(res.Members, reference{
+			Value:   u.ID.String(),
+			Display: u.DisplayName,
+			Ref:     h.userLocation(u.ID),
+		})
+	}
+	return res, nil
+}
+
+func (h Handler) groupLocation(id uuid.UUID) string {
+	return h.BaseURL + "/Groups/" + id.String()
+}
+
+// withMembers reports whether members were not excluded from the response,
+// which identity providers do to keep large groups cheap.
+func withMembers(r *http.Request) bool {
+	for _, attr := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return false
+		}
+	}
+	return true
+}
+
+func validGroup(w http.ResponseWriter, res groupResource) bool {
+	if strings.TrimSpace(res.DisplayName) == "" {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidValue, "displayName is required")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,321 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type patchRequest struct {
+	Schemas    []string  `json:"schemas"`
+	Operations []patchOp `json:"Operations"`
+}
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// errInvalidPatch wraps malformed operations and paths.
+var errInvalidPatch = errors.New("invalid patch operation")
+
+// patchPath is a parsed attribute path: an optional extension schema, the
+// attribute, an optional `[sub eq "value"]` filter and a sub-attribute.
+type patchPath struct {
+	Schema      string
+	Attr        string
+	FilterAttr  string
+	FilterValue string
+	Sub         string
+}
+
+var pathPattern = regexp.MustCompile(`^([\w$]+)(?:\[\s*([\w$]+)\s+(?i:eq)\s+"([^"]*)"\s*\])?(?:\.([\w$]+))?$`)
+
+// applyPatch applies PATCH operations to resource and decodes the result into
+// out. Operations work on the JSON form so every attribute is patchable.
+func applyPatch(resource any, ops []patchOp, out any) error {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	doc := map[string]any{}
+	if err = json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if err = applyOp(doc, op); err != nil {
+			return err
+		}
+	}
+	normalizeDocument(doc)
+	if raw, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidPatch, err)
+	}
+	return nil
+}
+
+func applyOp(doc map[string]any, op patchOp) error {
+	var value any
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return fmt.Errorf("%w: value: %w", errInvalidPatch, err)
+		}
+	}
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace":
+		if op.Path != "" {
+			path, err := parsePatchPath(op.Path)
+			if err != nil {
+				return err
+			}
+			setPath(doc, path, value, kind == "add")
+			return nil
+		}
+		// Without a path the value is an object of attributes to set.
+		attrs, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%w: %s without path needs an object value", errInvalidPatch, op.Op)
+		}
+		for name, attrValue := range attrs {
+			path, err := parsePatchPath(name)
+			if err != nil {
+				return err
+			}
+			setPath(doc, path, attrValue, kind == "add")
+		}
+		return nil
+	case "remove":
+		if op.Path == "" {
+			return fmt.Errorf("%w: remove needs a path", errInvalidPatch)
+		}
+		path, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		removePath(doc, path, value)
+		return nil
+	}
+	return fmt.Errorf("%w: unknown op %q", errInvalidPatch, op.Op)
+}
+
+func parsePatchPath(raw string) (patchPath, error) {
+	var path patchPath
+	rest := raw
+	for _, schema := range []string{schemaEnterpriseUser, schemaUser, schemaGroup} {
+		if len(rest) < len(schema) || !strings.EqualFold(rest[:len(schema)], schema) {
+			continue
+		}
+		rest = strings.TrimPrefix(rest[len(schema):], ":")
+		if schema == schemaEnterpriseUser {
+			path.Schema = schemaEnterpriseUser
+		}
+		break
+	}
+	if rest == "" && path.Schema != "" {
+		// The whole extension object, as sent in path-less operations.
+		return patchPath{Attr: path.Schema}, nil
+	}
+	m := pathPattern.FindStringSubmatch(rest)
+	if m == nil {
+		return patchPath{}, fmt.Errorf("%w: unsupported path %q", errInvalidPatch, raw)
+	}
+	path.Attr, path.FilterAttr, path.FilterValue, path.Sub = m[1], m[2], m[3], m[4]
+	return path, nil
+}
+
+// container returns the object holding path's attribute, creating the
+// extension object when asked to.
+func container(doc map[string]any, path patchPath, create bool) map[string]any {
+	if path.Schema == "" {
+		return doc
+	}
+	key := findKey(doc, path.Schema)
+	if ext, ok := doc[key].(map[string]any); ok {
+		return ext
+	}
+	if !create {
+		return nil
+	}
+	ext := map[string]any{}
+	doc[key] = ext
+	return ext
+}
+
+// findKey returns the existing key matching name case-insensitively, as SCIM
+// attribute names are, or name itself.
+func findKey(obj map[string]any, name string) string {
+	for key := range obj {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func setPath(doc map[string]any, path patchPath, value any, add bool) {
+	obj := container(doc, path, true)
+	key := findKey(obj, path.Attr)
+	switch {
+	case path.FilterAttr != "":
+		items, _ := obj[key].([]any)
+		idx := matchIndex(items, path.FilterAttr, path.FilterValue)
+		if len(idx) == 0 {
+			items = append(items, map[string]any{path.FilterAttr: path.FilterValue})
+			idx = []int{len(items) - 1}
+		}
+		for _, i := range idx {
+			item, _ := items[i].(map[string]any)
+			if path.Sub != "" {
+				item[findKey(item, path.Sub)] = value
+			} else if fields, ok := value.(map[string]any); ok {
+				for name, fieldValue := range fields {
+					item[findKey(item, name)] = fieldValue
+				}
+			}
+		}
+		obj[key] = items
+	case path.Sub != "":
+		sub, ok := obj[key].(map[string]any)
+		if !ok {
+			sub = map[string]any{}
+			obj[key] = sub
+		}
+		sub[findKey(sub, path.Sub)] = value
+	default:
+		existing, isList := obj[key].([]any)
+		values, valueIsList := value.([]any)
+		if add && isList && valueIsList {
+			obj[key] = appendUnique(existing, values)
+			return
+		}
+		current, isObject := obj[key].(map[string]any)
+		fields, valueIsObject := value.(map[string]any)
+		if add && isObject && valueIsObject {
+			for name, fieldValue := range fields {
+				current[findKey(current, name)] = fieldValue
+			}
+			return
+		}
+		obj[key] = value
+	}
+}
+
+func removePath(doc map[string]any, path patchPath, value any) {
+	obj := container(doc, path, false)
+	if obj == nil {
+		return
+	}
+	key := findKey(obj, path.Attr)
+	switch {
+	case path.FilterAttr != "":
+		items, _ := obj[key].([]any)
+		remove := map[int]bool{}
+		for _, i := range matchIndex(items, path.FilterAttr, path.FilterValue) {
+			remove[i] = true
+		}
+		kept := make([]any, 0, len(items))
+		for i, item := range items {
+			if !remove[i] {
+				kept = append(kept, item)
+				continue
+			}
+			if fields, ok := item.(map[string]any); ok && path.Sub != "" {
+				delete(fields, findKey(fields, path.Sub))
+				kept = append(kept, fields)
+			}
+		}
+		obj[key] = kept
+	case path.Sub != "":
+		if sub, ok := obj[key].(map[string]any); ok {
+			delete(sub, findKey(sub, path.Sub))
+		}
+	default:
+		existing, isList := obj[key].([]any)
+		values, valueIsList := value.([]any)
+		if !isList || !valueIsList {
+			delete(obj, key)
+			return
+		}
+		// Removing listed members, as Entra ID does for group membership.
+		drop := map[string]bool{}
+		for _, v := range values {
+			drop[itemValue(v)] = true
+		}
+		kept := make([]any, 0, len(existing))
+		for _, item := range existing {
+			if !drop[itemValue(item)] {
+				kept = append(kept, item)
+			}
+		}
+		obj[key] = kept
+	}
+}
+
+// matchIndex lists the items whose attr equals want.
+func matchIndex(items []any, attr, want string) []int {
+	var idx []int
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if got, isString := fields[findKey(fields, attr)].(string); isString && strings.EqualFold(got, want) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func appendUnique(existing, values []any) []any {
+	seen := make(map[string]bool, len(existing))
+	for _, item := range existing {
+		seen[itemValue(item)] = true
+	}
+	for _, item := range values {
+		if key := itemValue(item); !seen[key] {
+			seen[key] = true
+			existing = append(existing, item)
+		}
+	}
+	return existing
+}
+
+// itemValue returns the "value" of a multi-valued attribute item.
+func itemValue(item any) string {
+	if fields, ok := item.(map[string]any); ok {
+		item = fields[findKey(fields, "value")]
+	}
+	s, _ := item.(string)
+	return s
+}
+
+// normalizeDocument fixes the loose forms Entra ID sends: booleans as
+// strings and the manager as a bare ID.
+func normalizeDocument(doc map[string]any) {
+	activeKey := findKey(doc, "active")
+	if s, ok := doc[activeKey].(string); ok {
+		doc[activeKey] = strings.EqualFold(s, "true")
+	}
+	ext, ok := doc[findKey(doc, schemaEnterpriseUser)].(map[string]any)
+	if !ok {
+		return
+	}
+	managerKey := findKey(ext, "manager")
+	switch manager := ext[managerKey].(type) {
+	case string:
+		if manager == "" {
+			delete(ext, managerKey)
+		} else {
+			ext[managerKey] = map[string]any{"value": manager}
+		}
+	case nil:
+		delete(ext, managerKey)
+	}
+}
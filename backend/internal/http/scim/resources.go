@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// SCIM schema and message URNs.
+const (
+	schemaUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaEnterpriseUser = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	schemaGroup          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaListResponse   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError          = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// scimType values used in error responses.
+const (
+	scimTypeInvalidFilter = "invalidFilter"
+	scimTypeInvalidSyntax = "invalidSyntax"
+	scimTypeInvalidValue  = "invalidValue"
+	scimTypeInvalidPath   = "invalidPath"
+	scimTypeUniqueness    = "uniqueness"
+)
+
+type userResource struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Name        *userName       `json:"name,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Emails      []multiValue    `json:"emails,omitempty"`
+	Enterprise  *enterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta        *meta           `json:"meta,omitempty"`
+}
+
+type userName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type enterpriseUser struct {
+	EmployeeNumber string     `json:"employeeNumber,omitempty"`
+	Department     string     `json:"department,omitempty"`
+	CostCenter     string     `json:"costCenter,omitempty"`
+	Organization   string     `json:"organization,omitempty"`
+	Division       string     `json:"division,omitempty"`
+	Manager        *reference `json:"manager,omitempty"`
+}
+
+type groupResource struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []reference `json:"members,omitempty"`
+	Meta        *meta       `json:"meta,omitempty"`
+}
+
+type multiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type reference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// displayName picks the best available name for a user.
+func (u userResource) displayName() string {
+	switch {
+	case u.DisplayName != "":
+		return u.DisplayName
+	case u.Name != nil && u.Name.Formatted != "":
+		return u.Name.Formatted
+	case u.Name != nil && (u.Name.GivenName != "" || u.Name.FamilyName != ""):
+		return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	}
+	return u.UserName
+}
+
+func (u userResource) active() bool {
+	return u.Active == nil || *u.Active
+}
+
+func newMeta(resourceType, location string, created, updated pgtype.Timestamptz) *meta {
+	return &meta{
+		ResourceType: resourceType,
+		Created:      created.Time,
+		LastModified: updated.Time,
+		Location:     location,
+	}
+}
+
+// filter is a parsed `attribute eq "value"` expression, the only form
+// identity providers use when looking up resources.
+type filter struct {
+	Attribute string
+	Value     string
+}
+
+var filterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.:]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+var errUnsupportedFilter = errors.New("only `attribute eq \"value\"` filters are supported")
+
+// parseFilter parses a filter, allowing only the listed attributes. An empty
+// expression yields a zero filter.
+func parseFilter(expr string, allowed ...string) (filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return filter{}, nil
+	}
+	m := filterPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return filter{}, errUnsupportedFilter
+	}
+	for _, attr := range allowed {
+		if strings.EqualFold(m[1], attr) {
+			return filter{Attribute: attr, Value: strings.ReplaceAll(m[2], `\"`, `"`)}, nil
+		}
+	}
+	return filter{}, fmt.Errorf("filtering on %q is not supported", m[1])
+}
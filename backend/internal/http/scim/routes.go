@@ -0,0 +1,169 @@
+// Package scim serves a SCIM 2.0 provisioning endpoint so an identity
+// provider can push user and group changes instead of waiting for a sync.
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+// SourceName marks users and groups written through SCIM.
+const SourceName = "scim"
+
+const (
+	contentType     = "application/scim+json"
+	maxBodyBytes    = int64(1 << 20) // 1MiB
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Handler serves the SCIM endpoints.
+type Handler struct {
+	Store   *store.Store
+	Logger  *slog.Logger
+	BaseURL string
+}
+
+// RegisterRoutes mounts the SCIM endpoints, guarded by a bearer token.
+// baseURL is the public URL of the mount point, used in resource locations.
+func RegisterRoutes(r chi.Router, store *store.Store, token, baseURL string, logger *slog.Logger) {
+	h := Handler{Store: store, Logger: logger, BaseURL: strings.TrimSuffix(baseURL, "/")}
+	r.Use(bearerAuth(token))
+	r.Get("/ServiceProviderConfig", h.serviceProviderConfig)
+	r.Get("/ResourceTypes", h.resourceTypes)
+	r.Route("/Users", func(r chi.Router) {
+		r.Get("/", h.listUsers)
+		r.Post("/", h.createUser)
+		r.Get("/{id}", h.getUser)
+		r.Put("/{id}", h.replaceUser)
+		r.Patch("/{id}", h.patchUser)
+		r.Delete("/{id}", h.deleteUser)
+	})
+	r.Route("/Groups", func(r chi.Router) {
+		r.Get("/", h.listGroups)
+		r.Post("/", h.createGroup)
+		r.Get("/{id}", h.getGroup)
+		r.Put("/{id}", h.replaceGroup)
+		r.Patch("/{id}", h.patchGroup)
+		r.Delete("/{id}", h.deleteGroup)
+	})
+}
+
+// bearerAuth rejects requests without the configured bearer token.
+func bearerAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				respondError(w, http.StatusUnauthorized, "", "invalid bearer token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h Handler) serviceProviderConfig(w http.ResponseWriter, _ *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]any{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": maxPageSize},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with the configured SCIM bearer token",
+		}},
+	})
+}
+
+func (h Handler) resourceTypes(w http.ResponseWriter, _ *http.Request) {
+	types := []map[string]any{
+		{
+			"schemas":          []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+			"id":               "User",
+			"name":             "User",
+			"endpoint":         "/Users",
+			"schema":           schemaUser,
+			"schemaExtensions": []map[string]any{{"schema": schemaEnterpriseUser, "required": false}},
+		},
+		{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   schemaGroup,
+		},
+	}
+	respondJSON(w, http.StatusOK, listResponse(types, len(types), 1))
+}
+
+// page reads startIndex and count, which are 1-based per RFC 7644.
+func page(r *http.Request) (start, count int) {
+	start, count = 1, defaultPageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && n > 1 {
+		start = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && n >= 0 {
+		count = min(n, maxPageSize)
+	}
+	return start, count
+}
+
+// paginate slices items for a page.
+func paginate[T any](items []T, start, count int) []T {
+	from := min(start-1, len(items))
+	to := min(from+count, len(items))
+	return items[from:to]
+}
+
+func listResponse[T any](resources []T, total, start int) map[string]any {
+	return map[string]any{
+		"schemas":      []string{schemaListResponse},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, dest any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid request body")
+		return false
+	}
+	return true
+}
+
+func respondJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if payload == nil {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+// respondError writes a SCIM error; scimType may be empty.
+func respondError(w http.ResponseWriter, status int, scimType, detail string) {
+	body := map[string]any{
+		"schemas": []string{schemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	respondJSON(w, status, body)
+}
@@ -0,0 +1,333 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// Archive reasons, matching the ones the directory syncer records.
+const (
+	archiveReasonDisabled = "account disabled"
+	archiveReasonDeleted  = "removed from directory"
+)
+
+// Enterprise extension fields kept in the user's mapped attributes.
+const (
+	attributeCostCenter   = "costCenter"
+	attributeDivision     = "division"
+	attributeOrganization = "organization"
+)
+
+func (h Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f, err := parseFilter(r.URL.Query().Get("filter"), "userName", "externalId")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+		return
+	}
+	var users []sqlc.User
+	if f.Attribute == "userName" {
+		user, getErr := h.Store.GetUserByUPN(ctx, f.Value)
+		switch {
+		case errors.Is(getErr, pgx.ErrNoRows):
+		case getErr != nil:
+			h.Logger.Error("scim get user by upn", "err", getErr)
+			respondError(w, http.StatusInternalServerError, "", "failed to list users")
+			return
+		default:
+			users = append(users, user)
+		}
+	} else {
+		users, err = h.Store.ListUsers(ctx, "", true, nil)
+		if err != nil {
+			h.Logger.Error("scim list users", "err", err)
+			respondError(w, http.StatusInternalServerError, "", "failed to list users")
+			return
+		}
+	}
+	users = slices.DeleteFunc(users, func(u sqlc.User) bool {
+		return deleted(u) || (f.Attribute == "externalId" && u.ObjectID.String != f.Value)
+	})
+	start, count := page(r)
+	resources := make([]userResource, 0, count)
+	for _, u := range paginate(users, start, count) {
+		resources = append(resources, h.mapUser(u))
+	}
+	respondJSON(w, http.StatusOK, listResponse(resources, len(users), start))
+}
+
+func (h Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.mapUser(user))
+}
+
+// createUser provisions a user. A user that already signed in or was synced
+// with the same userName is adopted rather than duplicated.
+func (h Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var res userResource
+	if !decodeBody(w, r, &res) || !validUser(w, res) {
+		return
+	}
+	// externalId is the client's identifier and is only stored as the object
+	// ID; it never picks the row, so it cannot address another user.
+	id := uuid.New()
+	existing, err := h.Store.GetUserByUPN(ctx, res.UserName)
+	switch {
+	case err == nil:
+		if existing.Source.String == SourceName && !deleted(existing) {
+			respondError(w, http.StatusConflict, scimTypeUniqueness, "userName is already provisioned")
+			return
+		}
+		id = existing.ID
+	case !errors.Is(err, pgx.ErrNoRows):
+		h.Logger.Error("scim get user by upn", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to create user")
+		return
+	}
+	user, err := h.saveUser(ctx, id, res)
+	if err != nil {
+		h.Logger.Error("scim create user", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to create user")
+		return
+	}
+	w.Header().Set("Location", h.userLocation(user.ID))
+	respondJSON(w, http.StatusCreated, h.mapUser(user))
+}
+
+func (h Handler) replaceUser(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	var res userResource
+	if !decodeBody(w, r, &res) || !validUser(w, res) {
+		return
+	}
+	h.writeUser(w, r, existing, res)
+}
+
+func (h Handler) patchUser(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	var req patchRequest
+	if !decodeBody(w, r, &req) || !validPatch(w, req) {
+		return
+	}
+	var res userResource
+	if err := applyPatch(h.mapUser(existing), req.Operations, &res); err != nil {
+		h.respondPatchError(w, err)
+		return
+	}
+	if !validUser(w, res) {
+		return
+	}
+	h.writeUser(w, r, existing, res)
+}
+
+// deleteUser archives the user so their checkins are kept.
+func (h Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.Store.ArchiveUser(r.Context(), user.ID, archiveReasonDeleted); err != nil {
+		h.Logger.Error("scim archive user", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to delete user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeUser saves a replaced or patched user, rejecting a userName owned by
+// another user.
+func (h Handler) writeUser(w http.ResponseWriter, r *http.Request, existing sqlc.User, res userResource) {
+	ctx := r.Context()
+	if !strings.EqualFold(existing.Upn, res.UserName) {
+		other, err := h.Store.GetUserByUPN(ctx, res.UserName)
+		if err == nil && other.ID != existing.ID {
+			respondError(w, http.StatusConflict, scimTypeUniqueness, "userName belongs to another user")
+			return
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			h.Logger.Error("scim get user by upn", "err", err)
+			respondError(w, http.StatusInternalServerError, "", "failed to update user")
+			return
+		}
+	}
+	user, err := h.saveUser(ctx, existing.ID, res)
+	if err != nil {
+		h.Logger.Error("scim update user", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to update user")
+		return
+	}
+	respondJSON(w, http.StatusOK, h.mapUser(user))
+}
+
+// saveUser writes a user resource. Inactive users are archived as disabled.
+func (h Handler) saveUser(ctx context.Context, id uuid.UUID, res userResource) (sqlc.User, error) {
+	enterprise := res.Enterprise
+	if enterprise == nil {
+		enterprise = &enterpriseUser{}
+	}
+	existing, err := h.Store.GetUser(ctx, id)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.User{}, err
+	}
+	objectID := existing.ObjectID
+	if res.ExternalID != "" {
+		objectID = pgtype.Text{String: res.ExternalID, Valid: true}
+	}
+	_, err = h.Store.UpsertUser(ctx, sqlc.UpsertUserParams{
+		ID:          id,
+		Upn:         res.UserName,
+		DisplayName: res.displayName(),
+		ObjectID:    objectID,
+		Department:  pgtype.Text{String: enterprise.Department, Valid: enterprise.Department != ""},
+		Source:      pgtype.Text{String: SourceName, Valid: true},
+		Unarchive:   res.active(),
+	})
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	attributes := decodeAttributes(existing.Attributes)
+	setAttribute(attributes, attributeCostCenter, enterprise.CostCenter)
+	setAttribute(attributes, attributeDivision, enterprise.Division)
+	setAttribute(attributes, attributeOrganization, enterprise.Organization)
+	attrs := store.DirectoryAttributes{
+		EmployeeID: enterprise.EmployeeNumber,
+		JobTitle:   res.Title,
+		SetManager: true,
+		Attributes: attributes,
+	}
+	if enterprise.Manager != nil {
+		attrs.ManagerID, _ = uuid.Parse(enterprise.Manager.Value)
+	}
+	if err = h.Store.SetUserDirectoryAttributes(ctx, id, attrs); err != nil {
+		return sqlc.User{}, err
+	}
+	if !res.active() {
+		if _, err = h.Store.ArchiveUser(ctx, id, archiveReasonDisabled); err != nil {
+			return sqlc.User{}, err
+		}
+	}
+	return h.Store.GetUser(ctx, id)
+}
+
+// loadUser fetches the user named in the URL, writing a 404 for unknown or
+// deleted users.
+func (h Handler) loadUser(w http.ResponseWriter, r *http.Request) (sqlc.User, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "", "user not found")
+		return sqlc.User{}, false
+	}
+	user, err := h.Store.GetUser(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && deleted(user)) {
+		respondError(w, http.StatusNotFound, "", "user not found")
+		return sqlc.User{}, false
+	}
+	if err != nil {
+		h.Logger.Error("scim get user", "err", err)
+		respondError(w, http.StatusInternalServerError, "", "failed to load user")
+		return sqlc.User{}, false
+	}
+	return user, true
+}
+
+func (h Handler) mapUser(u sqlc.User) userResource {
+	active := !u.ArchivedAt.Valid
+	attributes := decodeAttributes(u.Attributes)
+	res := userResource{
+		Schemas:     []string{schemaUser, schemaEnterpriseUser},
+		ID:          u.ID.String(),
+		ExternalID:  u.ObjectID.String,
+		UserName:    u.Upn,
+		DisplayName: u.DisplayName,
+		Name:        &userName{Formatted: u.DisplayName},
+		Title:       u.JobTitle.String,
+		Active:      &active,
+		Enterprise: &enterpriseUser{
+			EmployeeNumber: u.EmployeeID.String,
+			Department:     u.Department.String,
+			CostCenter:     attributes[attributeCostCenter],
+			Organization:   attributes[attributeOrganization],
+			Division:       attributes[attributeDivision],
+		},
+		Meta: newMeta("User", h.userLocation(u.ID), u.CreatedAt, u.UpdatedAt),
+	}
+	if strings.Contains(u.Upn, "@") {
+		res.Emails = []multiValue{{Value: u.Upn, Type: "work", Primary: true}}
+	}
+	if u.ManagerID.Valid {
+		managerID := uuid.UUID(u.ManagerID.Bytes)
+		res.Enterprise.Manager = &reference{Value: managerID.String(), Ref: h.userLocation(managerID)}
+	}
+	return res
+}
+
+func (h Handler) userLocation(id uuid.UUID) string {
+	return h.BaseURL + "/Users/" + id.String()
+}
+
+func (h Handler) respondPatchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidPatch) {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidPath, err.Error())
+		return
+	}
+	h.Logger.Error("scim apply patch", "err", err)
+	respondError(w, http.StatusInternalServerError, "", "failed to apply patch")
+}
+
+// deleted reports whether a user was deleted, as opposed to disabled.
+func deleted(u sqlc.User) bool {
+	return u.ArchivedAt.Valid && u.ArchiveReason.String == archiveReasonDeleted
+}
+
+func validUser(w http.ResponseWriter, res userResource) bool {
+	if strings.TrimSpace(res.UserName) == "" {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidValue, "userName is required")
+		return false
+	}
+	return true
+}
+
+func validPatch(w http.ResponseWriter, req patchRequest) bool {
+	if !slices.Contains(req.Schemas, schemaPatchOp) || len(req.Operations) == 0 {
+		respondError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "expected a PatchOp with operations")
+		return false
+	}
+	return true
+}
+
+func decodeAttributes(raw []byte) map[string]string {
+	attributes := map[string]string{}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &attributes)
+	}
+	return attributes
+}
+
+func setAttribute(attributes map[string]string, name, value string) {
+	if value == "" {
+		delete(attributes, name)
+		return
+	}
+	attributes[name] = value
+}
@@ -0,0 +1,288 @@
+// Package roster caches the users each location's portal may sign in.
+package roster
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// Channel is the Postgres notification channel roster triggers send on.
+const Channel = "roster_changed"
+
+// retryInterval is how long to wait before listening again after the
+// notification connection fails.
+const retryInterval = 5 * time.Second
+
+// reconcileInterval is how often every roster version is reconciled while no
+// notification connection is open, since changes then go unannounced.
+const reconcileInterval = time.Minute
+
+// Cache keeps each location's roster in memory. Entries are dropped when
+// Postgres reports a change to the location's groups, their members, or a
+// user's details. While no notification connection is open, reads go
+// straight to the database.
+//
+// Reads never write. Roster versions are reconciled in the background for
+// the locations each notification affects, for every location when
+// listening starts, and every reconcileInterval while it is down.
+type Cache struct {
+	store  *store.Store
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]store.Roster
+	// listening is true while notifications are being received.
+	listening bool
+	// generation changes on every invalidation so a load that raced one is
+	// not cached.
+	generation uint64
+	// pending is what the next reconcile covers.
+	pending pendingRosters
+	wake    chan struct{}
+}
+
+// pendingRosters names the rosters whose versions are out of date.
+type pendingRosters struct {
+	all       bool
+	locations map[uuid.UUID]bool
+	groups    map[uuid.UUID]bool
+}
+
+// NewCache returns an empty cache that stays bypassed until Run is
+// listening.
+func NewCache(db *store.Store, logger *slog.Logger) *Cache {
+	return &Cache{
+		store:   db,
+		logger:  logger,
+		entries: make(map[uuid.UUID]store.Roster),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Get returns the location's roster: the active members of its groups, in
+// roster order, and its version. Users is shared between callers and must
+// not be modified.
+func (c *Cache) Get(ctx context.Context, locationID uuid.UUID) (store.Roster, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[locationID]
+	listening, generation := c.listening, c.generation
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	roster, err := c.store.Roster(ctx, locationID)
+	if err != nil {
+		return store.Roster{}, err
+	}
+	if listening {
+		c.mu.Lock()
+		if c.listening && c.generation == generation {
+			c.entries[locationID] = roster
+		}
+		c.mu.Unlock()
+	}
+	return roster, nil
+}
+
+// Users returns the location's roster users; see Get.
+func (c *Cache) Users(ctx context.Context, locationID uuid.UUID) ([]sqlc.User, error) {
+	roster, err := c.Get(ctx, locationID)
+	return roster.Users, err
+}
+
+// Run listens for roster changes until ctx is done, reconnecting after
+// failures, and reconciles roster versions as they change. The cache is
+// emptied and bypassed whenever it is not listening.
+func (c *Cache) Run(ctx context.Context) {
+	go c.reconcileLoop(ctx)
+	for {
+		err := c.store.Listen(ctx, Channel, c.start, c.invalidate)
+		c.stop()
+		if ctx.Err() != nil {
+			return
+		}
+		c.logger.WarnContext(ctx, "roster notifications lost", "err", err, "retry_in", retryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (c *Cache) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listening = true
+	c.generation++
+	clear(c.entries)
+	// Changes made while not listening were never announced.
+	c.pending.all = true
+	c.signal()
+}
+
+func (c *Cache) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listening = false
+	c.generation++
+	clear(c.entries)
+}
+
+// invalidate drops the entries a roster_changed payload affects and queues
+// their versions for reconciling. Anything unrecognised empties the cache.
+func (c *Cache) invalidate(payload string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	kind, raw, _ := strings.Cut(payload, ":")
+	id, err := uuid.Parse(raw)
+	switch {
+	case err == nil && kind == "location":
+		delete(c.entries, id)
+		c.pending.addLocation(id)
+	case err == nil && kind == "group":
+		for locationID, cached := range c.entries {
+			if slices.Contains(cached.GroupIDs, id) {
+				delete(c.entries, locationID)
+			}
+		}
+		c.pending.addGroup(id)
+	default:
+		clear(c.entries)
+		c.pending.all = true
+	}
+	c.signal()
+}
+
+// signal wakes the reconcile loop. c.mu must be held.
+func (c *Cache) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Cache) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		case <-ticker.C:
+			c.mu.Lock()
+			if !c.listening {
+				c.pending.all = true
+			}
+			c.mu.Unlock()
+		}
+		c.reconcile(ctx)
+	}
+}
+
+// reconcile brings the pending rosters' versions up to date, dropping the
+// cached entry of any whose version moved.
+func (c *Cache) reconcile(ctx context.Context) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = pendingRosters{}
+	c.mu.Unlock()
+
+	locationIDs, err := c.resolve(ctx, pending)
+	if err != nil {
+		c.logger.WarnContext(ctx, "resolve roster changes", "err", err)
+		c.requeue(pending)
+		return
+	}
+	for _, id := range locationIDs {
+		_, changed, err := c.store.ReconcileRoster(ctx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			c.logger.WarnContext(ctx, "reconcile roster", "err", err, "location", id)
+			c.requeue(pendingRosters{locations: map[uuid.UUID]bool{id: true}})
+			continue
+		}
+		if changed {
+			c.mu.Lock()
+			c.generation++
+			delete(c.entries, id)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// resolve lists the locations pending covers.
+func (c *Cache) resolve(ctx context.Context, pending pendingRosters) ([]uuid.UUID, error) {
+	if pending.all {
+		locs, err := c.store.ListLocations(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]uuid.UUID, 0, len(locs))
+		for _, loc := range locs {
+			ids = append(ids, loc.ID)
+		}
+		return ids, nil
+	}
+	seen := make(map[uuid.UUID]bool, len(pending.locations))
+	var ids []uuid.UUID
+	for id := range pending.locations {
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	for groupID := range pending.groups {
+		locs, err := c.store.ListLocationsForGroup(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range locs {
+			if !seen[loc.ID] {
+				seen[loc.ID] = true
+				ids = append(ids, loc.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// requeue puts rosters that failed to reconcile back for the next pass.
+func (c *Cache) requeue(pending pendingRosters) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending.all = c.pending.all || pending.all
+	for id := range pending.locations {
+		c.pending.addLocation(id)
+	}
+	for id := range pending.groups {
+		c.pending.addGroup(id)
+	}
+}
+
+func (p *pendingRosters) addLocation(id uuid.UUID) {
+	if p.locations == nil {
+		p.locations = make(map[uuid.UUID]bool)
+	}
+	p.locations[id] = true
+}
+
+func (p *pendingRosters) addGroup(id uuid.UUID) {
+	if p.groups == nil {
+		p.groups = make(map[uuid.UUID]bool)
+	}
+	p.groups[id] = true
+}
@@ -0,0 +1,174 @@
+package schedule
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCalendar means an iCalendar file could not be parsed.
+var ErrInvalidCalendar = errors.New("schedule: invalid calendar")
+
+// maxCalendarEvents bounds how many events one import may hold.
+const maxCalendarEvents = 5000
+
+// CalendarClosure is a closure read from an iCalendar VEVENT. UID identifies
+// the event so re-imports replace rather than duplicate it.
+type CalendarClosure struct {
+	UID string
+	Closure
+}
+
+// ParseICal reads the events of an iCalendar (RFC 5545) file as closures,
+// such as a school term calendar. Each event closes every day it touches.
+// Cancelled events are skipped; recurrence rules are not expanded, so
+// recurring events only close their first occurrence.
+func ParseICal(r io.Reader) ([]CalendarClosure, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var closures []CalendarClosure
+	var event map[string]property
+	for _, line := range lines {
+		prop := parseProperty(line)
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			event = map[string]property{}
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if event == nil {
+				return nil, fmt.Errorf("%w: END:VEVENT without BEGIN", ErrInvalidCalendar)
+			}
+			closure, ok, eventErr := eventClosure(event)
+			if eventErr != nil {
+				return nil, eventErr
+			}
+			if ok {
+				closures = append(closures, closure)
+			}
+			if len(closures) > maxCalendarEvents {
+				return nil, fmt.Errorf("%w: more than %d events", ErrInvalidCalendar, maxCalendarEvents)
+			}
+			event = nil
+		case event != nil:
+			if _, seen := event[prop.name]; !seen {
+				event[prop.name] = prop
+			}
+		}
+	}
+	return closures, nil
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfold joins continuation lines, which start with a space or tab.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCalendar, err)
+	}
+	return lines, nil
+}
+
+// parseProperty splits NAME;PARAM=VALUE:value.
+func parseProperty(line string) property {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	prop := property{name: strings.ToUpper(parts[0]), value: value, params: map[string]string{}}
+	for _, param := range parts[1:] {
+		if key, paramValue, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(paramValue, `"`)
+		}
+	}
+	return prop
+}
+
+func eventClosure(event map[string]property) (CalendarClosure, bool, error) {
+	if status, ok := event["STATUS"]; ok && strings.EqualFold(status.value, "CANCELLED") {
+		return CalendarClosure{}, false, nil
+	}
+	start, ok := event["DTSTART"]
+	if !ok {
+		return CalendarClosure{}, false, fmt.Errorf("%w: event without DTSTART", ErrInvalidCalendar)
+	}
+	startDate, _, err := parseCalendarTime(start)
+	if err != nil {
+		return CalendarClosure{}, false, err
+	}
+	endDate := startDate
+	if end, hasEnd := event["DTEND"]; hasEnd {
+		var endIsDate bool
+		if endDate, endIsDate, err = parseCalendarTime(end); err != nil {
+			return CalendarClosure{}, false, err
+		}
+		// DTEND is exclusive: all-day events end the day before, and timed
+		// events ending at midnight do not touch the next day.
+		if endIsDate || (endDate.Hour() == 0 && endDate.Minute() == 0 && endDate.After(startDate)) {
+			endDate = endDate.AddDate(0, 0, -1)
+		}
+	}
+	if endDate.Before(startDate) {
+		endDate = startDate
+	}
+	return CalendarClosure{
+		UID: event["UID"].value,
+		Closure: Closure{
+			Start:  dateOf(startDate),
+			End:    dateOf(endDate),
+			Reason: unescapeText(event["SUMMARY"].value),
+		},
+	}, true, nil
+}
+
+// parseCalendarTime reads a DATE or DATE-TIME value, reporting whether it was
+// a plain date. Times keep their TZID zone when it is known.
+func parseCalendarTime(prop property) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: bad date %q", ErrInvalidCalendar, value)
+		}
+		return t, true, nil
+	}
+	loc := time.UTC
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if zone, err := time.LoadLocation(tzid); err == nil {
+			loc = zone
+		}
+	}
+	layout := "20060102T150405"
+	if strings.HasSuffix(value, "Z") {
+		layout, loc = layout+"Z", time.UTC
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: bad date-time %q", ErrInvalidCalendar, value)
+	}
+	return t, false, nil
+}
+
+// unescapeText undoes RFC 5545 TEXT escaping.
+func unescapeText(value string) string {
+	replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return strings.TrimSpace(replacer.Replace(value))
+}
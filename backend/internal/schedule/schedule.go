@@ -0,0 +1,217 @@
+// Package schedule works out whether a location is open from its weekly
+// opening hours and closure calendar.
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Out-of-hours policies decide what happens to check-ins while a location is
+// closed.
+const (
+	// PolicyAllow records check-ins as usual.
+	PolicyAllow = "allow"
+	// PolicyFlag records check-ins but marks them as out of hours.
+	PolicyFlag = "flag"
+	// PolicyReject refuses check-ins; check-outs are still recorded, flagged,
+	// so nobody is left signed in.
+	PolicyReject = "reject"
+)
+
+// ErrInvalidHours means opening hours could not be parsed.
+var ErrInvalidHours = errors.New("schedule: invalid opening hours")
+
+// clockLayout is the format of opening and closing times.
+const clockLayout = "15:04"
+
+// lookahead bounds the search for the next opening.
+const lookahead = 366
+
+// weekdays maps the JSON day keys to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Interval is one opening period within a day, as "15:04" times. Close must
+// be after Open; "24:00" closes at midnight.
+type Interval struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// Hours lists opening intervals by day key ("mon" to "sun"). Days without an
+// entry are closed.
+type Hours map[string][]Interval
+
+// ParseHours decodes and validates opening hours.
+func ParseHours(raw []byte) (Hours, error) {
+	var hours Hours
+	if err := json.Unmarshal(raw, &hours); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHours, err)
+	}
+	return hours, hours.Validate()
+}
+
+// Validate checks day keys and that each interval closes after it opens.
+func (h Hours) Validate() error {
+	for day, intervals := range h {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("%w: unknown day %q", ErrInvalidHours, day)
+		}
+		for _, interval := range intervals {
+			open, err := parseClock(interval.Open)
+			if err != nil {
+				return err
+			}
+			closing, err := parseClock(interval.Close)
+			if err != nil {
+				return err
+			}
+			if closing <= open {
+				return fmt.Errorf("%w: %s %s-%s closes before it opens", ErrInvalidHours, day,
+					interval.Open, interval.Close)
+			}
+		}
+	}
+	return nil
+}
+
+// Closure is a closed period covering whole days, from Start to End
+// inclusive, as calendar dates.
+type Closure struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+// Schedule is a location's resolved schedule. Nil Hours means open around the
+// clock apart from closures.
+type Schedule struct {
+	Location *time.Location
+	Hours    Hours
+	Closures []Closure
+}
+
+// Status is whether a location is open at a given time.
+type Status struct {
+	Open bool
+	// Reason explains a closure, when one applies.
+	Reason string
+	// NextOpen is when a closed location next opens, if within a year.
+	NextOpen *time.Time
+}
+
+// StatusAt reports whether the location is open at t.
+func (s Schedule) StatusAt(t time.Time) Status {
+	local := t.In(s.location())
+	if closure, closed := s.closure(local); closed {
+		return Status{Reason: closure.Reason, NextOpen: s.nextOpen(local)}
+	}
+	if s.openAt(local) {
+		return Status{Open: true}
+	}
+	return Status{NextOpen: s.nextOpen(local)}
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func (s Schedule) closure(local time.Time) (Closure, bool) {
+	day := dateOf(local)
+	for _, c := range s.Closures {
+		if !day.Before(dateOf(c.Start)) && !day.After(dateOf(c.End)) {
+			return c, true
+		}
+	}
+	return Closure{}, false
+}
+
+func (s Schedule) openAt(local time.Time) bool {
+	if s.Hours == nil {
+		return true
+	}
+	minute := local.Hour()*60 + local.Minute()
+	for _, interval := range s.intervals(local.Weekday()) {
+		open, _ := parseClock(interval.Open)
+		closing, _ := parseClock(interval.Close)
+		if minute >= open && minute < closing {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen finds the next opening after local, skipping closed days.
+func (s Schedule) nextOpen(local time.Time) *time.Time {
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	for offset := range lookahead {
+		day := midnight.AddDate(0, 0, offset)
+		if _, closed := s.closure(day); closed {
+			continue
+		}
+		if s.Hours == nil {
+			// Open all day: the first day without a closure.
+			return &day
+		}
+		for _, open := range s.openings(day.Weekday()) {
+			at := time.Date(day.Year(), day.Month(), day.Day(), open/60, open%60, 0, 0, day.Location())
+			if at.After(local) {
+				return &at
+			}
+		}
+	}
+	return nil
+}
+
+func (s Schedule) intervals(day time.Weekday) []Interval {
+	var intervals []Interval
+	for key, dayIntervals := range s.Hours {
+		if weekdays[strings.ToLower(key)] == day {
+			intervals = append(intervals, dayIntervals...)
+		}
+	}
+	return intervals
+}
+
+// openings returns a day's opening minutes in order.
+func (s Schedule) openings(day time.Weekday) []int {
+	var openings []int
+	for _, interval := range s.intervals(day) {
+		open, _ := parseClock(interval.Open)
+		openings = append(openings, open)
+	}
+	slices.Sort(openings)
+	return openings
+}
+
+// parseClock converts "15:04" to minutes after midnight, allowing "24:00".
+func parseClock(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse(clockLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad time %q", ErrInvalidHours, value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// dateOf strips the time of day, keeping the calendar date.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,237 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// Entry is a setting's definition with its current stored state.
+type Entry struct {
+	Definition
+	Value int64
+	// Stored is false when the setting is on its default.
+	Stored    bool
+	Version   int64
+	UpdatedBy uuid.UUID
+	UpdatedAt time.Time
+}
+
+// Service serves the current settings and writes changes through to the
+// store. Reads never touch the database.
+type Service struct {
+	store   *store.Store
+	logger  *slog.Logger
+	current atomic.Pointer[Values]
+	// mu serialises reloads so an older load never replaces a newer one.
+	mu      sync.Mutex
+	version int64
+}
+
+// NewService builds a service that reports defaults until Reload runs.
+func NewService(store *store.Store, logger *slog.Logger) *Service {
+	s := &Service{store: store, logger: logger}
+	defaults := Defaults()
+	s.current.Store(&defaults)
+	return s
+}
+
+// Current returns the latest loaded settings.
+func (s *Service) Current() Values {
+	return *s.current.Load()
+}
+
+// SessionTTL returns the current admin session lifetime.
+func (s *Service) SessionTTL() time.Duration {
+	return s.Current().SessionTTL
+}
+
+// SyncTimeout returns the current sync run timeout.
+func (s *Service) SyncTimeout() time.Duration {
+	return s.Current().SyncTimeout
+}
+
+// RetentionBatchSize returns the current retention batch size.
+func (s *Service) RetentionBatchSize() int32 {
+	return s.Current().RetentionBatchSize
+}
+
+// Reload reads every stored setting. Stored values that no longer pass
+// validation, such as after bounds tighten, fall back to the default.
+func (s *Service) Reload(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows, err := s.store.ListSettings(ctx)
+	if err != nil {
+		return err
+	}
+	values := Defaults()
+	var version int64
+	for _, row := range rows {
+		version = max(version, row.Version)
+		entry, ok := s.entry(row)
+		if ok && entry.Stored {
+			entry.apply(&values, entry.Value)
+		}
+	}
+	s.current.Store(&values)
+	s.version = version
+	return nil
+}
+
+// Watch reloads whenever another replica changes a setting, checking every
+// interval until ctx is done.
+func (s *Service) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		version, err := s.store.SettingsVersion(ctx)
+		if err != nil {
+			s.logger.WarnContext(ctx, "check settings version", "err", err)
+			continue
+		}
+		s.mu.Lock()
+		changed := version != s.version
+		s.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err = s.Reload(ctx); err != nil {
+			s.logger.WarnContext(ctx, "reload settings", "err", err)
+			continue
+		}
+		s.logger.InfoContext(ctx, "settings reloaded", "version", version)
+	}
+}
+
+// List returns every setting with its stored state.
+func (s *Service) List(ctx context.Context) ([]Entry, error) {
+	rows, err := s.store.ListSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]sqlc.Setting, len(rows))
+	for _, row := range rows {
+		stored[row.Key] = row
+	}
+	entries := make([]Entry, 0, len(definitions))
+	for _, def := range definitions {
+		row, ok := stored[def.Key]
+		if !ok {
+			entries = append(entries, Entry{Definition: def, Value: def.Default})
+			continue
+		}
+		entry, _ := s.entry(row)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Get returns one setting with its stored state.
+func (s *Service) Get(ctx context.Context, key string) (Entry, error) {
+	if _, err := Lookup(key); err != nil {
+		return Entry{}, err
+	}
+	entries, err := s.List(ctx)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrUnknownSetting
+}
+
+// Set validates and stores a setting, then reloads so this replica uses it
+// straight away. A non-nil expected version must match the stored one, or
+// store.ErrSettingConflict is returned.
+func (s *Service) Set(
+	ctx context.Context,
+	key string,
+	raw json.RawMessage,
+	updatedBy uuid.UUID,
+	expected *int64,
+) (Entry, error) {
+	def, err := Lookup(key)
+	if err != nil {
+		return Entry{}, err
+	}
+	n, err := def.Parse(raw)
+	if err != nil {
+		return Entry{}, err
+	}
+	value, err := json.Marshal(def.Format(n))
+	if err != nil {
+		return Entry{}, err
+	}
+	return s.save(ctx, def, value, updatedBy, expected)
+}
+
+// Reset puts a setting back on its default.
+func (s *Service) Reset(ctx context.Context, key string, updatedBy uuid.UUID, expected *int64) (Entry, error) {
+	def, err := Lookup(key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return s.save(ctx, def, nil, updatedBy, expected)
+}
+
+func (s *Service) save(
+	ctx context.Context,
+	def Definition,
+	value []byte,
+	updatedBy uuid.UUID,
+	expected *int64,
+) (Entry, error) {
+	row, err := s.store.SaveSetting(ctx, def.Key, value, updatedBy, expected)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err = s.Reload(ctx); err != nil {
+		s.logger.WarnContext(ctx, "reload settings", "err", err)
+	}
+	entry, _ := s.entry(row)
+	return entry, nil
+}
+
+// entry reads a stored row, reporting false when its key is unknown. An
+// invalid value is logged and reported as the default.
+func (s *Service) entry(row sqlc.Setting) (Entry, bool) {
+	def, err := Lookup(row.Key)
+	if err != nil {
+		return Entry{}, false
+	}
+	entry := Entry{
+		Definition: def,
+		Value:      def.Default,
+		Version:    row.Version,
+		UpdatedAt:  row.UpdatedAt.Time,
+	}
+	if row.UpdatedBy.Valid {
+		entry.UpdatedBy = row.UpdatedBy.Bytes
+	}
+	if row.Value == nil {
+		return entry, true
+	}
+	n, err := def.Parse(row.Value)
+	if err != nil {
+		s.logger.Warn("ignoring stored setting", "key", row.Key, "err", err)
+		return entry, true
+	}
+	entry.Value, entry.Stored = n, true
+	return entry, true
+}
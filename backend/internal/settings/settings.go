@@ -0,0 +1,232 @@
+// Package settings holds runtime policy that admins can change without a
+// redeploy. Each setting has a typed definition with a default and bounds;
+// stored overrides live in Postgres and are reloaded while the server runs.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUnknownSetting means no setting has the requested key.
+	ErrUnknownSetting = errors.New("settings: unknown setting")
+	// ErrInvalidValue means a value has the wrong type or is out of bounds.
+	ErrInvalidValue = errors.New("settings: invalid value")
+)
+
+// Kind is how a setting's value is written in JSON.
+type Kind string
+
+const (
+	// KindDuration values are Go duration strings such as "15m".
+	KindDuration Kind = "duration"
+	// KindInteger values are JSON integers.
+	KindInteger Kind = "integer"
+)
+
+// Setting keys.
+const (
+	KeySessionTTL     = "auth.session_ttl"
+	KeyMaxUploadBytes = "uploads.max_image_bytes"
+	KeySyncTimeout    = "sync.timeout"
+	KeyRequestTimeout = "http.request_timeout"
+	KeyRetentionBatch = "retention.batch_size"
+	KeyPartitionAhead = "partitions.months_ahead"
+	KeyArchiveAfter   = "partitions.archive_after_months"
+	KeyClockSkew      = "portal.clock_skew"
+	KeyReplayWindow   = "portal.replay_window"
+	KeyDebounce       = "checkins.debounce"
+)
+
+// Values is a snapshot of every setting.
+type Values struct {
+	// SessionTTL is how long an admin session lasts.
+	SessionTTL time.Duration
+	// MaxUploadBytes bounds uploaded branding images.
+	MaxUploadBytes int64
+	// SyncTimeout bounds each directory or rule-group sync run.
+	SyncTimeout time.Duration
+	// RequestTimeout bounds each HTTP request.
+	RequestTimeout time.Duration
+	// RetentionBatchSize is how many checkins each retention statement
+	// changes.
+	RetentionBatchSize int32
+	// PartitionMonthsAhead is how many future months of checkin partitions
+	// are kept ready.
+	PartitionMonthsAhead int
+	// ArchiveAfterMonths is how many whole months of checkins stay attached
+	// before their partitions are archived; 0 never archives.
+	ArchiveAfterMonths int
+	// ClockSkew is how far ahead of the server a kiosk's occurredAt may be.
+	ClockSkew time.Duration
+	// ReplayWindow is how old a queued kiosk checkin may be when it arrives.
+	ReplayWindow time.Duration
+	// Debounce is how soon a repeated checkin is dropped as a double tap.
+	Debounce time.Duration
+}
+
+// Definition describes one setting. Default, Min and Max are in the
+// setting's base unit: nanoseconds for durations.
+type Definition struct {
+	Key         string
+	Kind        Kind
+	Description string
+	Default     int64
+	Min         int64
+	Max         int64
+	apply       func(*Values, int64)
+}
+
+var definitions = []Definition{
+	{
+		Key:         KeySessionTTL,
+		Kind:        KindDuration,
+		Description: "How long an admin stays signed in. Applies to new sign-ins.",
+		Default:     int64(8 * time.Hour),
+		Min:         int64(5 * time.Minute),
+		Max:         int64(30 * 24 * time.Hour),
+		apply:       func(v *Values, n int64) { v.SessionTTL = time.Duration(n) },
+	},
+	{
+		Key:         KeyMaxUploadBytes,
+		Kind:        KindInteger,
+		Description: "Largest branding image upload, in bytes.",
+		Default:     8 << 20,
+		Min:         256 << 10,
+		Max:         32 << 20,
+		apply:       func(v *Values, n int64) { v.MaxUploadBytes = n },
+	},
+	{
+		Key:         KeySyncTimeout,
+		Kind:        KindDuration,
+		Description: "How long a directory sync may run before it is cancelled.",
+		Default:     int64(15 * time.Minute),
+		Min:         int64(time.Minute),
+		Max:         int64(6 * time.Hour),
+		apply:       func(v *Values, n int64) { v.SyncTimeout = time.Duration(n) },
+	},
+	{
+		Key:         KeyRequestTimeout,
+		Kind:        KindDuration,
+		Description: "How long an HTTP request may run before it is cancelled.",
+		Default:     int64(60 * time.Second),
+		Min:         int64(5 * time.Second),
+		Max:         int64(10 * time.Minute),
+		apply:       func(v *Values, n int64) { v.RequestTimeout = time.Duration(n) },
+	},
+	{
+		Key:         KeyRetentionBatch,
+		Kind:        KindInteger,
+		Description: "How many checkins the retention purge changes per statement.",
+		Default:     1000,
+		Min:         100,
+		Max:         50000,
+		apply:       func(v *Values, n int64) { v.RetentionBatchSize = int32(n) },
+	},
+	{
+		Key:         KeyPartitionAhead,
+		Kind:        KindInteger,
+		Description: "How many months of checkin partitions to create ahead of time.",
+		Default:     3,
+		Min:         1,
+		Max:         24,
+		apply:       func(v *Values, n int64) { v.PartitionMonthsAhead = int(n) },
+	},
+	{
+		Key:         KeyArchiveAfter,
+		Kind:        KindInteger,
+		Description: "Months of checkins kept queryable before older partitions are archived. 0 never archives.",
+		Default:     0,
+		Min:         0,
+		Max:         240,
+		apply:       func(v *Values, n int64) { v.ArchiveAfterMonths = int(n) },
+	},
+	{
+		Key:         KeyClockSkew,
+		Kind:        KindDuration,
+		Description: "How far ahead of the server a kiosk's clock may run. Later checkin times are refused.",
+		Default:     int64(2 * time.Minute),
+		Min:         0,
+		Max:         int64(time.Hour),
+		apply:       func(v *Values, n int64) { v.ClockSkew = time.Duration(n) },
+	},
+	{
+		Key:         KeyReplayWindow,
+		Kind:        KindDuration,
+		Description: "How old a checkin queued by an offline kiosk may be when it is sent. Older ones are refused.",
+		Default:     int64(72 * time.Hour),
+		Min:         int64(5 * time.Minute),
+		Max:         int64(7 * 24 * time.Hour),
+		apply:       func(v *Values, n int64) { v.ReplayWindow = time.Duration(n) },
+	},
+	{
+		Key:         KeyDebounce,
+		Kind:        KindDuration,
+		Description: "Repeat checkins by the same user at a location within this long are ignored. 0s turns this off.",
+		Default:     int64(10 * time.Second),
+		Min:         0,
+		Max:         int64(10 * time.Minute),
+		apply:       func(v *Values, n int64) { v.Debounce = time.Duration(n) },
+	},
+}
+
+// Definitions returns every setting.
+func Definitions() []Definition {
+	return append([]Definition(nil), definitions...)
+}
+
+// Lookup finds a setting's definition.
+func Lookup(key string) (Definition, error) {
+	for _, def := range definitions {
+		if def.Key == key {
+			return def, nil
+		}
+	}
+	return Definition{}, fmt.Errorf("%w: %q", ErrUnknownSetting, key)
+}
+
+// Defaults returns every setting at its default.
+func Defaults() Values {
+	var values Values
+	for _, def := range definitions {
+		def.apply(&values, def.Default)
+	}
+	return values
+}
+
+// Parse reads and checks a JSON value for the setting.
+func (d Definition) Parse(raw json.RawMessage) (int64, error) {
+	var n int64
+	switch d.Kind {
+	case KindDuration:
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return 0, fmt.Errorf("%w: %s must be a duration string such as \"15m\"", ErrInvalidValue, d.Key)
+		}
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s: %q is not a duration", ErrInvalidValue, d.Key, text)
+		}
+		n = int64(duration)
+	case KindInteger:
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return 0, fmt.Errorf("%w: %s must be an integer", ErrInvalidValue, d.Key)
+		}
+	}
+	if n < d.Min || n > d.Max {
+		return 0, fmt.Errorf("%w: %s must be between %v and %v",
+			ErrInvalidValue, d.Key, d.Format(d.Min), d.Format(d.Max))
+	}
+	return n, nil
+}
+
+// Format returns a value as it is written in JSON.
+func (d Definition) Format(n int64) any {
+	if d.Kind == KindDuration {
+		return time.Duration(n).String()
+	}
+	return n
+}
@@ -0,0 +1,366 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+	"github.com/woodleighschool/signin-ui/internal/store/storetest"
+)
+
+// checkinFixture is a user and a portal key for their own locations, so
+// tests sharing the database never see each other's checkins.
+type checkinFixture struct {
+	db   *store.Store
+	user uuid.UUID
+	key  uuid.UUID
+	now  time.Time
+}
+
+func newCheckinFixture(t *testing.T) *checkinFixture {
+	t.Helper()
+	db := storetest.Open(t)
+	ctx := context.Background()
+	user, err := db.UpsertUser(ctx, sqlc.UpsertUserParams{
+		ID:          uuid.New(),
+		Upn:         uuid.NewString() + "@checkins.example",
+		DisplayName: "Checkin Test",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	key, err := db.CreateKey(ctx, sqlc.CreateKeyParams{
+		ID:          uuid.New(),
+		KeyValue:    store.GenerateKeyValue(),
+		LocationIds: []uuid.UUID{},
+	})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return &checkinFixture{db: db, user: user.ID, key: key.ID, now: time.Now().Truncate(time.Second)}
+}
+
+// location creates a location, below parent unless it is uuid.Nil.
+func (f *checkinFixture) location(t *testing.T, parent uuid.UUID) uuid.UUID {
+	t.Helper()
+	loc, err := f.db.CreateLocation(context.Background(), sqlc.CreateLocationParams{
+		ID:       uuid.New(),
+		Name:     "Checkin test",
+		Lower:    "checkin-test-" + uuid.NewString(),
+		GroupIds: []uuid.UUID{},
+		ParentID: pgtype.UUID{Bytes: parent, Valid: parent != uuid.Nil},
+	})
+	if err != nil {
+		t.Fatalf("create location: %v", err)
+	}
+	return loc.ID
+}
+
+// request asks for direction at the location, ago before the fixture's now.
+func (f *checkinFixture) request(locationID uuid.UUID, direction string, ago time.Duration) store.CheckinRequest {
+	params := sqlc.CreateCheckinParams{
+		UserID:     f.user,
+		LocationID: locationID,
+		KeyID:      pgtype.UUID{Bytes: f.key, Valid: true},
+		Direction:  direction,
+		OccurredAt: pgtype.Timestamptz{Time: f.now.Add(-ago), Valid: true},
+	}
+	if direction == store.DirectionTransfer {
+		params.Direction = "in"
+	}
+	return store.CheckinRequest{Params: params, Requested: direction, DirectionPolicy: store.DirectionPolicyFlag}
+}
+
+// record records each direction a minute apart, ending an hour before now.
+func (f *checkinFixture) record(t *testing.T, locationID uuid.UUID, directions ...string) {
+	t.Helper()
+	for i, direction := range directions {
+		ago := time.Hour + time.Duration(len(directions)-i)*time.Minute
+		if _, err := f.db.RecordCheckin(context.Background(), f.request(locationID, direction, ago)); err != nil {
+			t.Fatalf("record %s: %v", direction, err)
+		}
+	}
+}
+
+func TestRecordCheckinDirectionPolicies(t *testing.T) {
+	tests := []struct {
+		name            string
+		history         []string
+		direction       string
+		policy          string
+		closedToIn      bool
+		wantErr         error
+		wantDirection   string
+		wantFlagged     bool
+		wantCounterpart string
+	}{
+		{name: "in while absent", direction: "in", policy: store.DirectionPolicyReject, wantDirection: "in"},
+		{name: "out while present", history: []string{"in"}, direction: "out",
+			policy: store.DirectionPolicyReject, wantDirection: "out"},
+		{name: "in while present, flag", history: []string{"in"}, direction: "in",
+			policy: store.DirectionPolicyFlag, wantDirection: "in", wantFlagged: true},
+		{name: "in while present, insert", history: []string{"in"}, direction: "in",
+			policy: store.DirectionPolicyInsert, wantDirection: "in", wantCounterpart: "out"},
+		{name: "in while present, reject", history: []string{"in"}, direction: "in",
+			policy: store.DirectionPolicyReject, wantErr: store.ErrContradictoryCheckin},
+		{name: "out while absent, flag", direction: "out",
+			policy: store.DirectionPolicyFlag, wantDirection: "out", wantFlagged: true},
+		{name: "out while absent, insert", history: []string{"in", "out"}, direction: "out",
+			policy: store.DirectionPolicyInsert, wantDirection: "out", wantCounterpart: "in"},
+		{name: "out while absent, reject", direction: "out",
+			policy: store.DirectionPolicyReject, wantErr: store.ErrContradictoryCheckin},
+		{name: "toggle while absent", history: []string{"in", "out"}, direction: store.DirectionToggle,
+			policy: store.DirectionPolicyReject, wantDirection: "in"},
+		{name: "toggle while present", history: []string{"in"}, direction: store.DirectionToggle,
+			policy: store.DirectionPolicyReject, wantDirection: "out"},
+		{name: "toggle to in while closed", direction: store.DirectionToggle, closedToIn: true,
+			policy: store.DirectionPolicyFlag, wantErr: store.ErrLocationClosed},
+		{name: "toggle to out while closed", history: []string{"in"}, direction: store.DirectionToggle,
+			closedToIn: true, policy: store.DirectionPolicyFlag, wantDirection: "out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckinFixture(t)
+			locationID := f.location(t, uuid.Nil)
+			f.record(t, locationID, tt.history...)
+
+			req := f.request(locationID, tt.direction, 0)
+			req.DirectionPolicy = tt.policy
+			req.ClosedToIn = tt.closedToIn
+			outcome, err := f.db.RecordCheckin(context.Background(), req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("record = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("record: %v", err)
+			}
+			got := outcome.Checkin
+			if !outcome.Recorded || got.Direction != tt.wantDirection || got.Contradictory != tt.wantFlagged {
+				t.Errorf("recorded %t %s (contradictory %t), want %s (contradictory %t)",
+					outcome.Recorded, got.Direction, got.Contradictory, tt.wantDirection, tt.wantFlagged)
+			}
+			switch {
+			case tt.wantCounterpart == "" && outcome.Counterpart != nil:
+				t.Errorf("inserted a %s counterpart, want none", outcome.Counterpart.Direction)
+			case tt.wantCounterpart != "" && outcome.Counterpart == nil:
+				t.Errorf("no counterpart, want an inserted %s", tt.wantCounterpart)
+			case outcome.Counterpart != nil:
+				inserted := outcome.Counterpart
+				if inserted.Direction != tt.wantCounterpart || !inserted.AutoInserted ||
+					!inserted.OccurredAt.Time.Before(got.OccurredAt.Time) {
+					t.Errorf("counterpart = %s at %s (auto %t), want an auto-inserted %s before %s",
+						inserted.Direction, inserted.OccurredAt.Time, inserted.AutoInserted,
+						tt.wantCounterpart, got.OccurredAt.Time)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectionPolicyInherits(t *testing.T) {
+	f := newCheckinFixture(t)
+	ctx := context.Background()
+	parent := f.location(t, uuid.Nil)
+	child := f.location(t, parent)
+
+	policy, err := f.db.DirectionPolicy(ctx, child)
+	if err != nil || policy != store.DirectionPolicyFlag {
+		t.Fatalf("default policy = %q, %v; want %q", policy, err, store.DirectionPolicyFlag)
+	}
+	_, err = f.db.SetLocationDirectionPolicy(ctx, sqlc.SetLocationDirectionPolicyParams{
+		ID:              parent,
+		DirectionPolicy: pgtype.Text{String: store.DirectionPolicyReject, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("set parent policy: %v", err)
+	}
+	if policy, err = f.db.DirectionPolicy(ctx, child); err != nil || policy != store.DirectionPolicyReject {
+		t.Errorf("inherited policy = %q, %v; want %q", policy, err, store.DirectionPolicyReject)
+	}
+}
+
+func TestRecordCheckinDebounce(t *testing.T) {
+	const debounce = 30 * time.Second
+	tests := []struct {
+		name          string
+		last          string
+		gap           time.Duration
+		direction     string
+		wantDebounced bool
+	}{
+		{name: "same direction within window", last: "in", gap: 10 * time.Second, direction: "in",
+			wantDebounced: true},
+		{name: "same direction at window edge", last: "in", gap: debounce, direction: "in", wantDebounced: true},
+		{name: "same direction after window", last: "in", gap: debounce + time.Second, direction: "in"},
+		{name: "opposite direction within window", last: "in", gap: 10 * time.Second, direction: "out"},
+		{name: "toggle within window", last: "in", gap: 10 * time.Second, direction: store.DirectionToggle,
+			wantDebounced: true},
+		{name: "toggle after window", last: "in", gap: time.Minute, direction: store.DirectionToggle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckinFixture(t)
+			ctx := context.Background()
+			locationID := f.location(t, uuid.Nil)
+			first, err := f.db.RecordCheckin(ctx, f.request(locationID, tt.last, tt.gap))
+			if err != nil {
+				t.Fatalf("record first: %v", err)
+			}
+
+			req := f.request(locationID, tt.direction, 0)
+			req.Debounce = debounce
+			outcome, err := f.db.RecordCheckin(ctx, req)
+			if err != nil {
+				t.Fatalf("record second: %v", err)
+			}
+			if outcome.Debounced != tt.wantDebounced || outcome.Recorded == tt.wantDebounced {
+				t.Fatalf("debounced %t, recorded %t; want debounced %t",
+					outcome.Debounced, outcome.Recorded, tt.wantDebounced)
+			}
+			if tt.wantDebounced && outcome.Checkin.ID != first.Checkin.ID {
+				t.Errorf("debounced outcome holds checkin %d, want the earlier %d",
+					outcome.Checkin.ID, first.Checkin.ID)
+			}
+		})
+	}
+}
+
+func TestRecordCheckinReplay(t *testing.T) {
+	tests := []struct {
+		name          string
+		first         string
+		replay        string
+		otherUser     bool
+		wantErr       error
+		wantDirection string
+	}{
+		{name: "in replayed", first: "in", replay: "in", wantDirection: "in"},
+		{name: "toggle replayed is not toggled back", first: store.DirectionToggle,
+			replay: store.DirectionToggle, wantDirection: "in"},
+		{name: "key reused for another direction", first: "in", replay: "out",
+			wantErr: store.ErrIdempotencyConflict},
+		{name: "key reused for another user", first: "in", replay: "in", otherUser: true,
+			wantErr: store.ErrIdempotencyConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckinFixture(t)
+			ctx := context.Background()
+			locationID := f.location(t, uuid.Nil)
+			idempotencyKey := uuid.New()
+
+			req := f.request(locationID, tt.first, time.Minute)
+			req.IdempotencyKey = idempotencyKey
+			first, err := f.db.RecordCheckin(ctx, req)
+			if err != nil || first.Duplicate {
+				t.Fatalf("record first = duplicate %t, %v", first.Duplicate, err)
+			}
+
+			// A kiosk replaying its queue sends the same key later.
+			replay := f.request(locationID, tt.replay, 0)
+			replay.IdempotencyKey = idempotencyKey
+			if tt.otherUser {
+				other := newCheckinFixture(t)
+				replay.Params.UserID = other.user
+			}
+			outcome, err := f.db.RecordCheckin(ctx, replay)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("replay = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if !outcome.Duplicate || outcome.Checkin.ID != first.Checkin.ID ||
+				outcome.Checkin.Direction != tt.wantDirection {
+				t.Errorf("replay = duplicate %t, checkin %d %s; want duplicate of %d %s",
+					outcome.Duplicate, outcome.Checkin.ID, outcome.Checkin.Direction,
+					first.Checkin.ID, tt.wantDirection)
+			}
+			// Only the first attempt was recorded, so the user is still in.
+			next, err := f.db.RecordCheckin(ctx, f.request(locationID, store.DirectionToggle, 0))
+			if err != nil || next.Checkin.Direction != "out" {
+				t.Errorf("toggle after replay = %s, %v; want out", next.Checkin.Direction, err)
+			}
+		})
+	}
+}
+
+func TestRecordTransfer(t *testing.T) {
+	tests := []struct {
+		name       string
+		openIn     int
+		denyOpen   bool
+		wantClosed int
+		wantErr    error
+	}{
+		{name: "nothing to close"},
+		{name: "closes one location", openIn: 1, wantClosed: 1},
+		{name: "closes several locations", openIn: 2, wantClosed: 2},
+		{name: "deny location refuses", openIn: 2, denyOpen: true, wantErr: store.ErrTransferDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckinFixture(t)
+			ctx := context.Background()
+			target := f.location(t, uuid.Nil)
+			var open []uuid.UUID
+			for range tt.openIn {
+				locationID := f.location(t, uuid.Nil)
+				f.record(t, locationID, "in")
+				open = append(open, locationID)
+			}
+			if tt.denyOpen {
+				_, err := f.db.SetLocationTransferPolicy(ctx, sqlc.SetLocationTransferPolicyParams{
+					ID:             open[len(open)-1],
+					TransferPolicy: pgtype.Text{String: store.TransferPolicyDeny, Valid: true},
+				})
+				if err != nil {
+					t.Fatalf("set transfer policy: %v", err)
+				}
+			}
+
+			outcome, err := f.db.RecordCheckin(ctx, f.request(target, store.DirectionTransfer, 0))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("transfer = %v, want %v", err, tt.wantErr)
+				}
+				// The whole transfer rolled back: the user was never checked in.
+				next, err := f.db.RecordCheckin(ctx, f.request(target, store.DirectionToggle, 0))
+				if err != nil || next.Checkin.Direction != "in" {
+					t.Errorf("toggle after refused transfer = %s, %v; want in", next.Checkin.Direction, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transfer: %v", err)
+			}
+			in := outcome.Checkin
+			if !outcome.Recorded || in.Direction != "in" || in.LocationID != target {
+				t.Fatalf("transfer recorded %t %s at %s, want in at %s", outcome.Recorded, in.Direction,
+					in.LocationID, target)
+			}
+			if len(outcome.Closed) != tt.wantClosed || in.TransferID.Valid != (tt.wantClosed > 0) {
+				t.Fatalf("closed %d locations (transfer id %t), want %d", len(outcome.Closed),
+					in.TransferID.Valid, tt.wantClosed)
+			}
+			for _, out := range outcome.Closed {
+				if out.Direction != "out" || out.TransferID != in.TransferID ||
+					!out.OccurredAt.Time.Before(in.OccurredAt.Time) {
+					t.Errorf("closing checkin = %s at %s (transfer %v), want out before %s in transfer %v",
+						out.Direction, out.OccurredAt.Time, out.TransferID, in.OccurredAt.Time, in.TransferID)
+				}
+			}
+		})
+	}
+}
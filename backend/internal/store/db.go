@@ -2,10 +2,8 @@ package store
 
 import (
 	"context"
-	"embed"
 	"errors"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -13,9 +11,6 @@ import (
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
-//go:embed migrate/*.sql
-var migrations embed.FS
-
 // ErrNilPool signals use after the pool was closed.
 var ErrNilPool = errors.New("store: nil pool")
 
@@ -94,29 +89,66 @@ func (s *Store) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	return nil
 }
 
-// Queries returns the raw sqlc handle.
-func (s *Store) Queries() *sqlc.Queries {
-	return s.queries
+// TryAdvisoryLock takes a session-level Postgres advisory lock on a dedicated
+// connection. ok is false when another session already holds the lock; when
+// true, the caller must call unlock to release it and the connection.
+func (s *Store) TryAdvisoryLock(ctx context.Context, key int64) (unlock func(), ok bool, err error) {
+	if s.pool == nil {
+		return nil, false, ErrNilPool
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("acquire connection: %w", err)
+	}
+	if err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("advisory lock: %w", err)
+	}
+	if !ok {
+		conn.Release()
+		return nil, false, nil
+	}
+	unlock = func() {
+		if _, unlockErr := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key); unlockErr != nil {
+			// Drop the connection so the session, and its lock, go away.
+			_ = conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+	return unlock, true, nil
 }
 
-// Migrate applies the embedded SQL files in order.
-func (s *Store) Migrate(ctx context.Context) error {
-	entries, err := migrations.ReadDir("migrate")
+// Listen subscribes to a notification channel on a dedicated connection and
+// passes each payload to notify until ctx is done or the connection fails.
+// listening runs once the subscription is in place; notifications sent before
+// then are missed. The connection is held for as long as Listen runs.
+func (s *Store) Listen(ctx context.Context, channel string, listening func(), notify func(payload string)) error {
+	if s.pool == nil {
+		return ErrNilPool
+	}
+	conn, err := s.pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("list migrations: %w", err)
+		return fmt.Errorf("acquire connection: %w", err)
 	}
-	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		stmt, readErr := migrations.ReadFile("migrate/" + entry.Name())
-		if readErr != nil {
-			return fmt.Errorf("read migration %s: %w", entry.Name(), readErr)
-		}
-		if _, err = s.pool.Exec(ctx, string(stmt)); err != nil {
-			return fmt.Errorf("apply migration %s: %w", entry.Name(), err)
+	// Never hand a listening session back to the pool.
+	defer func() {
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("listen %s: %w", channel, err)
+	}
+	listening()
+	for {
+		notification, waitErr := conn.Conn().WaitForNotification(ctx)
+		if waitErr != nil {
+			return waitErr
 		}
+		notify(notification.Payload)
 	}
-	return nil
+}
+
+// Queries returns the raw sqlc handle.
+func (s *Store) Queries() *sqlc.Queries {
+	return s.queries
 }
@@ -0,0 +1,9 @@
+-- Drops every core table. Only useful on an otherwise empty database.
+DROP TABLE IF EXISTS assets;
+DROP TABLE IF EXISTS checkins;
+DROP TABLE IF EXISTS keys;
+DROP TABLE IF EXISTS locations;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS users;
+DROP FUNCTION IF EXISTS set_updated_at();
@@ -0,0 +1,20 @@
+-----------------------------------------------------------------------
+-- Directory sync state
+-----------------------------------------------------------------------
+CREATE TABLE IF NOT EXISTS sync_state (
+  name           TEXT PRIMARY KEY,
+  delta_link     TEXT NOT NULL,
+  full_synced_at TIMESTAMPTZ,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_sync_state_updated_at') THEN
+    CREATE TRIGGER trg_sync_state_updated_at
+      BEFORE UPDATE ON sync_state
+      FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+  END IF;
+END;
+$$;
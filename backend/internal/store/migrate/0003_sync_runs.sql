@@ -0,0 +1,21 @@
+-----------------------------------------------------------------------
+-- Directory sync run history
+-----------------------------------------------------------------------
+CREATE TABLE IF NOT EXISTS sync_runs (
+  id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  trigger        TEXT NOT NULL CHECK (trigger IN ('schedule', 'manual')),
+  triggered_by   UUID REFERENCES users (id) ON DELETE SET NULL,
+  status         TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+  started_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at    TIMESTAMPTZ,
+  users_created  INTEGER NOT NULL DEFAULT 0,
+  users_updated  INTEGER NOT NULL DEFAULT 0,
+  users_deleted  INTEGER NOT NULL DEFAULT 0,
+  groups_created INTEGER NOT NULL DEFAULT 0,
+  groups_updated INTEGER NOT NULL DEFAULT 0,
+  groups_deleted INTEGER NOT NULL DEFAULT 0,
+  error          TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_runs_started
+  ON sync_runs (started_at DESC);
@@ -0,0 +1,7 @@
+-- Archived users become ordinary users again.
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS users_deleted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sync_runs DROP COLUMN IF EXISTS users_archived;
+
+DROP INDEX IF EXISTS idx_users_archived_at;
+ALTER TABLE users DROP COLUMN IF EXISTS archive_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS archived_at;
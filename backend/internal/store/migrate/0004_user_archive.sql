@@ -0,0 +1,13 @@
+-----------------------------------------------------------------------
+-- Soft-deleted (archived) users
+-----------------------------------------------------------------------
+ALTER TABLE users ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS archive_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_users_archived_at
+  ON users (archived_at)
+  WHERE archived_at IS NOT NULL;
+
+-- The syncer archives users rather than deleting them.
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS users_archived INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sync_runs DROP COLUMN IF EXISTS users_deleted;
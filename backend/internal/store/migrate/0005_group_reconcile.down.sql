@@ -0,0 +1,2 @@
+-- Backfilled object IDs are kept; they are still valid Entra IDs.
+ALTER TABLE sync_runs DROP COLUMN IF EXISTS diff;
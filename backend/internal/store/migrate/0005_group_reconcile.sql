@@ -0,0 +1,10 @@
+-----------------------------------------------------------------------
+-- Group reconciliation
+-----------------------------------------------------------------------
+-- Every group so far came from the Entra sync, which keys groups by object
+-- ID; record it so reconciliation can tell synced groups apart.
+UPDATE groups
+SET object_id = id::text
+WHERE object_id IS NULL;
+
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS diff JSONB;
@@ -0,0 +1,7 @@
+ALTER TABLE sync_state DROP COLUMN IF EXISTS fingerprint;
+
+DROP INDEX IF EXISTS idx_users_attributes;
+ALTER TABLE users DROP COLUMN IF EXISTS attributes;
+ALTER TABLE users DROP COLUMN IF EXISTS manager_id;
+ALTER TABLE users DROP COLUMN IF EXISTS job_title;
+ALTER TABLE users DROP COLUMN IF EXISTS employee_id;
@@ -0,0 +1,13 @@
+-----------------------------------------------------------------------
+-- Mapped directory attributes
+-----------------------------------------------------------------------
+ALTER TABLE users ADD COLUMN IF NOT EXISTS employee_id TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS job_title TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS manager_id UUID;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_users_attributes
+  ON users USING GIN (attributes jsonb_path_ops);
+
+-- Delta links are only reusable with the same select list.
+ALTER TABLE sync_state ADD COLUMN IF NOT EXISTS fingerprint TEXT NOT NULL DEFAULT '';
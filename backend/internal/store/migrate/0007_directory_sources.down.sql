@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_groups_source;
+DROP INDEX IF EXISTS idx_users_source;
+ALTER TABLE groups DROP COLUMN IF EXISTS source;
+ALTER TABLE users DROP COLUMN IF EXISTS source;
@@ -0,0 +1,21 @@
+-----------------------------------------------------------------------
+-- Directory sources
+-----------------------------------------------------------------------
+-- Records which directory source owns a synced user or group, so a sync only
+-- reconciles its own rows.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS source TEXT;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS source TEXT;
+
+-- Rows synced before sources existed came from Entra ID.
+UPDATE users
+SET source = 'entra'
+WHERE source IS NULL
+  AND object_id IS NOT NULL;
+
+UPDATE groups
+SET source = 'entra'
+WHERE source IS NULL
+  AND object_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_source ON users (source);
+CREATE INDEX IF NOT EXISTS idx_groups_source ON groups (source);
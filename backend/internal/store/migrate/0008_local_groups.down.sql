@@ -0,0 +1,6 @@
+-- Rule-derived memberships only make sense with their rules.
+DELETE FROM group_members WHERE derived;
+
+DROP INDEX IF EXISTS idx_groups_rules;
+ALTER TABLE group_members DROP COLUMN IF EXISTS derived;
+ALTER TABLE groups DROP COLUMN IF EXISTS rules;
@@ -0,0 +1,14 @@
+-----------------------------------------------------------------------
+-- Local groups
+-----------------------------------------------------------------------
+-- Local groups are rosters managed in the admin UI (source = 'local'). The
+-- directory sync and SCIM never update or delete them.
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS rules JSONB;
+
+-- Members added because they match a local group's rules, as opposed to
+-- members added by hand. Rule refreshes only touch derived rows.
+ALTER TABLE group_members ADD COLUMN IF NOT EXISTS derived BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_groups_rules
+  ON groups (id)
+  WHERE rules IS NOT NULL;
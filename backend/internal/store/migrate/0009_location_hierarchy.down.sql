@@ -0,0 +1,7 @@
+-- Every location becomes a top-level location.
+DROP FUNCTION IF EXISTS location_ancestors(UUID);
+DROP FUNCTION IF EXISTS location_subtree(UUID[]);
+
+DROP INDEX IF EXISTS idx_locations_parent;
+ALTER TABLE locations DROP COLUMN IF EXISTS kind;
+ALTER TABLE locations DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,35 @@
+-----------------------------------------------------------------------
+-- Location hierarchy
+-----------------------------------------------------------------------
+-- Locations form a tree (site > building > room). Access granted on a
+-- location, and keys bound to it, cover its whole subtree.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES locations (id) ON DELETE RESTRICT;
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS kind TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_locations_parent
+  ON locations (parent_id);
+
+-- location_subtree lists the roots and every location below them. UNION
+-- rather than UNION ALL stops the walk should a cycle ever slip in.
+CREATE OR REPLACE FUNCTION location_subtree(roots UUID[])
+RETURNS SETOF UUID
+LANGUAGE sql STABLE AS $$
+  WITH RECURSIVE tree (id) AS (
+    SELECT id FROM locations WHERE id = ANY(roots)
+    UNION
+    SELECT l.id FROM locations l JOIN tree t ON l.parent_id = t.id
+  )
+  SELECT id FROM tree
+$$;
+
+-- location_ancestors lists a location and every location above it.
+CREATE OR REPLACE FUNCTION location_ancestors(location UUID)
+RETURNS SETOF UUID
+LANGUAGE sql STABLE AS $$
+  WITH RECURSIVE chain (id, parent_id) AS (
+    SELECT id, parent_id FROM locations WHERE id = location
+    UNION
+    SELECT l.id, l.parent_id FROM locations l JOIN chain c ON l.id = c.parent_id
+  )
+  SELECT id FROM chain
+$$;
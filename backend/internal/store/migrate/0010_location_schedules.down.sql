@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS location_closures;
+
+ALTER TABLE checkins DROP COLUMN IF EXISTS out_of_hours;
+
+ALTER TABLE locations DROP COLUMN IF EXISTS out_of_hours_policy;
+ALTER TABLE locations DROP COLUMN IF EXISTS opening_hours;
+ALTER TABLE locations DROP COLUMN IF EXISTS timezone;
@@ -0,0 +1,28 @@
+-----------------------------------------------------------------------
+-- Location schedules
+-----------------------------------------------------------------------
+-- Opening hours, timezone and out-of-hours policy are set per location;
+-- NULL inherits from the parent, and a root without them is always open.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS timezone TEXT;
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS opening_hours JSONB;
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS out_of_hours_policy TEXT;
+
+-- Checkins recorded while their location was closed.
+ALTER TABLE checkins ADD COLUMN IF NOT EXISTS out_of_hours BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Closed days, entered by hand or imported from an iCal feed. A closure
+-- covers its location's subtree.
+CREATE TABLE IF NOT EXISTS location_closures (
+  id          UUID PRIMARY KEY,
+  location_id UUID NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+  starts_on   DATE NOT NULL,
+  ends_on     DATE NOT NULL,
+  reason      TEXT NOT NULL DEFAULT '',
+  source      TEXT NOT NULL DEFAULT 'manual',
+  uid         TEXT,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (ends_on >= starts_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_location_closures_location
+  ON location_closures (location_id, ends_on);
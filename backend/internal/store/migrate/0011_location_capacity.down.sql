@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_checkins_occurred;
+DROP TABLE IF EXISTS location_waitlist;
+
+ALTER TABLE locations DROP COLUMN IF EXISTS capacity_policy;
+ALTER TABLE locations DROP COLUMN IF EXISTS capacity;
@@ -0,0 +1,20 @@
+-----------------------------------------------------------------------
+-- Location capacity
+-----------------------------------------------------------------------
+-- capacity caps how many people may be checked in to a location's subtree;
+-- NULL is unlimited. capacity_policy decides what a full location does:
+-- 'soft' warns, 'hard' rejects and 'waitlist' queues (NULL means soft).
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS capacity INTEGER CHECK (capacity > 0);
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS capacity_policy TEXT;
+
+-- People waiting for space at a full location.
+CREATE TABLE IF NOT EXISTS location_waitlist (
+  location_id UUID NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+  user_id     UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (location_id, user_id)
+);
+
+-- Occupancy looks at every recent checkin, whatever the location.
+CREATE INDEX IF NOT EXISTS idx_checkins_occurred
+  ON checkins (occurred_at);
@@ -0,0 +1,11 @@
+-- The global background goes back to being an asset; every other branding
+-- setting is lost.
+INSERT INTO assets (key, content_type, data, updated_at)
+SELECT 'portal_background', b.background_type, b.background, b.updated_at
+FROM portal_branding b
+WHERE b.location_id IS NULL
+  AND b.key_id IS NULL
+  AND b.background IS NOT NULL
+ON CONFLICT (key) DO NOTHING;
+
+DROP TABLE IF EXISTS portal_branding;
@@ -0,0 +1,41 @@
+-----------------------------------------------------------------------
+-- Portal branding
+-----------------------------------------------------------------------
+-- Branding for the portal, scoped to a key, a location (covering its
+-- subtree) or, with neither set, every portal. Empty fields fall back to
+-- the next scope out. Images keep their original bytes; etags are hashes
+-- of those bytes.
+CREATE TABLE IF NOT EXISTS portal_branding (
+  id              UUID PRIMARY KEY,
+  location_id     UUID REFERENCES locations (id) ON DELETE CASCADE,
+  key_id          UUID REFERENCES keys (id) ON DELETE CASCADE,
+  accent_color    TEXT,
+  welcome_text    TEXT,
+  language        TEXT,
+  background      BYTEA,
+  background_type TEXT,
+  background_etag TEXT,
+  logo            BYTEA,
+  logo_type       TEXT,
+  logo_etag       TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (location_id IS NULL OR key_id IS NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_portal_branding_scope
+  ON portal_branding (
+    COALESCE(location_id, '00000000-0000-0000-0000-000000000000'::uuid),
+    COALESCE(key_id, '00000000-0000-0000-0000-000000000000'::uuid)
+  );
+
+-- The old global background asset becomes the global branding background.
+INSERT INTO portal_branding (id, background, background_type, background_etag, updated_at)
+SELECT gen_random_uuid(), a.data, a.content_type, LEFT(encode(sha256(a.data), 'hex'), 32), a.updated_at
+FROM assets a
+WHERE a.key = 'portal_background'
+  AND NOT EXISTS (
+    SELECT 1 FROM portal_branding WHERE location_id IS NULL AND key_id IS NULL
+  );
+
+DELETE FROM assets WHERE key = 'portal_background';
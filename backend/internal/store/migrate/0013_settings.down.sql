@@ -0,0 +1,3 @@
+-- Every setting returns to its built-in default.
+DROP TABLE IF EXISTS settings;
+DROP SEQUENCE IF EXISTS settings_version_seq;
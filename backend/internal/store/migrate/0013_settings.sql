@@ -0,0 +1,16 @@
+-----------------------------------------------------------------------
+-- Runtime settings
+-----------------------------------------------------------------------
+-- Admin-editable settings, keyed by name. A NULL value means the
+-- setting is back on its built-in default. Every write takes a new
+-- version from one sequence, so the highest version tells replicas
+-- whether anything changed since they last loaded.
+CREATE SEQUENCE IF NOT EXISTS settings_version_seq;
+
+CREATE TABLE IF NOT EXISTS settings (
+  key        TEXT PRIMARY KEY,
+  value      JSONB,
+  version    BIGINT NOT NULL DEFAULT nextval('settings_version_seq'),
+  updated_by UUID REFERENCES users (id) ON DELETE SET NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+-- Anonymised checkins cannot be given their users back, so they go.
+DROP TABLE IF EXISTS retention_runs;
+
+DELETE FROM checkins WHERE user_id IS NULL;
+ALTER TABLE checkins DROP COLUMN IF EXISTS anonymised_at;
+ALTER TABLE checkins ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE locations DROP COLUMN IF EXISTS notes_retention_days;
+ALTER TABLE locations DROP COLUMN IF EXISTS retention_action;
+ALTER TABLE locations DROP COLUMN IF EXISTS retention_days;
@@ -0,0 +1,28 @@
+-----------------------------------------------------------------------
+-- Check-in retention
+-----------------------------------------------------------------------
+-- Per-location retention; NULL inherits from the parent location. Checkins
+-- older than retention_days are deleted or anonymised, and notes are
+-- cleared once older than notes_retention_days.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days > 0);
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS retention_action TEXT;
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS notes_retention_days INTEGER CHECK (notes_retention_days > 0);
+
+-- Anonymised checkins keep where and when, but not who.
+ALTER TABLE checkins ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE checkins ADD COLUMN IF NOT EXISTS anonymised_at TIMESTAMPTZ;
+
+-- One row per purge pass, as a record of what retention removed.
+CREATE TABLE IF NOT EXISTS retention_runs (
+  id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  status        TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+  started_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at   TIMESTAMPTZ,
+  deleted       BIGINT NOT NULL DEFAULT 0,
+  anonymised    BIGINT NOT NULL DEFAULT 0,
+  notes_cleared BIGINT NOT NULL DEFAULT 0,
+  error         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_runs_started
+  ON retention_runs (started_at DESC);
@@ -0,0 +1,2 @@
+-- The record of past erasures is lost; the erasures themselves stand.
+DROP TABLE IF EXISTS user_erasures;
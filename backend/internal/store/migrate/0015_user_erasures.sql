@@ -0,0 +1,17 @@
+-----------------------------------------------------------------------
+-- Subject erasure
+-----------------------------------------------------------------------
+-- One row per erased user, kept as proof the request was carried out.
+-- subject_id is the erased user's old ID; nothing else about them is kept.
+CREATE TABLE IF NOT EXISTS user_erasures (
+  id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  subject_id          UUID NOT NULL,
+  reference           TEXT,
+  erased_by           UUID REFERENCES users (id) ON DELETE SET NULL,
+  erased_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  checkins_anonymised BIGINT NOT NULL DEFAULT 0,
+  memberships_removed BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_erasures_erased
+  ON user_erasures (erased_at DESC);
@@ -0,0 +1,42 @@
+-- Attached partitions are folded back into one table. Archived partitions
+-- stay in checkins_archive for operators to restore or drop.
+DROP FUNCTION IF EXISTS anonymise_archived_checkins(UUID);
+DROP FUNCTION IF EXISTS archive_checkin_partition(TEXT);
+DROP FUNCTION IF EXISTS ensure_checkin_partition(DATE);
+
+ALTER TABLE checkins RENAME TO checkins_partitioned;
+ALTER TABLE checkins_partitioned RENAME CONSTRAINT checkins_pkey TO checkins_partitioned_pkey;
+ALTER INDEX IF EXISTS idx_checkins_location_time RENAME TO idx_checkins_partitioned_location_time;
+ALTER INDEX IF EXISTS idx_checkins_user_time RENAME TO idx_checkins_partitioned_user_time;
+ALTER INDEX IF EXISTS idx_checkins_occurred RENAME TO idx_checkins_partitioned_occurred;
+
+CREATE TABLE checkins (
+  id            BIGINT PRIMARY KEY DEFAULT nextval('checkins_id_seq'),
+  user_id       UUID REFERENCES users (id) ON DELETE CASCADE,
+  location_id   UUID NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+  key_id        UUID REFERENCES keys (id) ON DELETE SET NULL,
+  direction     TEXT NOT NULL CHECK (direction IN ('in', 'out')),
+  notes         TEXT,
+  occurred_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  out_of_hours  BOOLEAN NOT NULL DEFAULT FALSE,
+  anonymised_at TIMESTAMPTZ
+);
+
+INSERT INTO checkins (
+  id, user_id, location_id, key_id, direction, notes, occurred_at, created_at, out_of_hours, anonymised_at
+)
+SELECT id, user_id, location_id, key_id, direction, notes, occurred_at, created_at, out_of_hours, anonymised_at
+FROM checkins_partitioned;
+
+ALTER SEQUENCE checkins_id_seq OWNED BY checkins.id;
+DROP TABLE checkins_partitioned;
+
+CREATE INDEX IF NOT EXISTS idx_checkins_location_time
+  ON checkins (location_id, occurred_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_checkins_user_time
+  ON checkins (user_id, occurred_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_checkins_occurred
+  ON checkins (occurred_at);
@@ -0,0 +1,134 @@
+-----------------------------------------------------------------------
+-- Monthly check-in partitions
+-----------------------------------------------------------------------
+-- checkins becomes range partitioned on occurred_at, one partition per UTC
+-- month named checkins_pYYYYMM. A default partition catches rows outside
+-- every month; ensure_checkin_partition moves them out when their month is
+-- created. Detached partitions are kept in the checkins_archive schema.
+CREATE SCHEMA IF NOT EXISTS checkins_archive;
+
+ALTER TABLE checkins RENAME TO checkins_unpartitioned;
+ALTER TABLE checkins_unpartitioned RENAME CONSTRAINT checkins_pkey TO checkins_unpartitioned_pkey;
+
+CREATE TABLE checkins (
+  id            BIGINT NOT NULL DEFAULT nextval('checkins_id_seq'),
+  user_id       UUID REFERENCES users (id) ON DELETE CASCADE,
+  location_id   UUID NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+  key_id        UUID REFERENCES keys (id) ON DELETE SET NULL,
+  direction     TEXT NOT NULL CHECK (direction IN ('in', 'out')),
+  notes         TEXT,
+  occurred_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  out_of_hours  BOOLEAN NOT NULL DEFAULT FALSE,
+  anonymised_at TIMESTAMPTZ,
+  PRIMARY KEY (id, occurred_at)
+) PARTITION BY RANGE (occurred_at);
+
+CREATE TABLE checkins_default PARTITION OF checkins DEFAULT;
+
+-- Creates the partition for the UTC month holding day, moving any of its rows
+-- out of the default partition first. Returns false if it existed.
+CREATE OR REPLACE FUNCTION ensure_checkin_partition(day DATE)
+RETURNS BOOLEAN
+LANGUAGE plpgsql AS $$
+DECLARE
+  first_day DATE := date_trunc('month', day)::date;
+  lower_bound TIMESTAMPTZ := first_day::timestamp AT TIME ZONE 'UTC';
+  upper_bound TIMESTAMPTZ := (first_day + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC';
+  partition_name TEXT := 'checkins_p' || to_char(first_day, 'YYYYMM');
+BEGIN
+  IF to_regclass('public.' || partition_name) IS NOT NULL THEN
+    RETURN FALSE;
+  END IF;
+  EXECUTE format(
+    'CREATE TABLE %I (LIKE checkins INCLUDING DEFAULTS INCLUDING CONSTRAINTS)',
+    partition_name
+  );
+  EXECUTE format(
+    'WITH moved AS (
+       DELETE FROM checkins_default WHERE occurred_at >= $1 AND occurred_at < $2 RETURNING *
+     )
+     INSERT INTO %I SELECT * FROM moved',
+    partition_name
+  ) USING lower_bound, upper_bound;
+  EXECUTE format(
+    'ALTER TABLE checkins ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+    partition_name, lower_bound, upper_bound
+  );
+  RETURN TRUE;
+END;
+$$;
+
+-- Detaches a monthly partition into checkins_archive. Archived rows leave
+-- every query, including retention; erasure still anonymises them.
+CREATE OR REPLACE FUNCTION archive_checkin_partition(partition_name TEXT)
+RETURNS VOID
+LANGUAGE plpgsql AS $$
+BEGIN
+  EXECUTE format('ALTER TABLE checkins DETACH PARTITION %I', partition_name);
+  EXECUTE format('ALTER TABLE %I SET SCHEMA checkins_archive', partition_name);
+END;
+$$;
+
+-- Anonymises a user's checkins in every archived partition.
+CREATE OR REPLACE FUNCTION anonymise_archived_checkins(subject UUID)
+RETURNS BIGINT
+LANGUAGE plpgsql AS $$
+DECLARE
+  archived RECORD;
+  changed BIGINT;
+  total BIGINT := 0;
+BEGIN
+  FOR archived IN
+    SELECT tablename FROM pg_tables WHERE schemaname = 'checkins_archive'
+  LOOP
+    EXECUTE format(
+      'UPDATE checkins_archive.%I SET user_id = NULL, notes = NULL, anonymised_at = NOW() WHERE user_id = $1',
+      archived.tablename
+    ) USING subject;
+    GET DIAGNOSTICS changed = ROW_COUNT;
+    total := total + changed;
+  END LOOP;
+  RETURN total;
+END;
+$$;
+
+-- A partition for every month with checkins, plus this month and the next
+-- three, before the rows are copied across.
+DO $$
+DECLARE
+  first_day DATE;
+BEGIN
+  FOR first_day IN
+    SELECT generate_series(
+      date_trunc('month', COALESCE(
+        (SELECT MIN(occurred_at) FROM checkins_unpartitioned),
+        NOW()
+      ) AT TIME ZONE 'UTC'),
+      date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '3 months',
+      INTERVAL '1 month'
+    )::date
+  LOOP
+    PERFORM ensure_checkin_partition(first_day);
+  END LOOP;
+END;
+$$;
+
+INSERT INTO checkins (
+  id, user_id, location_id, key_id, direction, notes, occurred_at, created_at, out_of_hours, anonymised_at
+)
+SELECT id, user_id, location_id, key_id, direction, notes, occurred_at, created_at, out_of_hours, anonymised_at
+FROM checkins_unpartitioned;
+
+ALTER SEQUENCE checkins_id_seq OWNED BY checkins.id;
+DROP TABLE checkins_unpartitioned;
+
+-- Indexes on the parent are created on every partition, current and future.
+CREATE INDEX IF NOT EXISTS idx_checkins_location_time
+  ON checkins (location_id, occurred_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_checkins_user_time
+  ON checkins (user_id, occurred_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_checkins_occurred
+  ON checkins (occurred_at);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS trg_users_roster ON users;
+DROP TRIGGER IF EXISTS trg_locations_roster_delete ON locations;
+DROP TRIGGER IF EXISTS trg_locations_roster ON locations;
+DROP TRIGGER IF EXISTS trg_group_members_roster ON group_members;
+DROP FUNCTION IF EXISTS notify_user_roster();
+DROP FUNCTION IF EXISTS notify_location_roster();
+DROP FUNCTION IF EXISTS notify_group_roster();
@@ -0,0 +1,69 @@
+-----------------------------------------------------------------------
+-- Portal roster change notifications
+-----------------------------------------------------------------------
+-- Replicas cache each location's roster and drop entries when these
+-- triggers notify roster_changed. Payloads are location:<id> when a
+-- location's groups change, group:<id> when a group's members change, and *
+-- when a user's roster details change. Postgres folds identical payloads
+-- sent in one transaction, so bulk syncs send one notification per group.
+CREATE OR REPLACE FUNCTION notify_group_roster() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP IN ('UPDATE', 'DELETE') THEN
+    PERFORM pg_notify('roster_changed', 'group:' || OLD.group_id);
+  END IF;
+  IF TG_OP IN ('INSERT', 'UPDATE') THEN
+    PERFORM pg_notify('roster_changed', 'group:' || NEW.group_id);
+  END IF;
+  RETURN NULL;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION notify_location_roster() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  PERFORM pg_notify('roster_changed', 'location:' || OLD.id);
+  RETURN NULL;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION notify_user_roster() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  PERFORM pg_notify('roster_changed', '*');
+  RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trg_group_members_roster ON group_members;
+CREATE TRIGGER trg_group_members_roster
+  AFTER INSERT OR UPDATE OR DELETE ON group_members
+  FOR EACH ROW EXECUTE FUNCTION notify_group_roster();
+
+DROP TRIGGER IF EXISTS trg_locations_roster ON locations;
+CREATE TRIGGER trg_locations_roster
+  AFTER UPDATE OF group_ids ON locations
+  FOR EACH ROW
+  WHEN (OLD.group_ids IS DISTINCT FROM NEW.group_ids)
+  EXECUTE FUNCTION notify_location_roster();
+
+DROP TRIGGER IF EXISTS trg_locations_roster_delete ON locations;
+CREATE TRIGGER trg_locations_roster_delete
+  AFTER DELETE ON locations
+  FOR EACH ROW EXECUTE FUNCTION notify_location_roster();
+
+-- Deleted users leave through the group_members cascade.
+DROP TRIGGER IF EXISTS trg_users_roster ON users;
+CREATE TRIGGER trg_users_roster
+  AFTER UPDATE ON users
+  FOR EACH ROW
+  WHEN (
+    OLD.archived_at IS DISTINCT FROM NEW.archived_at
+    OR OLD.display_name IS DISTINCT FROM NEW.display_name
+    OR OLD.upn IS DISTINCT FROM NEW.upn
+    OR OLD.department IS DISTINCT FROM NEW.department
+    OR OLD.employee_id IS DISTINCT FROM NEW.employee_id
+    OR OLD.job_title IS DISTINCT FROM NEW.job_title
+    OR OLD.attributes IS DISTINCT FROM NEW.attributes
+  )
+  EXECUTE FUNCTION notify_user_roster();
@@ -0,0 +1,21 @@
+-----------------------------------------------------------------------
+-- Idempotent kiosk check-ins
+-----------------------------------------------------------------------
+-- One row per idempotency key a kiosk has sent, scoped to its portal key, so
+-- retried and replayed check-ins are recorded once. The outcome is kept to
+-- answer retries the same way. Rows are pruned by the retention job.
+CREATE TABLE IF NOT EXISTS checkin_requests (
+  key_id            UUID NOT NULL REFERENCES keys (id) ON DELETE CASCADE,
+  idempotency_key   UUID NOT NULL,
+  user_id           UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  direction         TEXT NOT NULL CHECK (direction IN ('in', 'out')),
+  checkin_id        BIGINT,
+  out_of_hours      BOOLEAN NOT NULL DEFAULT FALSE,
+  over_capacity     BOOLEAN NOT NULL DEFAULT FALSE,
+  waitlist_position INTEGER,
+  created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (key_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_checkin_requests_created
+  ON checkin_requests (created_at);
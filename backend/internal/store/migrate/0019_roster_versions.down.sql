@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS roster_entries;
+DROP TABLE IF EXISTS roster_versions;
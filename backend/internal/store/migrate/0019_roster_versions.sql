@@ -0,0 +1,27 @@
+-----------------------------------------------------------------------
+-- Versioned portal rosters
+-----------------------------------------------------------------------
+-- Each location's roster has a version that goes up whenever a reconcile
+-- finds users added, removed or with changed details. roster_entries keeps
+-- the version at which each user was last added, changed and removed, so
+-- kiosks can fetch only what changed since the version they hold. user_id
+-- has no foreign key so removals outlive deleted users.
+CREATE TABLE IF NOT EXISTS roster_versions (
+  location_id UUID PRIMARY KEY REFERENCES locations (id) ON DELETE CASCADE,
+  version     BIGINT NOT NULL DEFAULT 0,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS roster_entries (
+  location_id     UUID NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+  user_id         UUID NOT NULL,
+  -- digest covers the user fields kiosks show.
+  digest          TEXT NOT NULL,
+  added_version   BIGINT NOT NULL,
+  changed_version BIGINT NOT NULL,
+  removed_version BIGINT,
+  PRIMARY KEY (location_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_roster_entries_changed
+  ON roster_entries (location_id, changed_version);
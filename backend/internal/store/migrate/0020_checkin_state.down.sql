@@ -0,0 +1,8 @@
+DELETE FROM checkin_requests WHERE direction = 'toggle';
+ALTER TABLE checkin_requests DROP CONSTRAINT IF EXISTS checkin_requests_direction_check;
+ALTER TABLE checkin_requests ADD CONSTRAINT checkin_requests_direction_check
+  CHECK (direction IN ('in', 'out'));
+
+ALTER TABLE checkins DROP COLUMN IF EXISTS auto_inserted;
+ALTER TABLE checkins DROP COLUMN IF EXISTS contradictory;
+ALTER TABLE locations DROP COLUMN IF EXISTS direction_policy;
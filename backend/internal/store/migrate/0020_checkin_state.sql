@@ -0,0 +1,19 @@
+-----------------------------------------------------------------------
+-- Check-in state rules
+-----------------------------------------------------------------------
+-- direction_policy decides what happens to a checkin that contradicts the
+-- user's state at the location, an "in" while already in or an "out"
+-- without an "in": reject it, insert the missing counterpart first, or
+-- record it flagged. NULL inherits from the parent location; flag applies
+-- when no location sets one.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS direction_policy TEXT
+  CHECK (direction_policy IN ('reject', 'insert', 'flag'));
+
+-- Partitions created from now on pick these up through LIKE checkins.
+ALTER TABLE checkins ADD COLUMN IF NOT EXISTS contradictory BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE checkins ADD COLUMN IF NOT EXISTS auto_inserted BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Kiosks may ask the server to pick the direction.
+ALTER TABLE checkin_requests DROP CONSTRAINT IF EXISTS checkin_requests_direction_check;
+ALTER TABLE checkin_requests ADD CONSTRAINT checkin_requests_direction_check
+  CHECK (direction IN ('in', 'out', 'toggle'));
@@ -0,0 +1,8 @@
+DELETE FROM checkin_requests WHERE direction = 'transfer';
+ALTER TABLE checkin_requests DROP CONSTRAINT IF EXISTS checkin_requests_direction_check;
+ALTER TABLE checkin_requests ADD CONSTRAINT checkin_requests_direction_check
+  CHECK (direction IN ('in', 'out', 'toggle'));
+
+DROP INDEX IF EXISTS idx_checkins_transfer;
+ALTER TABLE checkins DROP COLUMN IF EXISTS transfer_id;
+ALTER TABLE locations DROP COLUMN IF EXISTS transfer_policy;
@@ -0,0 +1,22 @@
+-----------------------------------------------------------------------
+-- Cross-location transfers
+-----------------------------------------------------------------------
+-- A transfer checks a user in at one location and out of every other
+-- location they are still in, in one step. transfer_policy deny keeps a
+-- location out of transfers: presence there is never closed by one, and
+-- its kiosks cannot start one. NULL inherits from the parent location;
+-- allow applies when no location sets one.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS transfer_policy TEXT
+  CHECK (transfer_policy IN ('allow', 'deny'));
+
+-- The "in" and the "out"s of one transfer share a transfer_id. Partitions
+-- created from now on pick it up through LIKE checkins.
+ALTER TABLE checkins ADD COLUMN IF NOT EXISTS transfer_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_checkins_transfer
+  ON checkins (transfer_id)
+  WHERE transfer_id IS NOT NULL;
+
+ALTER TABLE checkin_requests DROP CONSTRAINT IF EXISTS checkin_requests_direction_check;
+ALTER TABLE checkin_requests ADD CONSTRAINT checkin_requests_direction_check
+  CHECK (direction IN ('in', 'out', 'toggle', 'transfer'));
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS archived_retention(UUID, TEXT, TIMESTAMPTZ, TIMESTAMPTZ, BOOLEAN);
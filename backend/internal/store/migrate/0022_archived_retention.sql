@@ -0,0 +1,57 @@
+-----------------------------------------------------------------------
+-- Retention for archived check-in partitions
+-----------------------------------------------------------------------
+-- Partitions detached into checkins_archive are out of the checkins
+-- table, so retention reaches them through this function instead. It
+-- applies one step of a location's rule to every archived partition:
+-- delete or anonymise rows before the cutoff, or clear their notes. Notes
+-- on rows that expire_before also covers are left to the expiry step.
+-- With dry_run it only counts. Kiosks never write to archived partitions,
+-- so each is changed in one statement rather than in batches.
+CREATE OR REPLACE FUNCTION archived_retention(
+  location UUID,
+  step TEXT,
+  before TIMESTAMPTZ,
+  expire_before TIMESTAMPTZ,
+  dry_run BOOLEAN
+)
+RETURNS BIGINT
+LANGUAGE plpgsql AS $$
+DECLARE
+  archived RECORD;
+  matching TEXT;
+  changed BIGINT;
+  total BIGINT := 0;
+BEGIN
+  matching := CASE step
+    WHEN 'delete' THEN 'location_id = $1 AND occurred_at < $2'
+    WHEN 'anonymise' THEN 'location_id = $1 AND occurred_at < $2 AND user_id IS NOT NULL'
+    WHEN 'clear_notes' THEN
+      'location_id = $1 AND occurred_at < $2 AND notes IS NOT NULL AND ($3 IS NULL OR occurred_at >= $3)'
+  END;
+  IF matching IS NULL THEN
+    RAISE EXCEPTION 'unknown retention step %', step;
+  END IF;
+  FOR archived IN
+    SELECT tablename FROM pg_tables WHERE schemaname = 'checkins_archive'
+  LOOP
+    IF dry_run THEN
+      EXECUTE format('SELECT COUNT(*) FROM checkins_archive.%I WHERE ' || matching, archived.tablename)
+        INTO changed USING location, before, expire_before;
+    ELSE
+      EXECUTE format(
+        CASE step
+          WHEN 'delete' THEN 'DELETE FROM checkins_archive.%I WHERE '
+          WHEN 'anonymise' THEN
+            'UPDATE checkins_archive.%I SET user_id = NULL, notes = NULL, anonymised_at = NOW() WHERE '
+          ELSE 'UPDATE checkins_archive.%I SET notes = NULL WHERE '
+        END || matching,
+        archived.tablename
+      ) USING location, before, expire_before;
+      GET DIAGNOSTICS changed = ROW_COUNT;
+    END IF;
+    total := total + changed;
+  END LOOP;
+  RETURN total;
+END;
+$$;
@@ -0,0 +1,17 @@
+-----------------------------------------------------------------------
+-- Login identities
+-----------------------------------------------------------------------
+-- Each login provider names its users by its own subject. A user is found
+-- by (provider, subject) once linked, so two providers asserting the same
+-- login never reach each other's users. Providers allowed to match
+-- existing users link on first sign-in by login.
+CREATE TABLE IF NOT EXISTS user_identities (
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
@@ -0,0 +1,24 @@
+ALTER TABLE checkins DROP CONSTRAINT IF EXISTS checkins_user_id_fkey;
+ALTER TABLE checkins ADD CONSTRAINT checkins_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+DO $$
+DECLARE
+  fk RECORD;
+BEGIN
+  FOR fk IN
+    SELECT c.conname, c.conrelid::regclass AS tbl
+    FROM pg_constraint c
+    JOIN pg_class t ON t.oid = c.conrelid
+    JOIN pg_namespace n ON n.oid = t.relnamespace
+    WHERE n.nspname = 'checkins_archive'
+      AND c.contype = 'f'
+      AND c.confrelid = 'public.users'::regclass
+      AND c.confdeltype = 'n'
+  LOOP
+    EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.tbl, fk.conname);
+    EXECUTE format(
+      'ALTER TABLE %s ADD CONSTRAINT %I FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE NOT VALID',
+      fk.tbl, fk.conname);
+  END LOOP;
+END $$;
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrate/*.sql
+var migrations embed.FS
+
+// migrationLockKey serialises migrations so replicas booting together take
+// turns instead of racing.
+const migrationLockKey int64 = 0x7369676e696e03
+
+const createLedgerSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    INTEGER PRIMARY KEY,
+  name       TEXT NOT NULL,
+  checksum   TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+var (
+	// ErrMigrationChanged means an applied migration file was edited after it
+	// ran. Ship a new migration instead.
+	ErrMigrationChanged = errors.New("store: applied migration has changed")
+	// ErrNoDownMigration means a migration has no .down.sql file to roll it
+	// back.
+	ErrNoDownMigration = errors.New("store: migration cannot be rolled back")
+	// ErrUnknownMigration means the ledger records a migration this build
+	// does not have, so it cannot be rolled back.
+	ErrUnknownMigration = errors.New("store: migration unknown to this build")
+)
+
+// Migration is one embedded schema migration. Files are NNNN_name.sql, with
+// an optional NNNN_name.down.sql that reverses it.
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string
+	up       string
+	down     string
+}
+
+// HasDown reports whether the migration can be rolled back.
+func (m Migration) HasDown() bool {
+	return m.down != ""
+}
+
+// MigrationStatus is a migration and its ledger entry, if any.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Changed is true when the file no longer matches the applied checksum.
+	Changed bool
+	// Unknown is true for applied migrations missing from this build, such
+	// as ones shipped by a newer release.
+	Unknown bool
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrate applies every embedded migration missing from the schema_migrations
+// ledger, in version order, each in its own transaction. It refuses to run
+// when an applied migration has since changed. Migrations this build does not
+// know, from a newer release, are left alone.
+func (s *Store) Migrate(ctx context.Context) ([]Migration, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int]appliedMigration) error {
+		for _, m := range all {
+			if prev, ok := applied[m.Version]; ok {
+				if prev.checksum != m.Checksum {
+					return fmt.Errorf("%w: %04d_%s", ErrMigrationChanged, m.Version, m.Name)
+				}
+				continue
+			}
+			if err := applyMigration(ctx, conn, m.up, func(tx pgx.Tx) error {
+				_, execErr := tx.Exec(ctx,
+					"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+					m.Version, m.Name, m.Checksum)
+				return execErr
+			}); err != nil {
+				return fmt.Errorf("apply migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// MigrateDown rolls back every applied migration above target, newest first.
+// Nothing runs unless every one of them has a down file.
+func (s *Store) MigrateDown(ctx context.Context, target int) ([]Migration, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]Migration, len(all))
+	for _, m := range all {
+		byVersion[m.Version] = m
+	}
+	var reverted []Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int]appliedMigration) error {
+		var pending []Migration
+		for version, prev := range applied {
+			if version <= target {
+				continue
+			}
+			m, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("%w: %04d_%s", ErrUnknownMigration, version, prev.name)
+			}
+			if !m.HasDown() {
+				return fmt.Errorf("%w: %04d_%s", ErrNoDownMigration, m.Version, m.Name)
+			}
+			pending = append(pending, m)
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].Version > pending[j].Version })
+		for _, m := range pending {
+			if err := applyMigration(ctx, conn, m.down, func(tx pgx.Tx) error {
+				_, execErr := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return execErr
+			}); err != nil {
+				return fmt.Errorf("roll back migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every embedded or applied migration in version order.
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	err = s.withMigrationLock(ctx, func(_ *pgxpool.Conn, applied map[int]appliedMigration) error {
+		for _, m := range all {
+			status := MigrationStatus{Migration: m}
+			if prev, ok := applied[m.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = prev.appliedAt
+				status.Changed = prev.checksum != m.Checksum
+				delete(applied, m.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for version, prev := range applied {
+			statuses = append(statuses, MigrationStatus{
+				Migration: Migration{Version: version, Name: prev.name, Checksum: prev.checksum},
+				Applied:   true,
+				AppliedAt: prev.appliedAt,
+				Unknown:   true,
+			})
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, err
+}
+
+// withMigrationLock holds the migration advisory lock on one connection,
+// creating the ledger if needed, and passes fn the applied migrations.
+func (s *Store) withMigrationLock(
+	ctx context.Context,
+	fn func(*pgxpool.Conn, map[int]appliedMigration) error,
+) error {
+	if s.pool == nil {
+		return ErrNilPool
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("migration lock: %w", err)
+	}
+	defer func() {
+		_, unlockErr := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if unlockErr != nil {
+			// Drop the connection so the session, and its lock, go away.
+			_ = conn.Conn().Close(context.Background())
+		}
+	}()
+	if _, err = conn.Exec(ctx, createLedgerSQL); err != nil {
+		return fmt.Errorf("create migration ledger: %w", err)
+	}
+	rows, err := conn.Query(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("read migration ledger: %w", err)
+	}
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var prev appliedMigration
+		if err = rows.Scan(&version, &prev.name, &prev.checksum, &prev.appliedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("read migration ledger: %w", err)
+		}
+		applied[version] = prev
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("read migration ledger: %w", err)
+	}
+	return fn(conn, applied)
+}
+
+// applyMigration runs a migration script and its ledger change in one
+// transaction.
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, script string, record func(pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if err = record(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// loadMigrations reads the embedded migrations in version order.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrations.ReadDir("migrate")
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file := entry.Name()
+		base, down := strings.CutSuffix(strings.TrimSuffix(file, ".sql"), ".down")
+		prefix, name, ok := strings.Cut(base, "_")
+		version, convErr := strconv.Atoi(prefix)
+		if !ok || convErr != nil {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.sql", file)
+		}
+		script, readErr := migrations.ReadFile("migrate/" + file)
+		if readErr != nil {
+			return nil, fmt.Errorf("read migration %s: %w", file, readErr)
+		}
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %s: version %d is used by %s too", file, version, m.Name)
+		}
+		if down {
+			m.down = string(script)
+			continue
+		}
+		sum := sha256.Sum256(script)
+		m.up, m.Checksum = string(script), hex.EncodeToString(sum[:])
+	}
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %04d_%s: down file without up file", m.Version, m.Name)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
@@ -10,12 +10,15 @@ FROM users
 WHERE id = $1;
 
 -- name: ListUsersForLocation :many
+-- Users with access to the location or any location above it.
 SELECT u.*
 FROM users u
-WHERE u.location_ids @> ARRAY[$1::uuid]
+WHERE u.location_ids && ARRAY(SELECT location_ancestors($1::uuid))
+  AND u.archived_at IS NULL
 ORDER BY u.display_name, u.upn;
 
 -- name: HasUserLocationAccess :one
-SELECT $2::uuid = ANY(u.location_ids)
+-- Access to a location covers its subtree.
+SELECT (u.location_ids && ARRAY(SELECT location_ancestors($2::uuid)))::boolean
 FROM users u
 WHERE u.id = $1;
@@ -0,0 +1,58 @@
+-- name: ListBranding :many
+SELECT id, location_id, key_id, accent_color, welcome_text, language,
+       background_type, background_etag, logo_type, logo_etag, created_at, updated_at
+FROM portal_branding
+ORDER BY key_id NULLS FIRST, location_id NULLS FIRST;
+
+-- name: ListBrandingForPortal :many
+-- Branding rows that may apply to a portal: the key's, those of the given
+-- locations and the global row.
+SELECT id, location_id, key_id, accent_color, welcome_text, language,
+       background_type, background_etag, logo_type, logo_etag, created_at, updated_at
+FROM portal_branding
+WHERE key_id = sqlc.arg(key_id)::uuid
+   OR location_id = ANY(sqlc.arg(location_ids)::uuid[])
+   OR (location_id IS NULL AND key_id IS NULL);
+
+-- name: GetBranding :one
+SELECT *
+FROM portal_branding
+WHERE location_id IS NOT DISTINCT FROM sqlc.narg(location_id)::uuid
+  AND key_id IS NOT DISTINCT FROM sqlc.narg(key_id)::uuid;
+
+-- name: GetBrandingByID :one
+SELECT *
+FROM portal_branding
+WHERE id = $1;
+
+-- name: UpsertBranding :one
+INSERT INTO portal_branding (
+  id, location_id, key_id, accent_color, welcome_text, language,
+  background, background_type, background_etag, logo, logo_type, logo_etag
+)
+VALUES (
+  $1, sqlc.narg(location_id), sqlc.narg(key_id), sqlc.narg(accent_color), sqlc.narg(welcome_text),
+  sqlc.narg(language), sqlc.narg(background), sqlc.narg(background_type), sqlc.narg(background_etag),
+  sqlc.narg(logo), sqlc.narg(logo_type), sqlc.narg(logo_etag)
+)
+ON CONFLICT (
+  (COALESCE(location_id, '00000000-0000-0000-0000-000000000000'::uuid)),
+  (COALESCE(key_id, '00000000-0000-0000-0000-000000000000'::uuid))
+) DO UPDATE
+SET accent_color = EXCLUDED.accent_color,
+    welcome_text = EXCLUDED.welcome_text,
+    language = EXCLUDED.language,
+    background = EXCLUDED.background,
+    background_type = EXCLUDED.background_type,
+    background_etag = EXCLUDED.background_etag,
+    logo = EXCLUDED.logo,
+    logo_type = EXCLUDED.logo_type,
+    logo_etag = EXCLUDED.logo_etag,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteBranding :execrows
+DELETE
+FROM portal_branding
+WHERE location_id IS NOT DISTINCT FROM sqlc.narg(location_id)::uuid
+  AND key_id IS NOT DISTINCT FROM sqlc.narg(key_id)::uuid;
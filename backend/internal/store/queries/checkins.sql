@@ -1,8 +1,65 @@
 -- name: CreateCheckin :one
-INSERT INTO checkins (user_id, location_id, key_id, direction, notes, occurred_at)
-VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))
+INSERT INTO checkins (
+  user_id, location_id, key_id, direction, notes, occurred_at, out_of_hours, contradictory, auto_inserted,
+  transfer_id
+)
+VALUES (
+  sqlc.arg(user_id)::uuid,
+  sqlc.arg(location_id),
+  sqlc.narg(key_id),
+  sqlc.arg(direction),
+  sqlc.narg(notes),
+  COALESCE(sqlc.narg(occurred_at)::timestamptz, NOW()),
+  sqlc.arg(out_of_hours),
+  sqlc.arg(contradictory),
+  sqlc.arg(auto_inserted),
+  sqlc.narg(transfer_id)
+)
 RETURNING *;
 
+-- name: GetCheckin :one
+SELECT *
+FROM checkins
+WHERE id = $1;
+
+-- name: LockCheckinState :exec
+SELECT pg_advisory_xact_lock(
+  hashtext(sqlc.arg(user_id)::uuid::text),
+  hashtext(sqlc.arg(location_id)::uuid::text)
+);
+
+-- name: GetLastCheckin :one
+SELECT *
+FROM checkins
+WHERE user_id = sqlc.arg(user_id)::uuid
+  AND location_id = sqlc.arg(location_id)
+  AND occurred_at > sqlc.arg(since)
+  AND occurred_at <= sqlc.arg(until)
+ORDER BY occurred_at DESC, id DESC
+LIMIT 1;
+
+-- name: ListOpenLocations :many
+-- Locations other than the excluded one where the user's last checkin in
+-- the window was an "in".
+SELECT latest.location_id
+FROM (
+  SELECT DISTINCT ON (c.location_id) c.location_id, c.direction
+  FROM checkins c
+  WHERE c.user_id = sqlc.arg(user_id)::uuid
+    AND c.location_id <> sqlc.arg(excluded_id)::uuid
+    AND c.occurred_at > sqlc.arg(since)
+    AND c.occurred_at <= sqlc.arg(until)
+  ORDER BY c.location_id, c.occurred_at DESC, c.id DESC
+) latest
+WHERE latest.direction = 'in'
+ORDER BY latest.location_id;
+
+-- name: ListTransferCheckins :many
+SELECT *
+FROM checkins
+WHERE transfer_id = $1
+ORDER BY occurred_at, id;
+
 -- name: ListCheckins :many
 SELECT c.*
 FROM checkins c
@@ -12,12 +69,12 @@ WHERE (
     SELECT 1
     FROM users u
     WHERE u.id = sqlc.arg(viewer_id)
-      AND c.location_id = ANY(COALESCE(u.location_ids, '{}'))
+      AND c.location_id IN (SELECT location_subtree(COALESCE(u.location_ids, '{}')))
   )
 )
 AND (
   sqlc.narg(location_id)::uuid IS NULL
-  OR c.location_id = sqlc.narg(location_id)::uuid
+  OR c.location_id IN (SELECT location_subtree(ARRAY[sqlc.narg(location_id)::uuid]))
 )
 AND (
   sqlc.narg(user_id)::uuid IS NULL
@@ -33,6 +90,9 @@ SELECT
   u.display_name AS user_display_name,
   u.upn          AS user_upn,
   u.department   AS user_department,
+  u.employee_id  AS user_employee_id,
+  u.job_title    AS user_job_title,
+  u.attributes   AS user_attributes,
   c.location_id,
   l.name         AS location_name,
   l.identifier   AS location_identifier,
@@ -40,7 +100,11 @@ SELECT
   c.direction,
   c.notes,
   c.occurred_at,
-  c.created_at
+  c.created_at,
+  c.out_of_hours,
+  c.contradictory,
+  c.auto_inserted,
+  c.transfer_id
 FROM checkins c
 JOIN users u ON c.user_id = u.id
 JOIN locations l ON c.location_id = l.id
@@ -50,16 +114,72 @@ WHERE (
     SELECT 1
     FROM users u2
     WHERE u2.id = sqlc.arg(viewer_id)
-      AND c.location_id = ANY(COALESCE(u2.location_ids, '{}'))
+      AND c.location_id IN (SELECT location_subtree(COALESCE(u2.location_ids, '{}')))
   )
 )
 AND (
   sqlc.narg(location_id)::uuid IS NULL
-  OR c.location_id = sqlc.narg(location_id)::uuid
+  OR c.location_id IN (SELECT location_subtree(ARRAY[sqlc.narg(location_id)::uuid]))
 )
 AND (
   sqlc.narg(user_id)::uuid IS NULL
   OR c.user_id = sqlc.narg(user_id)::uuid
 )
+AND (
+  sqlc.narg(user_attributes)::jsonb IS NULL
+  OR u.attributes @> sqlc.narg(user_attributes)::jsonb
+)
 ORDER BY c.occurred_at DESC
 LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: ExportCheckins :many
+SELECT
+  c.id,
+  c.occurred_at,
+  c.direction,
+  u.upn          AS user_upn,
+  u.display_name AS user_display_name,
+  u.employee_id  AS user_employee_id,
+  l.identifier   AS location_identifier,
+  l.name         AS location_name,
+  c.key_id,
+  c.notes,
+  c.out_of_hours
+FROM checkins c
+JOIN users u ON c.user_id = u.id
+JOIN locations l ON c.location_id = l.id
+WHERE c.occurred_at >= sqlc.arg(from_time)
+  AND c.occurred_at < sqlc.arg(to_time)
+  AND (
+    sqlc.narg(location_id)::uuid IS NULL
+    OR c.location_id IN (SELECT location_subtree(ARRAY[sqlc.narg(location_id)::uuid]))
+  )
+ORDER BY c.occurred_at, c.id;
+
+-- name: PurgeCheckinsBefore :execrows
+DELETE FROM checkins
+WHERE occurred_at < $1;
+
+-- name: ClaimCheckinRequest :execrows
+INSERT INTO checkin_requests (key_id, idempotency_key, user_id, direction)
+VALUES (sqlc.arg(key_id), sqlc.arg(idempotency_key), sqlc.arg(user_id), sqlc.arg(direction))
+ON CONFLICT DO NOTHING;
+
+-- name: GetCheckinRequest :one
+SELECT *
+FROM checkin_requests
+WHERE key_id = sqlc.arg(key_id)
+  AND idempotency_key = sqlc.arg(idempotency_key);
+
+-- name: FinishCheckinRequest :exec
+UPDATE checkin_requests
+SET checkin_id = sqlc.narg(checkin_id),
+    out_of_hours = sqlc.arg(out_of_hours),
+    over_capacity = sqlc.arg(over_capacity),
+    waitlist_position = sqlc.narg(waitlist_position)
+WHERE key_id = sqlc.arg(key_id)
+  AND idempotency_key = sqlc.arg(idempotency_key);
+
+-- name: DeleteExpiredCheckinRequests :execrows
+DELETE FROM checkin_requests
+WHERE created_at < $1;
@@ -0,0 +1,30 @@
+-- name: ListLocationClosures :many
+SELECT *
+FROM location_closures
+WHERE location_id = $1
+ORDER BY starts_on, ends_on;
+
+-- name: ListEffectiveClosures :many
+-- Closures on the location or any ancestor that end on or after from.
+SELECT *
+FROM location_closures
+WHERE location_id IN (SELECT location_ancestors(sqlc.arg(location_id)::uuid))
+  AND ends_on >= sqlc.arg(from_date)
+ORDER BY starts_on, ends_on;
+
+-- name: CreateLocationClosure :one
+INSERT INTO location_closures (id, location_id, starts_on, ends_on, reason, source, uid)
+VALUES ($1, $2, $3, $4, $5, $6, sqlc.narg(uid))
+RETURNING *;
+
+-- name: DeleteLocationClosure :execrows
+DELETE
+FROM location_closures
+WHERE id = $1
+  AND location_id = $2;
+
+-- name: DeleteCalendarClosures :exec
+DELETE
+FROM location_closures
+WHERE location_id = $1
+  AND source = 'ical';
@@ -1,12 +1,14 @@
 -- name: UpsertGroup :one
-INSERT INTO groups (id, display_name, description, object_id)
-VALUES ($1, $2, $3, $4)
+INSERT INTO groups (id, display_name, description, object_id, source)
+VALUES ($1, $2, $3, $4, sqlc.narg(source))
 ON CONFLICT (id)
 DO UPDATE SET
   display_name = EXCLUDED.display_name,
   description = EXCLUDED.description,
   object_id = EXCLUDED.object_id,
+  source = COALESCE(sqlc.narg(source), groups.source),
   updated_at = NOW()
+WHERE groups.source IS DISTINCT FROM 'local'
 RETURNING *;
 
 -- name: ListGroups :many
@@ -22,18 +24,34 @@ WHERE CASE
      END
 ORDER BY display_name;
 
--- name: DeleteGroup :exec
-DELETE FROM groups WHERE id = $1;
+-- name: DeleteGroup :execrows
+DELETE FROM groups
+WHERE id = $1
+  AND source IS DISTINCT FROM 'local';
 
--- name: AddGroupMember :exec
+-- name: AddGroupMember :execrows
 INSERT INTO group_members (group_id, user_id)
 SELECT $1, $2
 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2)
 ON CONFLICT DO NOTHING;
 
--- name: DeleteGroupMembers :exec
+-- name: DeleteGroupMember :exec
 DELETE FROM group_members
-WHERE group_id = $1;
+WHERE group_id = $1
+  AND user_id = $2;
+
+-- name: ListSyncedGroups :many
+SELECT *
+FROM groups
+WHERE source = $1
+  AND object_id IS NOT NULL
+ORDER BY display_name;
+
+-- name: ListLocationsForGroup :many
+SELECT *
+FROM locations
+WHERE $1::uuid = ANY(group_ids)
+ORDER BY name, identifier;
 
 -- name: ListGroupMemberIDs :many
 SELECT user_id
@@ -45,9 +63,87 @@ SELECT u.*
 FROM group_members gm
 JOIN users u ON gm.user_id = u.id
 WHERE gm.group_id = $1
+  AND u.archived_at IS NULL
+ORDER BY LOWER(COALESCE(u.display_name, u.upn)), u.upn;
+
+-- name: ListUsersForGroups :many
+SELECT u.*
+FROM users u
+WHERE u.archived_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM group_members gm
+    WHERE gm.user_id = u.id
+      AND gm.group_id = ANY(sqlc.arg(group_ids)::uuid[])
+  )
 ORDER BY LOWER(COALESCE(u.display_name, u.upn)), u.upn;
 
 -- name: GetGroup :one
 SELECT *
 FROM groups
 WHERE id = $1;
+
+-- name: CreateLocalGroup :one
+INSERT INTO groups (id, display_name, description, object_id, source, rules)
+VALUES ($1, $2, $3, $1::text, 'local', sqlc.narg(rules))
+RETURNING *;
+
+-- name: UpdateLocalGroup :one
+UPDATE groups
+SET display_name = $2,
+    description = $3,
+    rules = sqlc.narg(rules),
+    updated_at = NOW()
+WHERE id = $1
+  AND source = 'local'
+RETURNING *;
+
+-- name: DeleteLocalGroup :execrows
+DELETE FROM groups
+WHERE id = $1
+  AND source = 'local';
+
+-- name: ListRuleGroups :many
+SELECT *
+FROM groups
+WHERE source = 'local'
+  AND rules IS NOT NULL
+ORDER BY display_name;
+
+-- name: AddManualGroupMember :exec
+INSERT INTO group_members (group_id, user_id, derived)
+VALUES ($1, $2, FALSE)
+ON CONFLICT (group_id, user_id) DO UPDATE SET derived = FALSE;
+
+-- name: DeleteManualGroupMember :execrows
+DELETE FROM group_members
+WHERE group_id = $1
+  AND user_id = $2
+  AND NOT derived;
+
+-- name: ListRuleMatchedUserIDs :many
+SELECT u.id
+FROM users u
+WHERE u.archived_at IS NULL
+  AND (cardinality(sqlc.arg(departments)::text[]) = 0
+       OR LOWER(u.department) = ANY(sqlc.arg(departments)::text[]))
+  AND (cardinality(sqlc.arg(group_ids)::uuid[]) = 0
+       OR EXISTS (
+         SELECT 1
+         FROM group_members gm
+         WHERE gm.user_id = u.id
+           AND gm.group_id = ANY(sqlc.arg(group_ids)::uuid[])
+       ))
+  AND (sqlc.narg(attributes)::jsonb IS NULL OR u.attributes @> sqlc.narg(attributes)::jsonb);
+
+-- name: DeleteStaleDerivedMembers :execrows
+DELETE FROM group_members
+WHERE group_id = $1
+  AND derived
+  AND NOT (user_id = ANY(sqlc.arg(user_ids)::uuid[]));
+
+-- name: AddDerivedGroupMembers :execrows
+INSERT INTO group_members (group_id, user_id, derived)
+SELECT $1, matched.user_id, TRUE
+FROM UNNEST(sqlc.arg(user_ids)::uuid[]) AS matched (user_id)
+ON CONFLICT (group_id, user_id) DO NOTHING;
@@ -52,6 +52,13 @@ SELECT k.id,
        l.identifier AS location_identifier,
        l.notes_enabled AS location_notes_enabled
 FROM keys k
-JOIN locations l ON l.id = ANY(k.location_ids)
+JOIN locations l ON l.id IN (SELECT location_subtree(k.location_ids))
 WHERE k.key_value = $1
   AND LOWER(l.identifier) = LOWER($2);
+
+-- name: RotateKeyValue :one
+UPDATE keys
+SET key_value = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
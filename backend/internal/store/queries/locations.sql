@@ -1,6 +1,6 @@
 -- name: CreateLocation :one
-INSERT INTO locations (id, name, identifier, group_ids, notes_enabled)
-VALUES ($1, $2, LOWER($3), $4, $5)
+INSERT INTO locations (id, name, identifier, group_ids, notes_enabled, parent_id, kind)
+VALUES ($1, $2, LOWER($3), $4, $5, sqlc.narg(parent_id), sqlc.narg(kind))
 RETURNING *;
 
 -- name: UpdateLocation :one
@@ -9,6 +9,7 @@ SET name = $2,
     identifier = LOWER($3),
     group_ids = $4,
     notes_enabled = $5,
+    kind = sqlc.narg(kind),
     updated_at = NOW()
 WHERE id = $1
 RETURNING *;
@@ -41,12 +42,13 @@ WHERE CASE
 ORDER BY name, identifier;
 
 -- name: ListLocationsForUser :many
+-- Access to a location covers its subtree.
 SELECT l.*
 FROM locations l
 WHERE (
   sqlc.arg(is_admin)::bool = TRUE
-  OR l.id = ANY(
-    SELECT u.location_ids FROM users u WHERE u.id = sqlc.arg(user_id)
+  OR l.id IN (
+    SELECT location_subtree(u.location_ids) FROM users u WHERE u.id = sqlc.arg(user_id)
   )
 )
 AND (
@@ -59,3 +61,136 @@ AND (
   END
 )
 ORDER BY l.name, l.identifier;
+
+-- name: IsLocationInSubtree :one
+SELECT EXISTS (
+  SELECT 1
+  FROM location_subtree(ARRAY[sqlc.arg(root)::uuid]) AS subtree (id)
+  WHERE subtree.id = sqlc.arg(candidate)::uuid
+) AS in_subtree;
+
+-- name: SetLocationParent :one
+UPDATE locations
+SET parent_id = sqlc.narg(parent_id),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountChildLocations :one
+SELECT COUNT(*)
+FROM locations
+WHERE parent_id = $1;
+
+-- name: ListPresence :many
+-- Users whose latest checkin since the cutoff was an "in" within the
+-- location's subtree.
+WITH latest AS (
+  SELECT DISTINCT ON (c.user_id) c.user_id, c.location_id, c.direction, c.occurred_at
+  FROM checkins c
+  WHERE c.occurred_at >= sqlc.arg(since)
+    AND c.user_id IS NOT NULL
+  ORDER BY c.user_id, c.occurred_at DESC
+)
+SELECT u.id           AS user_id,
+       u.display_name AS user_display_name,
+       u.upn          AS user_upn,
+       latest.location_id,
+       l.name         AS location_name,
+       latest.occurred_at
+FROM latest
+JOIN users u ON u.id = latest.user_id
+JOIN locations l ON l.id = latest.location_id
+WHERE latest.direction = 'in'
+  AND u.archived_at IS NULL
+  AND latest.location_id IN (SELECT location_subtree(ARRAY[sqlc.arg(location_id)::uuid]))
+ORDER BY l.name, LOWER(u.display_name);
+
+-- name: SetLocationDirectionPolicy :one
+UPDATE locations
+SET direction_policy = sqlc.narg(direction_policy),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetLocationTransferPolicy :one
+UPDATE locations
+SET transfer_policy = sqlc.narg(transfer_policy),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetLocationSchedule :one
+UPDATE locations
+SET timezone = sqlc.narg(timezone),
+    opening_hours = sqlc.narg(opening_hours),
+    out_of_hours_policy = sqlc.narg(out_of_hours_policy),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetLocationCapacity :one
+UPDATE locations
+SET capacity = sqlc.narg(capacity),
+    capacity_policy = sqlc.narg(capacity_policy),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: LockCapacityLimits :many
+-- The location and its ancestors that set a capacity. FOR UPDATE locks
+-- them in id order, serialising checkins that count against them.
+SELECT *
+FROM locations
+WHERE id IN (SELECT location_ancestors(sqlc.arg(location_id)::uuid))
+  AND capacity IS NOT NULL
+ORDER BY id
+FOR UPDATE;
+
+-- name: ListCapacityLimits :many
+SELECT *
+FROM locations
+WHERE id IN (SELECT location_ancestors(sqlc.arg(location_id)::uuid))
+  AND capacity IS NOT NULL
+ORDER BY id;
+
+-- name: CountOccupancy :one
+-- Users other than exclude_user_id whose latest checkin since the cutoff was
+-- an "in" within the location's subtree.
+SELECT COUNT(*)
+FROM (
+  SELECT DISTINCT ON (c.user_id) c.user_id, c.location_id, c.direction
+  FROM checkins c
+  WHERE c.occurred_at >= sqlc.arg(since)
+    AND c.user_id IS NOT NULL
+  ORDER BY c.user_id, c.occurred_at DESC
+) latest
+WHERE latest.direction = 'in'
+  AND latest.user_id <> sqlc.arg(exclude_user_id)::uuid
+  AND latest.location_id IN (SELECT location_subtree(ARRAY[sqlc.arg(location_id)::uuid]));
+
+-- name: ListOccupancyHistory :many
+-- Occupancy of the location's subtree at each step between from and to,
+-- counting checkins within window_seconds before each step.
+WITH steps AS (
+  SELECT generate_series(
+    sqlc.arg(from_time)::timestamptz,
+    sqlc.arg(to_time)::timestamptz,
+    sqlc.arg(step_seconds)::int * INTERVAL '1 second'
+  ) AS at
+)
+SELECT steps.at::timestamptz AS at,
+       (
+         SELECT COUNT(*)
+         FROM (
+           SELECT DISTINCT ON (c.user_id) c.location_id, c.direction
+           FROM checkins c
+           WHERE c.occurred_at <= steps.at
+             AND c.occurred_at > steps.at - sqlc.arg(window_seconds)::int * INTERVAL '1 second'
+             AND c.user_id IS NOT NULL
+           ORDER BY c.user_id, c.occurred_at DESC
+         ) latest
+         WHERE latest.direction = 'in'
+           AND latest.location_id IN (SELECT location_subtree(ARRAY[sqlc.arg(location_id)::uuid]))
+       )::int AS occupancy
+FROM steps
+ORDER BY steps.at;
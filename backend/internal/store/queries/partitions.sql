@@ -0,0 +1,30 @@
+-- name: EnsureCheckinPartition :one
+SELECT ensure_checkin_partition(sqlc.arg(day)::date)::boolean AS created;
+
+-- name: ArchiveCheckinPartition :exec
+SELECT archive_checkin_partition(sqlc.arg(name)::text);
+
+-- name: ListCheckinPartitions :many
+-- Monthly partitions, attached or archived, with the planner's row estimate.
+SELECT c.relname::text AS name,
+       (n.nspname = 'checkins_archive')::boolean AS archived,
+       GREATEST(c.reltuples, 0)::bigint AS estimated_rows
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'r'
+  AND c.relname LIKE 'checkins\_p%'
+  AND (
+    n.nspname = 'checkins_archive'
+    OR c.oid IN (SELECT i.inhrelid FROM pg_catalog.pg_inherits i WHERE i.inhparent = 'checkins'::regclass)
+  )
+ORDER BY c.relname;
+
+-- name: CountUnpartitionedCheckins :one
+-- Rows in the default partition belong to months with no partition yet.
+SELECT COUNT(*)
+FROM checkins_default;
+
+-- name: ListUnpartitionedMonths :many
+SELECT DISTINCT date_trunc('month', occurred_at AT TIME ZONE 'UTC')::date AS month
+FROM checkins_default
+ORDER BY month;
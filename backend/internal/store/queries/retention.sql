@@ -0,0 +1,111 @@
+-- name: SetLocationRetention :one
+UPDATE locations
+SET retention_days = sqlc.narg(retention_days),
+    retention_action = sqlc.narg(retention_action),
+    notes_retention_days = sqlc.narg(notes_retention_days),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountRetention :one
+-- Counts what a retention pass would change at one location. Notes on rows
+-- that expire anyway are not counted twice.
+SELECT
+  COUNT(*) FILTER (
+    WHERE occurred_at < sqlc.narg(expire_before)::timestamptz
+      AND sqlc.arg(action)::text = 'delete'
+  )::bigint AS deleted,
+  COUNT(*) FILTER (
+    WHERE occurred_at < sqlc.narg(expire_before)::timestamptz
+      AND sqlc.arg(action)::text = 'anonymise'
+      AND user_id IS NOT NULL
+  )::bigint AS anonymised,
+  COUNT(*) FILTER (
+    WHERE occurred_at < sqlc.narg(notes_before)::timestamptz
+      AND notes IS NOT NULL
+      AND (sqlc.narg(expire_before)::timestamptz IS NULL OR occurred_at >= sqlc.narg(expire_before)::timestamptz)
+  )::bigint AS notes_cleared
+FROM checkins
+WHERE location_id = sqlc.arg(location_id);
+
+-- name: DeleteExpiredCheckins :execrows
+-- Deletes one batch; SKIP LOCKED keeps the purge out of check-ins' way. The
+-- outer occurred_at bound and the (id, occurred_at) match let the batch touch
+-- only the expired partitions, here and in the updates below.
+DELETE FROM checkins
+WHERE checkins.occurred_at < sqlc.arg(before)
+  AND (checkins.id, checkins.occurred_at) IN (
+    SELECT expired.id, expired.occurred_at
+    FROM checkins expired
+    WHERE expired.location_id = sqlc.arg(location_id)
+      AND expired.occurred_at < sqlc.arg(before)
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+  );
+
+-- name: AnonymiseExpiredCheckins :execrows
+UPDATE checkins
+SET user_id = NULL,
+    notes = NULL,
+    anonymised_at = NOW()
+WHERE checkins.occurred_at < sqlc.arg(before)
+  AND (checkins.id, checkins.occurred_at) IN (
+    SELECT expired.id, expired.occurred_at
+    FROM checkins expired
+    WHERE expired.location_id = sqlc.arg(location_id)
+      AND expired.occurred_at < sqlc.arg(before)
+      AND expired.user_id IS NOT NULL
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+  );
+
+-- name: ClearExpiredNotes :execrows
+UPDATE checkins
+SET notes = NULL
+WHERE checkins.occurred_at < sqlc.arg(before)
+  AND (checkins.id, checkins.occurred_at) IN (
+    SELECT expired.id, expired.occurred_at
+    FROM checkins expired
+    WHERE expired.location_id = sqlc.arg(location_id)
+      AND expired.occurred_at < sqlc.arg(before)
+      AND expired.notes IS NOT NULL
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+  );
+
+-- name: CreateRetentionRun :one
+INSERT INTO retention_runs DEFAULT VALUES
+RETURNING *;
+
+-- name: FinishRetentionRun :one
+UPDATE retention_runs
+SET status = $2,
+    deleted = $3,
+    anonymised = $4,
+    notes_cleared = $5,
+    error = $6,
+    finished_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: FailInterruptedRetentionRuns :exec
+UPDATE retention_runs
+SET status = 'failed',
+    finished_at = NOW(),
+    error = 'interrupted'
+WHERE status = 'running';
+
+-- name: ListRetentionRuns :many
+SELECT *
+FROM retention_runs
+ORDER BY started_at DESC
+LIMIT $1;
+
+-- name: ArchivedRetention :one
+SELECT archived_retention(
+  sqlc.arg(location_id)::uuid,
+  sqlc.arg(step)::text,
+  sqlc.arg(before)::timestamptz,
+  sqlc.narg(expire_before)::timestamptz,
+  sqlc.arg(dry_run)::boolean
+)::bigint AS changed;
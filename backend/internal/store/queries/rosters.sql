@@ -0,0 +1,78 @@
+-- name: LockRosterVersion :one
+INSERT INTO roster_versions (location_id)
+VALUES ($1)
+ON CONFLICT (location_id)
+DO UPDATE SET location_id = EXCLUDED.location_id
+RETURNING version;
+
+-- name: GetRosterVersion :one
+SELECT COALESCE(
+  (SELECT version FROM roster_versions WHERE location_id = $1),
+  0
+)::bigint AS version;
+
+-- name: SetRosterVersion :exec
+UPDATE roster_versions
+SET version = sqlc.arg(version),
+    updated_at = NOW()
+WHERE location_id = sqlc.arg(location_id);
+
+-- name: ApplyRosterChanges :one
+WITH current_roster AS (
+  SELECT
+    u.id AS user_id,
+    md5(ROW(u.display_name, u.upn, u.department, u.employee_id, u.job_title, u.attributes)::text) AS digest
+  FROM users u
+  WHERE u.archived_at IS NULL
+    AND EXISTS (
+      SELECT 1
+      FROM group_members gm
+      JOIN locations l ON gm.group_id = ANY(l.group_ids)
+      WHERE l.id = sqlc.arg(location_id)
+        AND gm.user_id = u.id
+    )
+),
+upserted AS (
+  INSERT INTO roster_entries (location_id, user_id, digest, added_version, changed_version)
+  SELECT sqlc.arg(location_id), cr.user_id, cr.digest, sqlc.arg(version), sqlc.arg(version)
+  FROM current_roster cr
+  ON CONFLICT (location_id, user_id)
+  DO UPDATE SET
+    digest = EXCLUDED.digest,
+    added_version = CASE
+      WHEN roster_entries.removed_version IS NULL THEN roster_entries.added_version
+      ELSE EXCLUDED.added_version
+    END,
+    changed_version = EXCLUDED.changed_version,
+    removed_version = NULL
+  WHERE roster_entries.removed_version IS NOT NULL
+     OR roster_entries.digest <> EXCLUDED.digest
+  RETURNING 1
+),
+removed AS (
+  UPDATE roster_entries re
+  SET removed_version = sqlc.arg(version),
+      changed_version = sqlc.arg(version)
+  WHERE re.location_id = sqlc.arg(location_id)
+    AND re.removed_version IS NULL
+    AND NOT EXISTS (SELECT 1 FROM current_roster cr WHERE cr.user_id = re.user_id)
+  RETURNING 1
+)
+SELECT ((SELECT COUNT(*) FROM upserted) + (SELECT COUNT(*) FROM removed))::bigint AS changed;
+
+-- name: ListRosterChanges :many
+SELECT sqlc.embed(u), re.added_version
+FROM roster_entries re
+JOIN users u ON u.id = re.user_id
+WHERE re.location_id = sqlc.arg(location_id)
+  AND re.removed_version IS NULL
+  AND re.changed_version > sqlc.arg(since)
+  AND re.changed_version <= sqlc.arg(version)
+ORDER BY LOWER(COALESCE(u.display_name, u.upn)), u.upn;
+
+-- name: ListRosterRemovals :many
+SELECT user_id
+FROM roster_entries
+WHERE location_id = sqlc.arg(location_id)
+  AND removed_version > sqlc.arg(since)::bigint
+  AND removed_version <= sqlc.arg(version)::bigint;
@@ -0,0 +1,20 @@
+-- name: ListSettings :many
+SELECT * FROM settings ORDER BY key;
+
+-- name: GetSettingsVersion :one
+SELECT COALESCE(MAX(version), 0)::bigint FROM settings;
+
+-- name: SaveSetting :one
+-- A NULL expected_version skips the version check; 0 expects the setting to
+-- never have been written.
+INSERT INTO settings AS s (key, value, updated_by)
+SELECT sqlc.arg(key)::text, sqlc.narg(value)::jsonb, sqlc.narg(updated_by)::uuid
+WHERE COALESCE(sqlc.narg(expected_version)::bigint, 0) = 0
+ON CONFLICT (key) DO UPDATE
+SET value = EXCLUDED.value,
+    version = nextval('settings_version_seq'),
+    updated_by = EXCLUDED.updated_by,
+    updated_at = NOW()
+WHERE sqlc.narg(expected_version)::bigint IS NULL
+   OR s.version = sqlc.narg(expected_version)::bigint
+RETURNING *;
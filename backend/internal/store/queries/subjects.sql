@@ -0,0 +1,91 @@
+-- name: ListSubjectGroups :many
+SELECT g.id,
+       g.display_name,
+       g.source,
+       gm.derived,
+       gm.created_at
+FROM group_members gm
+JOIN groups g ON g.id = gm.group_id
+WHERE gm.user_id = $1
+ORDER BY g.display_name;
+
+-- name: ListSubjectLocations :many
+-- Locations the user was granted directly; access covers their subtrees.
+SELECT l.id,
+       l.name,
+       l.identifier
+FROM locations l
+WHERE l.id = ANY((SELECT u.location_ids FROM users u WHERE u.id = $1)::uuid[])
+ORDER BY l.name;
+
+-- name: ListSubjectCheckins :many
+SELECT c.id,
+       c.location_id,
+       l.name       AS location_name,
+       l.identifier AS location_identifier,
+       c.key_id,
+       c.direction,
+       c.notes,
+       c.occurred_at,
+       c.created_at,
+       c.out_of_hours
+FROM checkins c
+JOIN locations l ON l.id = c.location_id
+WHERE c.user_id = sqlc.arg(user_id)::uuid
+ORDER BY c.occurred_at;
+
+-- name: ListSubjectWaitlist :many
+SELECT w.location_id,
+       l.name AS location_name,
+       w.created_at
+FROM location_waitlist w
+JOIN locations l ON l.id = w.location_id
+WHERE w.user_id = $1
+ORDER BY w.created_at;
+
+-- name: ListSubjectSyncRuns :many
+SELECT id, trigger, status, started_at
+FROM sync_runs
+WHERE triggered_by = $1
+ORDER BY started_at;
+
+-- name: ListSubjectSettings :many
+SELECT key, updated_at
+FROM settings
+WHERE updated_by = $1
+ORDER BY key;
+
+-- name: AnonymiseUserCheckins :execrows
+UPDATE checkins
+SET user_id = NULL,
+    notes = NULL,
+    anonymised_at = NOW()
+WHERE user_id = sqlc.arg(user_id)::uuid;
+
+-- name: DeleteUserMemberships :execrows
+DELETE FROM group_members
+WHERE user_id = $1;
+
+-- name: ClearManagerReferences :exec
+-- manager_id has no foreign key, so reports are unlinked by hand.
+UPDATE users
+SET manager_id = NULL
+WHERE manager_id = $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: CreateUserErasure :one
+INSERT INTO user_erasures (subject_id, reference, erased_by, checkins_anonymised, memberships_removed)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListUserErasures :many
+SELECT *
+FROM user_erasures
+ORDER BY erased_at DESC
+LIMIT $1;
+
+-- name: AnonymiseArchivedCheckins :one
+SELECT anonymise_archived_checkins(sqlc.arg(user_id)::uuid)::bigint AS anonymised;
@@ -0,0 +1,56 @@
+-- name: GetSyncState :one
+SELECT *
+FROM sync_state
+WHERE name = $1;
+
+-- name: SaveSyncState :exec
+INSERT INTO sync_state (name, delta_link, full_synced_at, fingerprint)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (name)
+DO UPDATE SET
+  delta_link = EXCLUDED.delta_link,
+  fingerprint = EXCLUDED.fingerprint,
+  full_synced_at = COALESCE(EXCLUDED.full_synced_at, sync_state.full_synced_at);
+
+-- name: DeleteSyncState :exec
+DELETE FROM sync_state WHERE name = $1;
+
+-- name: CreateSyncRun :one
+INSERT INTO sync_runs (trigger, triggered_by)
+VALUES ($1, $2)
+RETURNING *;
+
+-- name: FinishSyncRun :one
+UPDATE sync_runs
+SET status = $2,
+    finished_at = NOW(),
+    users_created = $3,
+    users_updated = $4,
+    users_archived = $5,
+    groups_created = $6,
+    groups_updated = $7,
+    groups_deleted = $8,
+    error = $9,
+    diff = $10
+WHERE id = $1
+RETURNING *;
+
+-- name: FailInterruptedSyncRuns :exec
+UPDATE sync_runs
+SET status = 'failed',
+    finished_at = NOW(),
+    error = 'interrupted'
+WHERE status = 'running';
+
+-- name: ListSyncRuns :many
+SELECT *
+FROM sync_runs
+ORDER BY started_at DESC
+LIMIT $1;
+
+-- name: GetLastSuccessfulSyncRun :one
+SELECT *
+FROM sync_runs
+WHERE status = 'succeeded'
+ORDER BY started_at DESC
+LIMIT 1;
@@ -1,6 +1,7 @@
 -- name: UpsertUser :one
-INSERT INTO users (id, upn, display_name, object_id, department, is_admin, location_ids)
-VALUES ($1, $2, $3, $4, $5, COALESCE(sqlc.narg(is_admin), FALSE), COALESCE(sqlc.narg(location_ids)::uuid[], '{}'))
+INSERT INTO users (id, upn, display_name, object_id, department, is_admin, location_ids, source)
+VALUES ($1, $2, $3, $4, $5, COALESCE(sqlc.narg(is_admin), FALSE), COALESCE(sqlc.narg(location_ids)::uuid[], '{}'),
+        sqlc.narg(source))
 ON CONFLICT (id)
 DO UPDATE SET
   upn = EXCLUDED.upn,
@@ -9,13 +10,24 @@ DO UPDATE SET
   department = EXCLUDED.department,
   is_admin = COALESCE(sqlc.narg(is_admin), users.is_admin),
   location_ids = COALESCE(sqlc.narg(location_ids)::uuid[], users.location_ids),
+  source = COALESCE(sqlc.narg(source), users.source),
+  archived_at = CASE WHEN sqlc.arg(unarchive)::boolean THEN NULL ELSE users.archived_at END,
+  archive_reason = CASE WHEN sqlc.arg(unarchive)::boolean THEN NULL ELSE users.archive_reason END,
   updated_at = NOW()
 RETURNING *;
 
+-- name: CreateUser :one
+INSERT INTO users (id, upn, display_name, object_id, department, location_ids, source)
+VALUES ($1, $2, $3, $4, $5, COALESCE(sqlc.arg(location_ids)::uuid[], '{}'), sqlc.arg(source))
+ON CONFLICT DO NOTHING
+RETURNING *;
+
 -- name: ListUsers :many
 SELECT *
 FROM users
-WHERE CASE
+WHERE (sqlc.arg(include_archived)::bool OR archived_at IS NULL)
+AND (sqlc.narg(attributes)::jsonb IS NULL OR attributes @> sqlc.narg(attributes)::jsonb)
+AND CASE
         WHEN sqlc.arg(search)::text = '' THEN TRUE
         ELSE (
             to_tsvector('simple', coalesce(display_name, '') || ' ' || coalesce(upn, '')) @@ websearch_to_tsquery('simple', sqlc.arg(search)::text)
@@ -26,46 +38,100 @@ WHERE CASE
 ORDER BY display_name;
 
 -- name: GetUser :one
-SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at
+SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at,
+       archived_at, archive_reason, employee_id, job_title, manager_id, attributes, source
 FROM users
 WHERE id = $1;
 
 -- name: GetUserByUPN :one
-SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at
+SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at,
+       archived_at, archive_reason, employee_id, job_title, manager_id, attributes, source
 FROM users
 WHERE LOWER(upn) = LOWER($1);
 
 -- name: GetUserByLogin :one
-SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at
+SELECT id, upn, display_name, object_id, department, is_admin, location_ids, created_at, updated_at,
+       archived_at, archive_reason, employee_id, job_title, manager_id, attributes, source
 FROM users
 WHERE LOWER(split_part(upn, '@', 1)) = LOWER($1);
 
+-- name: GetUserByIdentity :one
+SELECT u.id, u.upn, u.display_name, u.object_id, u.department, u.is_admin, u.location_ids, u.created_at,
+       u.updated_at, u.archived_at, u.archive_reason, u.employee_id, u.job_title, u.manager_id, u.attributes,
+       u.source
+FROM user_identities i
+JOIN users u ON u.id = i.user_id
+WHERE i.provider = $1 AND i.subject = $2;
+
+-- name: LinkUserIdentity :exec
+INSERT INTO user_identities (provider, subject, user_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (provider, subject)
+DO UPDATE SET last_login_at = NOW();
+
 -- name: GetUserGroups :many
 SELECT g.id,
        g.display_name,
        g.description,
        g.object_id,
        g.created_at,
-       g.updated_at
+       g.updated_at,
+       g.source,
+       g.rules
 FROM groups g
 JOIN group_members gm ON g.id = gm.group_id
 WHERE gm.user_id = $1
 ORDER BY g.display_name;
 
--- name: DeleteUser :exec
-DELETE
+-- name: SetUserDirectoryAttributes :exec
+UPDATE users
+SET employee_id = NULLIF(sqlc.arg(employee_id)::text, ''),
+    job_title = NULLIF(sqlc.arg(job_title)::text, ''),
+    manager_id = CASE
+      WHEN sqlc.arg(set_manager)::bool THEN sqlc.narg(manager_id)::uuid
+      ELSE manager_id
+    END,
+    attributes = sqlc.arg(attributes)::jsonb
+WHERE id = sqlc.arg(id);
+
+-- name: ListSyncedUsers :many
+SELECT id, object_id, archived_at, archive_reason
 FROM users
-WHERE id = $1;
+WHERE source = $1
+  AND object_id IS NOT NULL;
 
--- name: DeleteUserByUPN :exec
+-- name: ArchiveUser :execrows
+UPDATE users
+SET archived_at = NOW(),
+    archive_reason = $2
+WHERE id = $1
+  AND archived_at IS NULL;
+
+-- name: ArchiveUserByUPN :execrows
+UPDATE users
+SET archived_at = NOW(),
+    archive_reason = $2
+WHERE LOWER(upn) = LOWER($1)
+  AND archived_at IS NULL;
+
+-- name: LockPurgeableUsers :many
+SELECT id
+FROM users
+WHERE archived_at IS NOT NULL
+  AND archived_at < sqlc.arg(archived_before)::timestamptz
+ORDER BY id
+FOR UPDATE;
+
+-- name: PurgeArchivedUsers :execrows
 DELETE
 FROM users
-WHERE LOWER(upn) = LOWER($1);
+WHERE id = ANY(sqlc.arg(ids)::uuid[]);
 
 -- name: ListUsersForLocations :many
 SELECT DISTINCT u.*
 FROM users u
-WHERE (
+WHERE u.archived_at IS NULL
+AND (
   sqlc.arg(is_admin)::bool = TRUE
   OR (
     sqlc.narg(location_ids)::uuid[] IS NOT NULL
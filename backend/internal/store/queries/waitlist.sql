@@ -0,0 +1,37 @@
+-- name: AddToWaitlist :exec
+INSERT INTO location_waitlist (location_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT (location_id, user_id) DO NOTHING;
+
+-- name: GetWaitlistPosition :one
+SELECT COUNT(*)
+FROM location_waitlist w
+WHERE w.location_id = $1
+  AND w.created_at <= (
+    SELECT mine.created_at
+    FROM location_waitlist mine
+    WHERE mine.location_id = $1
+      AND mine.user_id = $2
+  );
+
+-- name: RemoveFromWaitlist :exec
+DELETE
+FROM location_waitlist
+WHERE user_id = $1
+  AND location_id = ANY(sqlc.arg(location_ids)::uuid[]);
+
+-- name: DeleteExpiredWaitlist :exec
+DELETE
+FROM location_waitlist
+WHERE created_at < sqlc.arg(before);
+
+-- name: ListWaitlist :many
+SELECT w.user_id,
+       u.display_name AS user_display_name,
+       u.upn          AS user_upn,
+       w.created_at
+FROM location_waitlist w
+JOIN users u ON u.id = w.user_id
+WHERE w.location_id = $1
+  AND w.created_at >= sqlc.arg(since)
+ORDER BY w.created_at;
@@ -0,0 +1,133 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+	"github.com/woodleighschool/signin-ui/internal/store/storetest"
+)
+
+// seedRoster creates a location whose groups each hold perGroup users, half
+// of them shared with the next group, and returns its ID.
+func seedRoster(tb testing.TB, db *store.Store, groups, perGroup int) uuid.UUID {
+	tb.Helper()
+	ctx := context.Background()
+	users := make([]uuid.UUID, groups*perGroup/2+perGroup)
+	for i := range users {
+		user, err := db.UpsertUser(ctx, sqlc.UpsertUserParams{
+			ID:          uuid.New(),
+			Upn:         uuid.NewString() + "@bench.example",
+			DisplayName: fmt.Sprintf("User %d", i),
+		})
+		if err != nil {
+			tb.Fatalf("create user: %v", err)
+		}
+		users[i] = user.ID
+	}
+	groupIDs := make([]uuid.UUID, groups)
+	for i := range groupIDs {
+		group, err := db.UpsertGroup(ctx, sqlc.UpsertGroupParams{
+			ID:          uuid.New(),
+			DisplayName: fmt.Sprintf("Group %d", i),
+			Source:      pgtype.Text{String: store.SourceLocal, Valid: true},
+		})
+		if err != nil {
+			tb.Fatalf("create group: %v", err)
+		}
+		start := i * perGroup / 2
+		if _, _, err = db.ReplaceGroupMembers(ctx, group.ID, users[start:start+perGroup]); err != nil {
+			tb.Fatalf("add group members: %v", err)
+		}
+		groupIDs[i] = group.ID
+	}
+	loc, err := db.CreateLocation(ctx, sqlc.CreateLocationParams{
+		ID:       uuid.New(),
+		Name:     "Roster bench",
+		Lower:    "roster-bench-" + uuid.NewString(),
+		GroupIds: groupIDs,
+	})
+	if err != nil {
+		tb.Fatalf("create location: %v", err)
+	}
+	return loc.ID
+}
+
+// rosterPerGroup is how rosters were built before the single query: one
+// query for the location's groups and one per group for its members.
+func rosterPerGroup(ctx context.Context, db *store.Store, locationID uuid.UUID) ([]sqlc.User, error) {
+	groupIDs, err := db.ListLocationGroupIDs(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uuid.UUID]struct{})
+	var users []sqlc.User
+	for _, groupID := range groupIDs {
+		members, err := db.ListGroupMembers(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if _, ok := seen[m.ID]; ok {
+				continue
+			}
+			seen[m.ID] = struct{}{}
+			users = append(users, m)
+		}
+	}
+	return users, nil
+}
+
+func BenchmarkRosterBuild(b *testing.B) {
+	db := storetest.Open(b)
+	ctx := context.Background()
+	for _, size := range []struct{ groups, perGroup int }{{1, 30}, {10, 30}, {40, 30}} {
+		locationID := seedRoster(b, db, size.groups, size.perGroup)
+		name := fmt.Sprintf("groups=%d", size.groups)
+		b.Run(name+"/one-query", func(b *testing.B) {
+			for b.Loop() {
+				if _, err := db.Roster(ctx, locationID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/per-group", func(b *testing.B) {
+			for b.Loop() {
+				if _, err := rosterPerGroup(ctx, db, locationID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRosterMatchesPerGroupBuild(t *testing.T) {
+	db := storetest.Open(t)
+	ctx := context.Background()
+	locationID := seedRoster(t, db, 4, 6)
+
+	roster, err := db.Roster(ctx, locationID)
+	if err != nil {
+		t.Fatalf("roster: %v", err)
+	}
+	want, err := rosterPerGroup(ctx, db, locationID)
+	if err != nil {
+		t.Fatalf("per-group roster: %v", err)
+	}
+	if len(roster.Users) != len(want) || len(want) != 15 {
+		t.Fatalf("roster has %d users, per-group build %d; want 15", len(roster.Users), len(want))
+	}
+	got := make(map[uuid.UUID]bool, len(roster.Users))
+	for _, u := range roster.Users {
+		got[u.ID] = true
+	}
+	for _, u := range want {
+		if !got[u.ID] {
+			t.Errorf("roster missing %s", u.ID)
+		}
+	}
+}
@@ -1,18 +1,38 @@
 package store
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/branding"
+	"github.com/woodleighschool/signin-ui/internal/schedule"
 	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
 )
 
-func (s *Store) ListUsers(ctx context.Context, search string) ([]sqlc.User, error) {
-	return s.queries.ListUsers(ctx, strings.TrimSpace(search))
+// ListUsers searches users, optionally matching mapped attribute values.
+// Archived users are only included when asked for.
+func (s *Store) ListUsers(
+	ctx context.Context,
+	search string,
+	includeArchived bool,
+	attributes map[string]string,
+) ([]sqlc.User, error) {
+	return s.queries.ListUsers(ctx, sqlc.ListUsersParams{
+		IncludeArchived: includeArchived,
+		Attributes:      attributeFilter(attributes),
+		Search:          strings.TrimSpace(search),
+	})
 }
 
 func (s *Store) ListUsersForLocations(
@@ -36,22 +56,247 @@ func (s *Store) GetUserByUPN(ctx context.Context, upn string) (sqlc.User, error)
 	return s.queries.GetUserByUPN(ctx, upn)
 }
 
-func (s *Store) GetUserByLogin(ctx context.Context, login string) (sqlc.User, error) {
-	return s.queries.GetUserByLogin(ctx, login)
+// ErrIdentityNotLinked means a login matches an existing user, but its
+// provider may not claim existing users.
+var ErrIdentityNotLinked = errors.New("store: identity is not linked to the user")
+
+// Identity is a login provider's user, as asserted at sign-in.
+type Identity struct {
+	Provider string
+	Subject  string
+	Login    string
+	// MatchExisting lets an identity seen for the first time link to the
+	// existing user with the same login.
+	MatchExisting bool
+}
+
+// SignIn returns the user an identity signs in as. Returns pgx.ErrNoRows
+// when no user matches.
+func (s *Store) SignIn(ctx context.Context, identity Identity) (sqlc.User, error) {
+	var user sqlc.User
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		user, err = signIn(ctx, sqlc.New(tx), identity)
+		return err
+	})
+	return user, err
+}
+
+// signIn finds the user linked to the identity, linking it to the user with
+// the same login when its provider may match existing users. A bare login
+// matches the local part of a UPN.
+func signIn(ctx context.Context, q *sqlc.Queries, identity Identity) (sqlc.User, error) {
+	user, err := q.GetUserByIdentity(ctx, sqlc.GetUserByIdentityParams{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	})
+	if !errors.Is(err, pgx.ErrNoRows) {
+		if err == nil {
+			err = linkIdentity(ctx, q, identity, user.ID)
+		}
+		return user, err
+	}
+	if strings.Contains(identity.Login, "@") {
+		user, err = q.GetUserByUPN(ctx, identity.Login)
+	} else {
+		user, err = q.GetUserByLogin(ctx, identity.Login)
+	}
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	if !identity.MatchExisting {
+		return sqlc.User{}, ErrIdentityNotLinked
+	}
+	return user, linkIdentity(ctx, q, identity, user.ID)
+}
+
+// linkIdentity records the identity against the user, or its latest sign-in
+// when already linked.
+func linkIdentity(ctx context.Context, q *sqlc.Queries, identity Identity, userID uuid.UUID) error {
+	return q.LinkUserIdentity(ctx, sqlc.LinkUserIdentityParams{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   userID,
+	})
+}
+
+// DirectoryAttributes are the mapped Entra ID attributes stored on a user.
+type DirectoryAttributes struct {
+	EmployeeID string
+	JobTitle   string
+	// SetManager replaces the manager with ManagerID; uuid.Nil clears it.
+	SetManager bool
+	ManagerID  uuid.UUID
+	Attributes map[string]string
+}
+
+// SetUserDirectoryAttributes stores mapped attributes, replacing previous ones.
+func (s *Store) SetUserDirectoryAttributes(ctx context.Context, id uuid.UUID, attrs DirectoryAttributes) error {
+	values := attrs.Attributes
+	if values == nil {
+		values = map[string]string{}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return s.queries.SetUserDirectoryAttributes(ctx, sqlc.SetUserDirectoryAttributesParams{
+		ID:         id,
+		EmployeeID: attrs.EmployeeID,
+		JobTitle:   attrs.JobTitle,
+		SetManager: attrs.SetManager,
+		ManagerID:  pgtype.UUID{Bytes: attrs.ManagerID, Valid: attrs.ManagerID != uuid.Nil},
+		Attributes: raw,
+	})
+}
+
+// ListSyncedUsers returns the archive state of every user synced from source.
+func (s *Store) ListSyncedUsers(ctx context.Context, source string) ([]sqlc.ListSyncedUsersRow, error) {
+	return s.queries.ListSyncedUsers(ctx, pgtype.Text{String: source, Valid: true})
+}
+
+// ArchiveUser soft-deletes a user, keeping their checkins. It reports whether
+// an active user was archived.
+func (s *Store) ArchiveUser(ctx context.Context, id uuid.UUID, reason string) (bool, error) {
+	rows, err := s.queries.ArchiveUser(ctx, sqlc.ArchiveUserParams{
+		ID:            id,
+		ArchiveReason: pgtype.Text{String: reason, Valid: reason != ""},
+	})
+	return rows > 0, err
 }
 
-func (s *Store) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	return s.queries.DeleteUser(ctx, id)
+// ArchiveUserByUPN soft-deletes a user by UPN.
+func (s *Store) ArchiveUserByUPN(ctx context.Context, upn, reason string) (bool, error) {
+	rows, err := s.queries.ArchiveUserByUPN(ctx, sqlc.ArchiveUserByUPNParams{
+		Lower:         upn,
+		ArchiveReason: pgtype.Text{String: reason, Valid: reason != ""},
+	})
+	return rows > 0, err
 }
 
-func (s *Store) DeleteUserByUPN(ctx context.Context, upn string) error {
-	return s.queries.DeleteUserByUPN(ctx, upn)
+// PurgeArchivedUsers permanently deletes users archived before the cutoff.
+// Their checkins are kept, anonymised as for an erasure, so attendance
+// history survives the purge.
+func (s *Store) PurgeArchivedUsers(ctx context.Context, archivedBefore time.Time) (int64, error) {
+	var purged int64
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		ids, err := q.LockPurgeableUsers(ctx, pgtype.Timestamptz{Time: archivedBefore, Valid: true})
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		for _, id := range ids {
+			if _, err = q.AnonymiseUserCheckins(ctx, id); err != nil {
+				return err
+			}
+			if _, err = q.AnonymiseArchivedCheckins(ctx, id); err != nil {
+				return err
+			}
+			if err = q.ClearManagerReferences(ctx, pgtype.UUID{Bytes: id, Valid: true}); err != nil {
+				return err
+			}
+		}
+		purged, err = q.PurgeArchivedUsers(ctx, ids)
+		return err
+	})
+	return purged, err
 }
 
 func (s *Store) UpsertUser(ctx context.Context, user sqlc.UpsertUserParams) (sqlc.User, error) {
 	return s.queries.UpsertUser(ctx, user)
 }
 
+// SourceJIT marks users created by just-in-time provisioning at login.
+const SourceJIT = "jit"
+
+// ProvisionUserParams describe a user created or refreshed at login.
+type ProvisionUserParams struct {
+	ID                 uuid.UUID
+	UPN                string
+	DisplayName        string
+	ObjectID           string
+	Department         string
+	DefaultLocationIDs []uuid.UUID
+}
+
+// ProvisionUser signs the identity in, creating its user from login claims
+// when none matches, or refreshing the matched user's profile. Existing
+// users keep their UPN, admin flag and location access, and archived users
+// stay archived: only the directory or SCIM can bring them back. Creation is
+// insert-only, so claims naming another user's ID or UPN return
+// ErrIdentityNotLinked instead of overwriting that user.
+func (s *Store) ProvisionUser(
+	ctx context.Context,
+	identity Identity,
+	params ProvisionUserParams,
+) (sqlc.User, error) {
+	var provisioned sqlc.User
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		objectID := pgtype.Text{String: params.ObjectID, Valid: params.ObjectID != ""}
+		department := pgtype.Text{String: params.Department, Valid: params.Department != ""}
+		existing, err := signIn(ctx, q, identity)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			provisioned, err = createJITUser(ctx, q, params, objectID, department)
+			if err != nil {
+				return err
+			}
+			return linkIdentity(ctx, q, identity, provisioned.ID)
+		case err != nil:
+			return err
+		}
+		if !objectID.Valid {
+			objectID = existing.ObjectID
+		}
+		if !department.Valid {
+			department = existing.Department
+		}
+		provisioned, err = q.UpsertUser(ctx, sqlc.UpsertUserParams{
+			ID:          existing.ID,
+			Upn:         existing.Upn,
+			DisplayName: params.DisplayName,
+			ObjectID:    objectID,
+			Department:  department,
+		})
+		return err
+	})
+	return provisioned, err
+}
+
+// createJITUser inserts a new user from login claims. It never touches an
+// existing row: a claimed ID or UPN that is already taken belongs to an
+// account this identity has not been linked to, so it is refused.
+func createJITUser(
+	ctx context.Context,
+	q *sqlc.Queries,
+	params ProvisionUserParams,
+	objectID, department pgtype.Text,
+) (sqlc.User, error) {
+	if _, err := q.GetUserByUPN(ctx, params.UPN); err == nil {
+		return sqlc.User{}, ErrIdentityNotLinked
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.User{}, err
+	}
+	id := params.ID
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+	created, err := q.CreateUser(ctx, sqlc.CreateUserParams{
+		ID:          id,
+		Upn:         params.UPN,
+		DisplayName: params.DisplayName,
+		ObjectID:    objectID,
+		Department:  department,
+		LocationIds: params.DefaultLocationIDs,
+		Source:      pgtype.Text{String: SourceJIT, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.User{}, ErrIdentityNotLinked
+	}
+	return created, err
+}
+
 // UpdateUserAccess changes admin flag and locations in one transaction.
 func (s *Store) UpdateUserAccess(
 	ctx context.Context,
@@ -105,27 +350,293 @@ func (s *Store) UpsertGroup(ctx context.Context, group sqlc.UpsertGroupParams) (
 	return s.queries.UpsertGroup(ctx, group)
 }
 
-func (s *Store) DeleteGroup(ctx context.Context, id uuid.UUID) error {
-	return s.queries.DeleteGroup(ctx, id)
+// DeleteGroup removes a group and reports whether a row existed.
+func (s *Store) DeleteGroup(ctx context.Context, id uuid.UUID) (bool, error) {
+	rows, err := s.queries.DeleteGroup(ctx, id)
+	return rows > 0, err
 }
 
-// ReplaceGroupMembers resets a group's members in one transaction.
-func (s *Store) ReplaceGroupMembers(ctx context.Context, groupID uuid.UUID, userIDs []uuid.UUID) error {
-	return s.WithTx(ctx, func(tx pgx.Tx) error {
+// ReplaceGroupMembers sets a group's members in one transaction, reporting
+// how many memberships were added and removed. An empty list empties the group.
+func (s *Store) ReplaceGroupMembers(
+	ctx context.Context,
+	groupID uuid.UUID,
+	userIDs []uuid.UUID,
+) (added, removed int, err error) {
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
 		queries := sqlc.New(tx)
-		if err := queries.DeleteGroupMembers(ctx, groupID); err != nil {
-			return err
+		existing, listErr := queries.ListGroupMemberIDs(ctx, groupID)
+		if listErr != nil {
+			return listErr
 		}
+		current := make(map[uuid.UUID]struct{}, len(existing))
+		for _, userID := range existing {
+			current[userID] = struct{}{}
+		}
+		wanted := make(map[uuid.UUID]struct{}, len(userIDs))
 		for _, userID := range userIDs {
-			if err := queries.AddGroupMember(ctx, sqlc.AddGroupMemberParams{
+			wanted[userID] = struct{}{}
+		}
+		for userID := range current {
+			if _, keep := wanted[userID]; keep {
+				continue
+			}
+			if delErr := queries.DeleteGroupMember(ctx, sqlc.DeleteGroupMemberParams{
 				GroupID: groupID,
 				UserID:  userID,
-			}); err != nil {
-				return err
+			}); delErr != nil {
+				return delErr
+			}
+			removed++
+		}
+		for userID := range wanted {
+			if _, exists := current[userID]; exists {
+				continue
+			}
+			rows, addErr := queries.AddGroupMember(ctx, sqlc.AddGroupMemberParams{
+				GroupID: groupID,
+				UserID:  userID,
+			})
+			if addErr != nil {
+				return addErr
 			}
+			added += int(rows)
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, removed, nil
+}
+
+// ListSyncedGroups returns groups that came from the given directory source.
+func (s *Store) ListSyncedGroups(ctx context.Context, source string) ([]sqlc.Group, error) {
+	return s.queries.ListSyncedGroups(ctx, pgtype.Text{String: source, Valid: true})
+}
+
+// SourceLocal marks groups managed in the admin UI. The directory sync and
+// SCIM never update or delete them.
+const SourceLocal = "local"
+
+// GroupRules derive a local group's members from the directory. Each set
+// field must match; within a field any listed value matches.
+type GroupRules struct {
+	Departments []string          `json:"departments,omitempty"`
+	MemberOf    []uuid.UUID       `json:"memberOf,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// Empty reports whether the rules match nothing in particular.
+func (r GroupRules) Empty() bool {
+	return len(r.Departments) == 0 && len(r.MemberOf) == 0 && len(r.Attributes) == 0
+}
+
+// DecodeGroupRules reads a group's stored rules, reporting false when it has
+// none.
+func DecodeGroupRules(raw []byte) (GroupRules, bool) {
+	var rules GroupRules
+	if len(raw) == 0 || json.Unmarshal(raw, &rules) != nil || rules.Empty() {
+		return GroupRules{}, false
+	}
+	return rules, true
+}
+
+// LocalGroupParams describe a local group. Nil or empty rules make every
+// member manual.
+type LocalGroupParams struct {
+	ID          uuid.UUID
+	DisplayName string
+	Description string
+	Rules       *GroupRules
+}
+
+// GroupMembershipChange counts members a rule refresh added and removed.
+type GroupMembershipChange struct {
+	GroupID     uuid.UUID
+	DisplayName string
+	Added       int
+	Removed     int
+}
+
+// CreateLocalGroup creates a local group with its manual members and applies
+// its rules.
+func (s *Store) CreateLocalGroup(
+	ctx context.Context,
+	params LocalGroupParams,
+	memberIDs []uuid.UUID,
+) (sqlc.Group, error) {
+	rules, err := encodeGroupRules(params.Rules)
+	if err != nil {
+		return sqlc.Group{}, err
+	}
+	var group sqlc.Group
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		var createErr error
+		group, createErr = q.CreateLocalGroup(ctx, sqlc.CreateLocalGroupParams{
+			ID:          params.ID,
+			DisplayName: params.DisplayName,
+			Description: pgtype.Text{String: params.Description, Valid: params.Description != ""},
+			Rules:       rules,
+		})
+		if createErr != nil {
+			return createErr
+		}
+		if createErr = addManualMembers(ctx, q, group.ID, memberIDs); createErr != nil {
+			return createErr
+		}
+		_, _, createErr = refreshRuleMembers(ctx, q, group)
+		return createErr
+	})
+	return group, err
+}
+
+// UpdateLocalGroup changes a local group's details and rules, re-deriving its
+// rule members. It returns pgx.ErrNoRows for groups that are not local.
+func (s *Store) UpdateLocalGroup(ctx context.Context, params LocalGroupParams) (sqlc.Group, error) {
+	rules, err := encodeGroupRules(params.Rules)
+	if err != nil {
+		return sqlc.Group{}, err
+	}
+	var group sqlc.Group
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		var updateErr error
+		group, updateErr = q.UpdateLocalGroup(ctx, sqlc.UpdateLocalGroupParams{
+			ID:          params.ID,
+			DisplayName: params.DisplayName,
+			Description: pgtype.Text{String: params.Description, Valid: params.Description != ""},
+			Rules:       rules,
+		})
+		if updateErr != nil {
+			return updateErr
+		}
+		_, _, updateErr = refreshRuleMembers(ctx, q, group)
+		return updateErr
+	})
+	return group, err
+}
+
+// DeleteLocalGroup removes a local group and reports whether it existed.
+func (s *Store) DeleteLocalGroup(ctx context.Context, id uuid.UUID) (bool, error) {
+	rows, err := s.queries.DeleteLocalGroup(ctx, id)
+	return rows > 0, err
+}
+
+// AddLocalGroupMembers adds manual members to a local group. Members already
+// derived from rules become manual, so they stay when the rules change.
+func (s *Store) AddLocalGroupMembers(ctx context.Context, groupID uuid.UUID, userIDs []uuid.UUID) error {
+	return s.WithTx(ctx, func(tx pgx.Tx) error {
+		return addManualMembers(ctx, sqlc.New(tx), groupID, userIDs)
+	})
+}
+
+// RemoveLocalGroupMember removes a manual member, reporting false when the
+// user is not one. Members matching the group's rules cannot be removed.
+func (s *Store) RemoveLocalGroupMember(ctx context.Context, groupID, userID uuid.UUID) (bool, error) {
+	rows, err := s.queries.DeleteManualGroupMember(ctx, sqlc.DeleteManualGroupMemberParams{
+		GroupID: groupID,
+		UserID:  userID,
+	})
+	return rows > 0, err
+}
+
+// RefreshRuleGroups re-derives the members of every local group with rules,
+// returning the groups whose membership changed.
+func (s *Store) RefreshRuleGroups(ctx context.Context) ([]GroupMembershipChange, error) {
+	groups, err := s.queries.ListRuleGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []GroupMembershipChange
+	for _, group := range groups {
+		var added, removed int
+		err = s.WithTx(ctx, func(tx pgx.Tx) error {
+			var refreshErr error
+			added, removed, refreshErr = refreshRuleMembers(ctx, sqlc.New(tx), group)
+			return refreshErr
+		})
+		if err != nil {
+			return changes, err
+		}
+		if added > 0 || removed > 0 {
+			changes = append(changes, GroupMembershipChange{
+				GroupID:     group.ID,
+				DisplayName: group.DisplayName,
+				Added:       added,
+				Removed:     removed,
+			})
+		}
+	}
+	return changes, nil
+}
+
+func addManualMembers(ctx context.Context, q *sqlc.Queries, groupID uuid.UUID, userIDs []uuid.UUID) error {
+	for _, userID := range userIDs {
+		if err := q.AddManualGroupMember(ctx, sqlc.AddManualGroupMemberParams{
+			GroupID: groupID,
+			UserID:  userID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshRuleMembers replaces a group's derived members with the users its
+// rules match. Groups without rules lose every derived member.
+func refreshRuleMembers(ctx context.Context, q *sqlc.Queries, group sqlc.Group) (added, removed int, err error) {
+	matched := []uuid.UUID{}
+	if rules, ok := DecodeGroupRules(group.Rules); ok {
+		departments := make([]string, 0, len(rules.Departments))
+		for _, department := range rules.Departments {
+			departments = append(departments, strings.ToLower(strings.TrimSpace(department)))
+		}
+		groupIDs := rules.MemberOf
+		if groupIDs == nil {
+			groupIDs = []uuid.UUID{}
+		}
+		matched, err = q.ListRuleMatchedUserIDs(ctx, sqlc.ListRuleMatchedUserIDsParams{
+			Departments: departments,
+			GroupIds:    groupIDs,
+			Attributes:  attributeFilter(rules.Attributes),
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		if matched == nil {
+			matched = []uuid.UUID{}
+		}
+	}
+	stale, err := q.DeleteStaleDerivedMembers(ctx, sqlc.DeleteStaleDerivedMembersParams{
+		GroupID: group.ID,
+		UserIds: matched,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	fresh, err := q.AddDerivedGroupMembers(ctx, sqlc.AddDerivedGroupMembersParams{
+		GroupID: group.ID,
+		UserIds: matched,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(fresh), int(stale), nil
+}
+
+// encodeGroupRules stores empty rules as NULL.
+func encodeGroupRules(rules *GroupRules) ([]byte, error) {
+	if rules == nil || rules.Empty() {
+		return nil, nil
+	}
+	return json.Marshal(rules)
+}
+
+// ListLocationsForGroup returns locations whose rosters include the group.
+func (s *Store) ListLocationsForGroup(ctx context.Context, groupID uuid.UUID) ([]sqlc.Location, error) {
+	return s.queries.ListLocationsForGroup(ctx, groupID)
 }
 
 func (s *Store) ListGroupMemberIDs(ctx context.Context, groupID uuid.UUID) ([]uuid.UUID, error) {
@@ -221,25 +732,109 @@ func (s *Store) ReplaceLocationGroups(ctx context.Context, locationID uuid.UUID,
 	return err
 }
 
+// ListUsersForGroups returns the active members of any of the groups, each
+// once, in roster order.
 func (s *Store) ListUsersForGroups(ctx context.Context, groupIDs []uuid.UUID) ([]sqlc.User, error) {
-	seen := make(map[uuid.UUID]struct{})
-	var users []sqlc.User
+	if len(groupIDs) == 0 {
+		return nil, nil
+	}
+	return s.queries.ListUsersForGroups(ctx, groupIDs)
+}
+
+// Roster is a location's allowed users at a roster version.
+type Roster struct {
+	Version  int64
+	GroupIDs []uuid.UUID
+	Users    []sqlc.User
+}
+
+// RosterDelta is how a roster changed between two versions. Changed holds
+// users who were added or whose details changed, with Added marking the ones
+// added since the older version.
+type RosterDelta struct {
+	Since   int64
+	Version int64
+	Changed []sqlc.User
+	Added   map[uuid.UUID]bool
+	Removed []uuid.UUID
+}
+
+// Roster reads a location's roster at its recorded version. It only reads:
+// versions move in ReconcileRoster, so a roster read just after a change can
+// carry the old version until the change is reconciled.
+func (s *Store) Roster(ctx context.Context, locationID uuid.UUID) (Roster, error) {
+	loc, err := s.queries.GetLocation(ctx, locationID)
+	if err != nil {
+		return Roster{}, err
+	}
+	// The version is read first, so the users are never older than it.
+	version, err := s.queries.GetRosterVersion(ctx, locationID)
+	if err != nil {
+		return Roster{}, err
+	}
+	roster := Roster{Version: version, GroupIDs: loc.GroupIds}
+	if len(loc.GroupIds) > 0 {
+		roster.Users, err = s.queries.ListUsersForGroups(ctx, loc.GroupIds)
+	}
+	return roster, err
+}
 
-	for _, gid := range groupIDs {
-		members, err := s.queries.ListGroupMembers(ctx, gid)
+// ReconcileRoster records who joined, left or changed in a location's roster
+// since it was last reconciled, bumping the version if anyone did. It
+// reports the version and whether it changed. Replicas reconciling the same
+// location at once take turns.
+func (s *Store) ReconcileRoster(ctx context.Context, locationID uuid.UUID) (int64, bool, error) {
+	var version, changed int64
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		_, err := q.GetLocation(ctx, locationID)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, m := range members {
-			if _, ok := seen[m.ID]; ok {
-				continue
-			}
-			seen[m.ID] = struct{}{}
-			users = append(users, m)
+		if version, err = q.LockRosterVersion(ctx, locationID); err != nil {
+			return err
 		}
-	}
+		changed, err = q.ApplyRosterChanges(ctx, sqlc.ApplyRosterChangesParams{
+			LocationID: locationID,
+			Version:    version + 1,
+		})
+		if err != nil || changed == 0 {
+			return err
+		}
+		version++
+		return q.SetRosterVersion(ctx, sqlc.SetRosterVersionParams{
+			LocationID: locationID,
+			Version:    version,
+		})
+	})
+	return version, changed > 0, err
+}
 
-	return users, nil
+// RosterChanges returns how a location's roster changed after since, up to
+// version, which should come from Roster.
+func (s *Store) RosterChanges(ctx context.Context, locationID uuid.UUID, since, version int64) (RosterDelta, error) {
+	delta := RosterDelta{Since: since, Version: version, Added: make(map[uuid.UUID]bool)}
+	rows, err := s.queries.ListRosterChanges(ctx, sqlc.ListRosterChangesParams{
+		LocationID: locationID,
+		Since:      since,
+		Version:    version,
+	})
+	if err != nil {
+		return delta, err
+	}
+	delta.Changed = make([]sqlc.User, 0, len(rows))
+	for _, row := range rows {
+		delta.Changed = append(delta.Changed, row.User)
+		if row.AddedVersion > since {
+			delta.Added[row.User.ID] = true
+		}
+	}
+	delta.Removed, err = s.queries.ListRosterRemovals(ctx, sqlc.ListRosterRemovalsParams{
+		LocationID: locationID,
+		Since:      since,
+		Version:    version,
+	})
+	return delta, err
 }
 
 func (s *Store) CreateLocation(ctx context.Context, params sqlc.CreateLocationParams) (sqlc.Location, error) {
@@ -250,7 +845,16 @@ func (s *Store) UpdateLocation(ctx context.Context, params sqlc.UpdateLocationPa
 	return s.queries.UpdateLocation(ctx, params)
 }
 
+// DeleteLocation removes a location. Locations with children must have them
+// moved or deleted first.
 func (s *Store) DeleteLocation(ctx context.Context, id uuid.UUID) error {
+	children, err := s.queries.CountChildLocations(ctx, pgtype.UUID{Bytes: id, Valid: true})
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return ErrLocationHasChildren
+	}
 	return s.queries.DeleteLocation(ctx, id)
 }
 
@@ -279,40 +883,881 @@ func (s *Store) ListLocationsForUser(
 	})
 }
 
-func (s *Store) CreateKey(ctx context.Context, params sqlc.CreateKeyParams) (sqlc.Key, error) {
-	return s.queries.CreateKey(ctx, params)
-}
+var (
+	// ErrLocationCycle means a move would place a location inside its own subtree.
+	ErrLocationCycle = errors.New("store: location cannot move below itself")
+	// ErrLocationHasChildren means a location still has child locations.
+	ErrLocationHasChildren = errors.New("store: location has child locations")
+)
 
-func (s *Store) UpdateKey(ctx context.Context, params sqlc.UpdateKeyParams) (sqlc.Key, error) {
-	return s.queries.UpdateKey(ctx, params)
-}
+// locationTreeLockKey serialises moves so concurrent ones cannot form a cycle.
+const locationTreeLockKey int64 = 0x7369676e696e02
 
-func (s *Store) DeleteKey(ctx context.Context, id uuid.UUID) error {
-	return s.queries.DeleteKey(ctx, id)
+// MoveLocation reparents a location; uuid.Nil makes it a root.
+func (s *Store) MoveLocation(ctx context.Context, id, parentID uuid.UUID) (sqlc.Location, error) {
+	var moved sqlc.Location
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", locationTreeLockKey); err != nil {
+			return err
+		}
+		q := sqlc.New(tx)
+		if parentID != uuid.Nil {
+			cycle, err := q.IsLocationInSubtree(ctx, sqlc.IsLocationInSubtreeParams{Root: id, Candidate: parentID})
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrLocationCycle
+			}
+		}
+		var err error
+		moved, err = q.SetLocationParent(ctx, sqlc.SetLocationParentParams{
+			ID:       id,
+			ParentID: pgtype.UUID{Bytes: parentID, Valid: parentID != uuid.Nil},
+		})
+		return err
+	})
+	return moved, err
 }
 
-func (s *Store) GetKey(ctx context.Context, id uuid.UUID) (sqlc.Key, error) {
-	return s.queries.GetKey(ctx, id)
+// ListPresence returns users whose latest checkin since the cutoff put them
+// inside the location's subtree.
+func (s *Store) ListPresence(
+	ctx context.Context,
+	locationID uuid.UUID,
+	since time.Time,
+) ([]sqlc.ListPresenceRow, error) {
+	return s.queries.ListPresence(ctx, sqlc.ListPresenceParams{
+		LocationID: locationID,
+		Since:      pgtype.Timestamptz{Time: since, Valid: true},
+	})
 }
 
-func (s *Store) GetKeyByValue(ctx context.Context, keyValue string) (sqlc.Key, error) {
-	return s.queries.GetKeyByValue(ctx, keyValue)
+// Closure sources.
+const (
+	ClosureSourceManual = "manual"
+	ClosureSourceICal   = "ical"
+)
+
+// LocationSchedule is a location's schedule with inherited settings resolved.
+type LocationSchedule struct {
+	schedule.Schedule
+	// Timezone is the IANA zone name; empty means the server's zone.
+	Timezone string
+	// Policy is the out-of-hours policy.
+	Policy string
 }
 
-func (s *Store) MarkKeyUsed(ctx context.Context, id uuid.UUID) (sqlc.Key, error) {
-	return s.queries.MarkKeyUsed(ctx, id)
+// GetLocationSchedule resolves a location's schedule. Timezone, opening hours
+// and policy come from the nearest location up the tree that sets them;
+// closures on the location or any ancestor are included from the day before
+// at onwards.
+func (s *Store) GetLocationSchedule(ctx context.Context, id uuid.UUID, at time.Time) (LocationSchedule, error) {
+	chain, err := s.locationChain(ctx, id)
+	if err != nil {
+		return LocationSchedule{}, err
+	}
+	resolved := LocationSchedule{Policy: schedule.PolicyAllow}
+	var timezoneSet, hoursSet, policySet bool
+	for _, loc := range chain {
+		if !timezoneSet && loc.Timezone.Valid {
+			resolved.Timezone, timezoneSet = loc.Timezone.String, true
+		}
+		if !hoursSet && len(loc.OpeningHours) > 0 {
+			if resolved.Hours, err = schedule.ParseHours(loc.OpeningHours); err != nil {
+				return LocationSchedule{}, err
+			}
+			hoursSet = true
+		}
+		if !policySet && loc.OutOfHoursPolicy.Valid {
+			resolved.Policy, policySet = loc.OutOfHoursPolicy.String, true
+		}
+	}
+	if resolved.Timezone != "" {
+		zone, err := time.LoadLocation(resolved.Timezone)
+		if err != nil {
+			return LocationSchedule{}, err
+		}
+		resolved.Location = zone
+	}
+	closures, err := s.queries.ListEffectiveClosures(ctx, sqlc.ListEffectiveClosuresParams{
+		LocationID: id,
+		FromDate:   pgtype.Date{Time: at.AddDate(0, 0, -1), Valid: true},
+	})
+	if err != nil {
+		return LocationSchedule{}, err
+	}
+	for _, c := range closures {
+		resolved.Closures = append(resolved.Closures, schedule.Closure{
+			Start:  c.StartsOn.Time,
+			End:    c.EndsOn.Time,
+			Reason: c.Reason,
+		})
+	}
+	return resolved, nil
 }
 
-func (s *Store) ListKeys(ctx context.Context) ([]sqlc.Key, error) {
-	return s.queries.ListKeys(ctx)
+// locationChain returns a location followed by its ancestors, nearest first.
+func (s *Store) locationChain(ctx context.Context, id uuid.UUID) ([]sqlc.Location, error) {
+	return locationChain(ctx, s.queries, id)
 }
 
-func (s *Store) ListKeyLocations(ctx context.Context, keyID uuid.UUID) ([]uuid.UUID, error) {
-	key, err := s.GetKey(ctx, keyID)
-	if err != nil {
-		return nil, err
+// locationChain reads the chain through q, so it can run in a transaction.
+func locationChain(ctx context.Context, q *sqlc.Queries, id uuid.UUID) ([]sqlc.Location, error) {
+	var chain []sqlc.Location
+	seen := make(map[uuid.UUID]bool)
+	for next := id; next != uuid.Nil && !seen[next]; {
+		seen[next] = true
+		loc, err := q.GetLocation(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, loc)
+		next = uuid.UUID(loc.ParentID.Bytes)
 	}
-	return key.LocationIds, nil
+	return chain, nil
+}
+
+func (s *Store) SetLocationSchedule(ctx context.Context, params sqlc.SetLocationScheduleParams) (sqlc.Location, error) {
+	return s.queries.SetLocationSchedule(ctx, params)
+}
+
+// ListLocationClosures returns the closures set on the location itself.
+func (s *Store) ListLocationClosures(ctx context.Context, locationID uuid.UUID) ([]sqlc.LocationClosure, error) {
+	return s.queries.ListLocationClosures(ctx, locationID)
+}
+
+// CreateLocationClosure adds a manual closure.
+func (s *Store) CreateLocationClosure(
+	ctx context.Context,
+	locationID uuid.UUID,
+	closure schedule.Closure,
+) (sqlc.LocationClosure, error) {
+	return s.queries.CreateLocationClosure(ctx, closureParams(locationID, closure, ClosureSourceManual, ""))
+}
+
+// DeleteLocationClosure removes a closure and reports whether it existed.
+func (s *Store) DeleteLocationClosure(ctx context.Context, locationID, closureID uuid.UUID) (bool, error) {
+	rows, err := s.queries.DeleteLocationClosure(ctx, sqlc.DeleteLocationClosureParams{
+		ID:         closureID,
+		LocationID: locationID,
+	})
+	return rows > 0, err
+}
+
+// ReplaceCalendarClosures swaps a location's imported closures for a fresh
+// import, leaving manual closures alone.
+func (s *Store) ReplaceCalendarClosures(
+	ctx context.Context,
+	locationID uuid.UUID,
+	closures []schedule.CalendarClosure,
+) ([]sqlc.LocationClosure, error) {
+	created := make([]sqlc.LocationClosure, 0, len(closures))
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		if err := q.DeleteCalendarClosures(ctx, locationID); err != nil {
+			return err
+		}
+		for _, c := range closures {
+			closure, err := q.CreateLocationClosure(ctx, closureParams(locationID, c.Closure, ClosureSourceICal, c.UID))
+			if err != nil {
+				return err
+			}
+			created = append(created, closure)
+		}
+		return nil
+	})
+	return created, err
+}
+
+func closureParams(
+	locationID uuid.UUID,
+	closure schedule.Closure,
+	source, uid string,
+) sqlc.CreateLocationClosureParams {
+	return sqlc.CreateLocationClosureParams{
+		ID:         uuid.New(),
+		LocationID: locationID,
+		StartsOn:   pgtype.Date{Time: closure.Start, Valid: true},
+		EndsOn:     pgtype.Date{Time: closure.End, Valid: true},
+		Reason:     closure.Reason,
+		Source:     source,
+		Uid:        pgtype.Text{String: uid, Valid: uid != ""},
+	}
+}
+
+// Capacity policies decide what a full location does with another check-in.
+const (
+	// CapacityPolicySoft records the checkin with a warning.
+	CapacityPolicySoft = "soft"
+	// CapacityPolicyHard refuses the checkin.
+	CapacityPolicyHard = "hard"
+	// CapacityPolicyWaitlist queues the user instead of checking them in.
+	CapacityPolicyWaitlist = "waitlist"
+)
+
+// OccupancyWindow bounds how far back occupancy looks for checkins, so people
+// who never checked out stop counting.
+const OccupancyWindow = 24 * time.Hour
+
+// ErrLocationFull means a hard capacity limit refused a checkin.
+var ErrLocationFull = errors.New("store: location is full")
+
+// CapacityLimit is a location with a capacity and its current occupancy.
+type CapacityLimit struct {
+	LocationID uuid.UUID
+	Name       string
+	Capacity   int
+	Policy     string
+	Occupancy  int
+}
+
+// Full reports whether one more person would exceed the capacity.
+func (l CapacityLimit) Full() bool {
+	return l.Occupancy >= l.Capacity
+}
+
+// ErrIdempotencyConflict means an idempotency key was reused for a different
+// user or direction.
+var ErrIdempotencyConflict = errors.New("store: idempotency key reused for a different checkin")
+
+// ErrLocationClosed means a toggle resolved to an "in" while the location's
+// hours policy refuses them.
+var ErrLocationClosed = errors.New("store: location is closed")
+
+// ErrContradictoryCheckin means a reject direction policy refused a checkin
+// that contradicts the user's state at the location.
+var ErrContradictoryCheckin = errors.New("store: checkin contradicts the user's state")
+
+// CheckinRequestTTL is how long idempotency keys are remembered. It outlasts
+// the longest replay window kiosks are allowed.
+const CheckinRequestTTL = 14 * 24 * time.Hour
+
+// DirectionToggle asks for the opposite of the user's state at the location.
+const DirectionToggle = "toggle"
+
+// DirectionTransfer checks the user in at the location and out of every
+// other location they are in, as one step.
+const DirectionTransfer = "transfer"
+
+// Transfer policies decide whether a location takes part in transfers.
+const (
+	TransferPolicyAllow = "allow"
+	TransferPolicyDeny  = "deny"
+)
+
+// ErrTransferDenied means a transfer would have closed the user's presence
+// at a location whose transfer policy is deny.
+var ErrTransferDenied = errors.New("store: location does not allow transfers")
+
+// Direction policies for checkins that contradict the user's state.
+const (
+	DirectionPolicyReject = "reject"
+	DirectionPolicyInsert = "insert"
+	DirectionPolicyFlag   = "flag"
+)
+
+// counterpartOffset is how long before a checkin its inserted counterpart is
+// recorded, so the two never tie.
+const counterpartOffset = time.Millisecond
+
+// CheckinRequest is a checkin as a kiosk asked for it.
+type CheckinRequest struct {
+	// Params.Direction is in, out or toggle, which is resolved under the
+	// checkin lock. Transfers ask for "in".
+	Params sqlc.CreateCheckinParams
+	// Requested is in, out, toggle or transfer. Idempotency keys are matched
+	// on it.
+	Requested string
+	// IdempotencyKey, when set, records the checkin at most once per portal
+	// key.
+	IdempotencyKey uuid.UUID
+	// Debounce drops a checkin within this long of the user's last one at
+	// the location in the same direction, or any direction for a toggle.
+	Debounce time.Duration
+	// DirectionPolicy handles a checkin that contradicts the user's state.
+	DirectionPolicy string
+	// ClosedToIn refuses a toggle that resolves to "in" with
+	// ErrLocationClosed, as the hours policy would an explicit one.
+	ClosedToIn bool
+}
+
+// CheckinOutcome describes what RecordCheckin did.
+type CheckinOutcome struct {
+	Checkin sqlc.Checkin
+	// Recorded is false when the user was waitlisted or refused.
+	Recorded bool
+	// Full lists the capacity limits that were already reached.
+	Full []CapacityLimit
+	// WaitlistPosition is the user's place in the queue, when waitlisted.
+	WaitlistPosition int
+	// Duplicate is true when the idempotency key had already been recorded.
+	// The outcome is then the first attempt's; Checkin holds only its ID,
+	// direction and flags, and Full is empty.
+	Duplicate bool
+	// OverCapacity is true when a soft or waitlist limit was already reached.
+	OverCapacity bool
+	// Debounced is true when the checkin repeated the user's last one too
+	// soon. Nothing was recorded; Checkin is the earlier checkin.
+	Debounced bool
+	// Counterpart is the checkin inserted before this one to resolve a
+	// contradiction, if any.
+	Counterpart *sqlc.Checkin
+	// Closed are the "out" checkins a transfer recorded at the other
+	// locations the user was in. They share Checkin's TransferID.
+	Closed []sqlc.Checkin
+}
+
+// RecordCheckin records a checkin, serialised per user and location so the
+// debounce and direction policy see every earlier checkin. For "in"
+// checkins it enforces capacity on the location and its ancestors, locking
+// them while occupancy is counted so concurrent kiosks cannot overfill a
+// location. The strictest policy among full limits applies: hard returns
+// ErrLocationFull, waitlist queues the user and soft records the checkin
+// anyway.
+//
+// A transfer is recorded as an "in" and, only if that is recorded, an "out"
+// at each other location the user was in; see recordTransfer.
+//
+// With an idempotency key, a repeated key returns the first attempt's
+// outcome with Duplicate set, or ErrIdempotencyConflict if it was for
+// another user or direction. Refused checkins are not remembered, so a retry
+// is judged afresh.
+func (s *Store) RecordCheckin(ctx context.Context, req CheckinRequest) (CheckinOutcome, error) {
+	var outcome CheckinOutcome
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		if req.IdempotencyKey == uuid.Nil {
+			var err error
+			outcome, err = record(ctx, q, req)
+			return err
+		}
+		keyID := uuid.UUID(req.Params.KeyID.Bytes)
+		// A concurrent claim of the same key waits here until the other
+		// transaction commits or rolls back.
+		claimed, err := q.ClaimCheckinRequest(ctx, sqlc.ClaimCheckinRequestParams{
+			KeyID:          keyID,
+			IdempotencyKey: req.IdempotencyKey,
+			UserID:         req.Params.UserID,
+			Direction:      req.Requested,
+		})
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			outcome, err = previousCheckin(ctx, q, keyID, req)
+			return err
+		}
+		if outcome, err = record(ctx, q, req); err != nil {
+			return err
+		}
+		finish := sqlc.FinishCheckinRequestParams{
+			KeyID:          keyID,
+			IdempotencyKey: req.IdempotencyKey,
+			OutOfHours:     outcome.Checkin.OutOfHours,
+			OverCapacity:   outcome.OverCapacity,
+		}
+		if outcome.Recorded || outcome.Debounced {
+			finish.CheckinID = pgtype.Int8{Int64: outcome.Checkin.ID, Valid: true}
+		} else {
+			finish.WaitlistPosition = pgtype.Int4{Int32: int32(outcome.WaitlistPosition), Valid: true}
+		}
+		return q.FinishCheckinRequest(ctx, finish)
+	})
+	return outcome, err
+}
+
+// PreviousCheckin returns the outcome already recorded for the request's
+// idempotency key, as RecordCheckin does for a repeat, or pgx.ErrNoRows if
+// the key is unused. It lets a retry be answered before it is re-validated.
+func (s *Store) PreviousCheckin(ctx context.Context, req CheckinRequest) (CheckinOutcome, error) {
+	return previousCheckin(ctx, s.queries, uuid.UUID(req.Params.KeyID.Bytes), req)
+}
+
+// previousCheckin rebuilds the outcome of an already claimed idempotency key.
+func previousCheckin(
+	ctx context.Context,
+	q *sqlc.Queries,
+	keyID uuid.UUID,
+	req CheckinRequest,
+) (CheckinOutcome, error) {
+	prev, err := q.GetCheckinRequest(ctx, sqlc.GetCheckinRequestParams{
+		KeyID:          keyID,
+		IdempotencyKey: req.IdempotencyKey,
+	})
+	if err != nil {
+		return CheckinOutcome{}, err
+	}
+	if prev.UserID != req.Params.UserID || prev.Direction != req.Requested {
+		return CheckinOutcome{}, ErrIdempotencyConflict
+	}
+	outcome := CheckinOutcome{
+		Recorded:         prev.CheckinID.Valid,
+		WaitlistPosition: int(prev.WaitlistPosition.Int32),
+		Duplicate:        true,
+		OverCapacity:     prev.OverCapacity,
+	}
+	if prev.CheckinID.Valid {
+		checkin, err := q.GetCheckin(ctx, prev.CheckinID.Int64)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return CheckinOutcome{}, err
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Purged since; only the request's record of it remains.
+			checkin = sqlc.Checkin{ID: prev.CheckinID.Int64, OutOfHours: prev.OutOfHours}
+		}
+		outcome.Checkin = checkin
+		if checkin.TransferID.Valid {
+			if outcome.Closed, err = transferClosed(ctx, q, checkin); err != nil {
+				return CheckinOutcome{}, err
+			}
+		}
+	}
+	return outcome, nil
+}
+
+// transferClosed returns the "out" checkins recorded with a transfer's "in".
+func transferClosed(ctx context.Context, q *sqlc.Queries, in sqlc.Checkin) ([]sqlc.Checkin, error) {
+	linked, err := q.ListTransferCheckins(ctx, in.TransferID)
+	if err != nil {
+		return nil, err
+	}
+	var closed []sqlc.Checkin
+	for _, c := range linked {
+		if c.ID != in.ID && c.Direction == "out" {
+			closed = append(closed, c)
+		}
+	}
+	return closed, nil
+}
+
+func lastCheckinParams(userID, locationID uuid.UUID, at time.Time) sqlc.GetLastCheckinParams {
+	return sqlc.GetLastCheckinParams{
+		UserID:     userID,
+		LocationID: locationID,
+		Since:      pgtype.Timestamptz{Time: at.Add(-OccupancyWindow), Valid: true},
+		Until:      pgtype.Timestamptz{Time: at, Valid: true},
+	}
+}
+
+// record does RecordCheckin's work inside the caller's transaction.
+func record(ctx context.Context, q *sqlc.Queries, req CheckinRequest) (CheckinOutcome, error) {
+	if req.Requested == DirectionTransfer {
+		return recordTransfer(ctx, q, req)
+	}
+	return recordCheckin(ctx, q, req)
+}
+
+// recordTransfer checks the user in at the request's location, then out of
+// each other location they were in when the transfer began, just before the
+// "in" so presence never shows them in two places. The checkins share a new
+// transfer ID. If the "in" is not recorded, because it was debounced or the
+// user was waitlisted, nothing is closed. A location among those to close
+// whose transfer policy is deny fails the whole transfer with
+// ErrTransferDenied.
+func recordTransfer(ctx context.Context, q *sqlc.Queries, req CheckinRequest) (CheckinOutcome, error) {
+	params := req.Params
+	if !params.OccurredAt.Valid {
+		params.OccurredAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	}
+	occurred := params.OccurredAt.Time
+	open, err := q.ListOpenLocations(ctx, sqlc.ListOpenLocationsParams{
+		UserID:     params.UserID,
+		ExcludedID: params.LocationID,
+		Since:      pgtype.Timestamptz{Time: occurred.Add(-OccupancyWindow), Valid: true},
+		Until:      params.OccurredAt,
+	})
+	if err != nil {
+		return CheckinOutcome{}, err
+	}
+
+	// Take every lock the transfer needs in id order, so two transfers for
+	// the same user cannot deadlock, then check each location again under
+	// its lock.
+	locked := append(slices.Clone(open), params.LocationID)
+	slices.SortFunc(locked, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	for _, id := range locked {
+		err := q.LockCheckinState(ctx, sqlc.LockCheckinStateParams{UserID: params.UserID, LocationID: id})
+		if err != nil {
+			return CheckinOutcome{}, err
+		}
+	}
+	var closing []uuid.UUID
+	for _, id := range open {
+		last, err := q.GetLastCheckin(ctx, lastCheckinParams(params.UserID, id, occurred))
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return CheckinOutcome{}, err
+		}
+		if last.Direction != "in" {
+			continue
+		}
+		policy, err := transferPolicy(ctx, q, id)
+		if err != nil {
+			return CheckinOutcome{}, err
+		}
+		if policy == TransferPolicyDeny {
+			return CheckinOutcome{}, ErrTransferDenied
+		}
+		closing = append(closing, id)
+	}
+
+	if len(closing) > 0 {
+		params.TransferID = pgtype.UUID{Bytes: uuid.New(), Valid: true}
+	}
+	req.Params = params
+	outcome, err := recordCheckin(ctx, q, req)
+	if err != nil || !outcome.Recorded {
+		return outcome, err
+	}
+	for _, id := range closing {
+		closed, err := q.CreateCheckin(ctx, sqlc.CreateCheckinParams{
+			UserID:     params.UserID,
+			LocationID: id,
+			KeyID:      params.KeyID,
+			Direction:  "out",
+			OccurredAt: pgtype.Timestamptz{Time: occurred.Add(-counterpartOffset), Valid: true},
+			TransferID: params.TransferID,
+		})
+		if err != nil {
+			return outcome, err
+		}
+		outcome.Closed = append(outcome.Closed, closed)
+	}
+	return outcome, nil
+}
+
+// recordCheckin records a single in or out inside the caller's transaction.
+// A toggle is resolved here, under the lock, so concurrent toggles for a
+// user see each other. Nothing is written until the checkin is known to be
+// recorded, so a refused or waitlisted "in" leaves the user's state alone.
+func recordCheckin(ctx context.Context, q *sqlc.Queries, req CheckinRequest) (CheckinOutcome, error) {
+	var outcome CheckinOutcome
+	params := req.Params
+	occurred := params.OccurredAt.Time
+	if !params.OccurredAt.Valid {
+		occurred = time.Now()
+		params.OccurredAt = pgtype.Timestamptz{Time: occurred, Valid: true}
+	}
+	err := q.LockCheckinState(ctx, sqlc.LockCheckinStateParams{
+		UserID:     params.UserID,
+		LocationID: params.LocationID,
+	})
+	if err != nil {
+		return outcome, err
+	}
+	last, err := q.GetLastCheckin(ctx, lastCheckinParams(params.UserID, params.LocationID, occurred))
+	found := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return outcome, err
+	}
+	if found && req.Debounce > 0 && occurred.Sub(last.OccurredAt.Time) <= req.Debounce &&
+		(last.Direction == params.Direction || params.Direction == DirectionToggle) {
+		return CheckinOutcome{Checkin: last, Debounced: true}, nil
+	}
+	present := found && last.Direction == "in"
+	if params.Direction == DirectionToggle {
+		params.Direction = "in"
+		if present {
+			params.Direction = "out"
+		}
+	}
+	if params.Direction == "in" && req.ClosedToIn {
+		return outcome, ErrLocationClosed
+	}
+
+	var counterpart *sqlc.CreateCheckinParams
+	if present == (params.Direction == "in") {
+		switch req.DirectionPolicy {
+		case DirectionPolicyReject:
+			return outcome, ErrContradictoryCheckin
+		case DirectionPolicyInsert:
+			inserted := params
+			inserted.Direction = "out"
+			if params.Direction == "out" {
+				inserted.Direction = "in"
+			}
+			inserted.Notes = pgtype.Text{}
+			inserted.OccurredAt = pgtype.Timestamptz{Time: occurred.Add(-counterpartOffset), Valid: true}
+			inserted.AutoInserted = true
+			inserted.TransferID = pgtype.UUID{}
+			counterpart = &inserted
+		default:
+			params.Contradictory = true
+		}
+	}
+
+	var limitIDs []uuid.UUID
+	if params.Direction == "in" {
+		locs, err := q.LockCapacityLimits(ctx, params.LocationID)
+		if err != nil {
+			return outcome, err
+		}
+		limits, err := countLimits(ctx, q, locs, params.UserID)
+		if err != nil {
+			return outcome, err
+		}
+		policy := ""
+		var waitlistAt uuid.UUID
+		for _, limit := range limits {
+			limitIDs = append(limitIDs, limit.LocationID)
+			if !limit.Full() {
+				continue
+			}
+			outcome.Full = append(outcome.Full, limit)
+			if limit.Policy == CapacityPolicyWaitlist && waitlistAt == uuid.Nil {
+				waitlistAt = limit.LocationID
+			}
+			if capacityPolicyRank[limit.Policy] > capacityPolicyRank[policy] {
+				policy = limit.Policy
+			}
+		}
+		outcome.OverCapacity = len(outcome.Full) > 0
+		switch policy {
+		case CapacityPolicyHard:
+			return outcome, ErrLocationFull
+		case CapacityPolicyWaitlist:
+			outcome.WaitlistPosition, err = joinWaitlist(ctx, q, waitlistAt, params.UserID)
+			return outcome, err
+		}
+	}
+
+	if counterpart != nil {
+		inserted, err := q.CreateCheckin(ctx, *counterpart)
+		if err != nil {
+			return outcome, err
+		}
+		outcome.Counterpart = &inserted
+	}
+	if outcome.Checkin, err = q.CreateCheckin(ctx, params); err != nil {
+		return outcome, err
+	}
+	outcome.Recorded = true
+	if params.Direction != "in" {
+		return outcome, nil
+	}
+	return outcome, q.RemoveFromWaitlist(ctx, sqlc.RemoveFromWaitlistParams{
+		UserID:      params.UserID,
+		LocationIds: limitIDs,
+	})
+}
+
+// DirectionPolicy resolves a location's direction policy from it and its
+// ancestors, falling back to flag.
+func (s *Store) DirectionPolicy(ctx context.Context, locationID uuid.UUID) (string, error) {
+	chain, err := s.locationChain(ctx, locationID)
+	if err != nil {
+		return "", err
+	}
+	for _, loc := range chain {
+		if loc.DirectionPolicy.Valid {
+			return loc.DirectionPolicy.String, nil
+		}
+	}
+	return DirectionPolicyFlag, nil
+}
+
+// TransferPolicy resolves whether a location takes part in transfers from it
+// and its ancestors, falling back to allow.
+func (s *Store) TransferPolicy(ctx context.Context, locationID uuid.UUID) (string, error) {
+	return transferPolicy(ctx, s.queries, locationID)
+}
+
+func transferPolicy(ctx context.Context, q *sqlc.Queries, locationID uuid.UUID) (string, error) {
+	chain, err := locationChain(ctx, q, locationID)
+	if err != nil {
+		return "", err
+	}
+	for _, loc := range chain {
+		if loc.TransferPolicy.Valid {
+			return loc.TransferPolicy.String, nil
+		}
+	}
+	return TransferPolicyAllow, nil
+}
+
+func (s *Store) SetLocationTransferPolicy(
+	ctx context.Context,
+	params sqlc.SetLocationTransferPolicyParams,
+) (sqlc.Location, error) {
+	return s.queries.SetLocationTransferPolicy(ctx, params)
+}
+
+func (s *Store) SetLocationDirectionPolicy(
+	ctx context.Context,
+	params sqlc.SetLocationDirectionPolicyParams,
+) (sqlc.Location, error) {
+	return s.queries.SetLocationDirectionPolicy(ctx, params)
+}
+
+// DeleteExpiredCheckinRequests forgets idempotency keys older than
+// CheckinRequestTTL.
+func (s *Store) DeleteExpiredCheckinRequests(ctx context.Context, now time.Time) (int64, error) {
+	return s.queries.DeleteExpiredCheckinRequests(ctx, pgtype.Timestamptz{
+		Time:  now.Add(-CheckinRequestTTL),
+		Valid: true,
+	})
+}
+
+// capacityPolicyRank orders policies from most to least lenient.
+var capacityPolicyRank = map[string]int{
+	"":                     0,
+	CapacityPolicySoft:     1,
+	CapacityPolicyWaitlist: 2,
+	CapacityPolicyHard:     3,
+}
+
+// LocationOccupancy returns how many people are in the location's subtree and
+// the capacity limits that apply to it.
+func (s *Store) LocationOccupancy(ctx context.Context, locationID uuid.UUID) (int, []CapacityLimit, error) {
+	count, err := s.queries.CountOccupancy(ctx, sqlc.CountOccupancyParams{
+		Since:      pgtype.Timestamptz{Time: time.Now().Add(-OccupancyWindow), Valid: true},
+		LocationID: locationID,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	locs, err := s.queries.ListCapacityLimits(ctx, locationID)
+	if err != nil {
+		return 0, nil, err
+	}
+	limits, err := countLimits(ctx, s.queries, locs, uuid.Nil)
+	return int(count), limits, err
+}
+
+// ListOccupancyHistory samples the occupancy of the location's subtree every
+// step between from and to.
+func (s *Store) ListOccupancyHistory(
+	ctx context.Context,
+	locationID uuid.UUID,
+	from, to time.Time,
+	step time.Duration,
+) ([]sqlc.ListOccupancyHistoryRow, error) {
+	return s.queries.ListOccupancyHistory(ctx, sqlc.ListOccupancyHistoryParams{
+		LocationID:    locationID,
+		FromTime:      pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:        pgtype.Timestamptz{Time: to, Valid: true},
+		StepSeconds:   int32(step / time.Second),
+		WindowSeconds: int32(OccupancyWindow / time.Second),
+	})
+}
+
+// ListWaitlist returns who is waiting for space at a location, in order.
+func (s *Store) ListWaitlist(ctx context.Context, locationID uuid.UUID) ([]sqlc.ListWaitlistRow, error) {
+	return s.queries.ListWaitlist(ctx, sqlc.ListWaitlistParams{
+		LocationID: locationID,
+		Since:      pgtype.Timestamptz{Time: time.Now().Add(-OccupancyWindow), Valid: true},
+	})
+}
+
+func (s *Store) SetLocationCapacity(ctx context.Context, params sqlc.SetLocationCapacityParams) (sqlc.Location, error) {
+	return s.queries.SetLocationCapacity(ctx, params)
+}
+
+// countLimits counts occupancy against each capacity-limited location,
+// leaving out excludeUserID so a repeat check-in does not count twice.
+func countLimits(
+	ctx context.Context,
+	q *sqlc.Queries,
+	locs []sqlc.Location,
+	excludeUserID uuid.UUID,
+) ([]CapacityLimit, error) {
+	since := pgtype.Timestamptz{Time: time.Now().Add(-OccupancyWindow), Valid: true}
+	limits := make([]CapacityLimit, 0, len(locs))
+	for _, loc := range locs {
+		count, err := q.CountOccupancy(ctx, sqlc.CountOccupancyParams{
+			Since:         since,
+			ExcludeUserID: excludeUserID,
+			LocationID:    loc.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		policy := loc.CapacityPolicy.String
+		if policy == "" {
+			policy = CapacityPolicySoft
+		}
+		limits = append(limits, CapacityLimit{
+			LocationID: loc.ID,
+			Name:       loc.Name,
+			Capacity:   int(loc.Capacity.Int32),
+			Policy:     policy,
+			Occupancy:  int(count),
+		})
+	}
+	return limits, nil
+}
+
+// joinWaitlist queues the user at a full location, dropping stale entries,
+// and returns their position.
+func joinWaitlist(ctx context.Context, q *sqlc.Queries, locationID, userID uuid.UUID) (int, error) {
+	before := pgtype.Timestamptz{Time: time.Now().Add(-OccupancyWindow), Valid: true}
+	if err := q.DeleteExpiredWaitlist(ctx, before); err != nil {
+		return 0, err
+	}
+	if err := q.AddToWaitlist(ctx, sqlc.AddToWaitlistParams{LocationID: locationID, UserID: userID}); err != nil {
+		return 0, err
+	}
+	position, err := q.GetWaitlistPosition(ctx, sqlc.GetWaitlistPositionParams{
+		LocationID: locationID,
+		UserID:     userID,
+	})
+	return int(position), err
+}
+
+func (s *Store) CreateKey(ctx context.Context, params sqlc.CreateKeyParams) (sqlc.Key, error) {
+	return s.queries.CreateKey(ctx, params)
+}
+
+func (s *Store) UpdateKey(ctx context.Context, params sqlc.UpdateKeyParams) (sqlc.Key, error) {
+	return s.queries.UpdateKey(ctx, params)
+}
+
+func (s *Store) DeleteKey(ctx context.Context, id uuid.UUID) error {
+	return s.queries.DeleteKey(ctx, id)
+}
+
+// GenerateKeyValue returns a random portal key value.
+func GenerateKeyValue() string {
+	const keyBytes = 24
+
+	buf := make([]byte, keyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return uuid.NewString()
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// RotateKey gives a key a new random value, keeping its description and
+// locations. Portals still using the old value stop working.
+func (s *Store) RotateKey(ctx context.Context, id uuid.UUID) (sqlc.Key, error) {
+	return s.queries.RotateKeyValue(ctx, sqlc.RotateKeyValueParams{ID: id, KeyValue: GenerateKeyValue()})
+}
+
+func (s *Store) GetKey(ctx context.Context, id uuid.UUID) (sqlc.Key, error) {
+	return s.queries.GetKey(ctx, id)
+}
+
+func (s *Store) GetKeyByValue(ctx context.Context, keyValue string) (sqlc.Key, error) {
+	return s.queries.GetKeyByValue(ctx, keyValue)
+}
+
+func (s *Store) MarkKeyUsed(ctx context.Context, id uuid.UUID) (sqlc.Key, error) {
+	return s.queries.MarkKeyUsed(ctx, id)
+}
+
+func (s *Store) ListKeys(ctx context.Context) ([]sqlc.Key, error) {
+	return s.queries.ListKeys(ctx)
+}
+
+func (s *Store) ListKeyLocations(ctx context.Context, keyID uuid.UUID) ([]uuid.UUID, error) {
+	key, err := s.GetKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return key.LocationIds, nil
 }
 
 func (s *Store) ReplaceKeyLocations(ctx context.Context, keyID uuid.UUID, locationIDs []uuid.UUID) error {
@@ -371,6 +1816,7 @@ func (s *Store) ListCheckinDetails(
 	isAdmin bool,
 	viewerID uuid.UUID,
 	locationID, userID uuid.NullUUID,
+	userAttributes map[string]string,
 	limit, offset int32,
 ) ([]sqlc.ListCheckinDetailsRow, error) {
 	return s.queries.ListCheckinDetails(ctx, sqlc.ListCheckinDetailsParams{
@@ -384,18 +1830,430 @@ func (s *Store) ListCheckinDetails(
 			Bytes: nullUUID(userID),
 			Valid: userID.Valid,
 		},
-		Limit:  limit,
-		Offset: offset,
+		UserAttributes: attributeFilter(userAttributes),
+		Limit:          limit,
+		Offset:         offset,
 	})
 }
 
-// UpsertUserAdmin updates only the admin flag.
-func (s *Store) UpsertUserAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) (sqlc.User, error) {
-	user, err := s.GetUser(ctx, userID)
-	if err != nil {
-		return sqlc.User{}, err
-	}
-	return s.UpsertUser(ctx, sqlc.UpsertUserParams{
+// ExportCheckins returns checkins in [from, to) in time order, optionally
+// limited to a location and its subtree.
+func (s *Store) ExportCheckins(
+	ctx context.Context,
+	from, to time.Time,
+	locationID uuid.NullUUID,
+) ([]sqlc.ExportCheckinsRow, error) {
+	return s.queries.ExportCheckins(ctx, sqlc.ExportCheckinsParams{
+		FromTime:   pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:     pgtype.Timestamptz{Time: to, Valid: true},
+		LocationID: pgtype.UUID{Bytes: nullUUID(locationID), Valid: locationID.Valid},
+	})
+}
+
+// PurgeCheckinsBefore permanently deletes checkins that occurred before the
+// cutoff.
+func (s *Store) PurgeCheckinsBefore(ctx context.Context, before time.Time) (int64, error) {
+	return s.queries.PurgeCheckinsBefore(ctx, pgtype.Timestamptz{Time: before, Valid: true})
+}
+
+// checkinPartitionPrefix starts the name of every monthly checkins partition,
+// which ends in the month as YYYYMM.
+const checkinPartitionPrefix = "checkins_p"
+
+// CheckinPartition is one monthly partition of checkins.
+type CheckinPartition struct {
+	Name string
+	// Month is the first instant of the partition's UTC month.
+	Month time.Time
+	// Archived partitions were detached into the checkins_archive schema.
+	Archived      bool
+	EstimatedRows int64
+}
+
+// ListCheckinPartitions returns monthly partitions, oldest first.
+func (s *Store) ListCheckinPartitions(ctx context.Context) ([]CheckinPartition, error) {
+	rows, err := s.queries.ListCheckinPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]CheckinPartition, 0, len(rows))
+	for _, row := range rows {
+		month, parseErr := time.Parse("200601", strings.TrimPrefix(row.Name, checkinPartitionPrefix))
+		if parseErr != nil {
+			continue
+		}
+		partitions = append(partitions, CheckinPartition{
+			Name:          row.Name,
+			Month:         month,
+			Archived:      row.Archived,
+			EstimatedRows: row.EstimatedRows,
+		})
+	}
+	return partitions, nil
+}
+
+// EnsureCheckinPartitions creates any missing partitions from the month of
+// from through monthsAhead months later, and for months after notBefore whose
+// rows sit in the default partition. It returns the partitions it created.
+func (s *Store) EnsureCheckinPartitions(
+	ctx context.Context,
+	from time.Time,
+	monthsAhead int,
+	notBefore time.Time,
+) ([]string, error) {
+	first := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	months := make([]time.Time, 0, monthsAhead+1)
+	for i := 0; i <= monthsAhead; i++ {
+		months = append(months, first.AddDate(0, i, 0))
+	}
+	stray, err := s.queries.ListUnpartitionedMonths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, month := range stray {
+		if !month.Time.Before(notBefore) {
+			months = append(months, month.Time)
+		}
+	}
+	var created []string
+	for _, month := range months {
+		ok, err := s.queries.EnsureCheckinPartition(ctx, pgtype.Date{Time: month, Valid: true})
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, checkinPartitionPrefix+month.Format("200601"))
+		}
+	}
+	return created, nil
+}
+
+// ArchiveCheckinPartitions detaches attached partitions whose whole month is
+// before the cutoff and returns their names.
+func (s *Store) ArchiveCheckinPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	partitions, err := s.ListCheckinPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var archived []string
+	for _, p := range partitions {
+		if p.Archived || p.Month.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+		if err = s.queries.ArchiveCheckinPartition(ctx, p.Name); err != nil {
+			return archived, err
+		}
+		archived = append(archived, p.Name)
+	}
+	return archived, nil
+}
+
+// CountUnpartitionedCheckins counts checkins in months without a partition.
+func (s *Store) CountUnpartitionedCheckins(ctx context.Context) (int64, error) {
+	return s.queries.CountUnpartitionedCheckins(ctx)
+}
+
+// Retention actions for checkins past a location's retention period.
+const (
+	RetentionDelete    = "delete"
+	RetentionAnonymise = "anonymise"
+)
+
+// RetentionRule is a location's retention policy with inherited settings
+// resolved. Zero days keeps checkins or notes indefinitely.
+type RetentionRule struct {
+	LocationID uuid.UUID
+	Name       string
+	// Days is how long checkins are kept before Action applies.
+	Days   int
+	Action string
+	// NotesDays is how long notes are kept.
+	NotesDays int
+}
+
+// Active reports whether the rule ever removes anything.
+func (r RetentionRule) Active() bool {
+	return r.Days > 0 || r.NotesDays > 0
+}
+
+// Cutoffs returns the times before which checkins expire and notes are
+// cleared; a zero time means no cutoff.
+func (r RetentionRule) Cutoffs(now time.Time) (expireBefore, notesBefore time.Time) {
+	if r.Days > 0 {
+		expireBefore = now.AddDate(0, 0, -r.Days)
+	}
+	if r.NotesDays > 0 {
+		notesBefore = now.AddDate(0, 0, -r.NotesDays)
+	}
+	return expireBefore, notesBefore
+}
+
+// RetentionCounts is how many checkins a retention pass changes.
+type RetentionCounts struct {
+	Deleted      int64
+	Anonymised   int64
+	NotesCleared int64
+}
+
+// Add accumulates another pass's counts.
+func (c *RetentionCounts) Add(other RetentionCounts) {
+	c.Deleted += other.Deleted
+	c.Anonymised += other.Anonymised
+	c.NotesCleared += other.NotesCleared
+}
+
+// GetRetentionRule resolves a location's retention rule. Each setting comes
+// from the nearest location up the tree that sets it.
+func (s *Store) GetRetentionRule(ctx context.Context, id uuid.UUID) (RetentionRule, error) {
+	chain, err := s.locationChain(ctx, id)
+	if err != nil {
+		return RetentionRule{}, err
+	}
+	return resolveRetention(chain), nil
+}
+
+// ListRetentionRules resolves the retention rule of every location that has
+// one.
+func (s *Store) ListRetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	locs, err := s.ListLocations(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]sqlc.Location, len(locs))
+	for _, loc := range locs {
+		byID[loc.ID] = loc
+	}
+	var rules []RetentionRule
+	for _, loc := range locs {
+		var chain []sqlc.Location
+		seen := make(map[uuid.UUID]bool)
+		for next, ok := loc, true; ok && !seen[next.ID]; next, ok = byID[uuid.UUID(next.ParentID.Bytes)] {
+			seen[next.ID] = true
+			chain = append(chain, next)
+		}
+		if rule := resolveRetention(chain); rule.Active() {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// resolveRetention merges a location chain, nearest first, into a rule.
+// Locations that set days without an action anonymise.
+func resolveRetention(chain []sqlc.Location) RetentionRule {
+	rule := RetentionRule{LocationID: chain[0].ID, Name: chain[0].Name}
+	for _, loc := range chain {
+		if rule.Days == 0 && loc.RetentionDays.Valid {
+			rule.Days = int(loc.RetentionDays.Int32)
+		}
+		if rule.Action == "" && loc.RetentionAction.Valid {
+			rule.Action = loc.RetentionAction.String
+		}
+		if rule.NotesDays == 0 && loc.NotesRetentionDays.Valid {
+			rule.NotesDays = int(loc.NotesRetentionDays.Int32)
+		}
+	}
+	if rule.Action == "" {
+		rule.Action = RetentionAnonymise
+	}
+	return rule
+}
+
+// PreviewRetention counts what ApplyRetention would change at the rule's
+// location, archived partitions included, without changing anything.
+func (s *Store) PreviewRetention(ctx context.Context, rule RetentionRule, now time.Time) (RetentionCounts, error) {
+	expireBefore, notesBefore := rule.Cutoffs(now)
+	row, err := s.queries.CountRetention(ctx, sqlc.CountRetentionParams{
+		ExpireBefore: pgtype.Timestamptz{Time: expireBefore, Valid: !expireBefore.IsZero()},
+		Action:       rule.Action,
+		NotesBefore:  pgtype.Timestamptz{Time: notesBefore, Valid: !notesBefore.IsZero()},
+		LocationID:   rule.LocationID,
+	})
+	if err != nil {
+		return RetentionCounts{}, err
+	}
+	counts := RetentionCounts{Deleted: row.Deleted, Anonymised: row.Anonymised, NotesCleared: row.NotesCleared}
+	archived, err := s.archivedRetention(ctx, rule, now, true)
+	counts.Add(archived)
+	return counts, err
+}
+
+// Steps archived_retention applies to archived partitions.
+const (
+	retentionStepDelete     = "delete"
+	retentionStepAnonymise  = "anonymise"
+	retentionStepClearNotes = "clear_notes"
+)
+
+// archivedRetention applies the rule to checkins in partitions archived out
+// of the checkins table, or with dryRun counts what it would change.
+func (s *Store) archivedRetention(
+	ctx context.Context,
+	rule RetentionRule,
+	now time.Time,
+	dryRun bool,
+) (RetentionCounts, error) {
+	var counts RetentionCounts
+	expireBefore, notesBefore := rule.Cutoffs(now)
+	expire := pgtype.Timestamptz{Time: expireBefore, Valid: !expireBefore.IsZero()}
+	step := func(name string, before time.Time) (int64, error) {
+		return s.queries.ArchivedRetention(ctx, sqlc.ArchivedRetentionParams{
+			LocationID:   rule.LocationID,
+			Step:         name,
+			Before:       pgtype.Timestamptz{Time: before, Valid: true},
+			ExpireBefore: expire,
+			DryRun:       dryRun,
+		})
+	}
+	var err error
+	if expire.Valid {
+		if rule.Action == RetentionDelete {
+			counts.Deleted, err = step(retentionStepDelete, expireBefore)
+		} else {
+			counts.Anonymised, err = step(retentionStepAnonymise, expireBefore)
+		}
+		if err != nil {
+			return counts, err
+		}
+	}
+	if !notesBefore.IsZero() {
+		counts.NotesCleared, err = step(retentionStepClearNotes, notesBefore)
+	}
+	return counts, err
+}
+
+// ApplyRetention expires checkins at the rule's location, then clears old
+// notes. Each batch is its own statement that skips rows locked by others,
+// so check-ins carry on while a large backlog is purged. Archived partitions
+// get the same rule afterwards.
+func (s *Store) ApplyRetention(
+	ctx context.Context,
+	rule RetentionRule,
+	now time.Time,
+	batchSize int32,
+) (RetentionCounts, error) {
+	var counts RetentionCounts
+	expireBefore, notesBefore := rule.Cutoffs(now)
+	var err error
+	if !expireBefore.IsZero() {
+		before := pgtype.Timestamptz{Time: expireBefore, Valid: true}
+		if rule.Action == RetentionDelete {
+			counts.Deleted, err = inBatches(ctx, batchSize, func() (int64, error) {
+				return s.queries.DeleteExpiredCheckins(ctx, sqlc.DeleteExpiredCheckinsParams{
+					LocationID: rule.LocationID, Before: before, BatchSize: batchSize,
+				})
+			})
+		} else {
+			counts.Anonymised, err = inBatches(ctx, batchSize, func() (int64, error) {
+				return s.queries.AnonymiseExpiredCheckins(ctx, sqlc.AnonymiseExpiredCheckinsParams{
+					LocationID: rule.LocationID, Before: before, BatchSize: batchSize,
+				})
+			})
+		}
+		if err != nil {
+			return counts, err
+		}
+	}
+	if !notesBefore.IsZero() {
+		before := pgtype.Timestamptz{Time: notesBefore, Valid: true}
+		counts.NotesCleared, err = inBatches(ctx, batchSize, func() (int64, error) {
+			return s.queries.ClearExpiredNotes(ctx, sqlc.ClearExpiredNotesParams{
+				LocationID: rule.LocationID, Before: before, BatchSize: batchSize,
+			})
+		})
+		if err != nil {
+			return counts, err
+		}
+	}
+	archived, err := s.archivedRetention(ctx, rule, now, false)
+	counts.Add(archived)
+	return counts, err
+}
+
+// inBatches runs batch until it changes fewer than batchSize rows and returns
+// the total changed.
+func inBatches(ctx context.Context, batchSize int32, batch func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := batch()
+		total += n
+		if err != nil || n < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+func (s *Store) SetLocationRetention(
+	ctx context.Context,
+	params sqlc.SetLocationRetentionParams,
+) (sqlc.Location, error) {
+	return s.queries.SetLocationRetention(ctx, params)
+}
+
+// CreateRetentionRun records the start of a retention pass.
+func (s *Store) CreateRetentionRun(ctx context.Context) (sqlc.RetentionRun, error) {
+	return s.queries.CreateRetentionRun(ctx)
+}
+
+// FinishRetentionRun records what a retention pass changed and how it ended.
+func (s *Store) FinishRetentionRun(
+	ctx context.Context,
+	id uuid.UUID,
+	counts RetentionCounts,
+	runErr error,
+) (sqlc.RetentionRun, error) {
+	params := sqlc.FinishRetentionRunParams{
+		ID:           id,
+		Status:       "succeeded",
+		Deleted:      counts.Deleted,
+		Anonymised:   counts.Anonymised,
+		NotesCleared: counts.NotesCleared,
+	}
+	if runErr != nil {
+		params.Status = "failed"
+		params.Error = pgtype.Text{String: runErr.Error(), Valid: true}
+	}
+	return s.queries.FinishRetentionRun(ctx, params)
+}
+
+// FailInterruptedRetentionRuns closes runs left running by a crashed process.
+func (s *Store) FailInterruptedRetentionRuns(ctx context.Context) error {
+	return s.queries.FailInterruptedRetentionRuns(ctx)
+}
+
+func (s *Store) ListRetentionRuns(ctx context.Context, limit int32) ([]sqlc.RetentionRun, error) {
+	return s.queries.ListRetentionRuns(ctx, limit)
+}
+
+// GrantAdmin makes the user with the UPN an admin. Users who have not signed
+// in or been synced yet are created, so the first admin can be bootstrapped
+// before anyone logs in.
+func (s *Store) GrantAdmin(ctx context.Context, upn string) (sqlc.User, error) {
+	user, err := s.GetUserByUPN(ctx, upn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		user, err = s.UpsertUser(ctx, sqlc.UpsertUserParams{
+			ID:          uuid.New(),
+			Upn:         upn,
+			DisplayName: upn,
+			Source:      pgtype.Text{String: SourceJIT, Valid: true},
+		})
+	}
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	return s.UpsertUserAdmin(ctx, user.ID, true)
+}
+
+// UpsertUserAdmin updates only the admin flag.
+func (s *Store) UpsertUserAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) (sqlc.User, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	return s.UpsertUser(ctx, sqlc.UpsertUserParams{
 		ID:          userID,
 		Upn:         user.Upn,
 		DisplayName: user.DisplayName,
@@ -423,6 +2281,308 @@ func (s *Store) TouchUser(ctx context.Context, userID uuid.UUID) (sqlc.User, err
 	})
 }
 
+// SubjectExport is everything stored about one user, for a subject access
+// request.
+type SubjectExport struct {
+	User      sqlc.User
+	Groups    []sqlc.ListSubjectGroupsRow
+	Locations []sqlc.ListSubjectLocationsRow
+	Checkins  []sqlc.ListSubjectCheckinsRow
+	Waitlist  []sqlc.ListSubjectWaitlistRow
+	// SyncRuns and Settings are audit records naming the user as the admin
+	// who acted.
+	SyncRuns []sqlc.ListSubjectSyncRunsRow
+	Settings []sqlc.ListSubjectSettingsRow
+}
+
+// ExportSubject gathers a user's data in one snapshot.
+func (s *Store) ExportSubject(ctx context.Context, userID uuid.UUID) (SubjectExport, error) {
+	var export SubjectExport
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+			return err
+		}
+		q := sqlc.New(tx)
+		var err error
+		if export.User, err = q.GetUser(ctx, userID); err != nil {
+			return err
+		}
+		if export.Groups, err = q.ListSubjectGroups(ctx, userID); err != nil {
+			return err
+		}
+		if export.Locations, err = q.ListSubjectLocations(ctx, userID); err != nil {
+			return err
+		}
+		if export.Checkins, err = q.ListSubjectCheckins(ctx, userID); err != nil {
+			return err
+		}
+		if export.Waitlist, err = q.ListSubjectWaitlist(ctx, userID); err != nil {
+			return err
+		}
+		actor := pgtype.UUID{Bytes: userID, Valid: true}
+		if export.SyncRuns, err = q.ListSubjectSyncRuns(ctx, actor); err != nil {
+			return err
+		}
+		export.Settings, err = q.ListSubjectSettings(ctx, actor)
+		return err
+	})
+	return export, err
+}
+
+// EraseUser erases a user for a subject erasure request. Their checkins stay,
+// anonymised, including those in archived partitions, so location counts and
+// occupancy history are unchanged; the user row, group memberships, waitlist
+// places and audit links go. Users still in a directory source come back on
+// the next sync, without history.
+func (s *Store) EraseUser(
+	ctx context.Context,
+	userID uuid.UUID,
+	reference string,
+	erasedBy uuid.UUID,
+) (sqlc.UserErasure, error) {
+	var erasure sqlc.UserErasure
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		checkins, err := q.AnonymiseUserCheckins(ctx, userID)
+		if err != nil {
+			return err
+		}
+		archived, err := q.AnonymiseArchivedCheckins(ctx, userID)
+		if err != nil {
+			return err
+		}
+		memberships, err := q.DeleteUserMemberships(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err = q.ClearManagerReferences(ctx, pgtype.UUID{Bytes: userID, Valid: true}); err != nil {
+			return err
+		}
+		deleted, err := q.DeleteUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return pgx.ErrNoRows
+		}
+		erasure, err = q.CreateUserErasure(ctx, sqlc.CreateUserErasureParams{
+			SubjectID:          userID,
+			Reference:          pgtype.Text{String: reference, Valid: reference != ""},
+			ErasedBy:           pgtype.UUID{Bytes: erasedBy, Valid: erasedBy != uuid.Nil},
+			CheckinsAnonymised: checkins + archived,
+			MembershipsRemoved: memberships,
+		})
+		return err
+	})
+	return erasure, err
+}
+
+func (s *Store) ListUserErasures(ctx context.Context, limit int32) ([]sqlc.UserErasure, error) {
+	return s.queries.ListUserErasures(ctx, limit)
+}
+
+// BrandingScope selects whose branding to read or write: a key, a location,
+// or neither for the global branding.
+type BrandingScope struct {
+	LocationID uuid.UUID
+	KeyID      uuid.UUID
+}
+
+func (b BrandingScope) params() (pgtype.UUID, pgtype.UUID) {
+	return pgtype.UUID{Bytes: b.LocationID, Valid: b.LocationID != uuid.Nil},
+		pgtype.UUID{Bytes: b.KeyID, Valid: b.KeyID != uuid.Nil}
+}
+
+// BrandingText holds the text settings of a branding scope. Empty fields fall
+// back to the next scope out.
+type BrandingText struct {
+	AccentColor string
+	WelcomeText string
+	Language    string
+}
+
+// Branding image kinds.
+const (
+	BrandingBackground = "background"
+	BrandingLogo       = "logo"
+)
+
+// BrandingImage is a stored branding image.
+type BrandingImage struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+}
+
+// ResolvedBranding is what a portal shows, each field taken from the nearest
+// scope that sets it: the key, then the location and its ancestors, then the
+// global branding.
+type ResolvedBranding struct {
+	BrandingText
+	// BackgroundID and LogoID name the branding rows holding the images, or
+	// uuid.Nil when there is none.
+	BackgroundID   uuid.UUID
+	BackgroundETag string
+	LogoID         uuid.UUID
+	LogoETag       string
+}
+
+// ListBranding returns every branding scope, without image data.
+func (s *Store) ListBranding(ctx context.Context) ([]sqlc.ListBrandingRow, error) {
+	return s.queries.ListBranding(ctx)
+}
+
+func (s *Store) GetBranding(ctx context.Context, scope BrandingScope) (sqlc.PortalBranding, error) {
+	locationID, keyID := scope.params()
+	return s.queries.GetBranding(ctx, sqlc.GetBrandingParams{LocationID: locationID, KeyID: keyID})
+}
+
+// SetBrandingText replaces a scope's text settings, keeping its images.
+func (s *Store) SetBrandingText(
+	ctx context.Context,
+	scope BrandingScope,
+	text BrandingText,
+) (sqlc.PortalBranding, error) {
+	return s.updateBranding(ctx, scope, func(params *sqlc.UpsertBrandingParams) {
+		params.AccentColor = pgtype.Text{String: text.AccentColor, Valid: text.AccentColor != ""}
+		params.WelcomeText = pgtype.Text{String: text.WelcomeText, Valid: text.WelcomeText != ""}
+		params.Language = pgtype.Text{String: text.Language, Valid: text.Language != ""}
+	})
+}
+
+// SetBrandingImage stores or, with nil data, clears a scope's background or
+// logo.
+func (s *Store) SetBrandingImage(
+	ctx context.Context,
+	scope BrandingScope,
+	kind string,
+	data []byte,
+	contentType string,
+) (sqlc.PortalBranding, error) {
+	contentTypeValue := pgtype.Text{String: contentType, Valid: data != nil}
+	etag := pgtype.Text{String: branding.ETag(data), Valid: data != nil}
+	return s.updateBranding(ctx, scope, func(params *sqlc.UpsertBrandingParams) {
+		if kind == BrandingLogo {
+			params.Logo, params.LogoType, params.LogoEtag = data, contentTypeValue, etag
+			return
+		}
+		params.Background, params.BackgroundType, params.BackgroundEtag = data, contentTypeValue, etag
+	})
+}
+
+// DeleteBranding removes a scope's branding and reports whether it existed.
+func (s *Store) DeleteBranding(ctx context.Context, scope BrandingScope) (bool, error) {
+	locationID, keyID := scope.params()
+	rows, err := s.queries.DeleteBranding(ctx, sqlc.DeleteBrandingParams{LocationID: locationID, KeyID: keyID})
+	return rows > 0, err
+}
+
+// GetBrandingImage loads an image from a branding row; uuid.Nil reads the
+// global branding. It returns pgx.ErrNoRows when there is no such image.
+func (s *Store) GetBrandingImage(ctx context.Context, id uuid.UUID, kind string) (BrandingImage, error) {
+	var row sqlc.PortalBranding
+	var err error
+	if id == uuid.Nil {
+		row, err = s.GetBranding(ctx, BrandingScope{})
+	} else {
+		row, err = s.queries.GetBrandingByID(ctx, id)
+	}
+	if err != nil {
+		return BrandingImage{}, err
+	}
+	img := BrandingImage{Data: row.Background, ContentType: row.BackgroundType.String, ETag: row.BackgroundEtag.String}
+	if kind == BrandingLogo {
+		img = BrandingImage{Data: row.Logo, ContentType: row.LogoType.String, ETag: row.LogoEtag.String}
+	}
+	if img.Data == nil {
+		return BrandingImage{}, pgx.ErrNoRows
+	}
+	return img, nil
+}
+
+// ResolveBranding works out the branding for a portal opened with a key at a
+// location.
+func (s *Store) ResolveBranding(ctx context.Context, keyID, locationID uuid.UUID) (ResolvedBranding, error) {
+	chain, err := s.locationChain(ctx, locationID)
+	if err != nil {
+		return ResolvedBranding{}, err
+	}
+	locationIDs := make([]uuid.UUID, 0, len(chain))
+	for _, loc := range chain {
+		locationIDs = append(locationIDs, loc.ID)
+	}
+	rows, err := s.queries.ListBrandingForPortal(ctx, sqlc.ListBrandingForPortalParams{
+		KeyID:       keyID,
+		LocationIds: locationIDs,
+	})
+	if err != nil {
+		return ResolvedBranding{}, err
+	}
+	// Rank rows from the key outwards to the global branding.
+	rank := func(row sqlc.ListBrandingForPortalRow) int {
+		switch {
+		case row.KeyID.Valid:
+			return 0
+		case row.LocationID.Valid:
+			return 1 + slices.Index(locationIDs, uuid.UUID(row.LocationID.Bytes))
+		}
+		return 1 + len(locationIDs)
+	}
+	slices.SortFunc(rows, func(a, b sqlc.ListBrandingForPortalRow) int { return rank(a) - rank(b) })
+
+	var resolved ResolvedBranding
+	for _, row := range rows {
+		resolved.AccentColor = cmp.Or(resolved.AccentColor, row.AccentColor.String)
+		resolved.WelcomeText = cmp.Or(resolved.WelcomeText, row.WelcomeText.String)
+		resolved.Language = cmp.Or(resolved.Language, row.Language.String)
+		if resolved.BackgroundID == uuid.Nil && row.BackgroundEtag.Valid {
+			resolved.BackgroundID, resolved.BackgroundETag = row.ID, row.BackgroundEtag.String
+		}
+		if resolved.LogoID == uuid.Nil && row.LogoEtag.Valid {
+			resolved.LogoID, resolved.LogoETag = row.ID, row.LogoEtag.String
+		}
+	}
+	return resolved, nil
+}
+
+// updateBranding applies change to a scope's current branding, creating it
+// if needed.
+func (s *Store) updateBranding(
+	ctx context.Context,
+	scope BrandingScope,
+	change func(*sqlc.UpsertBrandingParams),
+) (sqlc.PortalBranding, error) {
+	var saved sqlc.PortalBranding
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		locationID, keyID := scope.params()
+		current, err := q.GetBranding(ctx, sqlc.GetBrandingParams{LocationID: locationID, KeyID: keyID})
+		if errors.Is(err, pgx.ErrNoRows) {
+			current = sqlc.PortalBranding{ID: uuid.New(), LocationID: locationID, KeyID: keyID}
+		} else if err != nil {
+			return err
+		}
+		params := sqlc.UpsertBrandingParams{
+			ID:             current.ID,
+			LocationID:     locationID,
+			KeyID:          keyID,
+			AccentColor:    current.AccentColor,
+			WelcomeText:    current.WelcomeText,
+			Language:       current.Language,
+			Background:     current.Background,
+			BackgroundType: current.BackgroundType,
+			BackgroundEtag: current.BackgroundEtag,
+			Logo:           current.Logo,
+			LogoType:       current.LogoType,
+			LogoEtag:       current.LogoEtag,
+		}
+		change(&params)
+		saved, err = q.UpsertBranding(ctx, params)
+		return err
+	})
+	return saved, err
+}
+
 func (s *Store) SaveAsset(ctx context.Context, key, contentType string, data []byte) (sqlc.Asset, error) {
 	return s.queries.UpsertAsset(ctx, sqlc.UpsertAssetParams{
 		Key:         key,
@@ -439,6 +2599,104 @@ func (s *Store) DeleteAsset(ctx context.Context, key string) error {
 	return s.queries.DeleteAsset(ctx, key)
 }
 
+// ErrSettingConflict means a setting changed since the version the caller
+// last read.
+var ErrSettingConflict = errors.New("store: setting was changed by someone else")
+
+// ListSettings returns every stored setting.
+func (s *Store) ListSettings(ctx context.Context) ([]sqlc.Setting, error) {
+	return s.queries.ListSettings(ctx)
+}
+
+// SettingsVersion returns the newest setting version, or 0 when none are
+// stored.
+func (s *Store) SettingsVersion(ctx context.Context) (int64, error) {
+	return s.queries.GetSettingsVersion(ctx)
+}
+
+// SaveSetting stores a setting's JSON value; nil resets it to its default.
+// A non-nil expected version must match the stored one, with 0 meaning the
+// setting was never written.
+func (s *Store) SaveSetting(
+	ctx context.Context,
+	key string,
+	value []byte,
+	updatedBy uuid.UUID,
+	expected *int64,
+) (sqlc.Setting, error) {
+	params := sqlc.SaveSettingParams{
+		Key:       key,
+		Value:     value,
+		UpdatedBy: pgtype.UUID{Bytes: updatedBy, Valid: updatedBy != uuid.Nil},
+	}
+	if expected != nil {
+		params.ExpectedVersion = pgtype.Int8{Int64: *expected, Valid: true}
+	}
+	setting, err := s.queries.SaveSetting(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.Setting{}, ErrSettingConflict
+	}
+	return setting, err
+}
+
+// GetSyncState loads the stored delta link for a sync job.
+func (s *Store) GetSyncState(ctx context.Context, name string) (sqlc.SyncState, error) {
+	return s.queries.GetSyncState(ctx, name)
+}
+
+// SaveSyncState records a delta link and the select fingerprint it was issued
+// for; fullSync also stamps full_synced_at.
+func (s *Store) SaveSyncState(ctx context.Context, name, deltaLink, fingerprint string, fullSync bool) error {
+	params := sqlc.SaveSyncStateParams{Name: name, DeltaLink: deltaLink, Fingerprint: fingerprint}
+	if fullSync {
+		params.FullSyncedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	}
+	return s.queries.SaveSyncState(ctx, params)
+}
+
+// DeleteSyncState forgets a job's delta link so the next run is a full sync.
+func (s *Store) DeleteSyncState(ctx context.Context, name string) error {
+	return s.queries.DeleteSyncState(ctx, name)
+}
+
+// CreateSyncRun records the start of a sync run.
+func (s *Store) CreateSyncRun(ctx context.Context, trigger string, triggeredBy uuid.UUID) (sqlc.SyncRun, error) {
+	return s.queries.CreateSyncRun(ctx, sqlc.CreateSyncRunParams{
+		Trigger:     trigger,
+		TriggeredBy: pgtype.UUID{Bytes: triggeredBy, Valid: triggeredBy != uuid.Nil},
+	})
+}
+
+func (s *Store) FinishSyncRun(ctx context.Context, params sqlc.FinishSyncRunParams) (sqlc.SyncRun, error) {
+	return s.queries.FinishSyncRun(ctx, params)
+}
+
+// FailInterruptedSyncRuns closes runs left running by a crashed process.
+func (s *Store) FailInterruptedSyncRuns(ctx context.Context) error {
+	return s.queries.FailInterruptedSyncRuns(ctx)
+}
+
+func (s *Store) ListSyncRuns(ctx context.Context, limit int32) ([]sqlc.SyncRun, error) {
+	return s.queries.ListSyncRuns(ctx, limit)
+}
+
+func (s *Store) GetLastSuccessfulSyncRun(ctx context.Context) (sqlc.SyncRun, error) {
+	return s.queries.GetLastSuccessfulSyncRun(ctx)
+}
+
+// attributeFilter encodes attribute values for a JSONB containment match, or
+// nil to match everything.
+func attributeFilter(attributes map[string]string) []byte {
+	if len(attributes) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
 func nullUUID(v uuid.NullUUID) uuid.UUID {
 	if v.Valid {
 		return v.UUID
@@ -0,0 +1,35 @@
+// Package storetest opens the Postgres database package tests run against.
+package storetest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+// URLEnv names the variable holding the test database URL. Tests that need
+// Postgres are skipped when it is unset.
+const URLEnv = "TEST_DATABASE_URL"
+
+// Open connects to the test database and migrates it, or skips tb when no
+// database is configured. Tests share the database, so each one works on
+// rows it created itself.
+func Open(tb testing.TB) *store.Store {
+	tb.Helper()
+	url := os.Getenv(URLEnv)
+	if url == "" {
+		tb.Skipf("%s not set", URLEnv)
+	}
+	ctx := context.Background()
+	db, err := store.Open(ctx, store.Options{URL: url})
+	if err != nil {
+		tb.Fatalf("open test database: %v", err)
+	}
+	tb.Cleanup(db.Close)
+	if _, err = db.Migrate(ctx); err != nil {
+		tb.Fatalf("migrate test database: %v", err)
+	}
+	return db
+}
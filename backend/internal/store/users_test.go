@@ -0,0 +1,78 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+	"github.com/woodleighschool/signin-ui/internal/store/storetest"
+)
+
+func TestProvisionUserRefusesExistingAccounts(t *testing.T) {
+	db := storetest.Open(t)
+	ctx := context.Background()
+	loc, err := db.CreateLocation(ctx, sqlc.CreateLocationParams{
+		ID:       uuid.New(),
+		Name:     "Staff room",
+		Lower:    "staff-room-" + uuid.NewString(),
+		GroupIds: []uuid.UUID{},
+	})
+	if err != nil {
+		t.Fatalf("create location: %v", err)
+	}
+	admin, err := db.UpsertUser(ctx, sqlc.UpsertUserParams{
+		ID:          uuid.New(),
+		Upn:         "admin." + uuid.NewString() + "@school.example",
+		DisplayName: "Head of IT",
+		Department:  pgtype.Text{String: "IT", Valid: true},
+		IsAdmin:     true,
+		LocationIds: []uuid.UUID{loc.ID},
+		Source:      pgtype.Text{String: store.SourceLocal, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   uuid.UUID
+		upn  string
+	}{
+		{name: "claimed ID", id: admin.ID, upn: "mallory." + uuid.NewString() + "@evil.example"},
+		{name: "claimed UPN", id: uuid.New(), upn: admin.Upn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := store.Identity{Provider: "oidc", Subject: uuid.NewString(), Login: tt.upn}
+			_, err := db.ProvisionUser(ctx, identity, store.ProvisionUserParams{
+				ID:                 tt.id,
+				UPN:                tt.upn,
+				DisplayName:        "Mallory",
+				Department:         "Visitors",
+				DefaultLocationIDs: []uuid.UUID{},
+			})
+			if !errors.Is(err, store.ErrIdentityNotLinked) {
+				t.Fatalf("provision = %v, want ErrIdentityNotLinked", err)
+			}
+			got, err := db.GetUser(ctx, admin.ID)
+			if err != nil {
+				t.Fatalf("get admin: %v", err)
+			}
+			if got.Upn != admin.Upn || got.DisplayName != admin.DisplayName ||
+				got.Department != admin.Department || !got.IsAdmin ||
+				!slices.Equal(got.LocationIds, admin.LocationIds) || got.Source != admin.Source {
+				t.Errorf("admin = %+v, want unchanged %+v", got, admin)
+			}
+			identity.Login = "mallory@evil.example"
+			if _, err = db.SignIn(ctx, identity); !errors.Is(err, pgx.ErrNoRows) {
+				t.Errorf("sign in after refusal = %v, want no linked user", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+// userState and groupState name the persisted cursors of a source.
+func userState(source directory.Source) string {
+	return source.Name() + "-users"
+}
+
+func groupState(source directory.Source) string {
+	return source.Name() + "-groups"
+}
+
+// loadCursor returns the stored cursor, or "" when a full sync is due or the
+// cursor was issued for a different source fingerprint.
+func loadCursor(
+	ctx context.Context,
+	store *store.Store,
+	name, fingerprint string,
+	fullInterval time.Duration,
+) (string, error) {
+	state, err := store.GetSyncState(ctx, name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("load sync state %s: %w", name, err)
+	}
+	if state.Fingerprint != fingerprint {
+		return "", nil
+	}
+	if fullInterval > 0 && (!state.FullSyncedAt.Valid || time.Since(state.FullSyncedAt.Time) >= fullInterval) {
+		return "", nil
+	}
+	return state.DeltaLink, nil
+}
@@ -0,0 +1,269 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// syncGroups applies group and membership changes from the directory source.
+// Changed groups have their members replaced; nested membership changes are
+// picked up by the periodic full sync. Groups outside a scoped source's scope
+// are removed.
+func (r *Runner) syncGroups(ctx context.Context, stats *RunStats) error {
+	state := groupState(r.source)
+	fingerprint := r.source.Fingerprint()
+	cursor, err := loadCursor(ctx, r.store, state, fingerprint, r.opts.FullInterval)
+	if err != nil {
+		return err
+	}
+	delta, err := r.source.FetchGroups(ctx, cursor)
+	if errors.Is(err, directory.ErrCursorExpired) {
+		r.logger.WarnContext(ctx, "group cursor expired, running full sync", "source", r.source.Name())
+		delta, err = r.source.FetchGroups(ctx, "")
+	}
+	if err != nil {
+		return fmt.Errorf("fetch groups: %w", err)
+	}
+	var scope map[string]struct{}
+	if scoped, ok := r.source.(directory.Scoped); ok {
+		groupScope, restricted, scopeErr := scoped.GroupScope(ctx)
+		if scopeErr != nil {
+			return fmt.Errorf("list group scope: %w", scopeErr)
+		}
+		if restricted {
+			scope = groupScope
+		}
+	}
+	failed := 0
+	seen := make(map[uuid.UUID]struct{}, len(delta.Groups))
+	for _, g := range delta.Groups {
+		if !inScope(scope, g.ObjectID) {
+			delta.Removed = append(delta.Removed, g.ObjectID)
+			continue
+		}
+		groupID, syncErr := syncGroup(ctx, r.store, r.source.Name(), g, stats)
+		if syncErr != nil {
+			r.logger.ErrorContext(ctx, "sync group", "group", g.DisplayName, "err", syncErr)
+			failed++
+		}
+		seen[groupID] = struct{}{}
+	}
+	for _, objectID := range delta.Removed {
+		if objectID == "" {
+			continue
+		}
+		groupID := directoryID(objectID)
+		group, getErr := r.store.GetGroup(ctx, groupID)
+		if errors.Is(getErr, pgx.ErrNoRows) {
+			continue
+		}
+		if getErr == nil {
+			err = r.removeGroup(ctx, group, stats)
+		} else {
+			err = getErr
+		}
+		if err != nil {
+			r.logger.ErrorContext(ctx, "delete removed group", "group", groupID, "err", err)
+			failed++
+		}
+	}
+	// A full round lists every group, so anything synced earlier but missing
+	// now has vanished from the directory.
+	if delta.Full {
+		failed += r.removeVanishedGroups(ctx, seen, stats)
+	}
+	if scope != nil {
+		failed += r.reconcileGroupScope(ctx, scope, seen, stats)
+	}
+	// Keep the old link so failed items are retried on the next run.
+	if failed > 0 {
+		return fmt.Errorf("sync groups: %d changes failed", failed)
+	}
+	if err = r.store.SaveSyncState(ctx, state, delta.Cursor, fingerprint, delta.Full); err != nil {
+		return fmt.Errorf("save group cursor: %w", err)
+	}
+	r.logger.DebugContext(ctx, "group changes applied", "source", r.source.Name(),
+		"full", delta.Full, "changed", len(delta.Groups), "removed", len(delta.Removed))
+	return nil
+}
+
+// removeVanishedGroups deletes synced groups missing from a full listing and
+// returns how many deletions failed.
+func (r *Runner) removeVanishedGroups(ctx context.Context, seen map[uuid.UUID]struct{}, stats *RunStats) int {
+	synced, err := r.store.ListSyncedGroups(ctx, r.source.Name())
+	if err != nil {
+		r.logger.ErrorContext(ctx, "list synced groups", "err", err)
+		return 1
+	}
+	failed := 0
+	for _, group := range synced {
+		if _, ok := seen[group.ID]; ok {
+			continue
+		}
+		if err = r.removeGroup(ctx, group, stats); err != nil {
+			r.logger.ErrorContext(ctx, "delete vanished group", "group", group.ID, "err", err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// reconcileGroupScope removes synced groups outside the scope and loads
+// groups in scope that are not stored yet. It returns how many changes failed.
+func (r *Runner) reconcileGroupScope(
+	ctx context.Context,
+	scope map[string]struct{},
+	seen map[uuid.UUID]struct{},
+	stats *RunStats,
+) int {
+	synced, err := r.store.ListSyncedGroups(ctx, r.source.Name())
+	if err != nil {
+		r.logger.ErrorContext(ctx, "list synced groups", "err", err)
+		return 1
+	}
+	failed := 0
+	stored := make(map[string]struct{}, len(synced))
+	for _, group := range synced {
+		stored[group.ObjectID.String] = struct{}{}
+		if inScope(scope, group.ObjectID.String) {
+			continue
+		}
+		if err = r.removeGroup(ctx, group, stats); err != nil {
+			r.logger.ErrorContext(ctx, "delete out of scope group", "group", group.ID, "err", err)
+			failed++
+		}
+	}
+	scoped, ok := r.source.(directory.Scoped)
+	if !ok {
+		return failed
+	}
+	for objectID := range scope {
+		if _, ok = stored[objectID]; ok {
+			continue
+		}
+		if _, ok = seen[directoryID(objectID)]; ok {
+			continue
+		}
+		g, fetchErr := scoped.FetchGroup(ctx, objectID)
+		if fetchErr == nil {
+			_, fetchErr = syncGroup(ctx, r.store, r.source.Name(), g, stats)
+		}
+		if fetchErr != nil {
+			r.logger.ErrorContext(ctx, "sync group entering scope", "group", objectID, "err", fetchErr)
+			failed++
+		}
+	}
+	return failed
+}
+
+// removeGroup deletes a group, warning about locations that still list it.
+func (r *Runner) removeGroup(ctx context.Context, group sqlc.Group, stats *RunStats) error {
+	locations, err := r.store.ListLocationsForGroup(ctx, group.ID)
+	if err != nil {
+		return fmt.Errorf("list locations for group: %w", err)
+	}
+	deleted, err := r.store.DeleteGroup(ctx, group.ID)
+	if err != nil || !deleted {
+		return err
+	}
+	removed := RemovedGroup{ID: group.ID, DisplayName: group.DisplayName}
+	for _, loc := range locations {
+		removed.LocationIDs = append(removed.LocationIDs, loc.ID)
+		r.logger.WarnContext(ctx, "location references removed group",
+			"location", loc.Name, "location_id", loc.ID, "group", group.DisplayName, "group_id", group.ID)
+	}
+	stats.GroupsDeleted++
+	stats.Diff.RemovedGroups = append(stats.Diff.RemovedGroups, removed)
+	return nil
+}
+
+// syncGroup upserts a group and sets its members, returning the group ID.
+func syncGroup(
+	ctx context.Context,
+	store *store.Store,
+	source string,
+	g directory.Group,
+	stats *RunStats,
+) (uuid.UUID, error) {
+	groupID := parseGroupID(g.ObjectID)
+	objectID := g.ObjectID
+	if objectID == "" {
+		objectID = groupID.String()
+	}
+	params := sqlc.UpsertGroupParams{
+		ID:          groupID,
+		DisplayName: g.DisplayName,
+		Description: pgtype.Text{String: g.Description, Valid: g.Description != ""},
+		ObjectID:    pgtype.Text{String: objectID, Valid: true},
+		Source:      pgtype.Text{String: source, Valid: true},
+	}
+	// Membership-only delta items carry no properties; keep the stored ones.
+	if g.DisplayName == "" {
+		existing, err := store.GetGroup(ctx, groupID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return groupID, fmt.Errorf("load group: %w", err)
+		}
+		if err == nil {
+			params.DisplayName = existing.DisplayName
+			params.Description = existing.Description
+		}
+	}
+	row, err := store.UpsertGroup(ctx, params)
+	if err != nil {
+		return groupID, fmt.Errorf("upsert group: %w", err)
+	}
+	if wasInserted(row.CreatedAt, row.UpdatedAt) {
+		stats.GroupsCreated++
+	} else {
+		stats.GroupsUpdated++
+	}
+	// An empty member list empties the group rather than leaving stale members.
+	added, removed, err := store.ReplaceGroupMembers(ctx, row.ID, parseGroupMembers(g.Members))
+	if err != nil {
+		return row.ID, fmt.Errorf("replace group members: %w", err)
+	}
+	if added > 0 || removed > 0 {
+		stats.Diff.Memberships = append(stats.Diff.Memberships, MembershipChange{
+			GroupID:     row.ID,
+			DisplayName: row.DisplayName,
+			Added:       added,
+			Removed:     removed,
+		})
+	}
+	return row.ID, nil
+}
+
+// inScope reports whether an object ID is in a scope set; a nil set
+// includes everything.
+func inScope(scope map[string]struct{}, objectID string) bool {
+	if scope == nil {
+		return true
+	}
+	_, ok := scope[objectID]
+	return ok
+}
+
+func parseGroupMembers(memberIDs []string) []uuid.UUID {
+	var members []uuid.UUID
+	for _, memberID := range memberIDs {
+		if memberID != "" {
+			members = append(members, directoryID(memberID))
+		}
+	}
+	return members
+}
+
+func parseGroupID(objectID string) uuid.UUID {
+	if objectID == "" {
+		return uuid.New()
+	}
+	return directoryID(objectID)
+}
@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/woodleighschool/signin-ui/internal/store"
+)
+
+// partitionLockKey is the Postgres advisory lock held while partitions are
+// created or archived.
+const partitionLockKey int64 = 0x7369676e696e05
+
+// ErrPartitionsBusy means another replica is maintaining partitions.
+var ErrPartitionsBusy = errors.New("syncer: partition maintenance already running")
+
+// PartitionPolicy is how far ahead checkin partitions are created and how
+// long they stay attached.
+type PartitionPolicy struct {
+	MonthsAhead int
+	// ArchiveAfterMonths of 0 never archives.
+	ArchiveAfterMonths int
+}
+
+// PartitionChanges lists the partitions one maintenance pass touched.
+type PartitionChanges struct {
+	Created  []string
+	Archived []string
+}
+
+// MaintainPartitions creates upcoming monthly checkin partitions, gives rows
+// in the default partition a month of their own, and archives partitions
+// past the policy's horizon.
+func MaintainPartitions(ctx context.Context, db *store.Store, policy PartitionPolicy) (PartitionChanges, error) {
+	var changes PartitionChanges
+	unlock, ok, err := db.TryAdvisoryLock(ctx, partitionLockKey)
+	if err != nil {
+		return changes, err
+	}
+	if !ok {
+		return changes, ErrPartitionsBusy
+	}
+	defer unlock()
+
+	now := time.Now().UTC()
+	var archiveBefore time.Time
+	if policy.ArchiveAfterMonths > 0 {
+		thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		archiveBefore = thisMonth.AddDate(0, -policy.ArchiveAfterMonths, 0)
+	}
+	if changes.Created, err = db.EnsureCheckinPartitions(ctx, now, policy.MonthsAhead, archiveBefore); err != nil {
+		return changes, err
+	}
+	if !archiveBefore.IsZero() {
+		changes.Archived, err = db.ArchiveCheckinPartitions(ctx, archiveBefore)
+	}
+	return changes, err
+}
+
+// PartitionJob maintains partitions on a schedule, reading the policy as each
+// run starts. Overlapping runs are skipped.
+func PartitionJob(db *store.Store, policy func() PartitionPolicy, logger *slog.Logger) Job {
+	return func(ctx context.Context) error {
+		changes, err := MaintainPartitions(ctx, db, policy())
+		if errors.Is(err, ErrPartitionsBusy) {
+			logger.DebugContext(ctx, "partition maintenance already running, skipping")
+			return nil
+		}
+		if len(changes.Created) > 0 || len(changes.Archived) > 0 {
+			logger.InfoContext(ctx, "checkin partitions maintained",
+				"created", changes.Created, "archived", changes.Archived)
+		}
+		return err
+	}
+}
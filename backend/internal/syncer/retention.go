@@ -0,0 +1,95 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// retentionLockKey is the Postgres advisory lock held for a retention pass.
+const retentionLockKey int64 = 0x7369676e696e04
+
+// ErrRetentionRunning means another pass, possibly on another replica, holds
+// the lock.
+var ErrRetentionRunning = errors.New("syncer: retention already running")
+
+// RunRetention applies every location's retention rule and records the pass
+// in retention_runs. A failing location does not stop the others.
+func RunRetention(
+	ctx context.Context,
+	db *store.Store,
+	batchSize int32,
+	logger *slog.Logger,
+) (sqlc.RetentionRun, error) {
+	unlock, ok, err := db.TryAdvisoryLock(ctx, retentionLockKey)
+	if err != nil {
+		return sqlc.RetentionRun{}, err
+	}
+	if !ok {
+		return sqlc.RetentionRun{}, ErrRetentionRunning
+	}
+	defer unlock()
+	// Holding the lock means any run still marked running was cut short.
+	if err = db.FailInterruptedRetentionRuns(ctx); err != nil {
+		return sqlc.RetentionRun{}, fmt.Errorf("close interrupted runs: %w", err)
+	}
+	run, err := db.CreateRetentionRun(ctx)
+	if err != nil {
+		return sqlc.RetentionRun{}, fmt.Errorf("record retention run: %w", err)
+	}
+
+	var total store.RetentionCounts
+	var failed int
+	rules, err := db.ListRetentionRules(ctx)
+	now := time.Now()
+	for _, rule := range rules {
+		counts, applyErr := db.ApplyRetention(ctx, rule, now, batchSize)
+		total.Add(counts)
+		if applyErr != nil {
+			failed++
+			logger.ErrorContext(ctx, "apply retention", "location", rule.LocationID, "err", applyErr)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("retention: %d locations failed", failed)
+	}
+	if pruned, pruneErr := db.DeleteExpiredCheckinRequests(ctx, now); pruneErr != nil {
+		logger.WarnContext(ctx, "prune checkin idempotency keys", "err", pruneErr)
+	} else if pruned > 0 {
+		logger.DebugContext(ctx, "pruned checkin idempotency keys", "count", pruned)
+	}
+
+	// Record the outcome even when the run context has expired.
+	finished, finishErr := db.FinishRetentionRun(context.WithoutCancel(ctx), run.ID, total, err)
+	if finishErr != nil {
+		logger.ErrorContext(ctx, "record retention run result", "run", run.ID, "err", finishErr)
+		finished = run
+	}
+	return finished, err
+}
+
+// RetentionJob runs retention on a schedule, reading the batch size as each
+// run starts. Overlapping runs are skipped.
+func RetentionJob(db *store.Store, batchSize func() int32, logger *slog.Logger) Job {
+	return func(ctx context.Context) error {
+		run, err := RunRetention(ctx, db, batchSize(), logger)
+		if errors.Is(err, ErrRetentionRunning) {
+			logger.DebugContext(ctx, "retention already running, skipping")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		logger.InfoContext(ctx, "retention applied", "run", run.ID,
+			"deleted", run.Deleted, "anonymised", run.Anonymised, "notesCleared", run.NotesCleared)
+		return nil
+	}
+}
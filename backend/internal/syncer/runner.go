@@ -0,0 +1,239 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// Trigger sources recorded on sync runs.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// syncLockKey is the Postgres advisory lock held for the duration of a run.
+const syncLockKey int64 = 0x7369676e696e01
+
+var (
+	// ErrSyncRunning means another run, possibly on another replica, holds the lock.
+	ErrSyncRunning = errors.New("syncer: sync already running")
+	// ErrNotConfigured means no directory source is configured.
+	ErrNotConfigured = errors.New("syncer: directory source not configured")
+)
+
+// RunStats counts changes applied during one run.
+type RunStats struct {
+	UsersCreated  int32
+	UsersUpdated  int32
+	UsersArchived int32
+	GroupsCreated int32
+	GroupsUpdated int32
+	GroupsDeleted int32
+	Diff          RunDiff
+}
+
+// RunDiff details group removals and membership changes, stored with the run.
+type RunDiff struct {
+	RemovedGroups []RemovedGroup     `json:"removedGroups,omitempty"`
+	Memberships   []MembershipChange `json:"memberships,omitempty"`
+}
+
+// RemovedGroup is a group deleted because it vanished from the directory.
+// LocationIDs lists locations whose rosters still reference it.
+type RemovedGroup struct {
+	ID          uuid.UUID   `json:"id"`
+	DisplayName string      `json:"displayName"`
+	LocationIDs []uuid.UUID `json:"locationIds,omitempty"`
+}
+
+// MembershipChange counts members added to and removed from one group.
+type MembershipChange struct {
+	GroupID     uuid.UUID `json:"groupId"`
+	DisplayName string    `json:"displayName"`
+	Added       int       `json:"added"`
+	Removed     int       `json:"removed"`
+}
+
+// Options tune how a Runner syncs.
+type Options struct {
+	// FullInterval forces a full listing at least this often.
+	FullInterval time.Duration
+	// Timeout bounds runs started with Start, read as each run starts.
+	Timeout func() time.Duration
+}
+
+// Runner syncs users then groups from a directory source and records each run.
+type Runner struct {
+	store  *store.Store
+	source directory.Source
+	opts   Options
+	logger *slog.Logger
+}
+
+// NewRunner builds a runner.
+func NewRunner(store *store.Store, source directory.Source, opts Options, logger *slog.Logger) *Runner {
+	return &Runner{
+		store:  store,
+		source: source,
+		opts:   opts,
+		logger: logger,
+	}
+}
+
+// Enabled reports whether the directory source is configured.
+func (r *Runner) Enabled() bool {
+	return r != nil && r.source != nil && r.source.Enabled()
+}
+
+// Job adapts the runner for the scheduler. Overlapping runs are skipped.
+func (r *Runner) Job() Job {
+	return func(ctx context.Context) error {
+		run, unlock, err := r.begin(ctx, TriggerSchedule, uuid.Nil)
+		if errors.Is(err, ErrSyncRunning) {
+			r.logger.DebugContext(ctx, "sync already running, skipping")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = r.execute(ctx, run, unlock)
+		return err
+	}
+}
+
+// Start launches a manual run in the background and returns its record.
+func (r *Runner) Start(ctx context.Context, triggeredBy uuid.UUID) (sqlc.SyncRun, error) {
+	run, unlock, err := r.begin(ctx, TriggerManual, triggeredBy)
+	if err != nil {
+		return sqlc.SyncRun{}, err
+	}
+	go func() {
+		runCtx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout())
+		defer cancel()
+		if _, execErr := r.execute(runCtx, run, unlock); execErr != nil {
+			r.logger.Error("manual sync failed", "run", run.ID, "err", execErr)
+		}
+	}()
+	return run, nil
+}
+
+// Run syncs in the foreground and returns the finished run record.
+func (r *Runner) Run(ctx context.Context, triggeredBy uuid.UUID) (sqlc.SyncRun, error) {
+	run, unlock, err := r.begin(ctx, TriggerManual, triggeredBy)
+	if err != nil {
+		return sqlc.SyncRun{}, err
+	}
+	return r.execute(ctx, run, unlock)
+}
+
+// begin takes the cross-replica lock and records a running sync run.
+func (r *Runner) begin(ctx context.Context, trigger string, triggeredBy uuid.UUID) (sqlc.SyncRun, func(), error) {
+	if !r.Enabled() {
+		return sqlc.SyncRun{}, nil, ErrNotConfigured
+	}
+	unlock, ok, err := r.store.TryAdvisoryLock(ctx, syncLockKey)
+	if err != nil {
+		return sqlc.SyncRun{}, nil, err
+	}
+	if !ok {
+		return sqlc.SyncRun{}, nil, ErrSyncRunning
+	}
+	// Holding the lock means any run still marked running was cut short.
+	if err = r.store.FailInterruptedSyncRuns(ctx); err != nil {
+		unlock()
+		return sqlc.SyncRun{}, nil, fmt.Errorf("close interrupted runs: %w", err)
+	}
+	run, err := r.store.CreateSyncRun(ctx, trigger, triggeredBy)
+	if err != nil {
+		unlock()
+		return sqlc.SyncRun{}, nil, fmt.Errorf("record sync run: %w", err)
+	}
+	return run, unlock, nil
+}
+
+// execute syncs users then groups, refreshes rule-based local groups, stores
+// the outcome and releases the lock.
+func (r *Runner) execute(ctx context.Context, run sqlc.SyncRun, unlock func()) (sqlc.SyncRun, error) {
+	defer unlock()
+	var stats RunStats
+	err := r.syncUsers(ctx, &stats)
+	if err == nil {
+		err = r.syncGroups(ctx, &stats)
+	}
+	if err == nil {
+		err = r.refreshRuleGroups(ctx, &stats)
+	}
+	params := sqlc.FinishSyncRunParams{
+		ID:            run.ID,
+		Status:        "succeeded",
+		UsersCreated:  stats.UsersCreated,
+		UsersUpdated:  stats.UsersUpdated,
+		UsersArchived: stats.UsersArchived,
+		GroupsCreated: stats.GroupsCreated,
+		GroupsUpdated: stats.GroupsUpdated,
+		GroupsDeleted: stats.GroupsDeleted,
+	}
+	if err != nil {
+		params.Status = "failed"
+		params.Error = pgtype.Text{String: err.Error(), Valid: true}
+	}
+	if len(stats.Diff.RemovedGroups) > 0 || len(stats.Diff.Memberships) > 0 {
+		if diff, marshalErr := json.Marshal(stats.Diff); marshalErr == nil {
+			params.Diff = diff
+		}
+	}
+	// Record the outcome even when the run context has expired.
+	finished, finishErr := r.store.FinishSyncRun(context.WithoutCancel(ctx), params)
+	if finishErr != nil {
+		r.logger.ErrorContext(ctx, "record sync run result", "run", run.ID, "err", finishErr)
+		finished = run
+	}
+	return finished, err
+}
+
+// refreshRuleGroups re-derives local group members from the synced users and
+// groups, recording membership changes in the run diff.
+func (r *Runner) refreshRuleGroups(ctx context.Context, stats *RunStats) error {
+	changes, err := r.store.RefreshRuleGroups(ctx)
+	for _, change := range changes {
+		stats.Diff.Memberships = append(stats.Diff.Memberships, MembershipChange{
+			GroupID:     change.GroupID,
+			DisplayName: change.DisplayName,
+			Added:       change.Added,
+			Removed:     change.Removed,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("refresh rule groups: %w", err)
+	}
+	return nil
+}
+
+// RuleGroupsJob refreshes rule-based local groups on a schedule, for
+// deployments without a directory sync to do it after each run.
+func RuleGroupsJob(store *store.Store, logger *slog.Logger) Job {
+	return func(ctx context.Context) error {
+		changes, err := store.RefreshRuleGroups(ctx)
+		if err != nil {
+			return err
+		}
+		logger.DebugContext(ctx, "rule groups refreshed", "changed", len(changes))
+		return nil
+	}
+}
+
+// wasInserted reports whether an upserted row was new. Inserts leave
+// created_at equal to updated_at; the update trigger moves updated_at on.
+func wasInserted(createdAt, updatedAt pgtype.Timestamptz) bool {
+	return createdAt.Time.Equal(updatedAt.Time)
+}
@@ -0,0 +1,212 @@
+package syncer_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/woodleighschool/signin-ui/internal/graph"
+	"github.com/woodleighschool/signin-ui/internal/store/storetest"
+	"github.com/woodleighschool/signin-ui/internal/syncer"
+)
+
+// fakeGraph serves the Graph delta and membership endpoints the sync uses.
+// A full round pages users one at a time; a round resumed from a delta link
+// returns the pending changes.
+type fakeGraph struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	users       []map[string]any
+	userChanges []map[string]any
+	groups      []map[string]any
+	members     map[string][]string
+}
+
+func newFakeGraph(t *testing.T) *fakeGraph {
+	t.Helper()
+	f := &fakeGraph{members: make(map[string][]string)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGraph) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1.0")
+	query := r.URL.Query()
+	switch {
+	case path == "/users/delta()" && query.Has("$deltatoken"):
+		f.page(w, f.userChanges, "", "/users/delta()?$deltatoken=next")
+	case path == "/users/delta()":
+		page := 0
+		if query.Has("$skiptoken") {
+			page = len(f.users) - 1
+		}
+		if page < len(f.users)-1 {
+			f.page(w, f.users[page:page+1], "/users/delta()?$skiptoken=1", "")
+			return
+		}
+		f.page(w, f.users[page:], "", "/users/delta()?$deltatoken=first")
+	case path == "/groups/delta()" && query.Has("$deltatoken"):
+		f.page(w, nil, "", "/groups/delta()?$deltatoken=next")
+	case path == "/groups/delta()":
+		f.page(w, f.groups, "", "/groups/delta()?$deltatoken=first")
+	case strings.HasPrefix(path, "/groups/") && strings.HasSuffix(path, "/transitiveMembers"):
+		groupID := strings.TrimSuffix(strings.TrimPrefix(path, "/groups/"), "/transitiveMembers")
+		var value []map[string]any
+		for _, id := range f.members[groupID] {
+			value = append(value, map[string]any{"@odata.type": "#microsoft.graph.user", "id": id})
+		}
+		f.page(w, value, "", "")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGraph) page(w http.ResponseWriter, value []map[string]any, next, delta string) {
+	body := map[string]any{"value": value}
+	if value == nil {
+		body["value"] = []any{}
+	}
+	if next != "" {
+		body["@odata.nextLink"] = f.URL + "/v1.0" + next
+	}
+	if delta != "" {
+		body["@odata.deltaLink"] = f.URL + "/v1.0" + delta
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func graphUser(id, upn, name string) map[string]any {
+	return map[string]any{
+		"id":                id,
+		"userPrincipalName": upn,
+		"displayName":       name,
+		"accountEnabled":    true,
+		"department":        "Science",
+	}
+}
+
+func TestRunnerSyncsGraphDeltas(t *testing.T) {
+	db := storetest.Open(t)
+	ctx := context.Background()
+	fake := newFakeGraph(t)
+
+	alice, bob, group := uuid.New(), uuid.New(), uuid.New()
+	aliceUPN := "alice." + alice.String() + "@example.edu"
+	bobUPN := "bob." + bob.String() + "@example.edu"
+	fake.users = []map[string]any{
+		graphUser(alice.String(), aliceUPN, "Alice"),
+		graphUser(bob.String(), bobUPN, "Bob"),
+	}
+	fake.groups = []map[string]any{{"id": group.String(), "displayName": "Year 7 " + group.String()}}
+	fake.members[group.String()] = []string{alice.String(), bob.String()}
+
+	client, err := graph.NewClientForServer(fake.URL+"/v1.0", fake.Client())
+	if err != nil {
+		t.Fatalf("new graph client: %v", err)
+	}
+	source := graph.NewSource(client, graph.UserScope{}, "", nil)
+	runner := syncer.NewRunner(db, source, syncer.Options{}, slog.New(slog.DiscardHandler))
+	// Start from a full round whatever earlier runs left behind.
+	for _, state := range []string{graph.SourceName + "-users", graph.SourceName + "-groups"} {
+		if err = db.DeleteSyncState(ctx, state); err != nil {
+			t.Fatalf("reset %s: %v", state, err)
+		}
+	}
+
+	run, err := runner.Run(ctx, uuid.Nil)
+	if err != nil {
+		t.Fatalf("full sync: %v", err)
+	}
+	if run.Status != "succeeded" || run.UsersCreated != 2 || run.GroupsCreated != 1 {
+		t.Fatalf("full sync run = %s, %d users and %d groups created; want succeeded, 2 and 1",
+			run.Status, run.UsersCreated, run.GroupsCreated)
+	}
+	user, err := db.GetUserByUPN(ctx, aliceUPN)
+	if err != nil {
+		t.Fatalf("get synced user: %v", err)
+	}
+	if user.ID != alice || user.DisplayName != "Alice" || user.Department.String != "Science" {
+		t.Errorf("synced user = %s %q %q, want %s \"Alice\" \"Science\"",
+			user.ID, user.DisplayName, user.Department.String, alice)
+	}
+	members, err := db.ListGroupMembers(ctx, group)
+	if err != nil {
+		t.Fatalf("list group members: %v", err)
+	}
+	var memberIDs []uuid.UUID
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.ID)
+	}
+	if len(memberIDs) != 2 || !slices.Contains(memberIDs, alice) || !slices.Contains(memberIDs, bob) {
+		t.Errorf("group members = %v, want %s and %s", memberIDs, alice, bob)
+	}
+	state, err := db.GetSyncState(ctx, graph.SourceName+"-users")
+	if err != nil {
+		t.Fatalf("get user cursor: %v", err)
+	}
+	if !strings.HasSuffix(state.DeltaLink, "$deltatoken=first") {
+		t.Errorf("user cursor = %q, want the full round's delta link", state.DeltaLink)
+	}
+
+	fake.mu.Lock()
+	fake.userChanges = []map[string]any{
+		graphUser(alice.String(), aliceUPN, "Alice Renamed"),
+		{"id": bob.String(), "@removed": map[string]any{"reason": "deleted"}},
+	}
+	fake.mu.Unlock()
+
+	run, err = runner.Run(ctx, uuid.Nil)
+	if err != nil {
+		t.Fatalf("delta sync: %v", err)
+	}
+	if run.Status != "succeeded" || run.UsersArchived != 1 {
+		t.Fatalf("delta sync run = %s, %d users archived; want succeeded, 1", run.Status, run.UsersArchived)
+	}
+	if user, err = db.GetUser(ctx, alice); err != nil || user.DisplayName != "Alice Renamed" {
+		t.Errorf("renamed user = %q, %v; want \"Alice Renamed\"", user.DisplayName, err)
+	}
+	if user, err = db.GetUser(ctx, bob); err != nil || !user.ArchivedAt.Valid {
+		t.Errorf("removed user archived = %t, %v; want archived", user.ArchivedAt.Valid, err)
+	}
+
+	// Delta items carry only the properties that changed.
+	fake.mu.Lock()
+	fake.userChanges = []map[string]any{
+		{"id": alice.String(), "department": "Mathematics"},
+		{"id": bob.String(), "displayName": "Bob Returned"},
+	}
+	fake.mu.Unlock()
+
+	if run, err = runner.Run(ctx, uuid.Nil); err != nil || run.Status != "succeeded" {
+		t.Fatalf("partial delta sync = %v, %v; want succeeded", run.Status, err)
+	}
+	user, err = db.GetUser(ctx, alice)
+	if err != nil {
+		t.Fatalf("get partially updated user: %v", err)
+	}
+	if user.Upn != aliceUPN || user.DisplayName != "Alice Renamed" || user.Department.String != "Mathematics" ||
+		user.ArchivedAt.Valid {
+		t.Errorf("partially updated user = %s %q %q archived %t, want %s \"Alice Renamed\" \"Mathematics\" active",
+			user.Upn, user.DisplayName, user.Department.String, user.ArchivedAt.Valid, aliceUPN)
+	}
+	user, err = db.GetUser(ctx, bob)
+	if err != nil {
+		t.Fatalf("get archived user: %v", err)
+	}
+	if user.Upn != bobUPN || user.DisplayName != "Bob Returned" || !user.ArchivedAt.Valid {
+		t.Errorf("archived user = %s %q archived %t, want %s \"Bob Returned\" still archived",
+			user.Upn, user.DisplayName, user.ArchivedAt.Valid, bobUPN)
+	}
+}
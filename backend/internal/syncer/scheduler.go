@@ -25,10 +25,10 @@ func NewScheduler(logger *slog.Logger) *Scheduler {
 	}
 }
 
-// Add registers a cron entry with a per-run timeout.
-func (s *Scheduler) Add(spec, name string, timeout time.Duration, job Job) error {
+// Add registers a cron entry with a per-run timeout, read as each run starts.
+func (s *Scheduler) Add(spec, name string, timeout func() time.Duration, job Job) error {
 	_, err := s.cron.AddFunc(spec, func() {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout())
 		defer cancel()
 		if err := job(ctx); err != nil {
 			s.logger.Error("sync job failed", "job", name, "err", err)
@@ -0,0 +1,346 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/woodleighschool/signin-ui/internal/directory"
+	"github.com/woodleighschool/signin-ui/internal/store"
+	"github.com/woodleighschool/signin-ui/internal/store/sqlc"
+)
+
+// syncUsers applies user changes from the directory source. A full listing
+// runs when no cursor is stored, the cursor has expired, the source
+// fingerprint changed, or the last full sync is older than the runner's full
+// interval. Users missing from a full listing, or outside a scoped source's
+// scope, are archived.
+func (r *Runner) syncUsers(ctx context.Context, stats *RunStats) error {
+	state := userState(r.source)
+	fingerprint := r.source.Fingerprint()
+	cursor, err := loadCursor(ctx, r.store, state, fingerprint, r.opts.FullInterval)
+	if err != nil {
+		return err
+	}
+	changes, err := r.source.FetchUsers(ctx, cursor)
+	if errors.Is(err, directory.ErrCursorExpired) {
+		r.logger.WarnContext(ctx, "user cursor expired, running full sync", "source", r.source.Name())
+		changes, err = r.source.FetchUsers(ctx, "")
+	}
+	if err != nil {
+		return fmt.Errorf("fetch users: %w", err)
+	}
+	var scope map[string]struct{}
+	if scoped, ok := r.source.(directory.Scoped); ok {
+		userScope, restricted, scopeErr := scoped.UserScope(ctx)
+		if scopeErr != nil {
+			return fmt.Errorf("list user scope: %w", scopeErr)
+		}
+		if restricted {
+			scope = userScope
+		}
+	}
+	failed := 0
+	seen := make(map[string]struct{}, len(changes.Users))
+	for _, u := range changes.Users {
+		seen[u.ObjectID] = struct{}{}
+		if err = syncDirectoryUser(ctx, r.store, r.source.Name(), u, scope, stats); err != nil {
+			r.logger.ErrorContext(ctx, "sync user", "upn", u.UPN, "err", err)
+			failed++
+		}
+	}
+	for _, objectID := range changes.Removed {
+		userID, ok := parseDirectoryUserID(objectID)
+		if !ok {
+			continue
+		}
+		archived, archiveErr := r.store.ArchiveUser(ctx, userID, archiveReasonRemoved)
+		if archiveErr != nil {
+			r.logger.ErrorContext(ctx, "archive removed user", "user", userID, "err", archiveErr)
+			failed++
+			continue
+		}
+		if archived {
+			stats.UsersArchived++
+		}
+	}
+	if changes.Full || scope != nil {
+		failed += r.reconcileUsers(ctx, changes.Full, scope, seen, stats)
+	}
+	// Keep the old cursor so failed items are retried on the next run.
+	if failed > 0 {
+		return fmt.Errorf("sync users: %d changes failed", failed)
+	}
+	if err = r.store.SaveSyncState(ctx, state, changes.Cursor, fingerprint, changes.Full); err != nil {
+		return fmt.Errorf("save user cursor: %w", err)
+	}
+	r.logger.DebugContext(ctx, "user changes applied", "source", r.source.Name(),
+		"full", changes.Full, "changed", len(changes.Users), "removed", len(changes.Removed))
+	return nil
+}
+
+// reconcileUsers archives synced users missing from a full listing or outside
+// the scope, and loads users that entered the scope without appearing in the
+// changes. It returns how many changes failed.
+func (r *Runner) reconcileUsers(
+	ctx context.Context,
+	full bool,
+	scope, seen map[string]struct{},
+	stats *RunStats,
+) int {
+	synced, err := r.store.ListSyncedUsers(ctx, r.source.Name())
+	if err != nil {
+		r.logger.ErrorContext(ctx, "list synced users", "err", err)
+		return 1
+	}
+	failed := 0
+	settled := make(map[string]struct{}, len(synced))
+	for _, user := range synced {
+		objectID := user.ObjectID.String
+		_, wasSeen := seen[objectID]
+		reason := ""
+		switch {
+		case user.ArchivedAt.Valid:
+			// Users archived for other reasons stay archived until the source
+			// reports them again.
+			if user.ArchiveReason.String != archiveReasonOutOfScope {
+				settled[objectID] = struct{}{}
+			}
+			continue
+		case full && !wasSeen:
+			reason = archiveReasonRemoved
+		case !inScope(scope, objectID):
+			reason = archiveReasonOutOfScope
+		default:
+			settled[objectID] = struct{}{}
+			continue
+		}
+		settled[objectID] = struct{}{}
+		archived, archiveErr := r.store.ArchiveUser(ctx, user.ID, reason)
+		if archiveErr != nil {
+			r.logger.ErrorContext(ctx, "archive user", "user", user.ID, "reason", reason, "err", archiveErr)
+			failed++
+			continue
+		}
+		if archived {
+			stats.UsersArchived++
+		}
+	}
+	scoped, ok := r.source.(directory.Scoped)
+	if !ok || scope == nil {
+		return failed
+	}
+	for objectID := range scope {
+		if _, ok = seen[objectID]; ok {
+			continue
+		}
+		if _, ok = settled[objectID]; ok {
+			continue
+		}
+		u, fetchErr := scoped.FetchUser(ctx, objectID)
+		if fetchErr == nil {
+			fetchErr = syncDirectoryUser(ctx, r.store, r.source.Name(), u, scope, stats)
+		}
+		if fetchErr != nil {
+			r.logger.ErrorContext(ctx, "sync user entering scope", "user", objectID, "err", fetchErr)
+			failed++
+		}
+	}
+	return failed
+}
+
+// Reasons recorded when the syncer archives a user.
+const (
+	archiveReasonDisabled   = "account disabled"
+	archiveReasonGuest      = "guest account"
+	archiveReasonRemoved    = "removed from directory"
+	archiveReasonOutOfScope = "outside sync scope"
+)
+
+// skipReason returns why an inactive, guest or out of scope user is
+// archived, or "". A nil scope includes everyone.
+func skipReason(u directory.User, scope map[string]struct{}) string {
+	if !inScope(scope, u.ObjectID) {
+		return archiveReasonOutOfScope
+	}
+	if u.Active != nil && !*u.Active {
+		return archiveReasonDisabled
+	}
+	if strings.Contains(strings.ToUpper(u.UPN), "#EXT#") {
+		return archiveReasonGuest
+	}
+	return ""
+}
+
+// directoryNamespace derives stable user and group IDs from object IDs that
+// are not UUIDs, such as SIS student numbers.
+var directoryNamespace = uuid.MustParse("06fc0f71-f4c1-4842-8801-af5ae58113b1")
+
+// directoryID maps an object ID to a row ID. UUID object IDs, as used by
+// Entra ID, are kept as they are.
+func directoryID(objectID string) uuid.UUID {
+	if id, err := uuid.Parse(objectID); err == nil {
+		return id
+	}
+	return uuid.NewSHA1(directoryNamespace, []byte(objectID))
+}
+
+// parseDirectoryUserID maps the object ID, reporting false when it is empty.
+func parseDirectoryUserID(objectID string) (uuid.UUID, bool) {
+	if objectID == "" {
+		return uuid.Nil, false
+	}
+	return directoryID(objectID), true
+}
+
+// archiveDirectoryUser soft-deletes a user by object ID or UPN.
+func archiveDirectoryUser(
+	ctx context.Context,
+	store *store.Store,
+	userID uuid.UUID,
+	hasObjectID bool,
+	upn, reason string,
+) (bool, error) {
+	if hasObjectID {
+		return store.ArchiveUser(ctx, userID, reason)
+	}
+	if upn == "" {
+		return false, nil
+	}
+	return store.ArchiveUserByUPN(ctx, upn, reason)
+}
+
+func syncDirectoryUser(
+	ctx context.Context,
+	store *store.Store,
+	source string,
+	u directory.User,
+	scope map[string]struct{},
+	stats *RunStats,
+) error {
+	userID, hasObjectID := parseDirectoryUserID(u.ObjectID)
+	if u.Partial && hasObjectID {
+		merged, err := mergeStoredUser(ctx, store, userID, u)
+		if err != nil {
+			return fmt.Errorf("load user: %w", err)
+		}
+		u = merged
+	}
+	// A partial item for a user not synced yet has no UPN to create it with.
+	if u.UPN == "" {
+		return nil
+	}
+	if reason := skipReason(u, scope); reason != "" {
+		archived, err := archiveDirectoryUser(ctx, store, userID, hasObjectID, u.UPN, reason)
+		if err != nil {
+			return fmt.Errorf("archive user: %w", err)
+		}
+		if archived {
+			stats.UsersArchived++
+		}
+		return nil
+	}
+	if !hasObjectID {
+		resolvedID, err := resolveUserID(ctx, store, u.UPN)
+		if err != nil {
+			return fmt.Errorf("lookup user by UPN: %w", err)
+		}
+		userID = resolvedID
+	}
+	row, err := store.UpsertUser(ctx, sqlc.UpsertUserParams{
+		ID:          userID,
+		Upn:         u.UPN,
+		DisplayName: u.DisplayName,
+		ObjectID:    pgtype.Text{String: u.ObjectID, Valid: u.ObjectID != ""},
+		Department:  pgtype.Text{String: u.Department, Valid: u.Department != ""},
+		LocationIds: []uuid.UUID{},
+		Source:      pgtype.Text{String: source, Valid: true},
+		// Only an item reporting the account enabled brings an archived user
+		// back; items without accountEnabled leave the archive state alone.
+		Unarchive: u.Active != nil,
+	})
+	if err != nil {
+		return fmt.Errorf("upsert user: %w", err)
+	}
+	if wasInserted(row.CreatedAt, row.UpdatedAt) {
+		stats.UsersCreated++
+	} else {
+		stats.UsersUpdated++
+	}
+	if err = store.SetUserDirectoryAttributes(ctx, row.ID, directoryAttributes(u)); err != nil {
+		return fmt.Errorf("set user attributes: %w", err)
+	}
+	return nil
+}
+
+// mergeStoredUser fills the properties a partial item leaves empty from the
+// stored user. Items for users not stored yet are returned as they are.
+func mergeStoredUser(
+	ctx context.Context,
+	store *store.Store,
+	userID uuid.UUID,
+	u directory.User,
+) (directory.User, error) {
+	existing, err := store.GetUser(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, nil
+	}
+	if err != nil {
+		return u, err
+	}
+	if u.UPN == "" {
+		u.UPN = existing.Upn
+	}
+	if u.DisplayName == "" {
+		u.DisplayName = existing.DisplayName
+	}
+	if u.Department == "" {
+		u.Department = existing.Department.String
+	}
+	if u.EmployeeID == "" {
+		u.EmployeeID = existing.EmployeeID.String
+	}
+	if u.JobTitle == "" {
+		u.JobTitle = existing.JobTitle.String
+	}
+	stored := map[string]string{}
+	if len(existing.Attributes) > 0 {
+		if err = json.Unmarshal(existing.Attributes, &stored); err != nil {
+			return u, fmt.Errorf("decode attributes: %w", err)
+		}
+	}
+	maps.Copy(stored, u.Attributes)
+	u.Attributes = stored
+	return u, nil
+}
+
+// directoryAttributes converts the mapped directory fields for storage.
+func directoryAttributes(u directory.User) store.DirectoryAttributes {
+	attrs := store.DirectoryAttributes{
+		EmployeeID: u.EmployeeID,
+		JobTitle:   u.JobTitle,
+		Attributes: u.Attributes,
+	}
+	if u.ManagerID != nil {
+		attrs.SetManager = true
+		attrs.ManagerID, _ = parseDirectoryUserID(*u.ManagerID)
+	}
+	return attrs
+}
+
+func resolveUserID(ctx context.Context, store *store.Store, upn string) (uuid.UUID, error) {
+	existing, err := store.GetUserByUPN(ctx, upn)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.New(), nil
+		}
+		return uuid.Nil, err
+	}
+	return existing.ID, nil
+}
@@ -36,6 +36,10 @@ export interface Checkin {
   notes?: string;
   occurredAt: string;
   createdAt: string;
+  outOfHours?: boolean;
+  contradictory?: boolean;
+  autoInserted?: boolean;
+  transferId?: string | null;
 }
 
 export interface Location {
@@ -45,6 +49,134 @@ export interface Location {
   createdAt: string;
   groupIds: string[];
   notesEnabled: boolean;
+  parentId: string | null;
+  kind?: LocationKind;
+  capacity: number | null;
+  capacityPolicy?: CapacityPolicy;
+  directionPolicy?: DirectionPolicy;
+  transferPolicy?: TransferPolicy;
+}
+
+export type LocationKind = "site" | "building" | "room";
+
+// Users inside a location's subtree; counts roll up to ancestors.
+export interface LocationPresence {
+  locationId: string;
+  total: number;
+  since: string;
+  locations: { id: string; name: string; parentId: string | null; count: number }[] | null;
+  users: {
+    userId: string;
+    userDisplayName: string;
+    userUpn: string;
+    locationId: string;
+    locationName: string;
+    since: string;
+  }[];
+}
+
+export type CapacityPolicy = "soft" | "hard" | "waitlist";
+
+// What happens to a check-in that contradicts the user's state, e.g. two ins
+// in a row. Unset inherits the parent's, and the default is flag.
+export type DirectionPolicy = "reject" | "insert" | "flag";
+
+// Whether a location takes part in transfers. Unset inherits the parent's,
+// and the default is allow.
+export type TransferPolicy = "allow" | "deny";
+
+export interface CapacityLimit {
+  locationId: string;
+  name: string;
+  capacity: number;
+  policy: CapacityPolicy;
+  occupancy: number;
+  full?: boolean;
+}
+
+export interface LocationOccupancy {
+  locationId: string;
+  current: number;
+  limits: CapacityLimit[];
+  waitlist: { userId: string; userDisplayName: string; userUpn: string; since: string }[];
+  history: { at: string; occupancy: number }[];
+}
+
+export type RetentionAction = "delete" | "anonymise";
+
+// A resolved retention rule; null days keep data indefinitely.
+export interface RetentionRule {
+  days: number | null;
+  action: RetentionAction;
+  notesDays: number | null;
+}
+
+// A location's own retention settings (null inherits) and the resolved rule.
+export interface LocationRetention {
+  retentionDays: number | null;
+  retentionAction: RetentionAction | null;
+  notesRetentionDays: number | null;
+  effective: RetentionRule;
+}
+
+export interface RetentionCounts {
+  deleted: number;
+  anonymised: number;
+  notesCleared: number;
+}
+
+export interface RetentionPreview {
+  at: string;
+  locations: (RetentionCounts & {
+    locationId: string;
+    name: string;
+    rule: RetentionRule;
+    expireBefore: string | null;
+    notesBefore: string | null;
+  })[];
+  total: RetentionCounts;
+}
+
+export interface RetentionRun extends RetentionCounts {
+  id: string;
+  status: "running" | "succeeded" | "failed";
+  startedAt: string;
+  finishedAt?: string;
+  error?: string;
+}
+
+export type OutOfHoursPolicy = "allow" | "flag" | "reject";
+
+// Opening intervals by day key ("mon".."sun"), as "HH:MM" times.
+export type OpeningHours = Partial<Record<string, { open: string; close: string }[]>>;
+
+// A location's own schedule settings (null inherits) and the resolved result.
+export interface LocationSchedule {
+  timezone: string | null;
+  openingHours: OpeningHours | null;
+  outOfHoursPolicy: OutOfHoursPolicy | null;
+  effective: {
+    timezone: string;
+    openingHours: OpeningHours | null;
+    outOfHoursPolicy: OutOfHoursPolicy;
+  };
+  status: { open: boolean; closedReason?: string; nextOpen: string | null };
+}
+
+export interface LocationSchedulePayload {
+  timezone: string | null;
+  openingHours: OpeningHours | null;
+  outOfHoursPolicy: OutOfHoursPolicy | null;
+}
+
+export interface LocationClosure {
+  id: string;
+  locationId: string;
+  startsOn: string;
+  endsOn: string;
+  reason: string;
+  source: "manual" | "ical";
+  uid?: string;
 }
 
 export interface Key {
@@ -63,6 +195,12 @@ export interface DirectoryUser {
   isAdmin: boolean;
   createdAt?: string;
   updatedAt?: string;
+  archivedAt?: string;
+  archiveReason?: string;
+  employeeId?: string;
+  jobTitle?: string;
+  managerId?: string;
+  attributes?: Record<string, string>;
 }
 
 export interface UserDetailResponse {
@@ -76,6 +214,21 @@ export interface DirectoryGroup {
   id: string;
   displayName: string;
   description?: string;
+  source?: string;
+  rules?: GroupRules;
+}
+
+// Rules deriving a local group's members; every set field must match.
+export interface GroupRules {
+  departments?: string[];
+  memberOf?: string[];
+  attributes?: Record<string, string>;
+}
+
+export interface LocalGroupPayload {
+  displayName: string;
+  description?: string;
+  rules?: GroupRules | null;
 }
 
 export interface UpdateUserPayload {
@@ -94,9 +247,17 @@ export interface BuildInfo {
   buildDate: string;
 }
 
+export interface AuthProvider {
+  name: string;
+  displayName: string;
+  type: "oidc" | "saml";
+  loginUrl: string;
+}
+
 export interface AuthProviders {
   oauth: boolean;
   local: boolean;
+  providers: AuthProvider[];
 }
 
 // Location payloads
@@ -104,9 +265,10 @@ export interface LocationPayload {
   name: string;
   groupIds: string[];
   notesEnabled: boolean;
+  kind?: LocationKind;
 }
 
-export type LocationCreatePayload = LocationPayload;
+export type LocationCreatePayload = LocationPayload & { parentId?: string | null };
 export type LocationUpdatePayload = LocationPayload;
 
 // Key payloads
@@ -213,6 +375,59 @@ export async function listGroups(): Promise<DirectoryGroup[]> {
   return apiRequest<DirectoryGroup[]>("/groups");
 }
 
+export async function createLocalGroup(
+  payload: LocalGroupPayload & { memberIds?: string[] },
+): Promise<DirectoryGroup> {
+  return apiRequest<DirectoryGroup>("/groups", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+export async function updateLocalGroup(id: string, payload: LocalGroupPayload): Promise<DirectoryGroup> {
+  return apiRequest<DirectoryGroup>(`/groups/${id}`, {
+    method: "PATCH",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+export async function deleteLocalGroup(id: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/groups/${id}`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok && res.status !== 404) {
+    throw new Error("Failed to delete group");
+  }
+}
+
+export async function addGroupMembers(id: string, userIds: string[]): Promise<void> {
+  const res = await fetch(`${API_BASE}/groups/${id}/members`, {
+    method: "POST",
+    credentials: "include",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ userIds }),
+  });
+
+  if (!res.ok) {
+    throw new Error("Failed to add group members");
+  }
+}
+
+export async function removeGroupMember(id: string, userId: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/groups/${id}/members/${userId}`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok && res.status !== 404) {
+    throw new Error("Failed to remove group member");
+  }
+}
+
 export async function createLocation(payload: LocationCreatePayload): Promise<Location> {
   return apiRequest<Location>("/locations", {
     method: "POST",
@@ -240,6 +455,126 @@ export async function deleteLocation(id: string): Promise<void> {
   }
 }
 
+export async function moveLocation(id: string, parentId: string | null): Promise<Location> {
+  return apiRequest<Location>(`/locations/${id}/move`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ parentId }),
+  });
+}
+
+export async function getLocationPresence(id: string): Promise<LocationPresence> {
+  return apiRequest<LocationPresence>(`/locations/${id}/presence`);
+}
+
+export async function getLocationSchedule(id: string): Promise<LocationSchedule> {
+  return apiRequest<LocationSchedule>(`/locations/${id}/schedule`);
+}
+
+export async function updateLocationSchedule(id: string, payload: LocationSchedulePayload): Promise<LocationSchedule> {
+  return apiRequest<LocationSchedule>(`/locations/${id}/schedule`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+export async function listLocationClosures(id: string): Promise<LocationClosure[]> {
+  return apiRequest<LocationClosure[]>(`/locations/${id}/closures`);
+}
+
+export async function createLocationClosure(id: string, payload: { startsOn: string; endsOn?: string; reason?: string }): Promise<LocationClosure> {
+  return apiRequest<LocationClosure>(`/locations/${id}/closures`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+// Replaces the location's previously imported closures.
+export async function importLocationClosures(id: string, file: File): Promise<LocationClosure[]> {
+  const formData = new FormData();
+  formData.append("file", file);
+
+  const res = await fetch(`${API_BASE}/locations/${id}/closures/import`, {
+    method: "POST",
+    body: formData,
+    credentials: "include",
+  });
+  return handleResponse<LocationClosure[]>(res);
+}
+
+export async function deleteLocationClosure(id: string, closureId: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/locations/${id}/closures/${closureId}`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok && res.status !== 404) {
+    throw new Error("Failed to delete closure");
+  }
+}
+
+export async function updateLocationCapacity(id: string, capacity: number | null, policy?: CapacityPolicy): Promise<Location> {
+  return apiRequest<Location>(`/locations/${id}/capacity`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ capacity, policy }),
+  });
+}
+
+export async function updateLocationDirectionPolicy(id: string, policy?: DirectionPolicy): Promise<Location> {
+  return apiRequest<Location>(`/locations/${id}/direction-policy`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ policy }),
+  });
+}
+
+export async function updateLocationTransferPolicy(id: string, policy?: TransferPolicy): Promise<Location> {
+  return apiRequest<Location>(`/locations/${id}/transfer-policy`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ policy }),
+  });
+}
+
+export async function getLocationOccupancy(id: string, options: { from?: string; to?: string; step?: string } = {}): Promise<LocationOccupancy> {
+  const parameters = new URLSearchParams();
+  for (const [key, value] of Object.entries(options)) {
+    if (value) {
+      parameters.set(key, value);
+    }
+  }
+  const query = parameters.toString();
+  return apiRequest<LocationOccupancy>(`/locations/${id}/occupancy${query ? `?${query}` : ""}`);
+}
+
+export async function getLocationRetention(id: string): Promise<LocationRetention> {
+  return apiRequest<LocationRetention>(`/locations/${id}/retention`);
+}
+
+export async function updateLocationRetention(
+  id: string,
+  payload: Pick<LocationRetention, "retentionDays" | "retentionAction" | "notesRetentionDays">,
+): Promise<LocationRetention> {
+  return apiRequest<LocationRetention>(`/locations/${id}/retention`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+// Dry run of the retention job, for every location or just one.
+export async function previewRetention(locationId?: string): Promise<RetentionPreview> {
+  const query = locationId ? `?locationId=${encodeURIComponent(locationId)}` : "";
+  return apiRequest<RetentionPreview>(`/retention/preview${query}`);
+}
+
+export async function listRetentionRuns(): Promise<RetentionRun[]> {
+  return apiRequest<RetentionRun[]>("/retention/runs");
+}
+
 // Keys
 
 export async function listKeys(): Promise<Key[]> {
@@ -273,6 +608,11 @@ export async function deleteKey(id: string): Promise<void> {
   }
 }
 
+// Replaces the key's value; portals using the old value stop working.
+export async function rotateKey(id: string): Promise<Key> {
+  return apiRequest<Key>(`/keys/${id}/rotate`, { method: "POST" });
+}
+
 // Users
 
 export async function listUsers(): Promise<DirectoryUser[]> {
@@ -295,6 +635,42 @@ export async function updateUser(userId: string, payload: UpdateUserPayload): Pr
   });
 }
 
+export interface UserErasure {
+  id: string;
+  subjectId: string;
+  reference?: string;
+  erasedBy?: string;
+  erasedAt: string;
+  checkinsAnonymised: number;
+  membershipsRemoved: number;
+}
+
+// Subject access export: a JSON bundle, or a ZIP with one file per section.
+export async function exportUserData(userId: string, format: "json" | "zip" = "json"): Promise<Blob> {
+  const res = await fetch(`${API_BASE}/users/${userId}/export?format=${format}`, {
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    await handleResponse<never>(res);
+  }
+
+  return res.blob();
+}
+
+// Anonymises the user's checkins and deletes everything else held about them.
+export async function eraseUser(userId: string, reference?: string): Promise<UserErasure> {
+  return apiRequest<UserErasure>(`/users/${userId}/erase`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ reference }),
+  });
+}
+
+export async function listUserErasures(): Promise<UserErasure[]> {
+  return apiRequest<UserErasure[]>("/users/erasures");
+}
+
 // Checkins
 
 export async function listCheckins(limit = 50, offset = 0): Promise<Checkin[]> {
@@ -306,6 +682,16 @@ export async function listCheckins(limit = 50, offset = 0): Promise<Checkin[]> {
   return apiRequest<Checkin[]>(`/checkins?${parameters.toString()}`);
 }
 
+export interface CheckinPartitions {
+  partitions: { name: string; month: string; archived: boolean; estimatedRows: number }[];
+  // Checkins waiting in the default partition for their month to be created.
+  unpartitioned: number;
+}
+
+export async function listCheckinPartitions(): Promise<CheckinPartitions> {
+  return apiRequest<CheckinPartitions>("/checkins/partitions");
+}
+
 // Status
 
 export async function getStatus(): Promise<AppStatusResponse> {
@@ -320,30 +706,134 @@ export interface PortalConfig {
     name: string;
     identifier: string;
     notesEnabled: boolean;
+    transfersEnabled: boolean;
   };
-  users: DirectoryUser[];
+  // users is left out when the roster is fetched separately.
+  users?: DirectoryUser[];
+  rosterVersion: number;
   backgroundImageUrl?: string;
+  schedule?: {
+    open: boolean;
+    closedReason: string;
+    nextOpen: string | null;
+    timezone: string;
+    policy: OutOfHoursPolicy;
+  };
+  occupancy?: { count: number; full: boolean; limits: CapacityLimit[] };
+  branding?: {
+    accentColor: string;
+    welcomeText: string;
+    language: string;
+    backgroundUrl?: string;
+    logoUrl?: string;
+  };
 }
 
-export async function getPortalConfig(locationIdentifier: string, key: string): Promise<PortalConfig> {
-  const parameters = new URLSearchParams({ location: locationIdentifier, key }),
-    res = await fetch(`/api/portal/config?${parameters.toString()}`);
+export async function getPortalConfig(locationIdentifier: string, key: string, includeUsers = true): Promise<PortalConfig> {
+  const parameters = new URLSearchParams({ location: locationIdentifier, key, width: String(Math.round(window.screen.width * window.devicePixelRatio)) });
+  if (!includeUsers) {
+    parameters.set("users", "false");
+  }
+  const res = await fetch(`/api/portal/config?${parameters.toString()}`);
   return handleResponse<PortalConfig>(res);
 }
 
-export async function submitPortalCheckin(locationIdentifier: string, key: string, userId: string, direction: "in" | "out", notes?: string): Promise<void> {
+export interface PortalRoster {
+  version: number;
+  users: DirectoryUser[];
+}
+
+// Apply added and changed alike; a user can move between them when
+// attribute filters are in use.
+export interface PortalRosterDelta {
+  since: number;
+  version: number;
+  added: DirectoryUser[];
+  changed: DirectoryUser[];
+  removed: string[];
+}
+
+export async function getPortalRoster(locationIdentifier: string, key: string): Promise<PortalRoster> {
+  const parameters = new URLSearchParams({ location: locationIdentifier, key }),
+    res = await fetch(`/api/portal/roster?${parameters.toString()}`);
+  return handleResponse<PortalRoster>(res);
+}
+
+// Resolves to undefined when the server no longer knows the version, in
+// which case the full roster must be fetched again.
+export async function getPortalRosterChanges(locationIdentifier: string, key: string, since: number): Promise<PortalRosterDelta | undefined> {
+  const parameters = new URLSearchParams({ location: locationIdentifier, key, since: String(since) }),
+    res = await fetch(`/api/portal/roster/changes?${parameters.toString()}`);
+  if (res.status === 410) {
+    return undefined;
+  }
+  return handleResponse<PortalRosterDelta>(res);
+}
+
+// Waitlisted check-ins are not recorded; the user is queued instead.
+// debounced is set for a repeat tap that was dropped, and duplicate when the
+// check-in had already been received.
+export interface PortalCheckinResult {
+  direction?: "in" | "out";
+  debounced?: boolean;
+  contradictory?: boolean;
+  counterpartInserted?: boolean;
+  // Locations a transfer checked the user out of.
+  transferredFrom?: string[];
+  outOfHours?: boolean;
+  overCapacity?: boolean;
+  waitlisted?: boolean;
+  position?: number;
+  duplicate?: boolean;
+}
+
+export type PortalDirection = "in" | "out" | "toggle" | "transfer";
+
+// A kiosk check-in. The idempotency key lets it be retried or replayed
+// without being recorded twice. toggle picks the opposite of the user's state;
+// transfer checks the user in here and out of anywhere else they are.
+export interface PortalCheckin {
+  idempotencyKey: string;
+  userId: string;
+  direction: PortalDirection;
+  notes?: string;
+  occurredAt: string;
+}
+
+export async function submitPortalCheckin(locationIdentifier: string, key: string, checkin: PortalCheckin): Promise<PortalCheckinResult> {
   const res = await fetch("/api/portal/checkin", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({
       location: locationIdentifier,
       key,
-      userId,
-      direction,
-      notes,
+      ...checkin,
     }),
   });
-  return handleResponse<undefined>(res);
+  return handleResponse<PortalCheckinResult>(res);
+}
+
+// status is the HTTP status the check-in would have had on its own.
+export interface PortalReplayResult extends PortalCheckinResult {
+  idempotencyKey: string;
+  status: number;
+  error?: string;
+}
+
+// Sends check-ins queued while the kiosk was offline. Results are in the
+// order given.
+export async function replayPortalCheckins(locationIdentifier: string, key: string, checkins: PortalCheckin[]): Promise<PortalReplayResult[]> {
+  const res = await fetch("/api/portal/checkins/replay", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        location: locationIdentifier,
+        key,
+        checkins,
+      }),
+    }),
+    data = await handleResponse<{ results: PortalReplayResult[] }>(res);
+  return data.results;
 }
 
 // Settings
@@ -378,3 +868,120 @@ export async function deletePortalBackground(): Promise<void> {
   });
   return handleResponse<undefined>(res);
 }
+
+// Runtime settings. Durations are Go duration strings such as "15m";
+// integers are plain numbers.
+export type SettingType = "duration" | "integer";
+
+export interface Setting {
+  key: string;
+  type: SettingType;
+  description: string;
+  value: string | number;
+  default: string | number;
+  min: string | number;
+  max: string | number;
+  isDefault: boolean;
+  version: number;
+  updatedBy?: string;
+  updatedAt?: string;
+}
+
+export async function listSettings(): Promise<Setting[]> {
+  return apiRequest<Setting[]>("/settings");
+}
+
+export async function getSetting(key: string): Promise<Setting> {
+  return apiRequest<Setting>(`/settings/${encodeURIComponent(key)}`);
+}
+
+// version, when given, must match the stored version or the update fails with 409.
+export async function updateSetting(key: string, value: string | number, version?: number): Promise<Setting> {
+  return apiRequest<Setting>(`/settings/${encodeURIComponent(key)}`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ value, version }),
+  });
+}
+
+export async function resetSetting(key: string, version?: number): Promise<Setting> {
+  const query = version === undefined ? "" : `?version=${version}`;
+  return apiRequest<Setting>(`/settings/${encodeURIComponent(key)}${query}`, { method: "DELETE" });
+}
+
+// Branding
+
+// A branding scope: global when both IDs are null.
+export interface Branding {
+  id: string;
+  locationId: string | null;
+  keyId: string | null;
+  accentColor?: string;
+  welcomeText?: string;
+  language?: string;
+  backgroundUrl?: string;
+  logoUrl?: string;
+  updatedAt: string;
+}
+
+export interface BrandingPayload {
+  accentColor?: string;
+  welcomeText?: string;
+  language?: string;
+}
+
+export type BrandingScope = { type: "global" } | { type: "location"; id: string } | { type: "key"; id: string };
+
+function brandingPath(scope: BrandingScope): string {
+  switch (scope.type) {
+    case "location":
+      return `/branding/locations/${scope.id}`;
+    case "key":
+      return `/branding/keys/${scope.id}`;
+    default:
+      return "/branding/global";
+  }
+}
+
+export async function listBranding(): Promise<Branding[]> {
+  return apiRequest<Branding[]>("/branding");
+}
+
+export async function getBranding(scope: BrandingScope): Promise<Branding> {
+  return apiRequest<Branding>(brandingPath(scope));
+}
+
+export async function updateBranding(scope: BrandingScope, payload: BrandingPayload): Promise<Branding> {
+  return apiRequest<Branding>(brandingPath(scope), {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+}
+
+export async function deleteBranding(scope: BrandingScope): Promise<void> {
+  const res = await fetch(`${API_BASE}${brandingPath(scope)}`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok && res.status !== 404) {
+    throw new Error("Failed to delete branding");
+  }
+}
+
+export async function uploadBrandingImage(scope: BrandingScope, kind: "background" | "logo", file: File): Promise<Branding> {
+  const formData = new FormData();
+  formData.append("file", file);
+
+  const res = await fetch(`${API_BASE}${brandingPath(scope)}/${kind}`, {
+    method: "PUT",
+    body: formData,
+    credentials: "include",
+  });
+  return handleResponse<Branding>(res);
+}
+
+export async function deleteBrandingImage(scope: BrandingScope, kind: "background" | "logo"): Promise<Branding> {
+  return apiRequest<Branding>(`${brandingPath(scope)}/${kind}`, { method: "DELETE" });
+}
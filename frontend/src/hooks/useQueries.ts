@@ -1,3 +1,4 @@
+import { useEffect } from "react";
 import { keepPreviousData, useMutation, useQuery, useQueryClient, type UseMutationResult, type UseQueryResult } from "@tanstack/react-query";
 import {
   type ApiUser,
@@ -13,6 +14,9 @@ import {
   type LocationUpdatePayload,
   type PortalConfig,
   type PortalBackgroundSettings,
+  type PortalCheckinResult,
+  type PortalDirection,
+  type PortalRoster,
   type UserDetailResponse,
   type UpdateUserPayload,
   createKey,
@@ -23,6 +27,8 @@ import {
   getCurrentUser,
   getPortalBackground,
   getPortalConfig,
+  getPortalRoster,
+  getPortalRosterChanges,
   getStatus,
   getUserDetails,
   listCheckins,
@@ -30,12 +36,14 @@ import {
   listKeys,
   listLocations,
   listUsers,
+  replayPortalCheckins,
   submitPortalCheckin,
   updateKey,
   updateLocation,
   updateUser,
   uploadPortalBackground,
 } from "../api";
+import { loadQueuedCheckins, queueCheckin, removeQueuedCheckins } from "../utils/portalQueue";
 
 type QueryResult<T> = UseQueryResult<T, Error>;
 type MutationResult<TData, TVariables> = UseMutationResult<TData, Error, TVariables>;
@@ -204,27 +212,64 @@ export function useCheckins(limit = 50, offset = 0): QueryResult<Checkin[]> {
 }
 
 // Portal Hooks
+// The roster is left out; usePortalRoster keeps it up to date.
 export function usePortalConfig(locationIdentifier: string, key: string): QueryResult<PortalConfig> {
   return useQuery({
     queryKey: ["portalConfig", locationIdentifier, key],
-    queryFn: () => getPortalConfig(locationIdentifier, key),
+    queryFn: () => getPortalConfig(locationIdentifier, key, false),
     enabled: Boolean(locationIdentifier) && Boolean(key),
     retry: false,
   });
 }
 
+const PORTAL_ROSTER_INTERVAL_MS = 60_000;
+
+// Fetches the roster once, then polls for changes since the version held.
+export function usePortalRoster(locationIdentifier: string, key: string): QueryResult<PortalRoster> {
+  const queryClient = useQueryClient(),
+    queryKey = ["portalRoster", locationIdentifier, key];
+  return useQuery({
+    queryKey,
+    queryFn: async (): Promise<PortalRoster> => {
+      const current = queryClient.getQueryData<PortalRoster>(queryKey);
+      if (!current) {
+        return getPortalRoster(locationIdentifier, key);
+      }
+      const delta = await getPortalRosterChanges(locationIdentifier, key, current.version);
+      if (!delta) {
+        return getPortalRoster(locationIdentifier, key);
+      }
+      if (delta.version === current.version) {
+        return current;
+      }
+      const updated = new Map([...delta.added, ...delta.changed].map((user) => [user.id, user])),
+        removed = new Set([...delta.removed, ...updated.keys()]),
+        users = [...current.users.filter((user) => !removed.has(user.id)), ...updated.values()];
+      users.sort((a, b) => (a.displayName || a.upn).localeCompare(b.displayName || b.upn));
+      return { version: delta.version, users };
+    },
+    enabled: Boolean(locationIdentifier) && Boolean(key),
+    refetchInterval: PORTAL_ROSTER_INTERVAL_MS,
+  });
+}
+
+// queued is set when the kiosk was offline and the check-in will be sent later.
+export type PortalSubmitResult = PortalCheckinResult & { queued?: boolean };
+
+const PORTAL_REPLAY_INTERVAL_MS = 30_000;
+
 export function usePortalCheckin(): MutationResult<
-  void,
+  PortalSubmitResult,
   {
     locationIdentifier: string;
     key: string;
     userId: string;
-    direction: "in" | "out";
+    direction: PortalDirection;
     notes?: string;
   }
 > {
   return useMutation({
-    mutationFn: ({
+    mutationFn: async ({
       locationIdentifier,
       key,
       userId,
@@ -234,12 +279,71 @@ export function usePortalCheckin(): MutationResult<
       locationIdentifier: string;
       key: string;
       userId: string;
-      direction: "in" | "out";
+      direction: PortalDirection;
       notes?: string;
-    }) => submitPortalCheckin(locationIdentifier, key, userId, direction, notes),
+    }): Promise<PortalSubmitResult> => {
+      const checkin = {
+        idempotencyKey: crypto.randomUUID(),
+        userId,
+        direction,
+        occurredAt: new Date().toISOString(),
+        ...(notes ? { notes } : {}),
+      };
+      try {
+        return await submitPortalCheckin(locationIdentifier, key, checkin);
+      } catch (error) {
+        // fetch only rejects with a TypeError when the request never reached the server.
+        if (error instanceof TypeError) {
+          queueCheckin(locationIdentifier, key, checkin);
+          return { queued: true };
+        }
+        throw error;
+      }
+    },
   });
 }
 
+// Replays queued check-ins when the kiosk comes back online, and every so
+// often in case the browser misses it. Check-ins the server answered are
+// dropped from the queue; server errors are kept to try again.
+export function usePortalReplay(locationIdentifier: string, key: string): void {
+  useEffect(() => {
+    if (!locationIdentifier || !key) {
+      return;
+    }
+
+    let running = false;
+    const replay = async (): Promise<void> => {
+      const queued = loadQueuedCheckins(locationIdentifier, key);
+      if (running || queued.length === 0) {
+        return;
+      }
+      running = true;
+      try {
+        const results = await replayPortalCheckins(locationIdentifier, key, queued),
+          answered = new Set(results.filter((result) => result.status < 500).map((result) => result.idempotencyKey));
+        removeQueuedCheckins(locationIdentifier, key, answered);
+      } catch {
+        // Still offline; try again later
+      } finally {
+        running = false;
+      }
+    };
+    const onOnline = (): void => {
+        void replay();
+      },
+      timer = setInterval(onOnline, PORTAL_REPLAY_INTERVAL_MS);
+
+    window.addEventListener("online", onOnline);
+    onOnline();
+
+    return () => {
+      clearInterval(timer);
+      window.removeEventListener("online", onOnline);
+    };
+  }, [locationIdentifier, key]);
+}
+
 // Settings
 export function usePortalBackground(): QueryResult<PortalBackgroundSettings> {
   return useQuery<PortalBackgroundSettings>({
@@ -16,7 +16,7 @@ import {
   Typography,
 } from "@mui/material";
 
-import { getAuthProviders } from "../api";
+import { type AuthProvider, getAuthProviders } from "../api";
 import { Logo } from "../components";
 import { useToast } from "../hooks/useToast";
 
@@ -30,7 +30,7 @@ interface LoginFormData {
 }
 
 export default function Login({ onLogin }: LoginProperties): ReactElement {
-  const [oauthEnabled, setOauthEnabled] = useState(false),
+  const [providers, setProviders] = useState<AuthProvider[]>([]),
     [providerError, setProviderError] = useState<string | undefined>(),
     { showToast } = useToast(),
     {
@@ -43,8 +43,8 @@ export default function Login({ onLogin }: LoginProperties): ReactElement {
   useEffect(() => {
     const loadProviders = async (): Promise<void> => {
       try {
-        const providers = await getAuthProviders();
-        setOauthEnabled(providers.oauth);
+        const response = await getAuthProviders();
+        setProviders(response.providers);
         setProviderError(undefined);
       } catch (error) {
         setProviderError("Unable to determine OAuth availability. Use local credentials or reload to try again.");
@@ -127,19 +127,21 @@ export default function Login({ onLogin }: LoginProperties): ReactElement {
                 Manage Santa rules and monitor blocked executions.
               </Typography>
 
-              {/* OAuth sign-in */}
+              {/* Identity provider sign-in */}
               <Stack spacing={2}>
-                <Button
-                  component="a"
-                  href="/api/auth/login"
-                  variant="contained"
-                  fullWidth
-                  disabled={!oauthEnabled}
-                >
-                  Sign in with OAuth
-                </Button>
+                {providers.map((provider) => (
+                  <Button
+                    key={provider.name}
+                    component="a"
+                    href={provider.loginUrl}
+                    variant="contained"
+                    fullWidth
+                  >
+                    Sign in with {provider.displayName}
+                  </Button>
+                ))}
 
-                {!oauthEnabled && (
+                {providers.length === 0 && (
                   <Typography
                     variant="caption"
                     color="text.secondary"
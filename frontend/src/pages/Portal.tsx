@@ -4,7 +4,8 @@ import { Alert, Autocomplete, Box, Button, Card, CardContent, CircularProgress,
 import CheckCircleIcon from "@mui/icons-material/CheckCircle";
 import Fuse from "fuse.js";
 
-import { usePortalCheckin, usePortalConfig } from "../hooks/useQueries";
+import type { PortalDirection } from "../api";
+import { usePortalCheckin, usePortalConfig, usePortalReplay, usePortalRoster } from "../hooks/useQueries";
 import { Logo } from "../components/Logo";
 
 interface PortalUser {
@@ -16,7 +17,7 @@ interface PortalCheckinPayload {
   locationIdentifier: string;
   key: string;
   userId: string;
-  direction: "in" | "out";
+  direction: PortalDirection;
   notes?: string;
 }
 
@@ -57,6 +58,7 @@ export default function Portal(): ReactElement {
     key = searchParameters.get("key") ?? "",
     locationParameter = locationIdentifier ?? "",
     { data: config, isLoading, error } = usePortalConfig(locationParameter, key),
+    { data: roster } = usePortalRoster(locationParameter, key),
     portalCheckin = usePortalCheckin(),
     backgroundImageUrl = config?.backgroundImageUrl,
     portalLayoutProperties = backgroundImageUrl ? { backgroundImageUrl } : {},
@@ -64,7 +66,7 @@ export default function Portal(): ReactElement {
     [notes, setNotes] = useState(""),
     [successMessage, setSuccessMessage] = useState<string | undefined>(),
     [searchQuery, setSearchQuery] = useState(""),
-    users: PortalUser[] = config?.users ?? [],
+    users: PortalUser[] = roster?.users ?? [],
     fuse =
       users.length === 0
         ? undefined
@@ -89,6 +91,8 @@ export default function Portal(): ReactElement {
       return error_ instanceof Error ? error_.message : "Check-in failed. Please try again.";
     }, [portalCheckin.error, portalCheckin.isError]);
 
+  usePortalReplay(locationParameter, key);
+
   useEffect(() => {
     if (!successMessage) {
       return;
@@ -106,7 +110,7 @@ export default function Portal(): ReactElement {
     };
   }, [successMessage]);
 
-  const handleCheckin = async (direction: "in" | "out"): Promise<void> => {
+  const handleCheckin = async (direction: "in" | "out" | "transfer"): Promise<void> => {
     if (!selectedUser) {
       return;
     }
@@ -126,8 +130,15 @@ export default function Portal(): ReactElement {
     }
 
     try {
-      await portalCheckin.mutateAsync(payload);
-      setSuccessMessage(`Checked ${direction} ${selectedUser.displayName}`);
+      const result = await portalCheckin.mutateAsync(payload),
+        action = direction === "transfer" ? "transfer" : `check-${direction}`;
+      if (result.queued) {
+        setSuccessMessage(`Saved ${selectedUser.displayName}'s ${action} offline`);
+      } else if (direction === "transfer") {
+        setSuccessMessage(`Transferred ${selectedUser.displayName} here`);
+      } else {
+        setSuccessMessage(`Checked ${direction} ${selectedUser.displayName}`);
+      }
     } catch {
       // Errors surface via mutation state
     }
@@ -337,6 +348,19 @@ export default function Portal(): ReactElement {
           >
             {portalCheckin.isPending ? "Processing…" : "Check Out"}
           </Button>
+          {config?.location.transfersEnabled && (
+            <Button
+              variant="contained"
+              color="info"
+              fullWidth
+              disabled={!selectedUser || portalCheckin.isPending}
+              onClick={() => {
+                void handleCheckin("transfer");
+              }}
+            >
+              {portalCheckin.isPending ? "Processing…" : "Transfer Here"}
+            </Button>
+          )}
         </Stack>
 
         {/* Error from mutation */}
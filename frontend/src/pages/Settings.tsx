@@ -154,7 +154,7 @@ export default function Settings(): ReactElement {
                       {selectedFile ? "Change file" : "Select image"}
                       <input
                         type="file"
-                        accept="image/jpeg"
+                        accept="image/jpeg,image/png,image/webp"
                         hidden
                         onChange={handleFileChange}
                       />
@@ -187,7 +187,7 @@ export default function Settings(): ReactElement {
                     variant="caption"
                     color="text.secondary"
                   >
-                    JPEGs only. Max 2&nbsp;MB. Images are cached for portal users.
+                    JPEG, PNG or WebP. Max 8&nbsp;MB. Images are resized for each kiosk and cached.
                   </Typography>
                 </Stack>
               </CardContent>
@@ -0,0 +1,38 @@
+import type { PortalCheckin } from "../api";
+
+// Check-ins made while the kiosk is offline, kept in localStorage per
+// location and key until they are replayed.
+const MAX_QUEUED = 500;
+
+function storageKey(locationIdentifier: string, key: string): string {
+  return `portalQueue:${locationIdentifier}:${key}`;
+}
+
+export function loadQueuedCheckins(locationIdentifier: string, key: string): PortalCheckin[] {
+  try {
+    const raw = localStorage.getItem(storageKey(locationIdentifier, key)),
+      parsed: unknown = raw ? JSON.parse(raw) : [];
+    return Array.isArray(parsed) ? (parsed as PortalCheckin[]) : [];
+  } catch {
+    return [];
+  }
+}
+
+function saveQueuedCheckins(locationIdentifier: string, key: string, checkins: PortalCheckin[]): void {
+  if (checkins.length === 0) {
+    localStorage.removeItem(storageKey(locationIdentifier, key));
+    return;
+  }
+  localStorage.setItem(storageKey(locationIdentifier, key), JSON.stringify(checkins));
+}
+
+// Oldest check-ins are dropped once the queue is full.
+export function queueCheckin(locationIdentifier: string, key: string, checkin: PortalCheckin): void {
+  const queued = [...loadQueuedCheckins(locationIdentifier, key), checkin];
+  saveQueuedCheckins(locationIdentifier, key, queued.slice(-MAX_QUEUED));
+}
+
+export function removeQueuedCheckins(locationIdentifier: string, key: string, idempotencyKeys: Set<string>): void {
+  const remaining = loadQueuedCheckins(locationIdentifier, key).filter((checkin) => !idempotencyKeys.has(checkin.idempotencyKey));
+  saveQueuedCheckins(locationIdentifier, key, remaining);
+}